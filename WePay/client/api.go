@@ -0,0 +1,234 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Code WePay 的业务错误码，和服务端的 response.Code 一致，这里只列出调用方需要区分的
+type Code int
+
+const (
+	CodeOK Code = 0
+
+	CodeInternal        Code = 10000 // 服务内部错误
+	CodeInvalidParam    Code = 10001 // 参数不合法
+	CodeUnauthorized    Code = 10002 // token 不对
+	CodeTooManyRequests Code = 10003 // 触发限流
+	CodeBusy            Code = 10004 // 同一个用户的操作正在处理
+	CodeTimeout         Code = 10005 // 处理超时
+
+	CodeMerchantNotFound Code = 20001 // appid 没有关联商户
+
+	CodeBillNotFound         Code = 30001 // 转账单不存在
+	CodeBillNotOwned         Code = 30002 // 转账单不属于这个用户
+	CodeBillAlreadyConfirmed Code = 30003 // 转账单已经确认收款
+	CodeBillNotConfirmable   Code = 30004 // 转账单当前状态不能确认收款
+	CodeTransferPaused       Code = 30008 // 红包活动已暂停
+	CodeIdempotencyConflict  Code = 30009 // 幂等键已用于其他用户或金额的转账
+	CodeTransferPending      Code = 30010 // 微信暂时没有应答，转账单已经创建，WePay 会在后台继续发送，Error.Data 里是转账单号
+	CodeBillExists           Code = 30012 // 转账单号已经存在
+
+	CodeUserNotFound Code = 40003 // 用户还没有余额记录
+
+	CodeTransferDenied      Code = 50001 // 风控拒绝
+	CodeTransferUnderReview Code = 50002 // 进入人工审核，Error.Data 里是转账单号
+
+	CodeTransferRejected Code = 60001 // 微信拒绝了转账请求
+	CodeWxpayError       Code = 60002 // 调用微信支付 API 出错
+)
+
+// 转账单状态
+const (
+	StatusAccepted        = "ACCEPTED"
+	StatusProcessing      = "PROCESSING"
+	StatusReviewing       = "REVIEWING"
+	StatusWaitUserConfirm = "WAIT_USER_CONFIRM"
+	StatusTransfering     = "TRANSFERING"
+	StatusSuccess         = "SUCCESS"
+	StatusFail            = "FAIL"
+	StatusCanceling       = "CANCELING"
+	StatusCancelled       = "CANCELLED"
+)
+
+const (
+	pathInitiateTransfer = "/api/transfer/to_user"
+	pathStatus           = "/api/transfer/status"
+	pathConfirm          = "/api/transfer/confirm"
+	pathHistory          = "/api/transfer/history"
+	pathBalance          = "/api/transfer/amount"
+)
+
+type InitiateTransferRequest struct {
+	Appid  string `json:"appid"`
+	Openid string `json:"openid"`
+	Amount int64  `json:"amount"` // 金额，单位分
+	Remark string `json:"remark,omitempty"`
+	// DeviceId 用户的设备标识，用于风控，可以为空
+	DeviceId string `json:"device_id,omitempty"`
+	// IdempotencyKey 同一笔奖励保持不变，比如业务的订单号。为空时生成一个，只在这一次调用的重试里有效
+	IdempotencyKey string `json:"-"`
+}
+
+// TransferResult 发起转账的结果，小程序用 PackageInfo 拉起确认收款
+type TransferResult struct {
+	OutBillNo      string `json:"out_bill_no"`
+	TransferBillNo string `json:"transfer_bill_no,omitempty"`
+	CreateTime     string `json:"create_time,omitempty"`
+	State          string `json:"state,omitempty"`
+	PackageInfo    string `json:"package_info,omitempty"`
+}
+
+type TransferRecord struct {
+	OutBillNo  string `json:"out_bill_no"`
+	Amount     int64  `json:"amount"`
+	Remark     string `json:"remark"`
+	Status     string `json:"status"`
+	FailReason string `json:"fail_reason,omitempty"`
+	Ctime      string `json:"ctime"`
+	Utime      string `json:"utime"`
+}
+
+type ConfirmRequest struct {
+	Openid      string `json:"openid"` // 确认收款的用户，只能确认发给自己的转账单
+	Appid       string `json:"appid"`
+	PackageInfo string `json:"package_info"`
+}
+
+type ConfirmResult struct {
+	OutBillNo string `json:"out_bill_no"`
+	Status    string `json:"status"`
+}
+
+// HistoryQuery 除了 Openid 都可以为零值，表示不过滤
+type HistoryQuery struct {
+	Openid    string
+	Status    string
+	StartDate time.Time // 按天过滤，只用日期部分
+	EndDate   time.Time // 当天也包含在内
+	Cursor    int64     // 上一页的 NextCursor，0 表示第一页
+	Limit     int
+}
+
+type TransferHistory struct {
+	Records    []TransferRecord `json:"records"`
+	NextCursor int64            `json:"next_cursor"` // 0 表示没有下一页
+}
+
+// InitiateTransfer 给用户发红包。进入人工审核时返回 CodeTransferUnderReview 的 *Error，
+// 微信暂时没有应答时返回 CodeTransferPending 的 *Error，之后用 GetStatus 查询结果，
+// 同一个 IdempotencyKey 已经发起过时不会再次转账，按转账单当前的状态返回和第一次调用一样的结果
+func (c *Client) InitiateTransfer(ctx context.Context, req InitiateTransferRequest) (*TransferResult, error) {
+	key := req.IdempotencyKey
+	if key == "" {
+		key = uuid.NewString()
+	}
+	body := struct {
+		InitiateTransferRequest
+		Time string `json:"time"`
+	}{
+		InitiateTransferRequest: req,
+		Time:                    time.Now().Format("20060102150405"),
+	}
+	var res TransferResult
+	_, err := c.do(ctx, call{
+		method:         http.MethodPost,
+		path:           pathInitiateTransfer,
+		body:           body,
+		idempotencyKey: key,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// GetStatus 查询用户的转账单，不存在或者不属于这个用户时返回 CodeBillNotFound 的 *Error
+func (c *Client) GetStatus(ctx context.Context, openid, outBillNo string) (*TransferRecord, error) {
+	var res TransferRecord
+	_, err := c.do(ctx, call{
+		method: http.MethodGet,
+		path:   pathStatus,
+		query:  url.Values{"openid": {openid}, "out_bill_no": {outBillNo}},
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Confirm 用户确认收款后把转账单改为成功。已经确认过时返回 CodeBillAlreadyConfirmed 的 *Error，
+// 但如果是重试之前的请求已经确认成功，直接返回成功
+func (c *Client) Confirm(ctx context.Context, req ConfirmRequest) (*ConfirmResult, error) {
+	var res ConfirmResult
+	retries, err := c.do(ctx, call{
+		method: http.MethodPost,
+		path:   pathConfirm,
+		body:   req,
+	}, &res)
+	if retries > 0 && IsCode(err, CodeBillAlreadyConfirmed) {
+		return confirmedResult(err), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// confirmedResult 已经确认收款的错误里带着转账单当前的状态
+func confirmedResult(err error) *ConfirmResult {
+	var res ConfirmResult
+	var e *Error
+	if errors.As(err, &e) && len(e.Data) > 0 {
+		_ = json.Unmarshal(e.Data, &res)
+	}
+	return &res
+}
+
+// History 分页查询用户的转账历史
+func (c *Client) History(ctx context.Context, query HistoryQuery) (*TransferHistory, error) {
+	values := url.Values{"openid": {query.Openid}}
+	if query.Status != "" {
+		values.Set("status", query.Status)
+	}
+	if !query.StartDate.IsZero() {
+		values.Set("start_date", query.StartDate.Format(time.DateOnly))
+	}
+	if !query.EndDate.IsZero() {
+		values.Set("end_date", query.EndDate.Format(time.DateOnly))
+	}
+	if query.Cursor > 0 {
+		values.Set("cursor", strconv.FormatInt(query.Cursor, 10))
+	}
+	if query.Limit > 0 {
+		values.Set("limit", strconv.Itoa(query.Limit))
+	}
+	var res TransferHistory
+	_, err := c.do(ctx, call{
+		method: http.MethodGet,
+		path:   pathHistory,
+		query:  values,
+	}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Balance 查询用户的余额，单位分。用户还没有余额记录时返回 CodeUserNotFound 的 *Error
+func (c *Client) Balance(ctx context.Context, openid string) (int64, error) {
+	var balance int64
+	_, err := c.do(ctx, call{
+		method: http.MethodGet,
+		path:   pathBalance,
+		query:  url.Values{"openid": {openid}},
+	}, &balance)
+	return balance, err
+}
@@ -0,0 +1,216 @@
+// Package client 内部服务调用 WePay 发放红包的 Go SDK。
+// 请求发往 /api 下的接口，用 Authorization: Bearer <token> 鉴权，token 在 WePay 的 WEPAY_API_TOKENS 里配置。
+// 网络错误、限流和服务端临时错误会自动重试，发起转账时带上幂等键，重试不会重复转账
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	requestIDHeader      = "X-Request-Id"
+
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 3
+	defaultBackoff    = 200 * time.Millisecond
+	maxBackoff        = 5 * time.Second
+)
+
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	maxRetries int
+	backoff    time.Duration
+}
+
+// New baseURL 为 WePay 的地址，比如 http://wepay:8080，token 为分配给调用方的 token
+func New(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: defaultTimeout},
+		maxRetries: defaultMaxRetries,
+		backoff:    defaultBackoff,
+	}
+}
+
+// HTTPClient 替换默认的 http.Client，比如加上 tracing 的 Transport
+func (c *Client) HTTPClient(httpClient *http.Client) *Client {
+	c.httpClient = httpClient
+	return c
+}
+
+// Retry 失败后最多再重试 maxRetries 次，第 n 次重试前等待 backoff * 2^(n-1)，服务端返回 Retry-After 时以它为准。
+// maxRetries 为 0 时不重试
+func (c *Client) Retry(maxRetries int, backoff time.Duration) *Client {
+	c.maxRetries = maxRetries
+	c.backoff = backoff
+	return c
+}
+
+// Error WePay 返回的业务错误，Code 见 CodeXXX。没有返回统一格式的应答时（比如网关出错）Code 为 0
+type Error struct {
+	StatusCode int
+	Code       Code
+	Message    string
+	// Data 错误时附带的数据，比如进入审核的转账单号，没有时为空
+	Data json.RawMessage
+}
+
+func (e *Error) Error() string {
+	if e.Code == 0 {
+		return fmt.Sprintf("wepay: http %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("wepay: http %d: code %d: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsCode err 是不是 WePay 返回的 code 错误
+func IsCode(err error, code Code) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// retryable 服务端还没处理或者可以安全地重新处理的错误。
+// 转账单的业务错误（比如微信拒绝、活动暂停）重试也不会成功，不重试
+func (e *Error) retryable() bool {
+	switch e.Code {
+	case CodeInternal, CodeTooManyRequests, CodeBusy, CodeTimeout:
+		return true
+	case 0:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+	default:
+		return false
+	}
+}
+
+// call 一次调用，重试时请求体、幂等键和请求 id 都不变
+type call struct {
+	method         string
+	path           string
+	query          url.Values
+	body           any
+	idempotencyKey string
+}
+
+// result 统一格式的应答 {code, message, data}
+type result struct {
+	Code    Code            `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// do 发送请求并把 data 解析到 out，返回重试的次数。业务错误返回 *Error
+func (c *Client) do(ctx context.Context, req call, out any) (retries int, err error) {
+	var body []byte
+	if req.body != nil {
+		body, err = json.Marshal(req.body)
+		if err != nil {
+			return 0, err
+		}
+	}
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+	requestID := uuid.NewString()
+
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		var retryAfter time.Duration
+		retry, retryAfter, err = c.send(ctx, req, target, body, requestID, out)
+		// 调用方取消时不再重试
+		if !retry || attempt >= c.maxRetries || ctx.Err() != nil {
+			return attempt, err
+		}
+		wait := c.backoff << attempt
+		if wait > maxBackoff || wait <= 0 {
+			wait = maxBackoff
+		}
+		// 加上随机的抖动，避免多个调用方同时重试
+		wait = wait/2 + rand.N(wait/2+1)
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		case <-timer.C:
+		}
+	}
+}
+
+// send 发送一次请求，返回是否可以重试和服务端要求的重试等待时间
+func (c *Client) send(ctx context.Context, req call, target string, body []byte, requestID string, out any) (retry bool, retryAfter time.Duration, err error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target, reader)
+	if err != nil {
+		return false, 0, err
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	httpReq.Header.Set(requestIDHeader, requestID)
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if req.idempotencyKey != "" {
+		httpReq.Header.Set(idempotencyKeyHeader, req.idempotencyKey)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return true, 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return true, 0, err
+	}
+	retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+
+	var res result
+	if err := json.Unmarshal(data, &res); err != nil || (res.Code == CodeOK && res.Message == "") {
+		// 不是 WePay 的应答，比如网关返回的错误页面
+		e := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		return e.retryable(), retryAfter, e
+	}
+	if res.Code != CodeOK {
+		e := &Error{StatusCode: resp.StatusCode, Code: res.Code, Message: res.Message, Data: res.Data}
+		return e.retryable(), retryAfter, e
+	}
+	if out != nil && len(res.Data) > 0 {
+		if err := json.Unmarshal(res.Data, out); err != nil {
+			// 请求已经处理成功，不能重试
+			return false, 0, fmt.Errorf("wepay: decode %s response: %w", req.path, err)
+		}
+	}
+	return false, 0, nil
+}
+
+// parseRetryAfter 只支持秒数
+func parseRetryAfter(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,480 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/service"
+	svcmocks "wepay/internal/service/mocks"
+	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/web"
+	"wepay/internal/web/middleware"
+	"wepay/internal/web/openapi"
+	"wepay/internal/web/response"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wechatpay-apiv3/wechatpay-go/core"
+	"go.uber.org/mock/gomock"
+)
+
+const (
+	testToken = "token-1"
+	testAppid = "wxb9f4f763e5d4a6de"
+	testMchId = "1368139500"
+)
+
+// flakyServer 模拟网络不稳定：前 reject 个请求直接返回 503，不交给 WePay 处理；
+// 之后 drop 个请求 WePay 处理完了，但调用方收到的是 502，应答丢了
+type flakyServer struct {
+	next http.Handler
+
+	mu       sync.Mutex
+	reject   int
+	drop     int
+	requests []*http.Request
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	reject, drop := s.reject > 0, s.reject == 0 && s.drop > 0
+	if reject {
+		s.reject--
+	} else if drop {
+		s.drop--
+	}
+	s.mu.Unlock()
+
+	switch {
+	case reject:
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+	case drop:
+		s.next.ServeHTTP(httptest.NewRecorder(), r)
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	default:
+		s.next.ServeHTTP(w, r)
+	}
+}
+
+func (s *flakyServer) header(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := make([]string, 0, len(s.requests))
+	for _, r := range s.requests {
+		values = append(values, r.Header.Get(name))
+	}
+	return values
+}
+
+// newTestServer 和 main 一样挂载 /api/transfer，service 层用 mock
+func newTestServer(t *testing.T, transferSvc service.TransferService, userSvc service.UserService) *flakyServer {
+	ctrl := gomock.NewController(t)
+	mchConfig, _ := wxpay_utility.CreateMchConfig(testMchId, "serial", "certs/private_key.pem", "PUB_KEY_ID", "certs/public_key.pem")
+	merchantSvc := svcmocks.NewMockMerchantService(ctrl)
+	merchantSvc.EXPECT().GetByAppid(gomock.Any(), testAppid).
+		Return(domain.Merchant{MchId: testMchId, Appids: []string{testAppid}}, nil).AnyTimes()
+	merchantSvc.EXPECT().MchConfig(gomock.Any(), testMchId).Return(mchConfig, nil).AnyTimes()
+
+	spec, err := openapi.Load()
+	require.NoError(t, err)
+	server := gin.New()
+	server.ContextWithFallback = true
+	handler := web.NewTransferHandler(transferSvc, userSvc, merchantSvc)
+	// 和 main 一样先鉴权再校验参数
+	handler.RegisterRoutes(server.Group("/api/transfer",
+		middleware.NewAdminAuthBuilder(map[string]string{testToken: "reward-service"}).Build(),
+		openapi.NewValidatorBuilder(spec).Build()))
+	return &flakyServer{next: server}
+}
+
+func newTestClient(t *testing.T, handler http.Handler, token string) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return New(server.URL, token).Retry(3, time.Millisecond)
+}
+
+func TestClient_InitiateTransfer(t *testing.T) {
+	ctime := time.Date(2025, 7, 23, 10, 0, 0, 0, time.Local)
+	req := InitiateTransferRequest{
+		Appid:          testAppid,
+		Openid:         "o1234567890",
+		Amount:         100,
+		Remark:         "签到奖励",
+		IdempotencyKey: "order-1",
+	}
+	testCases := []struct {
+		name         string
+		token        string
+		reject, drop int
+		mock         func(ctrl *gomock.Controller) service.TransferService
+		wantResult   *TransferResult
+		wantCode     Code
+		wantRequests int
+	}{
+		{
+			name:  "success",
+			token: testToken,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), gomock.Any()).Return(domain.TransferRecord{}, service.ErrTransferNotFound)
+				transferSvc.EXPECT().InitiateTransfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, _ *wxpay_utility.MchConfig, bill *domain.TransferRecord, _ *service.TransferToUserRequest) (*service.TransferToUserResponse, error) {
+						assert.Equal(t, "签到奖励", bill.Remark)
+						return &service.TransferToUserResponse{
+							OutBillNo:   core.String(bill.OutBillNo),
+							State:       service.TRANSFERBILLSTATUS_WAIT_USER_CONFIRM.Ptr(),
+							PackageInfo: core.String(bill.PackageInfo),
+						}, nil
+					})
+				return transferSvc
+			},
+			wantResult:   &TransferResult{State: StatusWaitUserConfirm},
+			wantRequests: 1,
+		},
+		{
+			name:   "retry until wepay is reachable",
+			token:  testToken,
+			reject: 2,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), gomock.Any()).Return(domain.TransferRecord{}, service.ErrTransferNotFound)
+				transferSvc.EXPECT().InitiateTransfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&service.TransferToUserResponse{
+					OutBillNo: core.String("plfk2020042013"),
+					State:     service.TRANSFERBILLSTATUS_PROCESSING.Ptr(),
+				}, nil)
+				return transferSvc
+			},
+			wantResult:   &TransferResult{State: StatusProcessing},
+			wantRequests: 3,
+		},
+		{
+			name:  "response lost, retry does not transfer twice",
+			token: testToken,
+			drop:  1,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				var created *domain.TransferRecord
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, outbillno string) (domain.TransferRecord, error) {
+						if created == nil {
+							return domain.TransferRecord{}, service.ErrTransferNotFound
+						}
+						assert.Equal(t, created.OutBillNo, outbillno)
+						return *created, nil
+					}).Times(2)
+				transferSvc.EXPECT().InitiateTransfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, _ *wxpay_utility.MchConfig, bill *domain.TransferRecord, _ *service.TransferToUserRequest) (*service.TransferToUserResponse, error) {
+						record := *bill
+						record.Status = domain.TransferStatusWaitUserConfirm
+						record.Ctime = ctime
+						created = &record
+						return &service.TransferToUserResponse{OutBillNo: core.String(bill.OutBillNo), State: service.TRANSFERBILLSTATUS_WAIT_USER_CONFIRM.Ptr()}, nil
+					}).Times(1)
+				return transferSvc
+			},
+			wantResult:   &TransferResult{State: StatusWaitUserConfirm, CreateTime: ctime.Format(time.RFC3339)},
+			wantRequests: 2,
+		},
+		{
+			name:  "under review",
+			token: testToken,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), gomock.Any()).Return(domain.TransferRecord{}, service.ErrTransferNotFound)
+				transferSvc.EXPECT().InitiateTransfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, service.ErrTransferUnderReview)
+				return transferSvc
+			},
+			wantCode:     CodeTransferUnderReview,
+			wantRequests: 1,
+		},
+		{
+			name:  "rejected by wx is not retried",
+			token: testToken,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), gomock.Any()).Return(domain.TransferRecord{}, service.ErrTransferNotFound)
+				transferSvc.EXPECT().InitiateTransfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, service.ErrTransferRejected)
+				return transferSvc
+			},
+			wantCode:     CodeTransferRejected,
+			wantRequests: 1,
+		},
+		{
+			name:  "internal error is retried",
+			token: testToken,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), gomock.Any()).
+					Return(domain.TransferRecord{}, errors.New("db down")).Times(4)
+				return transferSvc
+			},
+			wantCode:     CodeInternal,
+			wantRequests: 4,
+		},
+		{
+			name:  "wrong token",
+			token: "token-2",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				return svcmocks.NewMockTransferService(ctrl)
+			},
+			wantCode:     CodeUnauthorized,
+			wantRequests: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			server := newTestServer(t, tc.mock(ctrl), nil)
+			server.reject, server.drop = tc.reject, tc.drop
+			c := newTestClient(t, server, tc.token)
+
+			res, err := c.InitiateTransfer(context.Background(), req)
+			keys := server.header(idempotencyKeyHeader)
+			assert.Len(t, keys, tc.wantRequests)
+			for _, key := range keys {
+				assert.Equal(t, req.IdempotencyKey, key, "重试时幂等键不变")
+			}
+			if tc.wantCode != CodeOK {
+				assert.True(t, IsCode(err, tc.wantCode), "%v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEmpty(t, res.OutBillNo)
+			assert.Equal(t, tc.wantResult.State, res.State)
+			if tc.wantResult.CreateTime != "" {
+				assert.Equal(t, tc.wantResult.CreateTime, res.CreateTime)
+			}
+		})
+	}
+}
+
+func TestClient_GeneratedIdempotencyKey(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	transferSvc := svcmocks.NewMockTransferService(ctrl)
+	transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), gomock.Any()).Return(domain.TransferRecord{}, service.ErrTransferNotFound).Times(2)
+	transferSvc.EXPECT().InitiateTransfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(&service.TransferToUserResponse{OutBillNo: core.String("plfk2020042013")}, nil).Times(2)
+	server := newTestServer(t, transferSvc, nil)
+	server.reject = 1
+	c := newTestClient(t, server, testToken)
+
+	req := InitiateTransferRequest{Appid: testAppid, Openid: "o1234567890", Amount: 100}
+	_, err := c.InitiateTransfer(context.Background(), req)
+	require.NoError(t, err)
+	_, err = c.InitiateTransfer(context.Background(), req)
+	require.NoError(t, err)
+
+	// 一次调用里的重试用同一个幂等键，两次调用是两笔转账
+	keys := server.header(idempotencyKeyHeader)
+	require.Len(t, keys, 3)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+	assert.NotEqual(t, keys[1], keys[2])
+	requestIDs := server.header(requestIDHeader)
+	assert.Equal(t, requestIDs[0], requestIDs[1])
+}
+
+func TestClient_GetStatus(t *testing.T) {
+	ctime := time.Date(2025, 7, 23, 10, 0, 0, 0, time.Local)
+	ctrl := gomock.NewController(t)
+	transferSvc := svcmocks.NewMockTransferService(ctrl)
+	transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), "b1").Return(domain.TransferRecord{
+		OutBillNo: "b1",
+		Openid:    "o1234567890",
+		Amount:    100,
+		Status:    domain.TransferStatusSuccess,
+		Ctime:     ctime,
+		Utime:     ctime,
+	}, nil).Times(2)
+	c := newTestClient(t, newTestServer(t, transferSvc, nil), testToken)
+
+	res, err := c.GetStatus(context.Background(), "o1234567890", "b1")
+	require.NoError(t, err)
+	assert.Equal(t, &TransferRecord{
+		OutBillNo: "b1",
+		Amount:    100,
+		Status:    StatusSuccess,
+		Ctime:     ctime.Format(time.RFC3339),
+		Utime:     ctime.Format(time.RFC3339),
+	}, res)
+
+	_, err = c.GetStatus(context.Background(), "o0000000000", "b1")
+	assert.True(t, IsCode(err, CodeBillNotFound), "%v", err)
+}
+
+func TestClient_Confirm(t *testing.T) {
+	req := ConfirmRequest{Openid: "o1234567890", Appid: testAppid, PackageInfo: "PKo1234567890-20250723100000"}
+	confirmed := domain.TransferRecord{OutBillNo: "b1", Status: domain.TransferStatusSuccess}
+	testCases := []struct {
+		name       string
+		drop       int
+		mock       func(ctrl *gomock.Controller) service.TransferService
+		wantResult *ConfirmResult
+		wantCode   Code
+	}{
+		{
+			name: "success",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ConfirmTransfer(gomock.Any(), req.Openid, req.PackageInfo).Return(confirmed, nil)
+				return transferSvc
+			},
+			wantResult: &ConfirmResult{OutBillNo: "b1", Status: StatusSuccess},
+		},
+		{
+			name: "already confirmed",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ConfirmTransfer(gomock.Any(), req.Openid, req.PackageInfo).Return(confirmed, service.ErrTransferConfirmed)
+				return transferSvc
+			},
+			wantCode: CodeBillAlreadyConfirmed,
+		},
+		{
+			// 第一次请求已经确认成功，重试时返回已确认
+			name: "response lost",
+			drop: 1,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				gomock.InOrder(
+					transferSvc.EXPECT().ConfirmTransfer(gomock.Any(), req.Openid, req.PackageInfo).Return(confirmed, nil),
+					transferSvc.EXPECT().ConfirmTransfer(gomock.Any(), req.Openid, req.PackageInfo).Return(confirmed, service.ErrTransferConfirmed),
+				)
+				return transferSvc
+			},
+			wantResult: &ConfirmResult{OutBillNo: "b1", Status: StatusSuccess},
+		},
+		{
+			name: "not confirmable",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ConfirmTransfer(gomock.Any(), req.Openid, req.PackageInfo).
+					Return(domain.TransferRecord{OutBillNo: "b1", Status: domain.TransferStatusProcessing}, service.ErrTransferNotConfirmable)
+				return transferSvc
+			},
+			wantCode: CodeBillNotConfirmable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			server := newTestServer(t, tc.mock(ctrl), nil)
+			server.drop = tc.drop
+			c := newTestClient(t, server, testToken)
+
+			res, err := c.Confirm(context.Background(), req)
+			if tc.wantCode != CodeOK {
+				assert.True(t, IsCode(err, tc.wantCode), "%v", err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantResult, res)
+		})
+	}
+}
+
+func TestClient_History(t *testing.T) {
+	ctime := time.Date(2025, 7, 23, 10, 0, 0, 0, time.Local)
+	ctrl := gomock.NewController(t)
+	transferSvc := svcmocks.NewMockTransferService(ctrl)
+	transferSvc.EXPECT().ListTransferHistory(gomock.Any(), domain.TransferHistoryQuery{
+		Openid:    "o1234567890",
+		Status:    domain.TransferStatusSuccess,
+		StartTime: time.Date(2025, 7, 1, 0, 0, 0, 0, time.Local),
+		EndTime:   time.Date(2025, 8, 1, 0, 0, 0, 0, time.Local),
+		Cursor:    100,
+		Limit:     1,
+	}).Return([]domain.TransferRecord{
+		{ID: 99, OutBillNo: "b1", Amount: 100, Status: domain.TransferStatusSuccess, Ctime: ctime, Utime: ctime},
+	}, int64(99), nil)
+	c := newTestClient(t, newTestServer(t, transferSvc, nil), testToken)
+
+	res, err := c.History(context.Background(), HistoryQuery{
+		Openid:    "o1234567890",
+		Status:    StatusSuccess,
+		StartDate: time.Date(2025, 7, 1, 0, 0, 0, 0, time.Local),
+		EndDate:   time.Date(2025, 7, 31, 0, 0, 0, 0, time.Local),
+		Cursor:    100,
+		Limit:     1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &TransferHistory{
+		Records: []TransferRecord{
+			{OutBillNo: "b1", Amount: 100, Status: StatusSuccess, Ctime: ctime.Format(time.RFC3339), Utime: ctime.Format(time.RFC3339)},
+		},
+		NextCursor: 99,
+	}, res)
+}
+
+func TestClient_Balance(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	userSvc := svcmocks.NewMockUserService(ctrl)
+	userSvc.EXPECT().GetAmount(gomock.Any(), "o1234567890").Return(int64(1500), nil)
+	server := newTestServer(t, svcmocks.NewMockTransferService(ctrl), userSvc)
+	c := newTestClient(t, server, testToken)
+
+	balance, err := c.Balance(context.Background(), "o1234567890")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1500), balance)
+
+	// openid 为空时不会到达 handler
+	_, err = c.Balance(context.Background(), "")
+	assert.True(t, IsCode(err, CodeInvalidParam), "%v", err)
+	// 没有鉴权时先返回 401，不暴露参数校验的细节
+	_, err = newTestClient(t, server, "token-2").Balance(context.Background(), "")
+	assert.True(t, IsCode(err, CodeUnauthorized), "%v", err)
+}
+
+func TestClient_RetryStopsWithContext(t *testing.T) {
+	server := &flakyServer{next: http.NotFoundHandler(), reject: 100}
+	c := newTestClient(t, server, testToken).Retry(100, time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.Balance(ctx, "o1234567890")
+	var e *Error
+	require.True(t, errors.As(err, &e), "%v", err)
+	assert.Equal(t, http.StatusServiceUnavailable, e.StatusCode)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Len(t, server.header(requestIDHeader), 1)
+}
+
+// TestCodes 和服务端的错误码一致
+func TestCodes(t *testing.T) {
+	codes := map[Code]response.Code{
+		CodeOK:                   response.CodeOK,
+		CodeInternal:             response.CodeInternal,
+		CodeInvalidParam:         response.CodeInvalidParam,
+		CodeUnauthorized:         response.CodeUnauthorized,
+		CodeTooManyRequests:      response.CodeTooManyRequests,
+		CodeBusy:                 response.CodeBusy,
+		CodeTimeout:              response.CodeTimeout,
+		CodeMerchantNotFound:     response.CodeMerchantNotFound,
+		CodeBillNotFound:         response.CodeBillNotFound,
+		CodeBillNotOwned:         response.CodeBillNotOwned,
+		CodeBillAlreadyConfirmed: response.CodeBillAlreadyConfirmed,
+		CodeBillNotConfirmable:   response.CodeBillNotConfirmable,
+		CodeTransferPaused:       response.CodeTransferPaused,
+		CodeIdempotencyConflict:  response.CodeIdempotencyConflict,
+		CodeTransferPending:      response.CodeTransferPending,
+		CodeBillExists:           response.CodeBillExists,
+		CodeUserNotFound:         response.CodeUserNotFound,
+		CodeTransferDenied:       response.CodeTransferDenied,
+		CodeTransferUnderReview:  response.CodeTransferUnderReview,
+		CodeTransferRejected:     response.CodeTransferRejected,
+		CodeWxpayError:           response.CodeWxpayError,
+	}
+	for code, want := range codes {
+		assert.Equal(t, int(want), int(code))
+	}
+}
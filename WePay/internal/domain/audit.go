@@ -0,0 +1,18 @@
+package domain
+
+import "time"
+
+// AuditLog 管理后台的操作记录
+type AuditLog struct {
+	ID       int64
+	Operator string // 操作人
+	Action   string
+	Target   string // 操作对象，如 openid、out_bill_no
+	Detail   string // 操作内容
+	Reason   string // 操作原因
+	Ctime    time.Time
+}
+
+const (
+	AuditActionAdjustBalance = "ADJUST_BALANCE"
+)
@@ -0,0 +1,40 @@
+package domain
+
+import "time"
+
+const (
+	MerchantAccountBasic     = "BASIC"     // 基本账户
+	MerchantAccountOperation = "OPERATION" // 运营账户，商家转账从这里出款
+	MerchantAccountFees      = "FEES"      // 手续费账户
+)
+
+// MerchantBalance 商户某个资金账户的余额，单位分
+type MerchantBalance struct {
+	AccountType string
+	Available   int64 // 可用余额
+	Pending     int64 // 不可用余额
+}
+
+// BalanceCheck 一次运营账户余额检查的结果
+type BalanceCheck struct {
+	Balance     MerchantBalance
+	PendingNeed int64 // 还没完成的转账单需要的金额
+	Threshold   int64 // 告警阈值
+	Low         bool  // 可用余额低于告警阈值
+	Paused      bool  // 可用余额不够支付还没完成的转账单，红包活动已暂停
+	CheckTime   time.Time
+}
+
+const (
+	AlertLevelWarning  = "WARNING"
+	AlertLevelCritical = "CRITICAL"
+	AlertLevelResolved = "RESOLVED"
+)
+
+// Alert 发给运维的告警
+type Alert struct {
+	Level   string
+	Title   string
+	Message string
+	Time    time.Time
+}
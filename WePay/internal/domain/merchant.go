@@ -0,0 +1,17 @@
+package domain
+
+import "time"
+
+// Merchant 一个微信支付商户号及其 API 密钥，Appids 是与它关联、由它出款的小程序
+type Merchant struct {
+	MchId                  string
+	CertificateSerialNo    string // 商户API证书序列号
+	PrivateKeyPath         string // 商户API证书对应的私钥文件路径
+	WechatPayPublicKeyId   string // 微信支付公钥ID
+	WechatPayPublicKeyPath string // 微信支付公钥文件路径
+	ApiV3KeyPath           string // 商户APIv3密钥文件路径，用于解密回调通知
+	NotifyUrl              string // 转账结果回调地址
+	Appids                 []string
+	Ctime                  time.Time
+	Utime                  time.Time
+}
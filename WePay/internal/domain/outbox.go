@@ -0,0 +1,21 @@
+package domain
+
+import "time"
+
+// TransferOutbox 待发送给微信的转账请求，和转账单在同一个事务里写入
+type TransferOutbox struct {
+	ID            int64
+	OutBillNo     string
+	Payload       string // TransferToUserRequest 的 JSON
+	Status        string
+	Attempts      int
+	NextRetryTime time.Time
+	LastError     string
+}
+
+const (
+	OutboxStatusPending = "PENDING"
+	OutboxStatusDone    = "DONE"
+	OutboxStatusFailed  = "FAILED" // 重试次数用尽，或者风控审核没通过
+	OutboxStatusHeld    = "HELD"   // 风控审核中，审核通过后改为 PENDING
+)
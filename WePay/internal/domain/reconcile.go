@@ -0,0 +1,31 @@
+package domain
+
+import "time"
+
+// ReconcileReport 某一天本地转账单与微信账单的对账结果
+type ReconcileReport struct {
+	BillDate         time.Time
+	WxBillCount      int             // 微信账单里的转账单数
+	LocalCount       int             // 本地当天变更过状态的转账单数
+	Matched          int             // 金额和状态都一致的转账单数
+	Missing          []ReconcileItem // 本地当天已成功，微信账单里没有
+	Unsettled        []ReconcileItem // 本地在零点前不久成功，可能记在下一天的账单里，不算账不平
+	Extra            []ReconcileItem // 微信账单里有，本地没有
+	AmountMismatched []ReconcileItem
+	StateMismatched  []ReconcileItem
+}
+
+// Balanced 是否账平
+func (r ReconcileReport) Balanced() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 &&
+		len(r.AmountMismatched) == 0 && len(r.StateMismatched) == 0
+}
+
+// ReconcileItem 一笔对不上的转账单
+type ReconcileItem struct {
+	OutBillNo   string
+	LocalAmount int64
+	WxAmount    int64
+	LocalStatus string
+	WxStatus    string
+}
@@ -0,0 +1,81 @@
+package domain
+
+const (
+	RiskDecisionAllow  = "ALLOW"
+	RiskDecisionReview = "REVIEW" // 转账单进入人工审核队列，审核通过后才发给微信
+	RiskDecisionDeny   = "DENY"   // 转账单直接失败
+)
+
+// 频率规则统计的维度
+const (
+	RiskDimensionOpenid = "openid"
+	RiskDimensionDevice = "device"
+	RiskDimensionIP     = "ip"
+)
+
+// RiskRules 发起转账前检查的风控规则，管理后台修改后各实例定期重新加载，不需要重新部署
+type RiskRules struct {
+	Velocity   []VelocityRule `json:"velocity"`
+	Blocklist  RiskBlocklist  `json:"blocklist"`
+	NewAccount NewAccountRule `json:"new_account"`
+	Amount     AmountRule     `json:"amount"`
+}
+
+// VelocityRule 同一个 openid、设备或者 IP 在窗口内的转账单数（包括这一张）超过 MaxCount 时命中
+type VelocityRule struct {
+	Dimension     string `json:"dimension"`
+	WindowSeconds int64  `json:"window_seconds"`
+	MaxCount      int64  `json:"max_count"`
+	Decision      string `json:"decision"` // REVIEW 或者 DENY
+}
+
+// RiskBlocklist 名单里的 openid、设备、IP 直接拒绝
+type RiskBlocklist struct {
+	Openids []string `json:"openids"`
+	Devices []string `json:"devices"`
+	IPs     []string `json:"ips"`
+}
+
+// NewAccountRule 第一张转账单不到 HoldSeconds 的账户为新账户，新账户单笔超过 MaxAmount 分时人工审核。
+// HoldSeconds 为 0 表示不启用
+type NewAccountRule struct {
+	HoldSeconds int64 `json:"hold_seconds"`
+	MaxAmount   int64 `json:"max_amount"`
+}
+
+// AmountRule 金额异常，各项为 0 表示不启用
+type AmountRule struct {
+	ReviewAbove int64 `json:"review_above"` // 单笔超过时人工审核
+	DenyAbove   int64 `json:"deny_above"`   // 单笔超过时拒绝
+	// 超过这个用户最近 30 天成功转账平均金额的 AverageMultiple 倍时人工审核，没有历史时不检查
+	AverageMultiple float64 `json:"average_multiple"`
+}
+
+// DefaultRiskRules 管理后台还没配置过规则时使用
+func DefaultRiskRules() RiskRules {
+	return RiskRules{
+		Velocity: []VelocityRule{
+			{Dimension: RiskDimensionOpenid, WindowSeconds: 3600, MaxCount: 10, Decision: RiskDecisionReview},
+			{Dimension: RiskDimensionDevice, WindowSeconds: 3600, MaxCount: 20, Decision: RiskDecisionReview},
+			{Dimension: RiskDimensionIP, WindowSeconds: 60, MaxCount: 30, Decision: RiskDecisionDeny},
+		},
+		NewAccount: NewAccountRule{HoldSeconds: 24 * 3600, MaxAmount: 2000},
+		Amount:     AmountRule{ReviewAbove: 20000, DenyAbove: 200000},
+	}
+}
+
+// RiskInput 风控评估的输入
+type RiskInput struct {
+	Openid   string
+	DeviceId string
+	ClientIp string
+	MchId    string
+	Type     string
+	Amount   int64
+}
+
+// RiskResult 风控结果，Reasons 为命中的规则
+type RiskResult struct {
+	Decision string
+	Reasons  []string
+}
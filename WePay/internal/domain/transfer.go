@@ -1,17 +1,46 @@
 package domain
 
+import "time"
+
 type TransferRecord struct {
-	ID          int64
-	OutBillNo   string // 转账单号
-	Openid      string // 转账用户ID
-	MchId       string // 商户ID
-	Amount      int64  // 转账金额
-	Remark      string // 转账备注
-	SceneId     string // 转账场景ID
-	Status      string // 转账状态
-	PackageInfo string // notify 的时候用
+	ID           int64
+	OutBillNo    string // 转账单号
+	Openid       string // 转账用户ID
+	MchId        string // 商户ID
+	Amount       int64  // 转账金额
+	Remark       string // 转账备注
+	SceneId      string // 转账场景ID
+	Type         string // 转账类型
+	Status       string // 转账状态
+	PackageInfo  string // notify 的时候用
+	FailReason   string // 失败原因
+	DeviceId     string // 发起转账的设备，小程序上报，可能为空
+	ClientIp     string // 发起转账的客户端 IP
+	RiskDecision string // 风控结果，见 RiskDecisionXXX
+	RiskReasons  string // 命中的风控规则，用逗号分隔
+	Ctime        time.Time
+	Utime        time.Time
+}
+
+// TransferHistoryQuery 转账历史查询条件
+type TransferHistoryQuery struct {
+	Openid    string    // 为空表示不过滤，只在管理后台使用
+	MchId     string    // 为空表示不过滤
+	OutBillNo string    // 为空表示不过滤
+	DeviceId  string    // 为空表示不过滤
+	ClientIp  string    // 为空表示不过滤
+	Status    string    // 为空表示不过滤
+	StartTime time.Time // 为零值表示不限制
+	EndTime   time.Time // 为零值表示不限制，不包含
+	Cursor    int64     // 上一页最后一条记录的 ID，0 表示第一页
+	Limit     int
 }
 
+const (
+	TransferTypeReward   = "REWARD"   // 签到红包，确认收款后计入余额
+	TransferTypeWithdraw = "WITHDRAW" // 余额提现
+)
+
 const (
 	TransferStatusAccepted        = "ACCEPTED"
 	TransferStatusProcessing      = "PROCESSING"
@@ -21,4 +50,12 @@ const (
 	TransferStatusFail            = "FAIL"
 	TransferStatusCanceling       = "CANCELING"
 	TransferStatusCancelled       = "CANCELLED"
+	// TransferStatusReviewing 风控人工审核中，还没发给微信，只在本地使用
+	TransferStatusReviewing = "REVIEWING"
 )
+
+// FinalTransferStatuses 终态，到了之后不能再改成其他状态
+var FinalTransferStatuses = []string{TransferStatusSuccess, TransferStatusFail, TransferStatusCancelled}
+
+// TransferFailReasonRiskDenied 风控直接拒绝的转账单的失败原因，这种转账单没有发给微信
+const TransferFailReasonRiskDenied = "RISK_DENIED"
@@ -0,0 +1,22 @@
+package domain
+
+import "time"
+
+// TransferStatusEvent 转账单的一次状态变更
+type TransferStatusEvent struct {
+	ID         int64
+	OutBillNo  string
+	FromStatus string
+	ToStatus   string
+	Source     string // 触发变更的来源
+	PayloadRef string // 原始报文的引用，如微信 Request-Id、回调通知 ID、操作人
+	Ctime      time.Time
+}
+
+const (
+	TransferEventSourceAPI     = "API_RESPONSE" // 调用微信 API 的应答
+	TransferEventSourceNotify  = "NOTIFY"       // 微信回调通知
+	TransferEventSourcePoller  = "POLLER"       // 后台轮询
+	TransferEventSourceAdmin   = "ADMIN"        // 管理后台操作
+	TransferEventSourceConfirm = "CONFIRM"      // 用户确认收款
+)
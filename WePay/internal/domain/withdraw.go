@@ -0,0 +1,20 @@
+package domain
+
+import "time"
+
+// Withdrawal 用户把余额提现到微信零钱的记录，与一笔转账单一一对应
+type Withdrawal struct {
+	ID        int64
+	Openid    string
+	OutBillNo string // 对应的转账单号
+	Amount    int64
+	Status    string
+	Ctime     time.Time
+	Utime     time.Time
+}
+
+const (
+	WithdrawStatusPending  = "PENDING"  // 已扣减余额，等待转账结果
+	WithdrawStatusSuccess  = "SUCCESS"  // 转账成功
+	WithdrawStatusRefunded = "REFUNDED" // 转账失败或撤销，余额已退回
+)
@@ -0,0 +1,63 @@
+package job
+
+import (
+	"context"
+	"time"
+	"wepay/internal/logger"
+	"wepay/internal/service"
+)
+
+// BalanceCheckJob 定时检查所有商户的运营账户余额
+type BalanceCheckJob struct {
+	svc       service.BalanceService
+	merchants service.MerchantService
+	interval  time.Duration
+	timeout   time.Duration // 一轮检查最多跑多久
+}
+
+func NewBalanceCheckJob(svc service.BalanceService, merchants service.MerchantService, interval time.Duration) *BalanceCheckJob {
+	return &BalanceCheckJob{
+		svc:       svc,
+		merchants: merchants,
+		interval:  interval,
+		timeout:   time.Minute,
+	}
+}
+
+// Start 启动时先检查一次，然后阻塞运行，直到 ctx 被取消。ctx 取消时正在跑的这一轮会跑完
+func (j *BalanceCheckJob) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		round, cancel := roundContext(ctx, j.timeout)
+		j.RunOnce(round)
+		cancel()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (j *BalanceCheckJob) RunOnce(ctx context.Context) {
+	merchants, err := j.merchants.List(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Error("check balance list merchants failed", "error", err)
+		return
+	}
+	for _, m := range merchants {
+		j.check(ctx, m.MchId)
+	}
+}
+
+func (j *BalanceCheckJob) check(ctx context.Context, mchid string) {
+	check, err := j.svc.CheckBalance(ctx, mchid)
+	if err != nil {
+		logger.FromContext(ctx).Error("check merchant balance failed", "mch_id", mchid, "error", err)
+		return
+	}
+	logger.FromContext(ctx).Info("merchant balance checked", "mch_id", mchid,
+		"available", check.Balance.Available, "pending", check.Balance.Pending, "need", check.PendingNeed,
+		"low", check.Low, "paused", check.Paused)
+}
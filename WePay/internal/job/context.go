@@ -0,0 +1,12 @@
+package job
+
+import (
+	"context"
+	"time"
+)
+
+// roundContext 每一轮任务用的 context：保留 ctx 里的 logger 和 trace，但不跟着 ctx 取消，
+// 退出时正在跑的这一轮可以跑完，不会在写到一半时中断；timeout 限制一轮最多跑多久
+func roundContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), timeout)
+}
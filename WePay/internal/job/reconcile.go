@@ -0,0 +1,85 @@
+package job
+
+import (
+	"context"
+	"time"
+	"wepay/internal/logger"
+	"wepay/internal/service"
+)
+
+// ReconcileJob 每天定时核对所有商户前一天的微信账单。微信在次日 10 点后才能下载前一天的账单
+type ReconcileJob struct {
+	svc       service.ReconcileService
+	merchants service.MerchantService
+	hour      int           // 每天几点开始对账
+	timeout   time.Duration // 一轮对账最多跑多久
+}
+
+func NewReconcileJob(svc service.ReconcileService, merchants service.MerchantService, hour int) *ReconcileJob {
+	return &ReconcileJob{
+		svc:       svc,
+		merchants: merchants,
+		hour:      hour,
+		timeout:   30 * time.Minute,
+	}
+}
+
+// Start 阻塞运行，直到 ctx 被取消。ctx 取消时正在跑的这一轮会跑完
+func (j *ReconcileJob) Start(ctx context.Context) {
+	for {
+		now := time.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day(), j.hour, 0, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			round, cancel := roundContext(ctx, j.timeout)
+			j.RunOnce(round, next.AddDate(0, 0, -1))
+			cancel()
+		}
+	}
+}
+
+// RunOnce 逐个商户核对 date 当天的账单并把结果打到日志里
+func (j *ReconcileJob) RunOnce(ctx context.Context, date time.Time) {
+	merchants, err := j.merchants.List(ctx)
+	if err != nil {
+		logger.FromContext(ctx).Error("reconcile list merchants failed", "error", err)
+		return
+	}
+	for _, m := range merchants {
+		j.reconcile(ctx, m.MchId, date)
+	}
+}
+
+func (j *ReconcileJob) reconcile(ctx context.Context, mchid string, date time.Time) {
+	l := logger.FromContext(ctx).With("mch_id", mchid, "date", date.Format(time.DateOnly))
+	report, err := j.svc.Reconcile(ctx, mchid, date)
+	if err != nil {
+		l.Error("reconcile failed", "error", err)
+		return
+	}
+	l.Info("reconciled", "wx", report.WxBillCount, "local", report.LocalCount, "matched", report.Matched,
+		"missing", len(report.Missing), "extra", len(report.Extra),
+		"amount_mismatched", len(report.AmountMismatched), "state_mismatched", len(report.StateMismatched))
+	for _, item := range report.Missing {
+		l.Warn("reconcile missing in wx bill", "item", item)
+	}
+	for _, item := range report.Unsettled {
+		l.Info("reconcile unsettled, check next day's bill", "item", item)
+	}
+	for _, item := range report.Extra {
+		l.Warn("reconcile missing locally", "item", item)
+	}
+	for _, item := range report.AmountMismatched {
+		l.Warn("reconcile amount mismatched", "item", item)
+	}
+	for _, item := range report.StateMismatched {
+		l.Warn("reconcile state mismatched", "item", item)
+	}
+}
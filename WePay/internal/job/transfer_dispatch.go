@@ -0,0 +1,54 @@
+package job
+
+import (
+	"context"
+	"time"
+	"wepay/internal/logger"
+	"wepay/internal/service"
+)
+
+// TransferDispatchJob 定时把 outbox 里没有发送成功的转账请求重新发给微信
+type TransferDispatchJob struct {
+	svc       service.TransferService
+	configs   service.MchConfigProvider
+	interval  time.Duration
+	timeout   time.Duration // 一轮发送最多跑多久
+	batchSize int
+}
+
+func NewTransferDispatchJob(svc service.TransferService, configs service.MchConfigProvider, interval time.Duration) *TransferDispatchJob {
+	return &TransferDispatchJob{
+		svc:       svc,
+		configs:   configs,
+		interval:  interval,
+		timeout:   time.Minute,
+		batchSize: 100,
+	}
+}
+
+// Start 阻塞运行，直到 ctx 被取消。ctx 取消时正在跑的这一轮会跑完
+func (j *TransferDispatchJob) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			round, cancel := roundContext(ctx, j.timeout)
+			j.RunOnce(round)
+			cancel()
+		}
+	}
+}
+
+// RunOnce 发送一批到了重试时间的请求
+func (j *TransferDispatchJob) RunOnce(ctx context.Context) {
+	sent, err := j.svc.DispatchPendingTransfers(ctx, j.configs, j.batchSize)
+	if err != nil {
+		logger.FromContext(ctx).Error("dispatch pending transfers failed", "error", err)
+	}
+	if sent > 0 {
+		logger.FromContext(ctx).Info("dispatched pending transfers", "sent", sent)
+	}
+}
@@ -0,0 +1,84 @@
+// Package logger 提供结构化日志：按请求、按任务把 logger 放在 context 里往下传，
+// 输出前屏蔽密钥和用户姓名等敏感字段
+package logger
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+type ctxKey struct{}
+
+// New 输出 JSON 格式的日志，level 为 debug、info、warn、error，不认识时用 info
+func New(w io.Writer, level string) *slog.Logger {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		l = slog.LevelInfo
+	}
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       l,
+		ReplaceAttr: redact,
+	}))
+}
+
+// WithContext 把 l 放进 ctx，之后的 FromContext 都会拿到 l
+func WithContext(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext 返回 ctx 里的 logger，没有时返回 slog.Default()
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+			return l
+		}
+	}
+	return slog.Default()
+}
+
+// With 给 ctx 里的 logger 加上 args，比如 With(ctx, "out_bill_no", outbillno)
+func With(ctx context.Context, args ...any) context.Context {
+	return WithContext(ctx, FromContext(ctx).With(args...))
+}
+
+// Redacted 替换敏感字段的值
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys 这些字段不管在哪一层都不输出原值，比较时忽略大小写
+var sensitiveKeys = map[string]bool{
+	"authorization": true,
+	"password":      true,
+	"token":         true,
+	"secret":        true,
+	"private_key":   true,
+	"api_v3_key":    true,
+	"user_name":     true, // 收款用户的实名
+	"real_name":     true,
+	"package_info":  true, // 拿到就能替用户确认收款
+}
+
+// hashedKeys 这些字段输出哈希，不暴露原值，同一个值的日志还能串起来
+var hashedKeys = map[string]bool{
+	"openid": true,
+}
+
+func redact(_ []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	if sensitiveKeys[key] {
+		return slog.String(a.Key, Redacted)
+	}
+	if hashedKeys[key] && a.Value.Kind() == slog.KindString && a.Value.String() != "" {
+		return slog.String(a.Key, Hash(a.Value.String()))
+	}
+	return a
+}
+
+// Hash 日志里脱敏字段的输出，排查问题时用同样的方法算出 openid 的哈希再搜索日志
+func Hash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,44 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew_Redact(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, "info")
+	l.Debug("hidden")
+	l.Info("wx request",
+		"Authorization", "WECHATPAY2-SHA256-RSA2048 mchid=...",
+		slog.Group("request", "user_name", "张三", "out_bill_no", "b1", "openid", "o1"),
+		"openid", "o1",
+		"package_info", "pk1")
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry), "debug 日志不输出")
+	assert.Equal(t, Redacted, entry["Authorization"])
+	assert.Equal(t, map[string]any{"user_name": Redacted, "out_bill_no": "b1", "openid": Hash("o1")}, entry["request"])
+	assert.Equal(t, Hash("o1"), entry["openid"], "openid 输出哈希")
+	assert.NotEqual(t, Hash("o1"), Hash("o2"))
+	assert.Equal(t, Redacted, entry["package_info"])
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, slog.Default(), FromContext(context.Background()))
+
+	var buf bytes.Buffer
+	ctx := WithContext(context.Background(), New(&buf, "debug"))
+	ctx = With(ctx, "request_id", "r1")
+	FromContext(ctx).Info("hello")
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "r1", entry["request_id"])
+}
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"context"
+	"wepay/internal/domain"
+	"wepay/internal/repository/dao"
+)
+
+type AuditRepository interface {
+	Create(ctx context.Context, log domain.AuditLog) error
+}
+
+type auditRepository struct {
+	dao dao.AuditDao
+}
+
+func NewAuditRepository(dao dao.AuditDao) AuditRepository {
+	return &auditRepository{dao: dao}
+}
+
+func (r *auditRepository) Create(ctx context.Context, log domain.AuditLog) error {
+	return r.dao.Insert(ctx, dao.AuditLog{
+		Operator: log.Operator,
+		Action:   log.Action,
+		Target:   log.Target,
+		Detail:   log.Detail,
+		Reason:   log.Reason,
+	})
+}
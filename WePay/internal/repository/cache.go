@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+	"wepay/internal/logger"
+	"wepay/internal/repository/cache"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultCacheExpiration 缓存的默认过期时间，删缓存失败时最多读到这么久的旧数据
+const DefaultCacheExpiration = 5 * time.Minute
+
+// cacheRedeleteDelay 删缓存之后隔一段时间再删一次，清掉其他实例在删除前读到旧值、删除后才写回的缓存
+const cacheRedeleteDelay = time.Second
+
+func balanceKey(openid string) string {
+	return "wepay:balance:" + openid
+}
+
+func transferStatusKey(outbillno string) string {
+	return "wepay:transfer_status:" + outbillno
+}
+
+// cacheAside 先读缓存，没命中时从数据库加载再写回缓存，写数据库之后删除缓存。
+// nil 表示不使用缓存，直接读数据库
+type cacheAside struct {
+	cache      cache.Cache
+	expiration time.Duration
+	group      singleflight.Group
+	// version 每次删缓存加一，回源期间变过说明读到的可能是旧值，不写回
+	version atomic.Uint64
+}
+
+func newCacheAside(c cache.Cache, expiration time.Duration) *cacheAside {
+	if c == nil {
+		return nil
+	}
+	if expiration <= 0 {
+		expiration = DefaultCacheExpiration
+	}
+	return &cacheAside{cache: c, expiration: expiration}
+}
+
+// get 同一个 key 并发没命中时只有一个请求回源，其他请求等它的结果，避免热点 key 击穿数据库。
+// 回源不跟随发起请求的 ctx 取消，以免一个请求断开让所有等待的请求一起失败。
+// load 返回 false 表示结果不能缓存，比如记录还不存在
+func (c *cacheAside) get(ctx context.Context, key string, load func(ctx context.Context) (string, bool, error)) (string, error) {
+	if c == nil {
+		val, _, err := load(ctx)
+		return val, err
+	}
+	val, err := c.cache.Get(ctx, key)
+	if err == nil {
+		return val, nil
+	}
+	if !errors.Is(err, cache.ErrKeyNotExist) {
+		// 缓存不可用时降级读数据库
+		logger.FromContext(ctx).Warn("cache get failed", "key", key, "error", err)
+	}
+	ch := c.group.DoChan(key, func() (interface{}, error) {
+		ctx := context.WithoutCancel(ctx)
+		version := c.version.Load()
+		val, ok, err := load(ctx)
+		if err != nil || !ok || c.version.Load() != version {
+			return val, err
+		}
+		if err := c.cache.Set(ctx, key, val, c.jitter()); err != nil {
+			logger.FromContext(ctx).Warn("cache set failed", "key", key, "error", err)
+		}
+		return val, nil
+	})
+	select {
+	case res := <-ch:
+		return res.Val.(string), res.Err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// invalidate 数据库写成功之后调用。删除失败只记录日志，等过期
+func (c *cacheAside) invalidate(ctx context.Context, keys ...string) {
+	if c == nil {
+		return
+	}
+	c.version.Add(1)
+	c.delete(ctx, keys)
+	ctx = context.WithoutCancel(ctx)
+	time.AfterFunc(cacheRedeleteDelay, func() {
+		c.delete(ctx, keys)
+	})
+}
+
+func (c *cacheAside) delete(ctx context.Context, keys []string) {
+	if err := c.cache.Delete(ctx, keys...); err != nil {
+		logger.FromContext(ctx).Warn("cache delete failed", "keys", keys, "error", err)
+	}
+}
+
+// jitter 过期时间加上最多 10% 的随机值，避免大量 key 同时过期
+func (c *cacheAside) jitter() time.Duration {
+	return c.expiration + time.Duration(rand.Int63n(int64(c.expiration)/10+1))
+}
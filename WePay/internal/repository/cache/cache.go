@@ -0,0 +1,17 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrKeyNotExist = errors.New("key 不存在")
+
+// Cache 缓存读多写少的数据，数据库才是准的，缓存可以随时丢
+type Cache interface {
+	// Get key 不存在或者已经过期时返回 ErrKeyNotExist
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, val string, expiration time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LocalCache 进程内的缓存，用于测试和单实例部署，多实例时各自的缓存不会一起失效
+type LocalCache struct {
+	mu    sync.RWMutex
+	items map[string]localItem
+	now   func() time.Time
+}
+
+type localItem struct {
+	val      string
+	deadline time.Time // 零值表示不过期
+}
+
+func NewLocalCache() *LocalCache {
+	return &LocalCache{items: make(map[string]localItem), now: time.Now}
+}
+
+func (c *LocalCache) Get(ctx context.Context, key string) (string, error) {
+	c.mu.RLock()
+	item, ok := c.items[key]
+	c.mu.RUnlock()
+	if !ok {
+		return "", ErrKeyNotExist
+	}
+	if !item.deadline.IsZero() && !c.now().Before(item.deadline) {
+		c.mu.Lock()
+		// 重新检查，避免删掉别人刚写进去的值
+		if cur, ok := c.items[key]; ok && cur.deadline.Equal(item.deadline) {
+			delete(c.items, key)
+		}
+		c.mu.Unlock()
+		return "", ErrKeyNotExist
+	}
+	return item.val, nil
+}
+
+func (c *LocalCache) Set(ctx context.Context, key string, val string, expiration time.Duration) error {
+	item := localItem{val: val}
+	if expiration > 0 {
+		item.deadline = c.now().Add(expiration)
+	}
+	c.mu.Lock()
+	c.items[key] = item
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *LocalCache) Delete(ctx context.Context, keys ...string) error {
+	c.mu.Lock()
+	for _, key := range keys {
+		delete(c.items, key)
+	}
+	c.mu.Unlock()
+	return nil
+}
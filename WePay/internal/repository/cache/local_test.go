@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	c := NewLocalCache()
+	c.now = func() time.Time { return now }
+
+	_, err := c.Get(ctx, "k1")
+	assert.ErrorIs(t, err, ErrKeyNotExist)
+
+	require.NoError(t, c.Set(ctx, "k1", "v1", time.Minute))
+	require.NoError(t, c.Set(ctx, "k2", "v2", 0))
+	val, err := c.Get(ctx, "k1")
+	require.NoError(t, err)
+	assert.Equal(t, "v1", val)
+
+	now = now.Add(time.Minute)
+	_, err = c.Get(ctx, "k1")
+	assert.ErrorIs(t, err, ErrKeyNotExist, "过期")
+	val, err = c.Get(ctx, "k2")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", val, "不过期")
+
+	require.NoError(t, c.Delete(ctx, "k2", "k3"))
+	_, err = c.Get(ctx, "k2")
+	assert.ErrorIs(t, err, ErrKeyNotExist)
+}
@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisCache 多个实例共用的缓存
+type RedisCache struct {
+	client redis.Cmdable
+}
+
+func NewRedisCache(client redis.Cmdable) *RedisCache {
+	return &RedisCache{client: client}
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) (string, error) {
+	val, err := c.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrKeyNotExist
+	}
+	return val, err
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, val string, expiration time.Duration) error {
+	return c.client.Set(ctx, key, val, expiration).Err()
+}
+
+func (c *RedisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.client.Del(ctx, keys...).Err()
+}
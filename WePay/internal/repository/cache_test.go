@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/repository/cache"
+	"wepay/internal/repository/dao"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := dao.OpenDB(dao.DriverSQLite, filepath.Join(t.TempDir(), "wepay.db"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, dao.InitTable(db))
+	return db
+}
+
+func TestUserRepository_CacheBalance(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	userDao := dao.NewUserDao(db)
+	c := cache.NewLocalCache()
+	users := NewUserRepository(userDao, c, time.Minute)
+	withdraws := NewWithdrawRepository(dao.NewWithdrawDao(db), c, time.Minute)
+
+	_, err := users.GetAmount(ctx, "o1")
+	assert.ErrorIs(t, err, ErrUserNotFound, "用户不存在时不缓存")
+	require.NoError(t, users.UpdateBalance(ctx, "o1", 100))
+	balance, err := users.GetAmount(ctx, "o1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), balance)
+
+	// 绕过 repository 修改数据库，读到的还是缓存
+	require.NoError(t, userDao.UpsertBalance(ctx, "o1", 1))
+	balance, err = users.GetAmount(ctx, "o1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), balance)
+
+	require.NoError(t, users.UpdateBalance(ctx, "o1", 10))
+	balance, err = users.GetAmount(ctx, "o1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(111), balance, "更新余额后删除缓存")
+
+	_, err = withdraws.CreateWithdrawal(ctx, domain.Withdrawal{Openid: "o1", OutBillNo: "b1", Amount: 11},
+		&domain.TransferRecord{OutBillNo: "b1", Openid: "o1", Amount: 11, PackageInfo: "pkb1"}, domain.TransferOutbox{})
+	require.NoError(t, err)
+	balance, err = users.GetAmount(ctx, "o1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), balance, "提现扣减余额后删除缓存")
+
+	refunded, err := withdraws.Refund(ctx, "b1")
+	require.NoError(t, err)
+	assert.True(t, refunded)
+	balance, err = users.GetAmount(ctx, "o1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(111), balance, "退回余额后删除缓存")
+
+	balance, err = users.AdjustBalance(ctx, "o1", -11, domain.AuditLog{Operator: "admin", Reason: "test"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), balance)
+	balance, err = users.GetAmount(ctx, "o1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), balance, "人工调整后删除缓存")
+}
+
+func TestTransferRepository_CacheStatus(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	repo := NewTransferRepository(dao.NewTransferDao(db), cache.NewLocalCache(), time.Minute)
+
+	status, err := repo.GetTransferStatus(ctx, "b1")
+	require.NoError(t, err)
+	assert.Empty(t, status)
+	require.NoError(t, repo.CreateTransferRequest(ctx, &domain.TransferRecord{
+		OutBillNo: "b1", Openid: "o1", PackageInfo: "pk1", Status: domain.TransferStatusProcessing,
+	}))
+	status, err = repo.GetTransferStatus(ctx, "b1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.TransferStatusProcessing, status, "不存在时没有缓存空状态")
+
+	_, err = repo.UpdateTransferRequestStatus(ctx, "b1", domain.TransferStatusSuccess, domain.TransferEventSourceNotify, "")
+	require.NoError(t, err)
+	status, err = repo.GetTransferStatus(ctx, "b1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.TransferStatusSuccess, status, "状态变更后删除缓存")
+}
+
+// countingUserDao 记录 GetAmount 回源的次数，回源时等 release 关闭
+type countingUserDao struct {
+	dao.UserDao
+	loads   atomic.Int32
+	release chan struct{}
+}
+
+func (d *countingUserDao) GetAmount(ctx context.Context, openid string) (int64, error) {
+	d.loads.Add(1)
+	<-d.release
+	return 42, ctx.Err()
+}
+
+func TestUserRepository_CacheStampede(t *testing.T) {
+	ctx := context.Background()
+	d := &countingUserDao{release: make(chan struct{})}
+	users := NewUserRepository(d, cache.NewLocalCache(), time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			balance, err := users.GetAmount(ctx, "o1")
+			assert.NoError(t, err)
+			assert.Equal(t, int64(42), balance)
+		}()
+	}
+	// 等第一个请求开始回源，其他请求在 singleflight 里排队
+	require.Eventually(t, func() bool { return d.loads.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(d.release)
+	wg.Wait()
+	assert.Equal(t, int32(1), d.loads.Load(), "并发没命中时只回源一次")
+
+	_, err := users.GetAmount(ctx, "o1")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), d.loads.Load(), "之后命中缓存")
+}
+
+func TestUserRepository_CacheLoadCanceled(t *testing.T) {
+	d := &countingUserDao{release: make(chan struct{})}
+	users := NewUserRepository(d, cache.NewLocalCache(), time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error)
+	go func() {
+		_, err := users.GetAmount(ctx, "o1")
+		canceled <- err
+	}()
+	require.Eventually(t, func() bool { return d.loads.Load() == 1 }, time.Second, time.Millisecond)
+	waited := make(chan int64)
+	go func() {
+		balance, err := users.GetAmount(context.Background(), "o1")
+		assert.NoError(t, err)
+		waited <- balance
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-canceled, context.Canceled, "取消的请求直接返回")
+	close(d.release)
+	assert.Equal(t, int64(42), <-waited, "等同一次回源的请求不受影响")
+	assert.Equal(t, int32(1), d.loads.Load())
+}
+
+func TestUserRepository_CacheInvalidateDuringLoad(t *testing.T) {
+	ctx := context.Background()
+	d := &countingUserDao{release: make(chan struct{})}
+	c := cache.NewLocalCache()
+	users := NewUserRepository(d, c, time.Minute)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := users.GetAmount(ctx, "o1")
+		assert.NoError(t, err)
+	}()
+	require.Eventually(t, func() bool { return d.loads.Load() == 1 }, time.Second, time.Millisecond)
+	// 回源期间余额变了，回源读到的是旧值
+	users.(*userRepository).cache.invalidate(ctx, balanceKey("o1"))
+	close(d.release)
+	<-done
+	_, err := c.Get(ctx, balanceKey("o1"))
+	assert.ErrorIs(t, err, cache.ErrKeyNotExist, "不把旧值写回缓存")
+}
+
+func TestUserRepository_NoCache(t *testing.T) {
+	ctx := context.Background()
+	d := &countingUserDao{release: make(chan struct{})}
+	close(d.release)
+	users := NewUserRepository(d, nil, 0)
+	for i := 0; i < 3; i++ {
+		_, err := users.GetAmount(ctx, "o1")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(3), d.loads.Load())
+}
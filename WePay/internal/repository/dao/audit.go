@@ -0,0 +1,35 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AuditLog struct {
+	ID       int64  `gorm:"primaryKey;autoIncrement"`
+	Operator string `gorm:"type:varchar(64)"`
+	Action   string `gorm:"type:varchar(64)"`
+	Target   string `gorm:"type:varchar(128);index"`
+	Detail   string
+	Reason   string
+	Ctime    time.Time
+}
+
+type AuditDao interface {
+	Insert(ctx context.Context, log AuditLog) error
+}
+
+type GormAuditDao struct {
+	db *gorm.DB
+}
+
+func NewAuditDao(db *gorm.DB) AuditDao {
+	return &GormAuditDao{db: db}
+}
+
+func (d *GormAuditDao) Insert(ctx context.Context, log AuditLog) error {
+	log.Ctime = time.Now()
+	return d.db.WithContext(ctx).Create(&log).Error
+}
@@ -0,0 +1,389 @@
+package dao
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// forEachDB 在每种数据库上跑一遍 fn。SQLite 总是测试，MySQL 和 PostgreSQL 配置了
+// WEPAY_TEST_MYSQL_DSN、WEPAY_TEST_POSTGRES_DSN 时才测试，测试前会清空所有表
+func forEachDB(t *testing.T, fn func(t *testing.T, db *gorm.DB)) {
+	dbs := []struct {
+		driver string
+		dsn    string
+	}{
+		{driver: DriverSQLite},
+		{driver: DriverMySQL, dsn: os.Getenv("WEPAY_TEST_MYSQL_DSN")},
+		{driver: DriverPostgres, dsn: os.Getenv("WEPAY_TEST_POSTGRES_DSN")},
+	}
+	for _, tc := range dbs {
+		t.Run(tc.driver, func(t *testing.T) {
+			dsn := tc.dsn
+			if tc.driver == DriverSQLite {
+				dsn = filepath.Join(t.TempDir(), "wepay.db")
+			}
+			if dsn == "" {
+				t.Skipf("%s dsn not configured", tc.driver)
+			}
+			db, err := OpenDB(tc.driver, dsn, &gorm.Config{Logger: logger.Discard})
+			require.NoError(t, err)
+			require.NoError(t, InitTable(db))
+			for _, table := range []string{"users", "transfer_request_records", "transfer_status_events",
+				"transfer_outboxes", "withdrawals", "audit_logs", "merchants", "merchant_apps", "risk_rules"} {
+				require.NoError(t, TruncateTable(db, table))
+			}
+			fn(t, db)
+		})
+	}
+}
+
+func TestUserDao_UpsertBalance(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		ctx := context.Background()
+		d := NewUserDao(db)
+		require.NoError(t, d.UpsertBalance(ctx, "o1", 10))
+		require.NoError(t, d.UpsertBalance(ctx, "o1", 20))
+		require.NoError(t, d.UpsertBalance(ctx, "o2", 5))
+
+		balance, err := d.GetAmount(ctx, "o1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(30), balance)
+		balance, err = d.GetAmount(ctx, "o2")
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), balance)
+	})
+}
+
+func TestUserDao_AdjustBalance(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		ctx := context.Background()
+		d := NewUserDao(db)
+		balance, err := d.AdjustBalance(ctx, "o1", 100, AuditLog{Operator: "alice", Target: "o1", Reason: "补发"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), balance)
+
+		_, err = d.AdjustBalance(ctx, "o1", -200, AuditLog{Operator: "alice", Target: "o1", Reason: "扣回"})
+		assert.ErrorIs(t, err, ErrInsufficientBalance)
+
+		balance, err = d.GetAmount(ctx, "o1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), balance, "调整失败时余额不变")
+		var audits int64
+		require.NoError(t, db.Model(&AuditLog{}).Count(&audits).Error)
+		assert.Equal(t, int64(1), audits, "调整失败时不写操作记录")
+	})
+}
+
+func TestWithdrawDao(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		ctx := context.Background()
+		users, d, transfers := NewUserDao(db), NewWithdrawDao(db), NewTransferDao(db)
+		insert := func(outbillno string, amount int64) error {
+			return d.Insert(ctx, Withdrawal{Openid: "o1", OutBillNo: outbillno, Amount: amount},
+				&TransferRequestRecord{OutBillNo: outbillno, Openid: "o1", Amount: amount, PackageInfo: "pk" + outbillno},
+				&TransferOutbox{OutBillNo: outbillno, Status: "PENDING"})
+		}
+		assert.ErrorIs(t, insert("b0", 10), ErrInsufficientBalance, "没有余额记录")
+
+		require.NoError(t, users.UpsertBalance(ctx, "o1", 100))
+		assert.ErrorIs(t, insert("b1", 101), ErrInsufficientBalance)
+		_, err := transfers.GetTransferRecordByOutBillNo(ctx, "b1")
+		assert.ErrorIs(t, err, ErrRecordNotFound, "余额不足时不写转账单")
+		require.NoError(t, insert("b1", 60))
+		assert.Error(t, insert("b1", 10), "转账单已经存在")
+		require.NoError(t, insert("b2", 40))
+		balance, err := users.GetAmount(ctx, "o1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), balance, "写转账单失败时不扣减余额")
+		record, err := transfers.GetTransferRecordByOutBillNo(ctx, "b2")
+		require.NoError(t, err)
+		assert.Equal(t, int64(40), record.Amount)
+
+		w, refunded, err := d.Refund(ctx, "b1")
+		require.NoError(t, err)
+		assert.True(t, refunded)
+		assert.Equal(t, "o1", w.Openid)
+		_, refunded, err = d.Refund(ctx, "b1")
+		require.NoError(t, err)
+		assert.False(t, refunded, "重复退回")
+		require.NoError(t, d.MarkSuccess(ctx, "b2"))
+		_, refunded, err = d.Refund(ctx, "b2")
+		require.NoError(t, err)
+		assert.False(t, refunded, "已成功的提现不能退回")
+
+		balance, err = users.GetAmount(ctx, "o1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(60), balance)
+	})
+}
+
+func TestTransferDao(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		ctx := context.Background()
+		d := NewTransferDao(db)
+		now := time.Now()
+		for i, mchid := range []string{"m1", "m1", "m2"} {
+			record := &TransferRequestRecord{
+				OutBillNo:   "b" + string(rune('1'+i)),
+				Openid:      "o1",
+				MchId:       mchid,
+				Amount:      int64(10 * (i + 1)),
+				Status:      "PROCESSING",
+				PackageInfo: "pk" + string(rune('1'+i)),
+				Ctime:       now,
+				Utime:       now,
+			}
+			outbox := &TransferOutbox{OutBillNo: record.OutBillNo, Status: "PENDING", NextRetryTime: now, Ctime: now, Utime: now}
+			require.NoError(t, d.CreateTransferRequestRecordWithOutbox(ctx, record, outbox))
+		}
+		// 同一个转账单号重复写入
+		err := d.CreateTransferRequestRecordWithOutbox(ctx,
+			&TransferRequestRecord{OutBillNo: "b1", Openid: "o1", MchId: "m1", Status: "PROCESSING", PackageInfo: "pk9", Ctime: now, Utime: now},
+			&TransferOutbox{OutBillNo: "b1", Status: "PENDING", NextRetryTime: now, Ctime: now, Utime: now})
+		assert.ErrorIs(t, err, ErrDuplicatedKey)
+		require.NoError(t, d.UpdateTransferRequestPackageInfo(ctx, "b3", "wx-pk3"))
+		record, err := d.GetTransferRecordByPackageInfo(ctx, "wx-pk3")
+		require.NoError(t, err)
+		assert.Equal(t, "b3", record.OutBillNo)
+
+		final := []string{"SUCCESS", "FAIL", "CANCELLED"}
+		before, err := d.UpdateTransferRequestStatus(ctx, "b1", "SUCCESS", final, TransferStatusEvent{Source: "NOTIFY"})
+		require.NoError(t, err)
+		assert.Equal(t, "PROCESSING", before.Status)
+		before, err = d.UpdateTransferRequestStatus(ctx, "b1", "SUCCESS", final, TransferStatusEvent{Source: "POLLER"})
+		require.NoError(t, err)
+		assert.Equal(t, "SUCCESS", before.Status)
+		// 过时的查询结果不能把终态改回处理中
+		_, err = d.UpdateTransferRequestStatus(ctx, "b1", "TRANSFERING", final, TransferStatusEvent{Source: "POLLER"})
+		assert.ErrorIs(t, err, ErrTransferStatusFinal)
+		status, err := d.GetTransferStatus(ctx, "b1")
+		require.NoError(t, err)
+		assert.Equal(t, "SUCCESS", status)
+		events, err := d.ListTransferStatusEvents(ctx, "b1")
+		require.NoError(t, err)
+		require.Len(t, events, 1, "状态没变时不记录事件")
+		assert.Equal(t, "PROCESSING", events[0].FromStatus)
+		assert.Equal(t, "SUCCESS", events[0].ToStatus)
+		assert.Equal(t, "NOTIFY", events[0].Source)
+		_, err = d.UpdateTransferRequestStatus(ctx, "nope", "SUCCESS", final, TransferStatusEvent{})
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+
+		sum, err := d.SumTransferAmountByStatus(ctx, "m1", []string{"PROCESSING"}, now.Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, int64(20), sum)
+		sum, err = d.SumTransferAmountByStatus(ctx, "m1", []string{"PROCESSING"}, now.Add(time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), sum, "since 之前创建的转账单不统计")
+		sum, err = d.SumTransferAmountByStatus(ctx, "m3", []string{"PROCESSING"}, now.Add(-time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), sum)
+		counts, err := d.CountTransferRecordsByStatus(ctx, []string{"PROCESSING", "SUCCESS", "FAIL"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int64{"PROCESSING": 2, "SUCCESS": 1}, counts)
+
+		page, err := d.ListTransferRecords(ctx, TransferRecordQuery{Openid: "o1", Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, page, 2)
+		assert.Equal(t, "b3", page[0].OutBillNo)
+		page, err = d.ListTransferRecords(ctx, TransferRecordQuery{Openid: "o1", Cursor: page[1].ID, Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Equal(t, "b1", page[0].OutBillNo)
+		page, err = d.ListTransferRecords(ctx, TransferRecordQuery{MchId: "m2", StartTime: now.Add(-time.Minute), Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Equal(t, "b3", page[0].OutBillNo)
+
+		count, err := d.CountTransferRecords(ctx, TransferRecordQuery{Openid: "o1", StartTime: now.Add(-time.Minute), Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), count, "忽略分页条件")
+		avg, count, err := d.AvgTransferAmount(ctx, TransferRecordQuery{Openid: "o1", Status: "PROCESSING"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(25), avg)
+		assert.Equal(t, int64(2), count)
+		avg, count, err = d.AvgTransferAmount(ctx, TransferRecordQuery{Openid: "o2"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), avg)
+		assert.Equal(t, int64(0), count)
+		first, err := d.FirstTransferTime(ctx, "o1")
+		require.NoError(t, err)
+		assert.WithinDuration(t, now, first, time.Second)
+		first, err = d.FirstTransferTime(ctx, "o2")
+		require.NoError(t, err)
+		assert.True(t, first.IsZero())
+	})
+}
+
+func TestTransferDao_ConfirmTransferRequest(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		ctx := context.Background()
+		d, users := NewTransferDao(db), NewUserDao(db)
+		now := time.Now()
+		for _, no := range []string{"b1", "b2"} {
+			require.NoError(t, d.CreateTransferRequestRecord(ctx, &TransferRequestRecord{OutBillNo: no, Openid: "o1",
+				Amount: 10, Status: "WAIT_USER_CONFIRM", PackageInfo: "pk" + no, Ctime: now, Utime: now}))
+		}
+
+		before, confirmed, err := d.ConfirmTransferRequest(ctx, "b1", "WAIT_USER_CONFIRM", "SUCCESS", true, TransferStatusEvent{Source: "CONFIRM"})
+		require.NoError(t, err)
+		assert.True(t, confirmed)
+		assert.Equal(t, "WAIT_USER_CONFIRM", before.Status)
+		_, confirmed, err = d.ConfirmTransferRequest(ctx, "b1", "WAIT_USER_CONFIRM", "SUCCESS", true, TransferStatusEvent{Source: "CONFIRM"})
+		require.NoError(t, err)
+		assert.False(t, confirmed, "已经确认过")
+		// 提现不入账
+		_, confirmed, err = d.ConfirmTransferRequest(ctx, "b2", "WAIT_USER_CONFIRM", "SUCCESS", false, TransferStatusEvent{Source: "CONFIRM"})
+		require.NoError(t, err)
+		assert.True(t, confirmed)
+
+		balance, err := users.GetAmount(ctx, "o1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), balance, "只入账一次")
+		events, err := d.ListTransferStatusEvents(ctx, "b1")
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, "SUCCESS", events[0].ToStatus)
+	})
+}
+
+// TestDao_CanceledContext 所有 DAO 方法都把 ctx 传给数据库，ctx 取消后不再执行
+func TestDao_CanceledContext(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		transferDao := NewTransferDao(db)
+		userDao := NewUserDao(db)
+
+		err := transferDao.CreateTransferRequestRecord(ctx, &TransferRequestRecord{OutBillNo: "b1", Openid: "o1", Amount: 100})
+		assert.ErrorIs(t, err, context.Canceled)
+		_, err = transferDao.GetTransferStatus(ctx, "b1")
+		assert.ErrorIs(t, err, context.Canceled)
+		_, err = transferDao.GetTransferRecordByOutBillNo(ctx, "b1")
+		assert.ErrorIs(t, err, context.Canceled)
+		_, err = transferDao.GetTransferRecordByPackageInfo(ctx, "p1")
+		assert.ErrorIs(t, err, context.Canceled)
+		_, err = userDao.GetAmount(ctx, "o1")
+		assert.ErrorIs(t, err, context.Canceled)
+
+		// 没有写进去
+		_, err = transferDao.GetTransferRecordByOutBillNo(context.Background(), "b1")
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+}
+
+func TestTransferOutboxDao_Claim(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		ctx := context.Background()
+		d := NewTransferOutboxDao(db)
+		now := time.Now()
+		outbox := TransferOutbox{OutBillNo: "b1", Status: outboxStatusPending, NextRetryTime: now, Ctime: now, Utime: now}
+		require.NoError(t, db.Create(&outbox).Error)
+
+		due, err := d.FindDue(ctx, now, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		ok, err := d.Claim(ctx, outbox.ID, now, now.Add(time.Minute))
+		require.NoError(t, err)
+		assert.True(t, ok)
+		ok, err = d.Claim(ctx, outbox.ID, now, now.Add(time.Minute))
+		require.NoError(t, err)
+		assert.False(t, ok, "租约期内不能重复抢占")
+		due, err = d.FindDue(ctx, now, 10)
+		require.NoError(t, err)
+		assert.Empty(t, due)
+
+		require.NoError(t, d.MarkDone(ctx, outbox.ID))
+		due, err = d.FindDue(ctx, now.Add(time.Hour), 10)
+		require.NoError(t, err)
+		assert.Empty(t, due)
+		var saved TransferOutbox
+		require.NoError(t, db.First(&saved, outbox.ID).Error)
+		assert.Equal(t, 1, saved.Attempts)
+		assert.Equal(t, outboxStatusDone, saved.Status)
+	})
+}
+
+func TestTransferOutboxDao_Held(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		ctx := context.Background()
+		d := NewTransferOutboxDao(db)
+		now := time.Now()
+		for _, outbillno := range []string{"b1", "b2"} {
+			outbox := TransferOutbox{OutBillNo: outbillno, Status: outboxStatusHeld, NextRetryTime: now, Ctime: now, Utime: now}
+			require.NoError(t, db.Create(&outbox).Error)
+		}
+		due, err := d.FindDue(ctx, now, 10)
+		require.NoError(t, err)
+		assert.Empty(t, due, "审核中的请求不发送")
+
+		ok, err := d.ReleaseHeld(ctx, "b1", now)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		ok, err = d.DiscardHeld(ctx, "b1", "fraud")
+		require.NoError(t, err)
+		assert.False(t, ok, "已经放行的请求不能再拒绝")
+		ok, err = d.DiscardHeld(ctx, "b2", "fraud")
+		require.NoError(t, err)
+		assert.True(t, ok)
+		ok, err = d.ReleaseHeld(ctx, "b2", now)
+		require.NoError(t, err)
+		assert.False(t, ok, "已经拒绝的请求不能再放行")
+
+		due, err = d.FindDue(ctx, now, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		assert.Equal(t, "b1", due[0].OutBillNo)
+	})
+}
+
+func TestRiskDao(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		ctx := context.Background()
+		d := NewRiskDao(db)
+		_, err := d.Latest(ctx)
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+
+		now := time.Now()
+		require.NoError(t, d.Insert(ctx, RiskRule{Rules: `{"v":1}`, Operator: "alice", Ctime: now}))
+		require.NoError(t, d.Insert(ctx, RiskRule{Rules: `{"v":2}`, Operator: "bob", Ctime: now}))
+		rule, err := d.Latest(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, `{"v":2}`, rule.Rules)
+		assert.Equal(t, "bob", rule.Operator)
+	})
+}
+
+func TestMerchantDao_Upsert(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		ctx := context.Background()
+		d := NewMerchantDao(db)
+		require.NoError(t, d.Upsert(ctx, Merchant{MchId: "m1", NotifyUrl: "http://a"}, []string{"wx1", "wx2"}))
+		require.NoError(t, d.Upsert(ctx, Merchant{MchId: "m2", NotifyUrl: "http://b"}, []string{"wx3"}))
+		// 修改 m1，并把 wx3 改由 m1 出款
+		require.NoError(t, d.Upsert(ctx, Merchant{MchId: "m1", NotifyUrl: "http://c"}, []string{"wx3"}))
+
+		m, err := d.FindByMchId(ctx, "m1")
+		require.NoError(t, err)
+		assert.Equal(t, "http://c", m.NotifyUrl)
+		mchid, err := d.FindMchIdByAppid(ctx, "wx3")
+		require.NoError(t, err)
+		assert.Equal(t, "m1", mchid)
+		_, err = d.FindMchIdByAppid(ctx, "wx4")
+		assert.ErrorIs(t, err, ErrRecordNotFound)
+
+		merchants, err := d.FindAll(ctx)
+		require.NoError(t, err)
+		assert.Len(t, merchants, 2)
+		apps, err := d.FindAppsByMchIds(ctx, []string{"m1"})
+		require.NoError(t, err)
+		assert.Len(t, apps, 3)
+	})
+}
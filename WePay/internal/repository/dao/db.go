@@ -0,0 +1,47 @@
+package dao
+
+import (
+	"fmt"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// 支持的数据库
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite" // 用于测试和单机演示
+)
+
+// OpenDB 按 driver 打开数据库，dsn 的格式见对应的 gorm driver
+func OpenDB(driver, dsn string, opts ...gorm.Option) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch driver {
+	case DriverMySQL:
+		dialector = mysql.Open(dsn)
+	case DriverPostgres:
+		dialector = postgres.Open(dsn)
+	case DriverSQLite:
+		dialector = sqlite.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported db driver %q", driver)
+	}
+	db, err := gorm.Open(dialector, opts...)
+	if err != nil {
+		return nil, err
+	}
+	// 各个数据库的唯一索引冲突统一翻译成 ErrDuplicatedKey
+	db.Config.TranslateError = true
+	if driver == DriverSQLite {
+		// SQLite 同一时间只允许一个写事务，多个连接并发写会报 database is locked
+		sqlDB, err := db.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
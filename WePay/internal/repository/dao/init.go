@@ -1,13 +1,25 @@
 package dao
 
 import (
+	"context"
+
 	"gorm.io/gorm"
 )
 
+// InitTable 执行所有还没执行的表结构变更，见 migrations 目录
 func InitTable(db *gorm.DB) error {
-	return db.AutoMigrate(&User{}, &TransferRequestRecord{})
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return err
+	}
+	_, err = migrator.Up(context.Background())
+	return err
 }
 
 func TruncateTable(db *gorm.DB, tableName string) error {
+	// SQLite 没有 TRUNCATE
+	if db.Dialector.Name() == DriverSQLite {
+		return db.Exec("DELETE FROM " + tableName).Error
+	}
 	return db.Exec("TRUNCATE TABLE " + tableName).Error
 }
@@ -0,0 +1,101 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Merchant 商户号及调用微信支付 API 需要的密钥配置
+type Merchant struct {
+	ID                     int64  `gorm:"primaryKey;autoIncrement"`
+	MchId                  string `gorm:"type:varchar(32);uniqueIndex"`
+	CertificateSerialNo    string
+	PrivateKeyPath         string
+	WechatPayPublicKeyId   string
+	WechatPayPublicKeyPath string
+	ApiV3KeyPath           string `gorm:"type:varchar(255);not null;default:''"`
+	NotifyUrl              string
+	Ctime                  time.Time
+	Utime                  time.Time
+}
+
+// MerchantApp 小程序与出款商户号的关联，一个 appid 只由一个商户号出款
+type MerchantApp struct {
+	ID    int64  `gorm:"primaryKey;autoIncrement"`
+	Appid string `gorm:"type:varchar(32);uniqueIndex"`
+	MchId string `gorm:"type:varchar(32);index"`
+	Ctime time.Time
+	Utime time.Time
+}
+
+type MerchantDao interface {
+	// Upsert 在同一个事务里写入商户和它关联的 appid，已存在时覆盖
+	Upsert(ctx context.Context, m Merchant, appids []string) error
+	FindByMchId(ctx context.Context, mchid string) (Merchant, error)
+	// FindMchIdByAppid 找到小程序对应的商户号
+	FindMchIdByAppid(ctx context.Context, appid string) (string, error)
+	FindAll(ctx context.Context) ([]Merchant, error)
+	FindAppsByMchIds(ctx context.Context, mchids []string) ([]MerchantApp, error)
+}
+
+type GormMerchantDao struct {
+	db *gorm.DB
+}
+
+func NewMerchantDao(db *gorm.DB) MerchantDao {
+	return &GormMerchantDao{db: db}
+}
+
+func (d *GormMerchantDao) Upsert(ctx context.Context, m Merchant, appids []string) error {
+	now := time.Now()
+	m.Ctime = now
+	m.Utime = now
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "mch_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"certificate_serial_no", "private_key_path",
+				"wechat_pay_public_key_id", "wechat_pay_public_key_path", "api_v3_key_path", "notify_url", "utime"}),
+		}).Create(&m).Error
+		if err != nil {
+			return err
+		}
+		for _, appid := range appids {
+			// appid 换了出款商户号时直接改过去
+			err = tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "appid"}},
+				DoUpdates: clause.AssignmentColumns([]string{"mch_id", "utime"}),
+			}).Create(&MerchantApp{Appid: appid, MchId: m.MchId, Ctime: now, Utime: now}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d *GormMerchantDao) FindByMchId(ctx context.Context, mchid string) (Merchant, error) {
+	var m Merchant
+	err := d.db.WithContext(ctx).Where("mch_id = ?", mchid).First(&m).Error
+	return m, err
+}
+
+func (d *GormMerchantDao) FindMchIdByAppid(ctx context.Context, appid string) (string, error) {
+	var app MerchantApp
+	err := d.db.WithContext(ctx).Where("appid = ?", appid).First(&app).Error
+	return app.MchId, err
+}
+
+func (d *GormMerchantDao) FindAll(ctx context.Context) ([]Merchant, error) {
+	var merchants []Merchant
+	err := d.db.WithContext(ctx).Order("id ASC").Find(&merchants).Error
+	return merchants, err
+}
+
+func (d *GormMerchantDao) FindAppsByMchIds(ctx context.Context, mchids []string) ([]MerchantApp, error) {
+	var apps []MerchantApp
+	err := d.db.WithContext(ctx).Where("mch_id IN ?", mchids).Order("id ASC").Find(&apps).Error
+	return apps, err
+}
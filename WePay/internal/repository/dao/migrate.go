@@ -0,0 +1,314 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrations 下按数据库分目录，文件名为 <版本号>_<名字>.up.sql / .down.sql，版本号从 1 开始连续递增
+//
+//go:embed migrations
+var migrationFS embed.FS
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration 一个版本的表结构变更
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus 某个版本是否已经执行
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// SchemaMigration 已经执行过的版本
+type SchemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrator 按版本号顺序执行 migrations 目录下当前数据库的 SQL
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFS, path.Join("migrations", db.Dialector.Name()))
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Up 按顺序执行所有还没执行的版本，返回这次执行的版本。多个实例同时启动时只有拿到锁的实例执行
+func (m *Migrator) Up(ctx context.Context) (done []Migration, err error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.baseline(ctx, applied); err != nil {
+		return nil, err
+	}
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err := m.run(ctx, migration.Up, func(tx *gorm.DB) error {
+			return tx.Create(&SchemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migrate up %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down 从最新的版本开始回滚 steps 个已执行的版本，返回这次回滚的版本
+func (m *Migrator) Down(ctx context.Context, steps int) (done []Migration, err error) {
+	unlock, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		err := m.run(ctx, migration.Down, func(tx *gorm.DB) error {
+			return tx.Delete(&SchemaMigration{}, migration.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migrate down %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		record, ok := applied[migration.Version]
+		res = append(res, MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			Applied:   ok,
+			AppliedAt: record.AppliedAt,
+		})
+	}
+	return res, nil
+}
+
+const (
+	migrateLockName          = "wepay_migrate"
+	migrateLockKey     int64 = 0x77657061796d6967 // pg_advisory_lock 的 key 只能是整数
+	migrateLockTimeout       = 5 * time.Minute
+)
+
+// sqliteMigrateMu SQLite 只在单机使用，进程内的锁就够了
+var sqliteMigrateMu sync.Mutex
+
+// lock 拿数据库的咨询锁，防止多个实例同时执行同一个版本。
+// MySQL 和 PostgreSQL 的咨询锁属于连接，所以加锁和解锁要在同一个连接上
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	driver := m.db.Dialector.Name()
+	if driver != DriverMySQL && driver != DriverPostgres {
+		sqliteMigrateMu.Lock()
+		return sqliteMigrateMu.Unlock, nil
+	}
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var unlockSQL string
+	var unlockArg any
+	if driver == DriverMySQL {
+		// 超时返回 0，出错返回 NULL
+		var got sql.NullInt64
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrateLockName,
+			int(migrateLockTimeout.Seconds())).Scan(&got)
+		if err == nil && got.Int64 != 1 {
+			err = fmt.Errorf("get lock %s timeout", migrateLockName)
+		}
+		unlockSQL, unlockArg = "SELECT RELEASE_LOCK(?)", migrateLockName
+	} else {
+		_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrateLockKey)
+		unlockSQL, unlockArg = "SELECT pg_advisory_unlock($1)", migrateLockKey
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("lock migrations: %w", err)
+	}
+	return func() {
+		// 请求取消了也要解锁，解锁失败时关闭连接也会释放锁
+		_, _ = conn.ExecContext(context.WithoutCancel(ctx), unlockSQL, unlockArg)
+		conn.Close()
+	}, nil
+}
+
+// run 在一个事务里执行 SQL 并记录版本。MySQL 的 DDL 会隐式提交，失败时需要人工处理
+func (m *Migrator) run(ctx context.Context, sql string, record func(tx *gorm.DB) error) error {
+	return m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, stmt := range splitStatements(sql) {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+		return record(tx)
+	})
+}
+
+// baseline 之前用 AutoMigrate 建的库已经有 0001 的表，但是没有执行记录，把 0001 记为已执行。
+// 之后的版本照常执行，表结构和 0001 不一致时会在执行后面的版本时报错
+func (m *Migrator) baseline(ctx context.Context, applied map[int64]SchemaMigration) error {
+	if len(applied) > 0 || !m.db.WithContext(ctx).Migrator().HasTable("transfer_request_records") {
+		return nil
+	}
+	first := m.migrations[0]
+	record := SchemaMigration{Version: first.Version, Name: first.Name, AppliedAt: time.Now()}
+	if err := m.db.WithContext(ctx).Create(&record).Error; err != nil {
+		return fmt.Errorf("baseline %04d_%s: %w", first.Version, first.Name, err)
+	}
+	applied[first.Version] = record
+	return nil
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int64]SchemaMigration, error) {
+	// 各个数据库都支持的写法，不用 AutoMigrate
+	err := m.db.WithContext(ctx).Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`).Error
+	if err != nil {
+		return nil, err
+	}
+	var records []SchemaMigration
+	if err := m.db.WithContext(ctx).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	res := make(map[int64]SchemaMigration, len(records))
+	for _, record := range records {
+		res[record.Version] = record
+	}
+	return res, nil
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for %s: %w", path.Base(dir), err)
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFileRe.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names: %s, %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	res := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down", migration.Version, migration.Name)
+		}
+		res = append(res, *migration)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+	for i, migration := range res {
+		if migration.Version != int64(i+1) {
+			return nil, fmt.Errorf("migration versions must be consecutive from 1, missing %d", i+1)
+		}
+	}
+	return res, nil
+}
+
+// splitStatements 按行尾的分号拆分 SQL，去掉注释行。MySQL 驱动默认不允许一次执行多条语句
+func splitStatements(sql string) []string {
+	var (
+		stmts []string
+		buf   strings.Builder
+	)
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(buf.String()), ";"))
+			buf.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(buf.String()); rest != "" {
+		stmts = append(stmts, rest)
+	}
+	return stmts
+}
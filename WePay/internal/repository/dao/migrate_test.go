@@ -0,0 +1,191 @@
+package dao
+
+import (
+	"context"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestMigrator_UpDown(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		ctx := context.Background()
+		m, err := NewMigrator(db)
+		require.NoError(t, err)
+
+		done, err := m.Up(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, done, "InitTable 已经执行过所有版本")
+		status, err := m.Status(ctx)
+		require.NoError(t, err)
+		require.NotEmpty(t, status)
+		for _, s := range status {
+			assert.True(t, s.Applied, s.Name)
+		}
+
+		done, err = m.Down(ctx, 1)
+		require.NoError(t, err)
+		require.Len(t, done, 1)
+		assert.Equal(t, status[len(status)-1].Version, done[0].Version)
+		status, err = m.Status(ctx)
+		require.NoError(t, err)
+		assert.False(t, status[len(status)-1].Applied)
+
+		done, err = m.Down(ctx, len(status))
+		require.NoError(t, err)
+		assert.Len(t, done, len(status)-1)
+		assert.False(t, db.Migrator().HasTable("transfer_request_records"))
+
+		done, err = m.Up(ctx)
+		require.NoError(t, err)
+		assert.Len(t, done, len(status))
+		assert.True(t, db.Migrator().HasTable("transfer_request_records"))
+	})
+}
+
+// TestMigrator_ConcurrentUp 多个实例同时启动时每个版本只执行一次
+func TestMigrator_ConcurrentUp(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		ctx := context.Background()
+		m, err := NewMigrator(db)
+		require.NoError(t, err)
+		_, err = m.Down(ctx, len(m.migrations))
+		require.NoError(t, err)
+
+		var (
+			wg    sync.WaitGroup
+			mu    sync.Mutex
+			total int
+		)
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m, err := NewMigrator(db)
+				if !assert.NoError(t, err) {
+					return
+				}
+				done, err := m.Up(ctx)
+				assert.NoError(t, err)
+				mu.Lock()
+				total += len(done)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, len(m.migrations), total)
+	})
+}
+
+// baselineUser、baselineTransferRequestRecord 是换成 SQL 迁移之前、最初用 AutoMigrate 建表时的模型
+type baselineUser struct {
+	Id       int64  `gorm:"primaryKey;autoIncrement"`
+	WxOpenId string `gorm:"uniqueIndex;type:varchar(128)"`
+	Username string
+	Balance  int64
+}
+
+func (baselineUser) TableName() string {
+	return "users"
+}
+
+type baselineTransferRequestRecord struct {
+	ID          int64 `gorm:"primaryKey;autoIncrement"`
+	OutBillNo   string
+	Openid      string
+	MchId       string
+	Amount      int64
+	Remark      string
+	SceneId     string
+	Status      string
+	PackageInfo string
+	Ctime       time.Time
+	Utime       time.Time
+}
+
+func (baselineTransferRequestRecord) TableName() string {
+	return "transfer_request_records"
+}
+
+// TestMigrator_UpgradeFromAutoMigrate 用最初的 AutoMigrate 建的库，跳过 0001，执行后面的版本后可以正常读写
+func TestMigrator_UpgradeFromAutoMigrate(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		ctx := context.Background()
+		m, err := NewMigrator(db)
+		require.NoError(t, err)
+		_, err = m.Down(ctx, len(m.migrations))
+		require.NoError(t, err)
+		require.NoError(t, db.Migrator().DropTable(&SchemaMigration{}))
+
+		require.NoError(t, db.AutoMigrate(&baselineUser{}, &baselineTransferRequestRecord{}))
+		now := time.Now()
+		require.NoError(t, db.Create(&baselineTransferRequestRecord{OutBillNo: "old", Openid: "o1", Amount: 100,
+			Status: "SUCCESS", PackageInfo: "pk-old", Ctime: now, Utime: now}).Error)
+
+		done, err := m.Up(ctx)
+		require.NoError(t, err)
+		require.Len(t, done, len(m.migrations)-1)
+		assert.Equal(t, int64(2), done[0].Version)
+		status, err := m.Status(ctx)
+		require.NoError(t, err)
+		for _, s := range status {
+			assert.True(t, s.Applied, s.Name)
+		}
+
+		d := NewTransferDao(db)
+		require.NoError(t, d.CreateTransferRequestRecord(ctx, &TransferRequestRecord{OutBillNo: "new", Openid: "o1",
+			Amount: 200, Type: "reward", Status: "ACCEPTED", PackageInfo: "pk-new", DeviceId: "d1", Ctime: now, Utime: now}))
+		old, err := d.GetTransferRecordByOutBillNo(ctx, "old")
+		require.NoError(t, err)
+		assert.Equal(t, int64(100), old.Amount)
+		assert.Empty(t, old.FailReason)
+	})
+}
+
+func TestMigrator_UniqueKeys(t *testing.T) {
+	forEachDB(t, func(t *testing.T, db *gorm.DB) {
+		now := time.Now()
+		record := func(outbillno, packageInfo string) *TransferRequestRecord {
+			return &TransferRequestRecord{OutBillNo: outbillno, Openid: "o1", PackageInfo: packageInfo, Ctime: now, Utime: now}
+		}
+		require.NoError(t, db.Create(record("b1", "pk1")).Error)
+		assert.Error(t, db.Create(record("b1", "pk2")).Error, "out_bill_no 重复")
+		assert.Error(t, db.Create(record("b2", "pk1")).Error, "package_info 重复")
+		assert.NoError(t, db.Create(record("b2", "pk2")).Error)
+	})
+}
+
+// 每种数据库的版本必须一一对应
+func TestMigrations_SameVersions(t *testing.T) {
+	dirs, err := fs.ReadDir(migrationFS, "migrations")
+	require.NoError(t, err)
+	var expected []Migration
+	for _, dir := range dirs {
+		migrations, err := loadMigrations(migrationFS, "migrations/"+dir.Name())
+		require.NoError(t, err, dir.Name())
+		if expected == nil {
+			expected = migrations
+			continue
+		}
+		require.Len(t, migrations, len(expected), dir.Name())
+		for i := range migrations {
+			assert.Equal(t, expected[i].Name, migrations[i].Name, dir.Name())
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	stmts := splitStatements(`-- 注释
+CREATE TABLE a (
+    id INT
+);
+
+CREATE INDEX idx_a ON a (id);
+DROP TABLE b`)
+	assert.Equal(t, []string{"CREATE TABLE a (\n    id INT\n)", "CREATE INDEX idx_a ON a (id)", "DROP TABLE b"}, stmts)
+}
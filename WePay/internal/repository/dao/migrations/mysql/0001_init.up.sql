@@ -0,0 +1,23 @@
+-- 与最初 AutoMigrate(&User{}, &TransferRequestRecord{}) 建出的表结构一致。
+-- 已经用 AutoMigrate 建过表的库没有执行记录，Migrator 会把这个版本记为已执行
+CREATE TABLE users (
+    id         BIGINT AUTO_INCREMENT PRIMARY KEY,
+    wx_open_id VARCHAR(128),
+    username   LONGTEXT,
+    balance    BIGINT,
+    UNIQUE INDEX idx_users_wx_open_id (wx_open_id)
+);
+
+CREATE TABLE transfer_request_records (
+    id           BIGINT AUTO_INCREMENT PRIMARY KEY,
+    out_bill_no  LONGTEXT,
+    openid       LONGTEXT,
+    mch_id       LONGTEXT,
+    amount       BIGINT,
+    remark       LONGTEXT,
+    scene_id     LONGTEXT,
+    status       LONGTEXT,
+    package_info LONGTEXT,
+    ctime        DATETIME(3),
+    utime        DATETIME(3)
+);
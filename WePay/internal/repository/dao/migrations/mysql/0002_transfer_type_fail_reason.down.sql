@@ -0,0 +1,8 @@
+DROP INDEX idx_ctime ON transfer_request_records;
+
+DROP INDEX idx_openid_ctime ON transfer_request_records;
+
+ALTER TABLE transfer_request_records
+    DROP COLUMN fail_reason,
+    DROP COLUMN type,
+    MODIFY openid LONGTEXT;
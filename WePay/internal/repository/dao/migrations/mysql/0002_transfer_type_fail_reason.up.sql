@@ -0,0 +1,9 @@
+-- 转账单记录转账类型和失败原因，按 openid、创建时间查询
+ALTER TABLE transfer_request_records
+    ADD COLUMN type LONGTEXT,
+    ADD COLUMN fail_reason LONGTEXT,
+    MODIFY openid VARCHAR(128);
+
+CREATE INDEX idx_openid_ctime ON transfer_request_records (openid, ctime);
+
+CREATE INDEX idx_ctime ON transfer_request_records (ctime);
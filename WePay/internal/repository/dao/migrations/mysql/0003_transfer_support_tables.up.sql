@@ -0,0 +1,71 @@
+-- 状态变更流水、待发送的转账请求、提现、审计日志和商户
+CREATE TABLE transfer_status_events (
+    id          BIGINT AUTO_INCREMENT PRIMARY KEY,
+    out_bill_no VARCHAR(128),
+    from_status VARCHAR(32),
+    to_status   VARCHAR(32),
+    source      VARCHAR(32),
+    payload_ref VARCHAR(255),
+    ctime       DATETIME(3),
+    INDEX idx_transfer_status_events_out_bill_no (out_bill_no)
+);
+
+CREATE TABLE transfer_outboxes (
+    id              BIGINT AUTO_INCREMENT PRIMARY KEY,
+    out_bill_no     VARCHAR(128),
+    payload         TEXT,
+    status          VARCHAR(32),
+    attempts        BIGINT,
+    next_retry_time DATETIME(3),
+    last_error      VARCHAR(1024),
+    ctime           DATETIME(3),
+    utime           DATETIME(3),
+    UNIQUE INDEX idx_transfer_outboxes_out_bill_no (out_bill_no),
+    INDEX idx_status_next_retry (status, next_retry_time)
+);
+
+CREATE TABLE withdrawals (
+    id          BIGINT AUTO_INCREMENT PRIMARY KEY,
+    openid      VARCHAR(128),
+    out_bill_no VARCHAR(128),
+    amount      BIGINT,
+    status      VARCHAR(32),
+    ctime       DATETIME(3),
+    utime       DATETIME(3),
+    INDEX idx_withdrawals_openid (openid),
+    UNIQUE INDEX idx_withdrawals_out_bill_no (out_bill_no)
+);
+
+CREATE TABLE audit_logs (
+    id       BIGINT AUTO_INCREMENT PRIMARY KEY,
+    operator VARCHAR(64),
+    action   VARCHAR(64),
+    target   VARCHAR(128),
+    detail   LONGTEXT,
+    reason   LONGTEXT,
+    ctime    DATETIME(3),
+    INDEX idx_audit_logs_target (target)
+);
+
+CREATE TABLE merchants (
+    id                         BIGINT AUTO_INCREMENT PRIMARY KEY,
+    mch_id                     VARCHAR(32),
+    certificate_serial_no      LONGTEXT,
+    private_key_path           LONGTEXT,
+    wechat_pay_public_key_id   LONGTEXT,
+    wechat_pay_public_key_path LONGTEXT,
+    notify_url                 LONGTEXT,
+    ctime                      DATETIME(3),
+    utime                      DATETIME(3),
+    UNIQUE INDEX idx_merchants_mch_id (mch_id)
+);
+
+CREATE TABLE merchant_apps (
+    id     BIGINT AUTO_INCREMENT PRIMARY KEY,
+    appid  VARCHAR(32),
+    mch_id VARCHAR(32),
+    ctime  DATETIME(3),
+    utime  DATETIME(3),
+    UNIQUE INDEX idx_merchant_apps_appid (appid),
+    INDEX idx_merchant_apps_mch_id (mch_id)
+);
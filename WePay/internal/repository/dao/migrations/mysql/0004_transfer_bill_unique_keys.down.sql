@@ -0,0 +1,7 @@
+DROP INDEX uk_transfer_request_records_package_info ON transfer_request_records;
+
+DROP INDEX uk_transfer_request_records_out_bill_no ON transfer_request_records;
+
+ALTER TABLE transfer_request_records
+    MODIFY out_bill_no LONGTEXT,
+    MODIFY package_info LONGTEXT;
@@ -0,0 +1,8 @@
+-- 同一个商户单号、同一个 package_info 只能有一张转账单。唯一索引要求列不能是 LONGTEXT
+ALTER TABLE transfer_request_records
+    MODIFY out_bill_no VARCHAR(128),
+    MODIFY package_info VARCHAR(255);
+
+CREATE UNIQUE INDEX uk_transfer_request_records_out_bill_no ON transfer_request_records (out_bill_no);
+
+CREATE UNIQUE INDEX uk_transfer_request_records_package_info ON transfer_request_records (package_info);
@@ -0,0 +1,11 @@
+DROP TABLE risk_rules;
+
+DROP INDEX idx_client_ip_ctime ON transfer_request_records;
+
+DROP INDEX idx_device_ctime ON transfer_request_records;
+
+ALTER TABLE transfer_request_records
+    DROP COLUMN risk_reasons,
+    DROP COLUMN risk_decision,
+    DROP COLUMN client_ip,
+    DROP COLUMN device_id;
@@ -0,0 +1,17 @@
+-- 风控：转账单记录设备、IP 和风控结果，风控规则每次修改都保留一个版本
+ALTER TABLE transfer_request_records
+    ADD COLUMN device_id VARCHAR(128) NOT NULL DEFAULT '',
+    ADD COLUMN client_ip VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN risk_decision VARCHAR(16) NOT NULL DEFAULT '',
+    ADD COLUMN risk_reasons VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_device_ctime ON transfer_request_records (device_id, ctime);
+
+CREATE INDEX idx_client_ip_ctime ON transfer_request_records (client_ip, ctime);
+
+CREATE TABLE risk_rules (
+    id       BIGINT AUTO_INCREMENT PRIMARY KEY,
+    rules    LONGTEXT NOT NULL,
+    operator VARCHAR(64) NOT NULL,
+    ctime    DATETIME(3)
+);
@@ -0,0 +1 @@
+DROP INDEX idx_openid_id ON transfer_request_records;
@@ -0,0 +1,3 @@
+DROP TABLE transfer_request_records;
+
+DROP TABLE users;
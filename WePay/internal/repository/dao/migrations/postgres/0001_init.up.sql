@@ -0,0 +1,23 @@
+-- 与最初 AutoMigrate(&User{}, &TransferRequestRecord{}) 建出的表结构一致。
+-- 已经用 AutoMigrate 建过表的库没有执行记录，Migrator 会把这个版本记为已执行
+CREATE TABLE users (
+    id         BIGSERIAL PRIMARY KEY,
+    wx_open_id VARCHAR(128),
+    username   TEXT,
+    balance    BIGINT
+);
+CREATE UNIQUE INDEX idx_users_wx_open_id ON users (wx_open_id);
+
+CREATE TABLE transfer_request_records (
+    id           BIGSERIAL PRIMARY KEY,
+    out_bill_no  TEXT,
+    openid       TEXT,
+    mch_id       TEXT,
+    amount       BIGINT,
+    remark       TEXT,
+    scene_id     TEXT,
+    status       TEXT,
+    package_info TEXT,
+    ctime        TIMESTAMPTZ,
+    utime        TIMESTAMPTZ
+);
@@ -0,0 +1,8 @@
+DROP INDEX idx_ctime;
+
+DROP INDEX idx_openid_ctime;
+
+ALTER TABLE transfer_request_records
+    DROP COLUMN fail_reason,
+    DROP COLUMN type,
+    ALTER COLUMN openid TYPE TEXT;
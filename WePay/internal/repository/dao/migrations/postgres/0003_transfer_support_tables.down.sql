@@ -0,0 +1,11 @@
+DROP TABLE merchant_apps;
+
+DROP TABLE merchants;
+
+DROP TABLE audit_logs;
+
+DROP TABLE withdrawals;
+
+DROP TABLE transfer_outboxes;
+
+DROP TABLE transfer_status_events;
@@ -0,0 +1,71 @@
+-- 状态变更流水、待发送的转账请求、提现、审计日志和商户
+CREATE TABLE transfer_status_events (
+    id          BIGSERIAL PRIMARY KEY,
+    out_bill_no VARCHAR(128),
+    from_status VARCHAR(32),
+    to_status   VARCHAR(32),
+    source      VARCHAR(32),
+    payload_ref VARCHAR(255),
+    ctime       TIMESTAMPTZ
+);
+CREATE INDEX idx_transfer_status_events_out_bill_no ON transfer_status_events (out_bill_no);
+
+CREATE TABLE transfer_outboxes (
+    id              BIGSERIAL PRIMARY KEY,
+    out_bill_no     VARCHAR(128),
+    payload         TEXT,
+    status          VARCHAR(32),
+    attempts        BIGINT,
+    next_retry_time TIMESTAMPTZ,
+    last_error      VARCHAR(1024),
+    ctime           TIMESTAMPTZ,
+    utime           TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX idx_transfer_outboxes_out_bill_no ON transfer_outboxes (out_bill_no);
+CREATE INDEX idx_status_next_retry ON transfer_outboxes (status, next_retry_time);
+
+CREATE TABLE withdrawals (
+    id          BIGSERIAL PRIMARY KEY,
+    openid      VARCHAR(128),
+    out_bill_no VARCHAR(128),
+    amount      BIGINT,
+    status      VARCHAR(32),
+    ctime       TIMESTAMPTZ,
+    utime       TIMESTAMPTZ
+);
+CREATE INDEX idx_withdrawals_openid ON withdrawals (openid);
+CREATE UNIQUE INDEX idx_withdrawals_out_bill_no ON withdrawals (out_bill_no);
+
+CREATE TABLE audit_logs (
+    id       BIGSERIAL PRIMARY KEY,
+    operator VARCHAR(64),
+    action   VARCHAR(64),
+    target   VARCHAR(128),
+    detail   TEXT,
+    reason   TEXT,
+    ctime    TIMESTAMPTZ
+);
+CREATE INDEX idx_audit_logs_target ON audit_logs (target);
+
+CREATE TABLE merchants (
+    id                         BIGSERIAL PRIMARY KEY,
+    mch_id                     VARCHAR(32),
+    certificate_serial_no      TEXT,
+    private_key_path           TEXT,
+    wechat_pay_public_key_id   TEXT,
+    wechat_pay_public_key_path TEXT,
+    notify_url                 TEXT,
+    ctime                      TIMESTAMPTZ,
+    utime                      TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX idx_merchants_mch_id ON merchants (mch_id);
+
+CREATE TABLE merchant_apps (
+    id     BIGSERIAL PRIMARY KEY,
+    appid  VARCHAR(32),
+    mch_id VARCHAR(32),
+    ctime  TIMESTAMPTZ,
+    utime  TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX idx_merchant_apps_appid ON merchant_apps (appid);
+CREATE INDEX idx_merchant_apps_mch_id ON merchant_apps (mch_id);
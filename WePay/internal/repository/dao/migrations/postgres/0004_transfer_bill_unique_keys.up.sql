@@ -0,0 +1,4 @@
+-- 同一个商户单号、同一个 package_info 只能有一张转账单
+CREATE UNIQUE INDEX uk_transfer_request_records_out_bill_no ON transfer_request_records (out_bill_no);
+
+CREATE UNIQUE INDEX uk_transfer_request_records_package_info ON transfer_request_records (package_info);
@@ -0,0 +1 @@
+ALTER TABLE merchants DROP COLUMN api_v3_key_path;
@@ -0,0 +1 @@
+DROP INDEX idx_openid_id;
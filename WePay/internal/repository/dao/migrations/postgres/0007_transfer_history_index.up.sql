@@ -0,0 +1,2 @@
+-- 转账历史按 openid 过滤、按 id 倒序分页，(openid, ctime) 的索引不能用于排序
+CREATE INDEX idx_openid_id ON transfer_request_records (openid, id);
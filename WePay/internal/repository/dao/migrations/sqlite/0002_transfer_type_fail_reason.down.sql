@@ -0,0 +1,7 @@
+DROP INDEX idx_ctime;
+
+DROP INDEX idx_openid_ctime;
+
+ALTER TABLE transfer_request_records DROP COLUMN fail_reason;
+
+ALTER TABLE transfer_request_records DROP COLUMN type;
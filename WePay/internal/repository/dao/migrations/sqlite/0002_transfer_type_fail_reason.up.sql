@@ -0,0 +1,8 @@
+-- 转账单记录转账类型和失败原因，按 openid、创建时间查询。SQLite 的列类型只影响类型亲和性，openid 不用改类型
+ALTER TABLE transfer_request_records ADD COLUMN type TEXT;
+
+ALTER TABLE transfer_request_records ADD COLUMN fail_reason TEXT;
+
+CREATE INDEX idx_openid_ctime ON transfer_request_records (openid, ctime);
+
+CREATE INDEX idx_ctime ON transfer_request_records (ctime);
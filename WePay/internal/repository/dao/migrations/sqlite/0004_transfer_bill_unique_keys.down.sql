@@ -0,0 +1,3 @@
+DROP INDEX uk_transfer_request_records_package_info;
+
+DROP INDEX uk_transfer_request_records_out_bill_no;
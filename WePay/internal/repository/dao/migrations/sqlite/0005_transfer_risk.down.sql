@@ -0,0 +1,13 @@
+DROP TABLE risk_rules;
+
+DROP INDEX idx_client_ip_ctime;
+
+DROP INDEX idx_device_ctime;
+
+ALTER TABLE transfer_request_records DROP COLUMN risk_reasons;
+
+ALTER TABLE transfer_request_records DROP COLUMN risk_decision;
+
+ALTER TABLE transfer_request_records DROP COLUMN client_ip;
+
+ALTER TABLE transfer_request_records DROP COLUMN device_id;
@@ -0,0 +1,2 @@
+-- 回调通知的 resource 用商户的 APIv3 密钥加密，和其他密钥一样只保存文件路径
+ALTER TABLE merchants ADD COLUMN api_v3_key_path VARCHAR(255) NOT NULL DEFAULT '';
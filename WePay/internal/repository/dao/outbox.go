@@ -0,0 +1,122 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	outboxStatusPending = "PENDING"
+	outboxStatusDone    = "DONE"
+	outboxStatusFailed  = "FAILED"
+	outboxStatusHeld    = "HELD"
+)
+
+// TransferOutbox 待发送给微信的转账请求
+type TransferOutbox struct {
+	ID            int64  `gorm:"primaryKey;autoIncrement"`
+	OutBillNo     string `gorm:"type:varchar(128);uniqueIndex"`
+	Payload       string `gorm:"type:text"`
+	Status        string `gorm:"type:varchar(32);index:idx_status_next_retry,priority:1"`
+	Attempts      int
+	NextRetryTime time.Time `gorm:"index:idx_status_next_retry,priority:2"`
+	LastError     string    `gorm:"type:varchar(1024)"`
+	Ctime         time.Time
+	Utime         time.Time
+}
+
+type TransferOutboxDao interface {
+	// FindDue 找出到了重试时间还没发送成功的请求
+	FindDue(ctx context.Context, now time.Time, limit int) ([]TransferOutbox, error)
+	// Claim 抢占一条请求，抢占成功后在 leaseUntil 之前其他实例不会再发送它，并累加尝试次数
+	Claim(ctx context.Context, id int64, now, leaseUntil time.Time) (bool, error)
+	MarkDone(ctx context.Context, id int64) error
+	MarkRetry(ctx context.Context, id int64, nextRetryTime time.Time, lastError string) error
+	MarkFailed(ctx context.Context, id int64, lastError string) error
+	// ReleaseHeld 把审核中的请求改为在 sendAt 发送，请求不在审核中时返回 false
+	ReleaseHeld(ctx context.Context, outbillno string, sendAt time.Time) (bool, error)
+	// DiscardHeld 把审核中的请求改为失败，不再发送，请求不在审核中时返回 false
+	DiscardHeld(ctx context.Context, outbillno string, reason string) (bool, error)
+}
+
+type GormTransferOutboxDao struct {
+	db *gorm.DB
+}
+
+func NewTransferOutboxDao(db *gorm.DB) TransferOutboxDao {
+	return &GormTransferOutboxDao{db: db}
+}
+
+func (d *GormTransferOutboxDao) FindDue(ctx context.Context, now time.Time, limit int) ([]TransferOutbox, error) {
+	var res []TransferOutbox
+	err := d.db.WithContext(ctx).
+		Where("status = ? AND next_retry_time <= ?", outboxStatusPending, now).
+		Order("next_retry_time ASC").Limit(limit).Find(&res).Error
+	return res, err
+}
+
+func (d *GormTransferOutboxDao) Claim(ctx context.Context, id int64, now, leaseUntil time.Time) (bool, error) {
+	res := d.db.WithContext(ctx).Model(&TransferOutbox{}).
+		Where("id = ? AND status = ? AND next_retry_time <= ?", id, outboxStatusPending, now).
+		Updates(map[string]interface{}{
+			"next_retry_time": leaseUntil,
+			"attempts":        gorm.Expr("attempts + 1"),
+			"utime":           now,
+		})
+	return res.RowsAffected == 1, res.Error
+}
+
+func (d *GormTransferOutboxDao) MarkDone(ctx context.Context, id int64) error {
+	return d.updateStatus(ctx, id, outboxStatusDone, map[string]interface{}{"last_error": ""})
+}
+
+func (d *GormTransferOutboxDao) MarkRetry(ctx context.Context, id int64, nextRetryTime time.Time, lastError string) error {
+	return d.updateStatus(ctx, id, outboxStatusPending, map[string]interface{}{
+		"next_retry_time": nextRetryTime,
+		"last_error":      truncate(lastError, 1024),
+	})
+}
+
+func (d *GormTransferOutboxDao) MarkFailed(ctx context.Context, id int64, lastError string) error {
+	return d.updateStatus(ctx, id, outboxStatusFailed, map[string]interface{}{
+		"last_error": truncate(lastError, 1024),
+	})
+}
+
+func (d *GormTransferOutboxDao) ReleaseHeld(ctx context.Context, outbillno string, sendAt time.Time) (bool, error) {
+	return d.updateHeld(ctx, outbillno, map[string]interface{}{
+		"status":          outboxStatusPending,
+		"next_retry_time": sendAt,
+	})
+}
+
+func (d *GormTransferOutboxDao) DiscardHeld(ctx context.Context, outbillno string, reason string) (bool, error) {
+	return d.updateHeld(ctx, outbillno, map[string]interface{}{
+		"status":     outboxStatusFailed,
+		"last_error": truncate(reason, 1024),
+	})
+}
+
+// updateHeld 只修改还在审核中的请求，两个管理员同时审核时只有一个能成功
+func (d *GormTransferOutboxDao) updateHeld(ctx context.Context, outbillno string, fields map[string]interface{}) (bool, error) {
+	fields["utime"] = time.Now()
+	res := d.db.WithContext(ctx).Model(&TransferOutbox{}).
+		Where("out_bill_no = ? AND status = ?", outbillno, outboxStatusHeld).
+		Updates(fields)
+	return res.RowsAffected == 1, res.Error
+}
+
+func (d *GormTransferOutboxDao) updateStatus(ctx context.Context, id int64, status string, fields map[string]interface{}) error {
+	fields["status"] = status
+	fields["utime"] = time.Now()
+	return d.db.WithContext(ctx).Model(&TransferOutbox{}).Where("id = ?", id).Updates(fields).Error
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
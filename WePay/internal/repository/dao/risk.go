@@ -0,0 +1,41 @@
+package dao
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RiskRule 风控规则的一个版本，每次修改都新增一行，最新的一行生效
+type RiskRule struct {
+	ID       int64  `gorm:"primaryKey;autoIncrement"`
+	Rules    string `gorm:"type:text"` // domain.RiskRules 的 JSON
+	Operator string `gorm:"type:varchar(64)"`
+	Ctime    time.Time
+}
+
+type RiskDao interface {
+	// Latest 返回最新的规则，还没配置过时返回 ErrRecordNotFound
+	Latest(ctx context.Context) (RiskRule, error)
+	Insert(ctx context.Context, rule RiskRule) error
+}
+
+type GormRiskDao struct {
+	db *gorm.DB
+}
+
+func NewRiskDao(db *gorm.DB) RiskDao {
+	return &GormRiskDao{db: db}
+}
+
+func (d *GormRiskDao) Latest(ctx context.Context) (RiskRule, error) {
+	var rule RiskRule
+	err := d.db.WithContext(ctx).Order("id DESC").First(&rule).Error
+	return rule, err
+}
+
+func (d *GormRiskDao) Insert(ctx context.Context, rule RiskRule) error {
+	rule.Ctime = time.Now()
+	return d.db.WithContext(ctx).Create(&rule).Error
+}
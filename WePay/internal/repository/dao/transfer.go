@@ -2,31 +2,95 @@ package dao
 
 import (
 	"context"
+	"errors"
+	"slices"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var ErrRecordNotFound = gorm.ErrRecordNotFound
+
+// ErrDuplicatedKey 写入的记录和已有的记录唯一索引冲突，比如同一个转账单号
+var ErrDuplicatedKey = gorm.ErrDuplicatedKey
+
+// ErrTransferStatusFinal 转账单已经是终态，不能再改成其他状态
+var ErrTransferStatusFinal = errors.New("转账单已经是终态，不能再修改状态")
+
 type TransferDao interface {
 	CreateTransferRequestRecord(ctx context.Context, req *TransferRequestRecord) error
-	UpdateTransferRequestStatus(ctx context.Context, outbillno string, status string) error
+	// CreateTransferRequestRecordWithOutbox 在同一个事务里写入转账单和待发送的请求
+	CreateTransferRequestRecordWithOutbox(ctx context.Context, req *TransferRequestRecord, outbox *TransferOutbox) error
+	// UpdateTransferRequestStatus 修改 Status，并在同一个事务里记录状态变更事件，返回修改前的转账单
+	UpdateTransferRequestStatus(ctx context.Context, outbillno string, status string, finalStatuses []string, event TransferStatusEvent) (TransferRequestRecord, error)
+	// ConfirmTransferRequest 在同一个事务里把处于 from 状态的转账单改为 to 并记录状态变更事件，credit 为 true 时把金额计入用户余额。
+	// 只有条件更新恰好改了一行时才入账，返回修改前的转账单和是否修改了
+	ConfirmTransferRequest(ctx context.Context, outbillno, from, to string, credit bool, event TransferStatusEvent) (TransferRequestRecord, bool, error)
+	UpdateTransferRequestFailReason(ctx context.Context, outbillno string, reason string) error
+	UpdateTransferRequestPackageInfo(ctx context.Context, outbillno string, packageInfo string) error
 	GetTransferStatus(ctx context.Context, outbillno string) (string, error)
 	GetTransferRecordByOutBillNo(ctx context.Context, outbillno string) (TransferRequestRecord, error)
 	GetTransferRecordByPackageInfo(ctx context.Context, packageInfo string) (TransferRequestRecord, error)
+	ListTransferRecords(ctx context.Context, query TransferRecordQuery) ([]TransferRequestRecord, error)
+	// ListTransferStatusEvents 按发生顺序返回转账单的状态变更事件
+	ListTransferStatusEvents(ctx context.Context, outbillno string) ([]TransferStatusEvent, error)
+	// SumTransferAmountByStatus 统计商户 mchid 在 since 之后创建、处于 statuses 这些状态的转账单的总金额
+	SumTransferAmountByStatus(ctx context.Context, mchid string, statuses []string, since time.Time) (int64, error)
+	// CountTransferRecordsByStatus 按状态统计处于 statuses 这些状态的转账单数，没有转账单的状态不返回
+	CountTransferRecordsByStatus(ctx context.Context, statuses []string) (map[string]int64, error)
+	// CountTransferRecords 统计符合条件的转账单数，忽略分页条件
+	CountTransferRecords(ctx context.Context, query TransferRecordQuery) (int64, error)
+	// AvgTransferAmount 符合条件的转账单的平均金额和单数，忽略分页条件
+	AvgTransferAmount(ctx context.Context, query TransferRecordQuery) (int64, int64, error)
+	// FirstTransferTime openid 的第一张转账单的创建时间，没有时返回零值
+	FirstTransferTime(ctx context.Context, openid string) (time.Time, error)
 }
 
 type TransferRequestRecord struct {
-	ID          int64 `gorm:"primaryKey;autoIncrement"`
-	OutBillNo   string
-	Openid      string
+	ID          int64  `gorm:"primaryKey;autoIncrement;index:idx_openid_id,priority:2"`
+	OutBillNo   string `gorm:"type:varchar(128);uniqueIndex:uk_transfer_request_records_out_bill_no"`
+	Openid      string `gorm:"type:varchar(128);index:idx_openid_ctime,priority:1;index:idx_openid_id,priority:1"`
 	MchId       string
 	Amount      int64
 	Remark      string
 	SceneId     string
+	Type        string
 	Status      string
-	PackageInfo string
-	Ctime       time.Time
-	Utime       time.Time
+	PackageInfo string `gorm:"type:varchar(255);uniqueIndex:uk_transfer_request_records_package_info"`
+	FailReason  string
+	DeviceId    string `gorm:"type:varchar(128);index:idx_device_ctime,priority:1"`
+	ClientIp    string `gorm:"type:varchar(64);index:idx_client_ip_ctime,priority:1"`
+	// 风控结果和命中的规则
+	RiskDecision string    `gorm:"type:varchar(16)"`
+	RiskReasons  string    `gorm:"type:varchar(255)"`
+	Ctime        time.Time `gorm:"index:idx_openid_ctime,priority:2;index:idx_ctime;index:idx_device_ctime,priority:2;index:idx_client_ip_ctime,priority:2"`
+	Utime        time.Time
+}
+
+// TransferStatusEvent 转账单状态变更的流水，只追加不修改
+type TransferStatusEvent struct {
+	ID         int64  `gorm:"primaryKey;autoIncrement"`
+	OutBillNo  string `gorm:"type:varchar(128);index"`
+	FromStatus string `gorm:"type:varchar(32)"`
+	ToStatus   string `gorm:"type:varchar(32)"`
+	Source     string `gorm:"type:varchar(32)"`
+	PayloadRef string `gorm:"type:varchar(255)"`
+	Ctime      time.Time
+}
+
+// TransferRecordQuery 转账记录的分页查询条件，按 id 倒序做游标分页，为空的条件不过滤
+type TransferRecordQuery struct {
+	Openid    string
+	MchId     string
+	OutBillNo string
+	DeviceId  string
+	ClientIp  string
+	Status    string
+	StartTime time.Time
+	EndTime   time.Time
+	Cursor    int64
+	Limit     int
 }
 
 type GormTransferDao struct {
@@ -38,33 +102,227 @@ func NewTransferDao(db *gorm.DB) TransferDao {
 }
 
 func (d *GormTransferDao) CreateTransferRequestRecord(ctx context.Context, req *TransferRequestRecord) error {
-	return d.db.Create(req).Error
+	return d.db.WithContext(ctx).Create(req).Error
+}
+
+func (d *GormTransferDao) CreateTransferRequestRecordWithOutbox(ctx context.Context, req *TransferRequestRecord, outbox *TransferOutbox) error {
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(req).Error; err != nil {
+			return err
+		}
+		return tx.Create(outbox).Error
+	})
+}
+
+// UpdateTransferRequestStatus 修改 Status，状态没有变化时什么都不做。
+// 已经处于 finalStatuses 里的状态时返回 ErrTransferStatusFinal，过时的查询或回调不能把终态改回处理中
+func (d *GormTransferDao) UpdateTransferRequestStatus(ctx context.Context, outbillno string, status string, finalStatuses []string, event TransferStatusEvent) (TransferRequestRecord, error) {
+	var record TransferRequestRecord
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("out_bill_no = ?", outbillno).First(&record).Error
+		if err != nil {
+			return err
+		}
+		if record.Status == status {
+			return nil
+		}
+		if slices.Contains(finalStatuses, record.Status) {
+			return ErrTransferStatusFinal
+		}
+		now := time.Now()
+		err = tx.Model(&TransferRequestRecord{}).Where("id = ?", record.ID).Updates(
+			map[string]interface{}{
+				"status": status,
+				"utime":  now,
+			},
+		).Error
+		if err != nil {
+			return err
+		}
+		event.OutBillNo = outbillno
+		event.FromStatus = record.Status
+		event.ToStatus = status
+		event.Ctime = now
+		return tx.Create(&event).Error
+	})
+	return record, err
+}
+
+func (d *GormTransferDao) ConfirmTransferRequest(ctx context.Context, outbillno, from, to string, credit bool, event TransferStatusEvent) (TransferRequestRecord, bool, error) {
+	var record TransferRequestRecord
+	confirmed := false
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		res := tx.Model(&TransferRequestRecord{}).Where("out_bill_no = ? AND status = ?", outbillno, from).Updates(
+			map[string]interface{}{
+				"status": to,
+				"utime":  now,
+			},
+		)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected != 1 {
+			// 已经被并发的请求确认过了，或者不在 from 状态
+			return nil
+		}
+		if err := tx.Where("out_bill_no = ?", outbillno).First(&record).Error; err != nil {
+			return err
+		}
+		record.Status = from
+		event.OutBillNo = outbillno
+		event.FromStatus = from
+		event.ToStatus = to
+		event.Ctime = now
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+		if credit {
+			if err := upsertBalance(tx, record.Openid, record.Amount); err != nil {
+				return err
+			}
+		}
+		confirmed = true
+		return nil
+	})
+	return record, confirmed && err == nil, err
+}
+
+func (d *GormTransferDao) UpdateTransferRequestFailReason(ctx context.Context, outbillno string, reason string) error {
+	return d.db.WithContext(ctx).Model(&TransferRequestRecord{}).Where("out_bill_no = ?", outbillno).Updates(
+		map[string]interface{}{
+			"fail_reason": reason,
+			"utime":       time.Now(),
+		},
+	).Error
 }
 
-// UpdateTransferRequestStatus 修改 Status
-func (d *GormTransferDao) UpdateTransferRequestStatus(ctx context.Context, outbillno string, status string) error {
-	return d.db.Model(&TransferRequestRecord{}).Where("out_bill_no = ?", outbillno).Updates(
+func (d *GormTransferDao) UpdateTransferRequestPackageInfo(ctx context.Context, outbillno string, packageInfo string) error {
+	return d.db.WithContext(ctx).Model(&TransferRequestRecord{}).Where("out_bill_no = ?", outbillno).Updates(
 		map[string]interface{}{
-			"status": status,
-			"utime":  time.Now(),
+			"package_info": packageInfo,
+			"utime":        time.Now(),
 		},
 	).Error
 }
 
 func (d *GormTransferDao) GetTransferStatus(ctx context.Context, outbillno string) (string, error) {
 	var status string
-	err := d.db.Model(&TransferRequestRecord{}).Where("out_bill_no = ?", outbillno).Select("status").Scan(&status).Error
+	err := d.db.WithContext(ctx).Model(&TransferRequestRecord{}).Where("out_bill_no = ?", outbillno).Select("status").Scan(&status).Error
 	return status, err
 }
 
 func (d *GormTransferDao) GetTransferRecordByOutBillNo(ctx context.Context, outbillno string) (TransferRequestRecord, error) {
 	var record TransferRequestRecord
-	err := d.db.Model(&TransferRequestRecord{}).Where("out_bill_no = ?", outbillno).First(&record).Error
+	err := d.db.WithContext(ctx).Model(&TransferRequestRecord{}).Where("out_bill_no = ?", outbillno).First(&record).Error
 	return record, err
 }
 
 func (d *GormTransferDao) GetTransferRecordByPackageInfo(ctx context.Context, packageInfo string) (TransferRequestRecord, error) {
 	var record TransferRequestRecord
-	err := d.db.Model(&TransferRequestRecord{}).Where("package_info = ?", packageInfo).First(&record).Error
+	err := d.db.WithContext(ctx).Model(&TransferRequestRecord{}).Where("package_info = ?", packageInfo).First(&record).Error
 	return record, err
 }
+
+// ListTransferRecords 按 id 倒序分页查询转账记录，Cursor 为上一页最后一条记录的 id。
+// 按 openid 查询时用 (openid, id) 的索引排序
+func (d *GormTransferDao) ListTransferRecords(ctx context.Context, query TransferRecordQuery) ([]TransferRequestRecord, error) {
+	var records []TransferRequestRecord
+	tx := d.where(d.db.WithContext(ctx).Model(&TransferRequestRecord{}), query)
+	if query.Cursor > 0 {
+		tx = tx.Where("id < ?", query.Cursor)
+	}
+	err := tx.Order("id DESC").Limit(query.Limit).Find(&records).Error
+	return records, err
+}
+
+func (d *GormTransferDao) CountTransferRecords(ctx context.Context, query TransferRecordQuery) (int64, error) {
+	var count int64
+	err := d.where(d.db.WithContext(ctx).Model(&TransferRequestRecord{}), query).Count(&count).Error
+	return count, err
+}
+
+func (d *GormTransferDao) AvgTransferAmount(ctx context.Context, query TransferRecordQuery) (int64, int64, error) {
+	var res struct {
+		Total int64
+		Count int64
+	}
+	err := d.where(d.db.WithContext(ctx).Model(&TransferRequestRecord{}), query).
+		Select("COALESCE(SUM(amount), 0) AS total, COUNT(*) AS count").
+		Scan(&res).Error
+	if err != nil || res.Count == 0 {
+		return 0, 0, err
+	}
+	return res.Total / res.Count, res.Count, nil
+}
+
+func (d *GormTransferDao) FirstTransferTime(ctx context.Context, openid string) (time.Time, error) {
+	var record TransferRequestRecord
+	err := d.db.WithContext(ctx).Select("ctime").Where("openid = ?", openid).Order("ctime ASC").Limit(1).Find(&record).Error
+	return record.Ctime, err
+}
+
+// where 加上 query 里不为空的过滤条件，不包括分页条件
+func (d *GormTransferDao) where(tx *gorm.DB, query TransferRecordQuery) *gorm.DB {
+	if query.Openid != "" {
+		tx = tx.Where("openid = ?", query.Openid)
+	}
+	if query.MchId != "" {
+		tx = tx.Where("mch_id = ?", query.MchId)
+	}
+	if query.OutBillNo != "" {
+		tx = tx.Where("out_bill_no = ?", query.OutBillNo)
+	}
+	if query.DeviceId != "" {
+		tx = tx.Where("device_id = ?", query.DeviceId)
+	}
+	if query.ClientIp != "" {
+		tx = tx.Where("client_ip = ?", query.ClientIp)
+	}
+	if query.Status != "" {
+		tx = tx.Where("status = ?", query.Status)
+	}
+	if !query.StartTime.IsZero() {
+		tx = tx.Where("ctime >= ?", query.StartTime)
+	}
+	if !query.EndTime.IsZero() {
+		tx = tx.Where("ctime < ?", query.EndTime)
+	}
+	return tx
+}
+
+func (d *GormTransferDao) ListTransferStatusEvents(ctx context.Context, outbillno string) ([]TransferStatusEvent, error) {
+	var events []TransferStatusEvent
+	err := d.db.WithContext(ctx).Where("out_bill_no = ?", outbillno).Order("id ASC").Find(&events).Error
+	return events, err
+}
+
+func (d *GormTransferDao) SumTransferAmountByStatus(ctx context.Context, mchid string, statuses []string, since time.Time) (int64, error) {
+	var sum int64
+	err := d.db.WithContext(ctx).Model(&TransferRequestRecord{}).
+		Select("COALESCE(SUM(amount), 0)").
+		Where("mch_id = ? AND status IN ? AND ctime >= ?", mchid, statuses, since).
+		Scan(&sum).Error
+	return sum, err
+}
+
+func (d *GormTransferDao) CountTransferRecordsByStatus(ctx context.Context, statuses []string) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Cnt    int64
+	}
+	err := d.db.WithContext(ctx).Model(&TransferRequestRecord{}).
+		Select("status, COUNT(*) AS cnt").
+		Where("status IN ?", statuses).
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		res[row.Status] = row.Cnt
+	}
+	return res, nil
+}
@@ -2,6 +2,8 @@ package dao
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -17,6 +19,8 @@ type User struct {
 type UserDao interface {
 	GetAmount(ctx context.Context, openid string) (int64, error)
 	UpsertBalance(ctx context.Context, openid string, amount int64) error
+	// AdjustBalance 人工调整余额，调整后余额不能为负，并在同一个事务里写入操作记录
+	AdjustBalance(ctx context.Context, openid string, delta int64, audit AuditLog) (int64, error)
 }
 
 type GormUserDao struct {
@@ -29,22 +33,57 @@ func NewUserDao(db *gorm.DB) UserDao {
 
 func (d *GormUserDao) GetAmount(ctx context.Context, openid string) (int64, error) {
 	var user User
-	err := d.db.Where("wx_open_id = ?", openid).First(&user).Error
+	err := d.db.WithContext(ctx).Where("wx_open_id = ?", openid).First(&user).Error
 	return user.Balance, err
 }
 
 func (d *GormUserDao) UpsertBalance(ctx context.Context, openid string, amount int64) error {
+	return upsertBalance(d.db.WithContext(ctx), openid, amount)
+}
+
+// upsertBalance 把 amount 累加到用户余额，用户不存在时创建，tx 可以是事务
+func upsertBalance(tx *gorm.DB, openid string, amount int64) error {
 	user := User{
 		WxOpenId: openid,
 		Username: openid,
 		Balance:  amount,
 	}
-	return d.db.WithContext(ctx).
+	return tx.
 		Clauses(clause.OnConflict{
 			Columns: []clause.Column{{Name: "wx_open_id"}}, // 依据 openid 冲突
 			DoUpdates: clause.Assignments(map[string]interface{}{
-				"balance": gorm.Expr("balance + ?", amount), // 累加
+				// 累加，带上表名，避免和待插入行（PostgreSQL/SQLite 的 EXCLUDED）的同名列混淆
+				"balance": gorm.Expr("? + ?", clause.Column{Table: clause.CurrentTable, Name: "balance"}, amount),
 			}),
 		}).
 		Create(&user).Error
 }
+
+func (d *GormUserDao) AdjustBalance(ctx context.Context, openid string, delta int64, audit AuditLog) (int64, error) {
+	var balance int64
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("wx_open_id = ?", openid).First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			user = User{WxOpenId: openid, Username: openid}
+			if err = tx.Create(&user).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		}
+		balance = user.Balance + delta
+		if balance < 0 {
+			return ErrInsufficientBalance
+		}
+		err = tx.Model(&User{}).Where("id = ?", user.Id).Update("balance", balance).Error
+		if err != nil {
+			return err
+		}
+		audit.Ctime = time.Now()
+		return tx.Create(&audit).Error
+	})
+	return balance, err
+}
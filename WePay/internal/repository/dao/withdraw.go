@@ -0,0 +1,121 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInsufficientBalance = errors.New("余额不足")
+
+const (
+	withdrawStatusPending  = "PENDING"
+	withdrawStatusSuccess  = "SUCCESS"
+	withdrawStatusRefunded = "REFUNDED"
+)
+
+type Withdrawal struct {
+	ID        int64  `gorm:"primaryKey;autoIncrement"`
+	Openid    string `gorm:"type:varchar(128);index"`
+	OutBillNo string `gorm:"type:varchar(128);uniqueIndex"`
+	Amount    int64
+	Status    string `gorm:"type:varchar(32)"`
+	Ctime     time.Time
+	Utime     time.Time
+}
+
+type WithdrawDao interface {
+	// Insert 在同一个事务里锁定用户余额、扣减，写入提现记录、提现的转账单和待发送的请求
+	Insert(ctx context.Context, w Withdrawal, bill *TransferRequestRecord, outbox *TransferOutbox) error
+	// Refund 把 PENDING 的提现退回余额，返回提现记录和是否真的退回了
+	Refund(ctx context.Context, outbillno string) (Withdrawal, bool, error)
+	// MarkSuccess 把 PENDING 的提现标记为成功
+	MarkSuccess(ctx context.Context, outbillno string) error
+}
+
+type GormWithdrawDao struct {
+	db *gorm.DB
+}
+
+func NewWithdrawDao(db *gorm.DB) WithdrawDao {
+	return &GormWithdrawDao{db: db}
+}
+
+func (d *GormWithdrawDao) Insert(ctx context.Context, w Withdrawal, bill *TransferRequestRecord, outbox *TransferOutbox) error {
+	now := time.Now()
+	w.Status = withdrawStatusPending
+	w.Ctime = now
+	w.Utime = now
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("wx_open_id = ?", w.Openid).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInsufficientBalance
+		}
+		if err != nil {
+			return err
+		}
+		if user.Balance < w.Amount {
+			return ErrInsufficientBalance
+		}
+		err = tx.Model(&User{}).Where("id = ?", user.Id).
+			Update("balance", gorm.Expr("balance - ?", w.Amount)).Error
+		if err != nil {
+			return err
+		}
+		if err = tx.Create(&w).Error; err != nil {
+			return err
+		}
+		if err = tx.Create(bill).Error; err != nil {
+			return err
+		}
+		return tx.Create(outbox).Error
+	})
+}
+
+func (d *GormWithdrawDao) Refund(ctx context.Context, outbillno string) (Withdrawal, bool, error) {
+	var w Withdrawal
+	refunded := false
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("out_bill_no = ?", outbillno).First(&w).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// 不是提现的转账单
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if w.Status != withdrawStatusPending {
+			return nil
+		}
+		err = tx.Model(&Withdrawal{}).Where("id = ?", w.ID).Updates(map[string]interface{}{
+			"status": withdrawStatusRefunded,
+			"utime":  time.Now(),
+		}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&User{}).Where("wx_open_id = ?", w.Openid).
+			Update("balance", gorm.Expr("balance + ?", w.Amount)).Error
+		if err != nil {
+			return err
+		}
+		refunded = true
+		return nil
+	})
+	return w, refunded, err
+}
+
+func (d *GormWithdrawDao) MarkSuccess(ctx context.Context, outbillno string) error {
+	return d.db.WithContext(ctx).Model(&Withdrawal{}).
+		Where("out_bill_no = ? AND status = ?", outbillno, withdrawStatusPending).
+		Updates(map[string]interface{}{
+			"status": withdrawStatusSuccess,
+			"utime":  time.Now(),
+		}).Error
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"wepay/internal/domain"
+	"wepay/internal/repository/dao"
+)
+
+var ErrMerchantNotFound = errors.New("商户不存在")
+
+type MerchantRepository interface {
+	Save(ctx context.Context, m domain.Merchant) error
+	FindByMchId(ctx context.Context, mchid string) (domain.Merchant, error)
+	FindByAppid(ctx context.Context, appid string) (domain.Merchant, error)
+	FindAll(ctx context.Context) ([]domain.Merchant, error)
+}
+
+type merchantRepository struct {
+	dao dao.MerchantDao
+}
+
+func NewMerchantRepository(dao dao.MerchantDao) MerchantRepository {
+	return &merchantRepository{dao: dao}
+}
+
+func (r *merchantRepository) Save(ctx context.Context, m domain.Merchant) error {
+	return r.dao.Upsert(ctx, dao.Merchant{
+		MchId:                  m.MchId,
+		CertificateSerialNo:    m.CertificateSerialNo,
+		PrivateKeyPath:         m.PrivateKeyPath,
+		WechatPayPublicKeyId:   m.WechatPayPublicKeyId,
+		WechatPayPublicKeyPath: m.WechatPayPublicKeyPath,
+		ApiV3KeyPath:           m.ApiV3KeyPath,
+		NotifyUrl:              m.NotifyUrl,
+	}, m.Appids)
+}
+
+// FindByMchId 返回的商户不带 Appids
+func (r *merchantRepository) FindByMchId(ctx context.Context, mchid string) (domain.Merchant, error) {
+	m, err := r.dao.FindByMchId(ctx, mchid)
+	if errors.Is(err, dao.ErrRecordNotFound) {
+		return domain.Merchant{}, ErrMerchantNotFound
+	}
+	if err != nil {
+		return domain.Merchant{}, err
+	}
+	return r.toDomain(m), nil
+}
+
+// FindByAppid 返回的商户只带这一个 appid
+func (r *merchantRepository) FindByAppid(ctx context.Context, appid string) (domain.Merchant, error) {
+	mchid, err := r.dao.FindMchIdByAppid(ctx, appid)
+	if errors.Is(err, dao.ErrRecordNotFound) {
+		return domain.Merchant{}, ErrMerchantNotFound
+	}
+	if err != nil {
+		return domain.Merchant{}, err
+	}
+	m, err := r.FindByMchId(ctx, mchid)
+	m.Appids = []string{appid}
+	return m, err
+}
+
+func (r *merchantRepository) FindAll(ctx context.Context) ([]domain.Merchant, error) {
+	entities, err := r.dao.FindAll(ctx)
+	if err != nil || len(entities) == 0 {
+		return nil, err
+	}
+	mchids := make([]string, 0, len(entities))
+	for _, m := range entities {
+		mchids = append(mchids, m.MchId)
+	}
+	apps, err := r.dao.FindAppsByMchIds(ctx, mchids)
+	if err != nil {
+		return nil, err
+	}
+	appids := make(map[string][]string, len(entities))
+	for _, app := range apps {
+		appids[app.MchId] = append(appids[app.MchId], app.Appid)
+	}
+	merchants := make([]domain.Merchant, 0, len(entities))
+	for _, m := range entities {
+		merchant := r.toDomain(m)
+		merchant.Appids = appids[m.MchId]
+		merchants = append(merchants, merchant)
+	}
+	return merchants, nil
+}
+
+func (r *merchantRepository) toDomain(m dao.Merchant) domain.Merchant {
+	return domain.Merchant{
+		MchId:                  m.MchId,
+		CertificateSerialNo:    m.CertificateSerialNo,
+		PrivateKeyPath:         m.PrivateKeyPath,
+		WechatPayPublicKeyId:   m.WechatPayPublicKeyId,
+		WechatPayPublicKeyPath: m.WechatPayPublicKeyPath,
+		ApiV3KeyPath:           m.ApiV3KeyPath,
+		NotifyUrl:              m.NotifyUrl,
+		Ctime:                  m.Ctime,
+		Utime:                  m.Utime,
+	}
+}
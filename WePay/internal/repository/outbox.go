@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/repository/dao"
+)
+
+type TransferOutboxRepository interface {
+	FindDue(ctx context.Context, now time.Time, limit int) ([]domain.TransferOutbox, error)
+	Claim(ctx context.Context, id int64, now, leaseUntil time.Time) (bool, error)
+	MarkDone(ctx context.Context, id int64) error
+	MarkRetry(ctx context.Context, id int64, nextRetryTime time.Time, lastError string) error
+	MarkFailed(ctx context.Context, id int64, lastError string) error
+	// ReleaseHeld 风控审核通过，请求改为在 sendAt 发送，请求不在审核中时返回 false
+	ReleaseHeld(ctx context.Context, outbillno string, sendAt time.Time) (bool, error)
+	// DiscardHeld 风控审核不通过，请求不再发送，请求不在审核中时返回 false
+	DiscardHeld(ctx context.Context, outbillno string, reason string) (bool, error)
+}
+
+type transferOutboxRepository struct {
+	dao dao.TransferOutboxDao
+}
+
+func NewTransferOutboxRepository(dao dao.TransferOutboxDao) TransferOutboxRepository {
+	return &transferOutboxRepository{dao: dao}
+}
+
+func (r *transferOutboxRepository) FindDue(ctx context.Context, now time.Time, limit int) ([]domain.TransferOutbox, error) {
+	entities, err := r.dao.FindDue(ctx, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]domain.TransferOutbox, 0, len(entities))
+	for _, e := range entities {
+		res = append(res, domain.TransferOutbox{
+			ID:            e.ID,
+			OutBillNo:     e.OutBillNo,
+			Payload:       e.Payload,
+			Status:        e.Status,
+			Attempts:      e.Attempts,
+			NextRetryTime: e.NextRetryTime,
+			LastError:     e.LastError,
+		})
+	}
+	return res, nil
+}
+
+func (r *transferOutboxRepository) Claim(ctx context.Context, id int64, now, leaseUntil time.Time) (bool, error) {
+	return r.dao.Claim(ctx, id, now, leaseUntil)
+}
+
+func (r *transferOutboxRepository) MarkDone(ctx context.Context, id int64) error {
+	return r.dao.MarkDone(ctx, id)
+}
+
+func (r *transferOutboxRepository) MarkRetry(ctx context.Context, id int64, nextRetryTime time.Time, lastError string) error {
+	return r.dao.MarkRetry(ctx, id, nextRetryTime, lastError)
+}
+
+func (r *transferOutboxRepository) MarkFailed(ctx context.Context, id int64, lastError string) error {
+	return r.dao.MarkFailed(ctx, id, lastError)
+}
+
+func (r *transferOutboxRepository) ReleaseHeld(ctx context.Context, outbillno string, sendAt time.Time) (bool, error) {
+	return r.dao.ReleaseHeld(ctx, outbillno, sendAt)
+}
+
+func (r *transferOutboxRepository) DiscardHeld(ctx context.Context, outbillno string, reason string) (bool, error) {
+	return r.dao.DiscardHeld(ctx, outbillno, reason)
+}
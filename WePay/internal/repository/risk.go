@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"wepay/internal/domain"
+	"wepay/internal/repository/dao"
+)
+
+var ErrRiskRulesNotFound = errors.New("还没有配置风控规则")
+
+type RiskRepository interface {
+	// GetRules 返回最新的风控规则，还没配置过时返回 ErrRiskRulesNotFound
+	GetRules(ctx context.Context) (domain.RiskRules, error)
+	// SaveRules 保存一个新版本的规则，旧版本保留用于追溯
+	SaveRules(ctx context.Context, rules domain.RiskRules, operator string) error
+}
+
+type riskRepository struct {
+	dao dao.RiskDao
+}
+
+func NewRiskRepository(dao dao.RiskDao) RiskRepository {
+	return &riskRepository{dao: dao}
+}
+
+func (r *riskRepository) GetRules(ctx context.Context) (domain.RiskRules, error) {
+	rule, err := r.dao.Latest(ctx)
+	if errors.Is(err, dao.ErrRecordNotFound) {
+		return domain.RiskRules{}, ErrRiskRulesNotFound
+	}
+	if err != nil {
+		return domain.RiskRules{}, err
+	}
+	var rules domain.RiskRules
+	err = json.Unmarshal([]byte(rule.Rules), &rules)
+	return rules, err
+}
+
+func (r *riskRepository) SaveRules(ctx context.Context, rules domain.RiskRules, operator string) error {
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	return r.dao.Insert(ctx, dao.RiskRule{Rules: string(data), Operator: operator})
+}
@@ -4,46 +4,131 @@ import (
 	"context"
 	"time"
 	"wepay/internal/domain"
+	"wepay/internal/repository/cache"
 	"wepay/internal/repository/dao"
 )
 
+var (
+	ErrTransferNotFound = dao.ErrRecordNotFound
+	// ErrTransferExists 转账单号已经存在
+	ErrTransferExists = dao.ErrDuplicatedKey
+	// ErrTransferFinished 转账单已经是终态，不能再改成其他状态
+	ErrTransferFinished = dao.ErrTransferStatusFinal
+)
+
 type TransferRepository interface {
 	CreateTransferRequest(ctx context.Context, req *domain.TransferRecord) error
-	UpdateTransferRequestStatus(ctx context.Context, outbillno, state string) error
+	// CreateTransferRequestWithOutbox 在同一个事务里写入转账单和待发送给微信的请求
+	CreateTransferRequestWithOutbox(ctx context.Context, req *domain.TransferRecord, outbox domain.TransferOutbox) (int64, error)
+	// UpdateTransferRequestStatus 修改状态并记录来源，用于追溯状态变更。
+	// 返回修改前的转账单，它的 Status 和 state 相同时表示状态没有变化
+	UpdateTransferRequestStatus(ctx context.Context, outbillno, state, source, payloadRef string) (domain.TransferRecord, error)
+	// ConfirmTransfer 在同一个事务里把待确认的转账单改为成功，credit 为 true 时把金额计入用户余额。
+	// 转账单不在待确认状态时什么都不做，返回 false
+	ConfirmTransfer(ctx context.Context, outbillno string, credit bool, source, payloadRef string) (domain.TransferRecord, bool, error)
+	UpdateTransferFailReason(ctx context.Context, outbillno, reason string) error
+	// UpdateTransferPackageInfo 保存微信返回的 package_info，小程序确认收款时用它找到转账单
+	UpdateTransferPackageInfo(ctx context.Context, outbillno, packageInfo string) error
 	GetTransferStatus(ctx context.Context, outbillno string) (string, error)
 	GetTransferRecordByOutBillNo(ctx context.Context, outbillno string) (domain.TransferRecord, error)
 	GetTransferRecordByPackageInfo(ctx context.Context, packageInfo string) (domain.TransferRecord, error)
+	ListTransferRecords(ctx context.Context, query domain.TransferHistoryQuery) ([]domain.TransferRecord, error)
+	GetTransferTimeline(ctx context.Context, outbillno string) ([]domain.TransferStatusEvent, error)
+	// SumUnfinishedTransferAmount 统计商户 mchid 在 since 之后创建、钱还没从运营账户转出的转账单的总金额。
+	// 待用户确认和转账中的转账单，微信已经扣了款，不再统计
+	SumUnfinishedTransferAmount(ctx context.Context, mchid string, since time.Time) (int64, error)
+	// CountUnfinishedTransfers 按状态统计还没到终态的转账单数，包括审核中的
+	CountUnfinishedTransfers(ctx context.Context) (map[string]int64, error)
+	// CountTransferRecords 统计符合条件的转账单数，忽略分页条件
+	CountTransferRecords(ctx context.Context, query domain.TransferHistoryQuery) (int64, error)
+	// AvgTransferAmount 符合条件的转账单的平均金额和单数，忽略分页条件
+	AvgTransferAmount(ctx context.Context, query domain.TransferHistoryQuery) (avg int64, count int64, err error)
+	// FirstTransferTime openid 的第一张转账单的创建时间，没有时返回零值
+	FirstTransferTime(ctx context.Context, openid string) (time.Time, error)
 }
 
 type transferRepository struct {
-	dao dao.TransferDao
+	dao   dao.TransferDao
+	cache *cacheAside
 }
 
-func NewTransferRepository(dao dao.TransferDao) TransferRepository {
-	return &transferRepository{dao: dao}
+// NewTransferRepository c 为 nil 时不缓存转账状态。确认收款会修改余额，c 要和 UserRepository 用同一个
+func NewTransferRepository(dao dao.TransferDao, c cache.Cache, expiration time.Duration) TransferRepository {
+	return &transferRepository{dao: dao, cache: newCacheAside(c, expiration)}
 }
 
 func (r *transferRepository) CreateTransferRequest(ctx context.Context, req *domain.TransferRecord) error {
-	return r.dao.CreateTransferRequestRecord(ctx, &dao.TransferRequestRecord{
-		OutBillNo:   req.OutBillNo,
-		Openid:      req.Openid,
-		MchId:       req.MchId,
-		Amount:      req.Amount,
-		Remark:      req.Remark,
-		SceneId:     req.SceneId,
-		Status:      req.Status,
-		PackageInfo: req.PackageInfo,
-		Ctime:       time.Now(),
-		Utime:       time.Now(),
+	return r.dao.CreateTransferRequestRecord(ctx, toTransferEntity(req))
+}
+
+// CreateTransferRequestWithOutbox 返回 outbox 的 id
+func (r *transferRepository) CreateTransferRequestWithOutbox(ctx context.Context, req *domain.TransferRecord, outbox domain.TransferOutbox) (int64, error) {
+	entity := toOutboxEntity(req.OutBillNo, outbox)
+	err := r.dao.CreateTransferRequestRecordWithOutbox(ctx, toTransferEntity(req), entity)
+	return entity.ID, err
+}
+
+// toOutboxEntity 新建的请求，没有指定状态时等待发送
+func toOutboxEntity(outbillno string, outbox domain.TransferOutbox) *dao.TransferOutbox {
+	now := time.Now()
+	status := outbox.Status
+	if status == "" {
+		status = domain.OutboxStatusPending
+	}
+	return &dao.TransferOutbox{
+		OutBillNo:     outbillno,
+		Payload:       outbox.Payload,
+		Status:        status,
+		Attempts:      outbox.Attempts,
+		NextRetryTime: outbox.NextRetryTime,
+		Ctime:         now,
+		Utime:         now,
+	}
+}
+
+func (r *transferRepository) UpdateTransferRequestStatus(ctx context.Context, outbillno, state, source, payloadRef string) (domain.TransferRecord, error) {
+	before, err := r.dao.UpdateTransferRequestStatus(ctx, outbillno, state, domain.FinalTransferStatuses, dao.TransferStatusEvent{
+		Source:     source,
+		PayloadRef: payloadRef,
 	})
+	if err != nil {
+		return domain.TransferRecord{}, err
+	}
+	r.cache.invalidate(ctx, transferStatusKey(outbillno))
+	return r.toDomain(before), nil
 }
 
-func (r *transferRepository) UpdateTransferRequestStatus(ctx context.Context, outbillno, state string) error {
-	return r.dao.UpdateTransferRequestStatus(ctx, outbillno, state)
+// ConfirmTransfer 返回修改前的转账单
+func (r *transferRepository) ConfirmTransfer(ctx context.Context, outbillno string, credit bool, source, payloadRef string) (domain.TransferRecord, bool, error) {
+	before, confirmed, err := r.dao.ConfirmTransferRequest(ctx, outbillno, domain.TransferStatusWaitUserConfirm,
+		domain.TransferStatusSuccess, credit, dao.TransferStatusEvent{
+			Source:     source,
+			PayloadRef: payloadRef,
+		})
+	if err != nil || !confirmed {
+		return domain.TransferRecord{}, false, err
+	}
+	r.cache.invalidate(ctx, transferStatusKey(outbillno))
+	if credit {
+		r.cache.invalidate(ctx, balanceKey(before.Openid))
+	}
+	return r.toDomain(before), true, nil
+}
+
+func (r *transferRepository) UpdateTransferFailReason(ctx context.Context, outbillno, reason string) error {
+	return r.dao.UpdateTransferRequestFailReason(ctx, outbillno, reason)
+}
+
+func (r *transferRepository) UpdateTransferPackageInfo(ctx context.Context, outbillno, packageInfo string) error {
+	return r.dao.UpdateTransferRequestPackageInfo(ctx, outbillno, packageInfo)
 }
 
 func (r *transferRepository) GetTransferStatus(ctx context.Context, outbillno string) (string, error) {
-	return r.dao.GetTransferStatus(ctx, outbillno)
+	return r.cache.get(ctx, transferStatusKey(outbillno), func(ctx context.Context) (string, bool, error) {
+		status, err := r.dao.GetTransferStatus(ctx, outbillno)
+		// 转账单不存在时状态为空，不缓存，避免转账单创建之后还读到空状态
+		return status, err == nil && status != "", err
+	})
 }
 
 func (r *transferRepository) GetTransferRecordByOutBillNo(ctx context.Context, outbillno string) (domain.TransferRecord, error) {
@@ -51,15 +136,7 @@ func (r *transferRepository) GetTransferRecordByOutBillNo(ctx context.Context, o
 	if err != nil {
 		return domain.TransferRecord{}, err
 	}
-	return domain.TransferRecord{
-		OutBillNo: record.OutBillNo,
-		Openid:    record.Openid,
-		Amount:    record.Amount,
-		MchId:     record.MchId,
-		Remark:    record.Remark,
-		SceneId:   record.SceneId,
-		Status:    record.Status,
-	}, nil
+	return r.toDomain(record), nil
 }
 
 func (r *transferRepository) GetTransferRecordByPackageInfo(ctx context.Context, packageInfo string) (domain.TransferRecord, error) {
@@ -67,13 +144,136 @@ func (r *transferRepository) GetTransferRecordByPackageInfo(ctx context.Context,
 	if err != nil {
 		return domain.TransferRecord{}, err
 	}
+	return r.toDomain(record), nil
+}
+
+func (r *transferRepository) ListTransferRecords(ctx context.Context, query domain.TransferHistoryQuery) ([]domain.TransferRecord, error) {
+	records, err := r.dao.ListTransferRecords(ctx, r.toQuery(query))
+	if err != nil {
+		return nil, err
+	}
+	res := make([]domain.TransferRecord, 0, len(records))
+	for _, record := range records {
+		res = append(res, r.toDomain(record))
+	}
+	return res, nil
+}
+
+func (r *transferRepository) GetTransferTimeline(ctx context.Context, outbillno string) ([]domain.TransferStatusEvent, error) {
+	events, err := r.dao.ListTransferStatusEvents(ctx, outbillno)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]domain.TransferStatusEvent, 0, len(events))
+	for _, event := range events {
+		res = append(res, domain.TransferStatusEvent{
+			ID:         event.ID,
+			OutBillNo:  event.OutBillNo,
+			FromStatus: event.FromStatus,
+			ToStatus:   event.ToStatus,
+			Source:     event.Source,
+			PayloadRef: event.PayloadRef,
+			Ctime:      event.Ctime,
+		})
+	}
+	return res, nil
+}
+
+func (r *transferRepository) SumUnfinishedTransferAmount(ctx context.Context, mchid string, since time.Time) (int64, error) {
+	return r.dao.SumTransferAmountByStatus(ctx, mchid, []string{
+		domain.TransferStatusAccepted,
+		domain.TransferStatusProcessing,
+	}, since)
+}
+
+func (r *transferRepository) CountUnfinishedTransfers(ctx context.Context) (map[string]int64, error) {
+	statuses := []string{
+		domain.TransferStatusReviewing,
+		domain.TransferStatusAccepted,
+		domain.TransferStatusProcessing,
+		domain.TransferStatusWaitUserConfirm,
+		domain.TransferStatusTransfering,
+		domain.TransferStatusCanceling,
+	}
+	counts, err := r.dao.CountTransferRecordsByStatus(ctx, statuses)
+	if err != nil {
+		return nil, err
+	}
+	// 没有转账单的状态也返回 0
+	for _, status := range statuses {
+		if _, ok := counts[status]; !ok {
+			counts[status] = 0
+		}
+	}
+	return counts, nil
+}
+
+func (r *transferRepository) CountTransferRecords(ctx context.Context, query domain.TransferHistoryQuery) (int64, error) {
+	return r.dao.CountTransferRecords(ctx, r.toQuery(query))
+}
+
+func (r *transferRepository) AvgTransferAmount(ctx context.Context, query domain.TransferHistoryQuery) (int64, int64, error) {
+	return r.dao.AvgTransferAmount(ctx, r.toQuery(query))
+}
+
+func (r *transferRepository) FirstTransferTime(ctx context.Context, openid string) (time.Time, error) {
+	return r.dao.FirstTransferTime(ctx, openid)
+}
+
+func (r *transferRepository) toQuery(query domain.TransferHistoryQuery) dao.TransferRecordQuery {
+	return dao.TransferRecordQuery{
+		Openid:    query.Openid,
+		MchId:     query.MchId,
+		OutBillNo: query.OutBillNo,
+		DeviceId:  query.DeviceId,
+		ClientIp:  query.ClientIp,
+		Status:    query.Status,
+		StartTime: query.StartTime,
+		EndTime:   query.EndTime,
+		Cursor:    query.Cursor,
+		Limit:     query.Limit,
+	}
+}
+
+func toTransferEntity(req *domain.TransferRecord) *dao.TransferRequestRecord {
+	return &dao.TransferRequestRecord{
+		OutBillNo:    req.OutBillNo,
+		Openid:       req.Openid,
+		MchId:        req.MchId,
+		Amount:       req.Amount,
+		Remark:       req.Remark,
+		SceneId:      req.SceneId,
+		Type:         req.Type,
+		Status:       req.Status,
+		PackageInfo:  req.PackageInfo,
+		FailReason:   req.FailReason,
+		DeviceId:     req.DeviceId,
+		ClientIp:     req.ClientIp,
+		RiskDecision: req.RiskDecision,
+		RiskReasons:  req.RiskReasons,
+		Ctime:        time.Now(),
+		Utime:        time.Now(),
+	}
+}
+
+func (r *transferRepository) toDomain(record dao.TransferRequestRecord) domain.TransferRecord {
 	return domain.TransferRecord{
-		OutBillNo: record.OutBillNo,
-		Openid:    record.Openid,
-		Amount:    record.Amount,
-		MchId:     record.MchId,
-		Remark:    record.Remark,
-		SceneId:   record.SceneId,
-		Status:    record.Status,
-	}, nil
+		ID:           record.ID,
+		OutBillNo:    record.OutBillNo,
+		Openid:       record.Openid,
+		Amount:       record.Amount,
+		MchId:        record.MchId,
+		Remark:       record.Remark,
+		SceneId:      record.SceneId,
+		Type:         record.Type,
+		Status:       record.Status,
+		PackageInfo:  record.PackageInfo,
+		FailReason:   record.FailReason,
+		DeviceId:     record.DeviceId,
+		ClientIp:     record.ClientIp,
+		RiskDecision: record.RiskDecision,
+		RiskReasons:  record.RiskReasons,
+		Ctime:        record.Ctime,
+		Utime:        record.Utime,
+	}
 }
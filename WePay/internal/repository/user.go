@@ -2,26 +2,65 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/repository/cache"
 	"wepay/internal/repository/dao"
 )
 
+// ErrUserNotFound 用户还没有余额记录
+var ErrUserNotFound = errors.New("用户不存在")
+
 type UserRepository interface {
 	GetAmount(ctx context.Context, openid string) (int64, error)
 	UpdateBalance(ctx context.Context, openid string, amount int64) error
+	AdjustBalance(ctx context.Context, openid string, delta int64, audit domain.AuditLog) (int64, error)
 }
 
 type userRepository struct {
-	dao dao.UserDao
+	dao   dao.UserDao
+	cache *cacheAside
 }
 
-func NewUserRepository(dao dao.UserDao) UserRepository {
-	return &userRepository{dao: dao}
+// NewUserRepository c 为 nil 时不缓存余额
+func NewUserRepository(dao dao.UserDao, c cache.Cache, expiration time.Duration) UserRepository {
+	return &userRepository{dao: dao, cache: newCacheAside(c, expiration)}
 }
 
 func (r *userRepository) GetAmount(ctx context.Context, openid string) (int64, error) {
-	return r.dao.GetAmount(ctx, openid)
+	val, err := r.cache.get(ctx, balanceKey(openid), func(ctx context.Context) (string, bool, error) {
+		balance, err := r.dao.GetAmount(ctx, openid)
+		if errors.Is(err, dao.ErrRecordNotFound) {
+			err = ErrUserNotFound
+		}
+		return strconv.FormatInt(balance, 10), err == nil, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(val, 10, 64)
 }
 
 func (r *userRepository) UpdateBalance(ctx context.Context, openid string, amount int64) error {
-	return r.dao.UpsertBalance(ctx, openid, amount)
+	err := r.dao.UpsertBalance(ctx, openid, amount)
+	if err == nil {
+		r.cache.invalidate(ctx, balanceKey(openid))
+	}
+	return err
+}
+
+func (r *userRepository) AdjustBalance(ctx context.Context, openid string, delta int64, audit domain.AuditLog) (int64, error) {
+	balance, err := r.dao.AdjustBalance(ctx, openid, delta, dao.AuditLog{
+		Operator: audit.Operator,
+		Action:   audit.Action,
+		Target:   audit.Target,
+		Detail:   audit.Detail,
+		Reason:   audit.Reason,
+	})
+	if err == nil {
+		r.cache.invalidate(ctx, balanceKey(openid))
+	}
+	return balance, err
 }
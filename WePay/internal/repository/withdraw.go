@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/repository/cache"
+	"wepay/internal/repository/dao"
+)
+
+var ErrInsufficientBalance = dao.ErrInsufficientBalance
+
+type WithdrawRepository interface {
+	// CreateWithdrawal 在同一个事务里扣减余额，写入提现记录、提现的转账单 bill 和待发送的请求，返回 outbox 的 id。
+	// 余额不足时什么都不写，返回 ErrInsufficientBalance
+	CreateWithdrawal(ctx context.Context, w domain.Withdrawal, bill *domain.TransferRecord, outbox domain.TransferOutbox) (int64, error)
+	Refund(ctx context.Context, outbillno string) (bool, error)
+	MarkSuccess(ctx context.Context, outbillno string) error
+}
+
+type withdrawRepository struct {
+	dao   dao.WithdrawDao
+	cache *cacheAside
+}
+
+// NewWithdrawRepository 提现会修改余额，c 要和 UserRepository 用同一个
+func NewWithdrawRepository(dao dao.WithdrawDao, c cache.Cache, expiration time.Duration) WithdrawRepository {
+	return &withdrawRepository{dao: dao, cache: newCacheAside(c, expiration)}
+}
+
+func (r *withdrawRepository) CreateWithdrawal(ctx context.Context, w domain.Withdrawal, bill *domain.TransferRecord, outbox domain.TransferOutbox) (int64, error) {
+	entity := toOutboxEntity(bill.OutBillNo, outbox)
+	err := r.dao.Insert(ctx, dao.Withdrawal{
+		Openid:    w.Openid,
+		OutBillNo: w.OutBillNo,
+		Amount:    w.Amount,
+	}, toTransferEntity(bill), entity)
+	if err == nil {
+		r.cache.invalidate(ctx, balanceKey(w.Openid))
+	}
+	return entity.ID, err
+}
+
+func (r *withdrawRepository) Refund(ctx context.Context, outbillno string) (bool, error) {
+	w, refunded, err := r.dao.Refund(ctx, outbillno)
+	if refunded {
+		r.cache.invalidate(ctx, balanceKey(w.Openid))
+	}
+	return refunded, err
+}
+
+func (r *withdrawRepository) MarkSuccess(ctx context.Context, outbillno string) error {
+	return r.dao.MarkSuccess(ctx, outbillno)
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/logger"
+)
+
+// Alerter 告警的发送渠道
+type Alerter interface {
+	Alert(ctx context.Context, alert domain.Alert) error
+}
+
+// LogAlerter 把告警打到日志里，没有配置其他渠道时使用
+type LogAlerter struct{}
+
+func (LogAlerter) Alert(ctx context.Context, alert domain.Alert) error {
+	logger.FromContext(ctx).Warn("alert", "level", alert.Level, "title", alert.Title, "message", alert.Message)
+	return nil
+}
+
+// WebhookAlerter 把告警以 JSON POST 到 webhook
+type WebhookAlerter struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookAlerter(url string) *WebhookAlerter {
+	return &WebhookAlerter{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (a *WebhookAlerter) Alert(ctx context.Context, alert domain.Alert) error {
+	body, err := json.Marshal(map[string]string{
+		"level":   alert.Level,
+		"title":   alert.Title,
+		"message": alert.Message,
+		"time":    alert.Time.Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
+
+// MultiAlerter 把告警发给所有渠道，返回第一个错误
+type MultiAlerter []Alerter
+
+func (m MultiAlerter) Alert(ctx context.Context, alert domain.Alert) error {
+	var firstErr error
+	for _, alerter := range m {
+		if err := alerter.Alert(ctx, alert); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"context"
+	"wepay/internal/domain"
+	"wepay/internal/repository"
+)
+
+// AuditService 记录管理后台的操作，谁在什么时候对什么做了什么
+type AuditService interface {
+	Record(ctx context.Context, log domain.AuditLog) error
+}
+
+type auditService struct {
+	repo repository.AuditRepository
+}
+
+func NewAuditService(repo repository.AuditRepository) AuditService {
+	return &auditService{repo: repo}
+}
+
+func (s *auditService) Record(ctx context.Context, log domain.AuditLog) error {
+	return s.repo.Create(ctx, log)
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/logger"
+	"wepay/internal/repository"
+)
+
+var ErrTransferPaused = errors.New("运营账户余额不足，红包活动已暂停")
+
+// pendingNeedWindow 只统计这段时间内创建的转账单，更早还没发出去的转账单要人工处理，不再占用余额
+const pendingNeedWindow = 24 * time.Hour
+
+type BalanceService interface {
+	// CheckBalance 查询商户 mchid 的运营账户余额，低于阈值时告警，不够支付还没完成的转账单时暂停这个商户的红包活动，
+	// 余额恢复后自动恢复
+	CheckBalance(ctx context.Context, mchid string) (domain.BalanceCheck, error)
+}
+
+// BalanceSource 商户资金账户余额的来源
+type BalanceSource interface {
+	QueryBalance(ctx context.Context, mchid, accountType string) (domain.MerchantBalance, error)
+}
+
+type balanceService struct {
+	source    BalanceSource
+	repo      repository.TransferRepository
+	guard     *FundGuard
+	alerter   Alerter
+	threshold int64 // 可用余额低于这个值时告警，单位分
+
+	mu      sync.Mutex
+	alerted map[string]string // 商户号 -> 上次发出的告警级别，级别没变时不重复告警
+}
+
+func NewBalanceService(source BalanceSource, repo repository.TransferRepository, guard *FundGuard,
+	alerter Alerter, threshold int64) BalanceService {
+	return &balanceService{
+		source:    source,
+		repo:      repo,
+		guard:     guard,
+		alerter:   alerter,
+		threshold: threshold,
+		alerted:   make(map[string]string),
+	}
+}
+
+func (s *balanceService) CheckBalance(ctx context.Context, mchid string) (domain.BalanceCheck, error) {
+	balance, err := s.source.QueryBalance(ctx, mchid, domain.MerchantAccountOperation)
+	if err != nil {
+		return domain.BalanceCheck{}, fmt.Errorf("query balance: %w", err)
+	}
+	need, err := s.repo.SumUnfinishedTransferAmount(ctx, mchid, time.Now().Add(-pendingNeedWindow))
+	if err != nil {
+		return domain.BalanceCheck{}, err
+	}
+	check := evaluateBalance(balance, need, s.threshold)
+
+	wasPaused, _ := s.guard.Paused(mchid)
+	if check.Paused {
+		s.guard.Pause(mchid, fmt.Sprintf("可用余额 %d 分，还没完成的转账单需要 %d 分", balance.Available, need))
+	} else if wasPaused {
+		s.guard.Resume(mchid)
+	}
+
+	// 同一个商户的检查可能并发，整个判断和发送都在锁里，保证同一级别只告警一次
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := s.alerted[mchid]
+	switch {
+	case check.Paused:
+		if last != domain.AlertLevelCritical {
+			s.alert(ctx, mchid, domain.AlertLevelCritical, "商户 "+mchid+" 运营账户余额不足，红包活动已暂停", check)
+		}
+	case wasPaused:
+		s.alert(ctx, mchid, domain.AlertLevelResolved, "商户 "+mchid+" 运营账户余额已恢复，红包活动已恢复", check)
+	case check.Low:
+		if last != domain.AlertLevelWarning {
+			s.alert(ctx, mchid, domain.AlertLevelWarning, "商户 "+mchid+" 运营账户余额低于告警阈值", check)
+		}
+	case last == domain.AlertLevelWarning || last == domain.AlertLevelCritical:
+		s.alert(ctx, mchid, domain.AlertLevelResolved, "商户 "+mchid+" 运营账户余额已恢复到告警阈值以上", check)
+	}
+	return check, nil
+}
+
+// alert 发送成功后记下告警级别，发送失败时下次检查再发
+func (s *balanceService) alert(ctx context.Context, mchid, level, title string, check domain.BalanceCheck) {
+	err := s.alerter.Alert(ctx, domain.Alert{
+		Level: level,
+		Title: title,
+		Message: fmt.Sprintf("可用余额 %d 分，不可用余额 %d 分，还没完成的转账单需要 %d 分，告警阈值 %d 分",
+			check.Balance.Available, check.Balance.Pending, check.PendingNeed, check.Threshold),
+		Time: check.CheckTime,
+	})
+	if err != nil {
+		logger.FromContext(ctx).Error("send alert failed", "title", title, "error", err)
+		return
+	}
+	s.alerted[mchid] = level
+}
+
+// evaluateBalance 可用余额不够支付还没完成的转账单时暂停，低于阈值时告警
+func evaluateBalance(balance domain.MerchantBalance, need, threshold int64) domain.BalanceCheck {
+	return domain.BalanceCheck{
+		Balance:     balance,
+		PendingNeed: need,
+		Threshold:   threshold,
+		Low:         balance.Available < threshold,
+		Paused:      balance.Available < need,
+		CheckTime:   time.Now(),
+	}
+}
+
+// FundGuard 按商户号记录红包活动是否因为余额不足暂停，由余额检查和转账失败时设置，发起红包转账前检查。
+// nil 表示从不暂停
+type FundGuard struct {
+	mu     sync.RWMutex
+	paused map[string]string // 暂停的商户号 -> 暂停原因
+}
+
+func NewFundGuard() *FundGuard {
+	return &FundGuard{paused: make(map[string]string)}
+}
+
+func (g *FundGuard) Pause(mchid, reason string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.paused[mchid]; !ok {
+		slog.Warn("campaign paused", "mch_id", mchid, "reason", reason)
+	}
+	g.paused[mchid] = reason
+}
+
+func (g *FundGuard) Resume(mchid string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.paused[mchid]; ok {
+		slog.Info("campaign resumed", "mch_id", mchid)
+	}
+	delete(g.paused, mchid)
+}
+
+// Paused 返回商户 mchid 是否暂停及暂停原因
+func (g *FundGuard) Paused(mchid string) (bool, string) {
+	if g == nil {
+		return false, ""
+	}
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	reason, ok := g.paused[mchid]
+	return ok, reason
+}
+
+// WxpayBalanceSource 通过微信支付 API 查询商户资金账户余额
+type WxpayBalanceSource struct {
+	configs MchConfigProvider
+}
+
+func NewWxpayBalanceSource(configs MchConfigProvider) *WxpayBalanceSource {
+	return &WxpayBalanceSource{configs: configs}
+}
+
+func (s *WxpayBalanceSource) QueryBalance(ctx context.Context, mchid, accountType string) (domain.MerchantBalance, error) {
+	config, err := s.configs.MchConfig(ctx, mchid)
+	if err != nil {
+		return domain.MerchantBalance{}, err
+	}
+	var resp struct {
+		AvailableAmount int64 `json:"available_amount"`
+		PendingAmount   int64 `json:"pending_amount"`
+	}
+	path := "/v3/merchant/fund/balance/" + url.PathEscape(accountType)
+	if err := doWxpayRequest(ctx, config, "merchant_balance", http.MethodGet, path, nil, &resp); err != nil {
+		return domain.MerchantBalance{}, err
+	}
+	return domain.MerchantBalance{
+		AccountType: accountType,
+		Available:   resp.AvailableAmount,
+		Pending:     resp.PendingAmount,
+	}, nil
+}
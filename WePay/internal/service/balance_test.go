@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/repository"
+	"wepay/internal/repository/dao"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvaluateBalance(t *testing.T) {
+	testCases := []struct {
+		name       string
+		available  int64
+		need       int64
+		wantLow    bool
+		wantPaused bool
+	}{
+		{name: "余额充足", available: 500000, need: 10000},
+		{name: "低于阈值但够支付", available: 50000, need: 10000, wantLow: true},
+		{name: "不够支付还没完成的转账单", available: 5000, need: 10000, wantLow: true, wantPaused: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			check := evaluateBalance(domain.MerchantBalance{Available: tc.available}, tc.need, 100000)
+			assert.Equal(t, tc.wantLow, check.Low)
+			assert.Equal(t, tc.wantPaused, check.Paused)
+		})
+	}
+}
+
+func TestFundGuard(t *testing.T) {
+	var nilGuard *FundGuard
+	paused, _ := nilGuard.Paused("1900001109")
+	assert.False(t, paused)
+	nilGuard.Pause("1900001109", "ignored")
+
+	guard := NewFundGuard()
+	guard.Pause("1900001109", "NOT_ENOUGH")
+	paused, reason := guard.Paused("1900001109")
+	assert.True(t, paused)
+	assert.Equal(t, "NOT_ENOUGH", reason)
+	paused, _ = guard.Paused("1900001110")
+	assert.False(t, paused, "其他商户不受影响")
+	guard.Resume("1900001109")
+	paused, _ = guard.Paused("1900001109")
+	assert.False(t, paused)
+}
+
+func TestWebhookAlerter(t *testing.T) {
+	var got map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+	}))
+	defer server.Close()
+
+	err := NewWebhookAlerter(server.URL).Alert(context.Background(), domain.Alert{
+		Level:   domain.AlertLevelCritical,
+		Title:   "运营账户余额不足",
+		Message: "可用余额 0 分",
+		Time:    time.Date(2025, 7, 23, 10, 0, 0, 0, time.UTC),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"level":   domain.AlertLevelCritical,
+		"title":   "运营账户余额不足",
+		"message": "可用余额 0 分",
+		"time":    "2025-07-23T10:00:00Z",
+	}, got)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	assert.Error(t, NewWebhookAlerter(failing.URL).Alert(context.Background(), domain.Alert{}))
+}
+
+type fakeBalanceSource struct {
+	available int64
+}
+
+func (s *fakeBalanceSource) QueryBalance(ctx context.Context, mchid, accountType string) (domain.MerchantBalance, error) {
+	return domain.MerchantBalance{AccountType: accountType, Available: s.available}, nil
+}
+
+type recordingAlerter struct {
+	levels []string
+}
+
+func (a *recordingAlerter) Alert(ctx context.Context, alert domain.Alert) error {
+	a.levels = append(a.levels, alert.Level)
+	return nil
+}
+
+func TestBalanceService_CheckBalance(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	transferRepo := repository.NewTransferRepository(dao.NewTransferDao(db), nil, 0)
+	for i, record := range []domain.TransferRecord{
+		{OutBillNo: "b1", MchId: "1900001109", Amount: 10000, Status: domain.TransferStatusProcessing},
+		// 微信已经扣款，不再占用余额
+		{OutBillNo: "b2", MchId: "1900001109", Amount: 50000, Status: domain.TransferStatusWaitUserConfirm},
+		{OutBillNo: "b3", MchId: "1900001109", Amount: 50000, Status: domain.TransferStatusTransfering},
+	} {
+		record.Openid = "o1"
+		record.PackageInfo = "pk" + string(rune('1'+i))
+		require.NoError(t, transferRepo.CreateTransferRequest(ctx, &record))
+	}
+	// 超出统计窗口的转账单不再占用余额
+	old := time.Now().Add(-2 * pendingNeedWindow)
+	require.NoError(t, db.Create(&dao.TransferRequestRecord{OutBillNo: "b4", Openid: "o1", MchId: "1900001109",
+		Amount: 50000, Status: domain.TransferStatusProcessing, PackageInfo: "pk4", Ctime: old, Utime: old}).Error)
+
+	source := &fakeBalanceSource{}
+	alerter := &recordingAlerter{}
+	guard := NewFundGuard()
+	svc := NewBalanceService(source, transferRepo, guard, alerter, 100000)
+
+	steps := []struct {
+		name       string
+		available  int64
+		wantPaused bool
+		wantAlerts []string
+	}{
+		{name: "不够支付", available: 5000, wantPaused: true, wantAlerts: []string{domain.AlertLevelCritical}},
+		{name: "还是不够支付，不重复告警", available: 5000, wantPaused: true},
+		{name: "恢复但低于阈值", available: 50000, wantAlerts: []string{domain.AlertLevelResolved}},
+		{name: "低于阈值", available: 50000, wantAlerts: []string{domain.AlertLevelWarning}},
+		{name: "还是低于阈值，不重复告警", available: 50000},
+		{name: "恢复到阈值以上", available: 500000, wantAlerts: []string{domain.AlertLevelResolved}},
+		{name: "余额充足", available: 500000},
+	}
+	for _, step := range steps {
+		alerter.levels = nil
+		source.available = step.available
+		check, err := svc.CheckBalance(ctx, "1900001109")
+		require.NoError(t, err, step.name)
+		assert.Equal(t, int64(10000), check.PendingNeed, step.name)
+		paused, _ := guard.Paused("1900001109")
+		assert.Equal(t, step.wantPaused, paused, step.name)
+		assert.Equal(t, step.wantAlerts, alerter.levels, step.name)
+	}
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wepay/internal/logger"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrLockTimeout = errors.New("获取锁超时，请稍后重试")
+
+// defaultLockTimeout 等锁的最长时间，同一个用户的请求一般很快处理完，等太久说明有重复提交
+const defaultLockTimeout = 3 * time.Second
+
+// commitTimeout 不跟着请求取消的资金写入最多用多久
+const commitTimeout = 5 * time.Second
+
+// commitContext 已经决定要做的资金变更（入账、扣减和它的补偿）用的 context：保留 ctx 里的 logger 和 trace，
+// 但不跟着请求取消，调用方断开时不会只做了一半；commitTimeout 限制最多等多久
+func commitContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), commitTimeout)
+}
+
+// 发起转账用一把锁，提现单独一把：提现内部会发起转账，用同一把锁会自己等自己
+func transferLockKey(openid string) string {
+	return "transfer:" + openid
+}
+
+func withdrawLockKey(openid string) string {
+	return "withdraw:" + openid
+}
+
+// Locker 多个实例之间互斥执行同一个 key 的临界区
+type Locker interface {
+	// Lock 最多等 timeout，超时返回 ErrLockTimeout。拿到锁后必须调用返回的 unlock，重复调用是安全的
+	Lock(ctx context.Context, key string, timeout time.Duration) (unlock func(), err error)
+}
+
+// LocalLocker 进程内的锁，只有一个实例或者没有 Redis 时使用
+type LocalLocker struct {
+	mu    sync.Mutex
+	locks map[string]*localLock
+}
+
+type localLock struct {
+	sem  chan struct{}
+	refs int // 持有和等待的请求数，为 0 时删除，避免 map 无限增长
+}
+
+func NewLocalLocker() *LocalLocker {
+	return &LocalLocker{locks: make(map[string]*localLock)}
+}
+
+func (l *LocalLocker) Lock(ctx context.Context, key string, timeout time.Duration) (func(), error) {
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &localLock{sem: make(chan struct{}, 1)}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case lock.sem <- struct{}{}:
+		var once sync.Once
+		return func() {
+			once.Do(func() {
+				<-lock.sem
+				l.release(key, lock)
+			})
+		}, nil
+	case <-timer.C:
+		l.release(key, lock)
+		return nil, ErrLockTimeout
+	case <-ctx.Done():
+		l.release(key, lock)
+		return nil, ctx.Err()
+	}
+}
+
+func (l *LocalLocker) release(key string, lock *localLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(l.locks, key)
+	}
+}
+
+// 只删除自己加的锁，锁过期后被别人拿走时不能误删
+var redisUnlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// RedisLocker 用 SET NX 实现的分布式锁
+type RedisLocker struct {
+	client        redis.Cmdable
+	lease         time.Duration // 锁的过期时间，持有锁的实例崩溃时到期自动释放，要比临界区的最长耗时长
+	retryInterval time.Duration
+}
+
+func NewRedisLocker(client redis.Cmdable, lease time.Duration) *RedisLocker {
+	return &RedisLocker{client: client, lease: lease, retryInterval: 20 * time.Millisecond}
+}
+
+func (l *RedisLocker) Lock(ctx context.Context, key string, timeout time.Duration) (func(), error) {
+	key = "wepay:lock:" + key
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := l.client.SetNX(ctx, key, token, l.lease).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			var once sync.Once
+			return func() {
+				once.Do(func() {
+					// 请求的 ctx 可能已经取消了，释放锁不能因此失败
+					ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+					defer cancel()
+					if err := redisUnlockScript.Run(ctx, l.client, []string{key}, token).Err(); err != nil {
+						slog.Warn("release lock failed", "key", key, "error", err)
+					}
+				})
+			}, nil
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return nil, ErrLockTimeout
+		}
+		select {
+		case <-time.After(min(wait, l.retryInterval)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func newLockToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// LockStats 加锁的统计
+type LockStats struct {
+	Acquired int64
+	Timeouts int64
+	Errors   int64
+	WaitTime time.Duration // 所有加锁请求等待时间的总和
+}
+
+// MeteredLocker 统计加锁的次数、超时次数和等待时间
+type MeteredLocker struct {
+	Locker
+	acquired  atomic.Int64
+	timeouts  atomic.Int64
+	errors    atomic.Int64
+	waitNanos atomic.Int64
+}
+
+func NewMeteredLocker(l Locker) *MeteredLocker {
+	return &MeteredLocker{Locker: l}
+}
+
+func (l *MeteredLocker) Lock(ctx context.Context, key string, timeout time.Duration) (func(), error) {
+	start := time.Now()
+	unlock, err := l.Locker.Lock(ctx, key, timeout)
+	l.waitNanos.Add(int64(time.Since(start)))
+	switch {
+	case err == nil:
+		l.acquired.Add(1)
+	case errors.Is(err, ErrLockTimeout):
+		l.timeouts.Add(1)
+		logger.FromContext(ctx).Warn("lock timeout", "key", key, "wait", time.Since(start))
+	default:
+		l.errors.Add(1)
+		logger.FromContext(ctx).Error("lock failed", "key", key, "error", err)
+	}
+	return unlock, err
+}
+
+func (l *MeteredLocker) Stats() LockStats {
+	return LockStats{
+		Acquired: l.acquired.Load(),
+		Timeouts: l.timeouts.Load(),
+		Errors:   l.errors.Load(),
+		WaitTime: time.Duration(l.waitNanos.Load()),
+	}
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/repository"
+	"wepay/internal/repository/dao"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLocker(t *testing.T, locker Locker) {
+	ctx := context.Background()
+	unlock, err := locker.Lock(ctx, "k1", time.Second)
+	require.NoError(t, err)
+
+	_, err = locker.Lock(ctx, "k1", 50*time.Millisecond)
+	assert.ErrorIs(t, err, ErrLockTimeout)
+	unlock2, err := locker.Lock(ctx, "k2", 50*time.Millisecond)
+	require.NoError(t, err, "不同的 key 互不影响")
+	unlock2()
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = locker.Lock(cancelCtx, "k1", time.Second)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// 释放之后等待的请求拿到锁
+	done := make(chan error)
+	go func() {
+		unlock, err := locker.Lock(ctx, "k1", time.Second)
+		if err == nil {
+			unlock()
+		}
+		done <- err
+	}()
+	time.Sleep(30 * time.Millisecond)
+	unlock()
+	unlock()
+	assert.NoError(t, <-done)
+}
+
+func TestLocalLocker(t *testing.T) {
+	locker := NewLocalLocker()
+	testLocker(t, locker)
+	assert.Empty(t, locker.locks, "没有人持有和等待的锁被删除")
+}
+
+func TestRedisLocker(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	testLocker(t, NewRedisLocker(client, time.Minute))
+
+	// 锁过期后被别人拿走，原来的持有者不能把别人的锁删掉
+	ctx := context.Background()
+	locker := NewRedisLocker(client, time.Second)
+	unlock, err := locker.Lock(ctx, "k3", time.Second)
+	require.NoError(t, err)
+	mr.FastForward(2 * time.Second)
+	unlock2, err := locker.Lock(ctx, "k3", time.Second)
+	require.NoError(t, err)
+	unlock()
+	assert.True(t, mr.Exists("wepay:lock:k3"))
+	unlock2()
+	assert.False(t, mr.Exists("wepay:lock:k3"))
+}
+
+func TestMeteredLocker(t *testing.T) {
+	ctx := context.Background()
+	locker := NewMeteredLocker(NewLocalLocker())
+	unlock, err := locker.Lock(ctx, "k1", time.Second)
+	require.NoError(t, err)
+	_, err = locker.Lock(ctx, "k1", 10*time.Millisecond)
+	assert.ErrorIs(t, err, ErrLockTimeout)
+	unlock()
+
+	stats := locker.Stats()
+	assert.Equal(t, int64(1), stats.Acquired)
+	assert.Equal(t, int64(1), stats.Timeouts)
+	assert.Equal(t, int64(0), stats.Errors)
+	assert.GreaterOrEqual(t, stats.WaitTime, 10*time.Millisecond)
+}
+
+func TestTransferService_ConfirmTransferConcurrently(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	transferRepo := repository.NewTransferRepository(dao.NewTransferDao(db), nil, 0)
+	userRepo := repository.NewUserRepository(dao.NewUserDao(db), nil, 0)
+	svc := NewTransferService(transferRepo, repository.NewWithdrawRepository(dao.NewWithdrawDao(db), nil, 0),
+		nil, t.TempDir(), nil, nil, nil)
+
+	require.NoError(t, transferRepo.CreateTransferRequest(ctx, &domain.TransferRecord{
+		OutBillNo:   "b1",
+		Openid:      "o1",
+		MchId:       "m1",
+		Amount:      100,
+		Type:        domain.TransferTypeReward,
+		Status:      domain.TransferStatusWaitUserConfirm,
+		PackageInfo: "pk1",
+	}))
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		confirmed int
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := svc.ConfirmTransfer(ctx, "o1", "pk1")
+			if err == nil {
+				mu.Lock()
+				confirmed++
+				mu.Unlock()
+				return
+			}
+			assert.ErrorIs(t, err, ErrTransferConfirmed)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, confirmed)
+	_, err := svc.ConfirmTransfer(ctx, "o2", "pk1")
+	assert.ErrorIs(t, err, ErrTransferNotOwned, "其他用户不能确认")
+	balance, err := userRepo.GetAmount(ctx, "o1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), balance, "只入账一次")
+	status, err := transferRepo.GetTransferStatus(ctx, "b1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.TransferStatusSuccess, status)
+}
+
+func TestCommitContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	commitCtx, commitCancel := commitContext(ctx)
+	defer commitCancel()
+	// 请求取消之后还能写完
+	assert.NoError(t, commitCtx.Err())
+	deadline, ok := commitCtx.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(commitTimeout), deadline, time.Second)
+}
+
+// cancelOnRead 读到转账单之后取消请求，模拟调用方在确认收款的中途断开
+type cancelOnRead struct {
+	repository.TransferRepository
+	cancel context.CancelFunc
+}
+
+func (r cancelOnRead) GetTransferRecordByPackageInfo(ctx context.Context, packageInfo string) (domain.TransferRecord, error) {
+	record, err := r.TransferRepository.GetTransferRecordByPackageInfo(ctx, packageInfo)
+	r.cancel()
+	return record, err
+}
+
+func TestTransferService_ConfirmTransferCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := newTestDB(t)
+	transferRepo := repository.NewTransferRepository(dao.NewTransferDao(db), nil, 0)
+	userRepo := repository.NewUserRepository(dao.NewUserDao(db), nil, 0)
+	svc := NewTransferService(cancelOnRead{TransferRepository: transferRepo, cancel: cancel},
+		repository.NewWithdrawRepository(dao.NewWithdrawDao(db), nil, 0), nil, t.TempDir(), nil, nil, nil)
+	require.NoError(t, transferRepo.CreateTransferRequest(context.Background(), &domain.TransferRecord{
+		OutBillNo:   "b1",
+		Openid:      "o1",
+		MchId:       "m1",
+		Amount:      100,
+		Type:        domain.TransferTypeReward,
+		Status:      domain.TransferStatusWaitUserConfirm,
+		PackageInfo: "pk1",
+	}))
+
+	_, err := svc.ConfirmTransfer(ctx, "o1", "pk1")
+	require.NoError(t, err)
+	balance, err := userRepo.GetAmount(context.Background(), "o1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), balance, "请求取消之后仍然入账")
+}
+
+func TestTransferService_UpdateTransferStatusFinal(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	transferRepo := repository.NewTransferRepository(dao.NewTransferDao(db), nil, 0)
+	svc := NewTransferService(transferRepo, repository.NewWithdrawRepository(dao.NewWithdrawDao(db), nil, 0),
+		nil, t.TempDir(), nil, nil, nil)
+	require.NoError(t, transferRepo.CreateTransferRequest(ctx, &domain.TransferRecord{
+		OutBillNo:   "b1",
+		Openid:      "o1",
+		MchId:       "m1",
+		Amount:      100,
+		Type:        domain.TransferTypeReward,
+		Status:      domain.TransferStatusProcessing,
+		PackageInfo: "pk1",
+	}))
+
+	require.NoError(t, svc.UpdateTransferStatus(ctx, "b1", domain.TransferStatusSuccess, domain.TransferEventSourceNotify, "r1"))
+	// 重复的回调不报错
+	require.NoError(t, svc.UpdateTransferStatus(ctx, "b1", domain.TransferStatusSuccess, domain.TransferEventSourceNotify, "r2"))
+	// 过时的查询结果不能把成功改回转账中
+	err := svc.UpdateTransferStatus(ctx, "b1", domain.TransferStatusTransfering, domain.TransferEventSourcePoller, "")
+	assert.ErrorIs(t, err, ErrTransferFinished)
+	status, err := svc.GetTransferStatus(ctx, "b1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.TransferStatusSuccess, status)
+	timeline, err := svc.GetTransferTimeline(ctx, "b1")
+	require.NoError(t, err)
+	assert.Len(t, timeline, 1)
+}
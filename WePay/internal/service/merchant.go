@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"wepay/internal/domain"
+	"wepay/internal/repository"
+	"wepay/internal/service/wxpay_utility"
+)
+
+var ErrMerchantNotFound = repository.ErrMerchantNotFound
+
+// MchConfigProvider 按商户号取调用微信支付 API 的商户配置
+type MchConfigProvider interface {
+	MchConfig(ctx context.Context, mchid string) (*wxpay_utility.MchConfig, error)
+}
+
+// MerchantService 商户注册表，按 appid 或商户号找到出款商户及其密钥
+type MerchantService interface {
+	MchConfigProvider
+	// Register 新增或修改商户及其关联的 appid，修改后重新加载密钥
+	Register(ctx context.Context, m domain.Merchant) error
+	List(ctx context.Context) ([]domain.Merchant, error)
+	// GetByAppid 找到小程序对应的出款商户，返回的 Appids 只有这一个 appid
+	GetByAppid(ctx context.Context, appid string) (domain.Merchant, error)
+}
+
+type merchantService struct {
+	repo repository.MerchantRepository
+
+	mu      sync.RWMutex
+	configs map[string]*wxpay_utility.MchConfig // 按商户号缓存已加载密钥的配置
+}
+
+func NewMerchantService(repo repository.MerchantRepository) MerchantService {
+	return &merchantService{
+		repo:    repo,
+		configs: make(map[string]*wxpay_utility.MchConfig),
+	}
+}
+
+func (s *merchantService) Register(ctx context.Context, m domain.Merchant) error {
+	if err := s.repo.Save(ctx, m); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.configs, m.MchId)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *merchantService) List(ctx context.Context) ([]domain.Merchant, error) {
+	return s.repo.FindAll(ctx)
+}
+
+func (s *merchantService) GetByAppid(ctx context.Context, appid string) (domain.Merchant, error) {
+	return s.repo.FindByAppid(ctx, appid)
+}
+
+func (s *merchantService) MchConfig(ctx context.Context, mchid string) (*wxpay_utility.MchConfig, error) {
+	s.mu.RLock()
+	config, ok := s.configs[mchid]
+	s.mu.RUnlock()
+	if ok {
+		return config, nil
+	}
+
+	m, err := s.repo.FindByMchId(ctx, mchid)
+	if err != nil {
+		return nil, err
+	}
+	config, err = wxpay_utility.CreateMchConfig(
+		m.MchId,
+		m.CertificateSerialNo,
+		m.PrivateKeyPath,
+		m.WechatPayPublicKeyId,
+		m.WechatPayPublicKeyPath,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if m.ApiV3KeyPath != "" {
+		apiV3Key, err := wxpay_utility.LoadApiV3KeyWithPath(m.ApiV3KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := config.SetApiV3Key(apiV3Key); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	s.configs[mchid] = config
+	s.mu.Unlock()
+	return config, nil
+}
+
+// CheckMchConfigs 从文件加载所有商户的密钥，任何一个商户加载失败都返回错误，用于就绪检查。
+// 不用 MchConfig：它加载失败时用空的密钥代替，而且只在第一次加载，之后密钥文件丢失也发现不了
+func CheckMchConfigs(ctx context.Context, merchants MerchantService) error {
+	list, err := merchants.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range list {
+		if err := checkMerchantKeys(m); err != nil {
+			return fmt.Errorf("merchant %s: %w", m.MchId, err)
+		}
+	}
+	return nil
+}
+
+// checkMerchantKeys 商户私钥、微信支付公钥和配置了的 APIv3 密钥都能加载
+func checkMerchantKeys(m domain.Merchant) error {
+	if _, err := wxpay_utility.LoadPrivateKeyWithPath(m.PrivateKeyPath); err != nil {
+		return err
+	}
+	if _, err := wxpay_utility.LoadPublicKeyWithPath(m.WechatPayPublicKeyPath); err != nil {
+		return err
+	}
+	if m.ApiV3KeyPath == "" {
+		return nil
+	}
+	apiV3Key, err := wxpay_utility.LoadApiV3KeyWithPath(m.ApiV3KeyPath)
+	if err != nil {
+		return err
+	}
+	return new(wxpay_utility.MchConfig).SetApiV3Key(apiV3Key)
+}
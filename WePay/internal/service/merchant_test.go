@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"wepay/internal/domain"
+	"wepay/internal/repository"
+	"wepay/internal/repository/dao"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckMchConfigs(t *testing.T) {
+	dir := t.TempDir()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	privateKeyPath := filepath.Join(dir, "private_key.pem")
+	require.NoError(t, os.WriteFile(privateKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	der, err = x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	publicKeyPath := filepath.Join(dir, "public_key.pem")
+	require.NoError(t, os.WriteFile(publicKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+	apiV3KeyPath := filepath.Join(dir, "apiv3_key")
+	require.NoError(t, os.WriteFile(apiV3KeyPath, []byte("0123456789abcdef0123456789abcdef\n"), 0o600))
+	shortKeyPath := filepath.Join(dir, "short_key")
+	require.NoError(t, os.WriteFile(shortKeyPath, []byte("short"), 0o600))
+
+	testCases := []struct {
+		name    string
+		modify  func(m *domain.Merchant)
+		wantErr bool
+	}{
+		{name: "密钥都能加载", modify: func(m *domain.Merchant) {}},
+		{name: "没有配置 APIv3 密钥", modify: func(m *domain.Merchant) { m.ApiV3KeyPath = "" }},
+		{name: "私钥文件不存在", modify: func(m *domain.Merchant) { m.PrivateKeyPath = filepath.Join(dir, "missing.pem") }, wantErr: true},
+		{name: "公钥文件不存在", modify: func(m *domain.Merchant) { m.WechatPayPublicKeyPath = filepath.Join(dir, "missing.pem") }, wantErr: true},
+		{name: "私钥文件不是私钥", modify: func(m *domain.Merchant) { m.PrivateKeyPath = publicKeyPath }, wantErr: true},
+		{name: "APIv3 密钥长度不对", modify: func(m *domain.Merchant) { m.ApiV3KeyPath = shortKeyPath }, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			svc := NewMerchantService(repository.NewMerchantRepository(dao.NewMerchantDao(newTestDB(t))))
+			m := domain.Merchant{
+				MchId:                  "1900001109",
+				CertificateSerialNo:    "serial",
+				PrivateKeyPath:         privateKeyPath,
+				WechatPayPublicKeyId:   "PUB_KEY_ID",
+				WechatPayPublicKeyPath: publicKeyPath,
+				ApiV3KeyPath:           apiV3KeyPath,
+				Appids:                 []string{"wx1"},
+			}
+			tc.modify(&m)
+			require.NoError(t, svc.Register(ctx, m))
+			// 先加载一次配置，检查不受缓存的影响
+			_, _ = svc.MchConfig(ctx, m.MchId)
+
+			err := CheckMchConfigs(ctx, svc)
+			if tc.wantErr {
+				assert.ErrorContains(t, err, "merchant 1900001109")
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
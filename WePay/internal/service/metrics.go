@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/repository"
+	"wepay/internal/service/wxpay_utility"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// 转账链路的指标，注册在默认的 Registry 上，由 /metrics 暴露
+var (
+	transferInitiated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wepay",
+		Subsystem: "transfer",
+		Name:      "initiated_total",
+		Help:      "已写入的转账单数，包括被风控拒绝和进入审核的",
+	}, []string{"scene", "type"})
+	transferSucceeded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wepay",
+		Subsystem: "transfer",
+		Name:      "succeeded_total",
+		Help:      "转账成功的转账单数",
+	}, []string{"scene", "type"})
+	transferFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wepay",
+		Subsystem: "transfer",
+		Name:      "failed_total",
+		Help:      "转账失败的转账单数，code 为微信的失败原因或者本地的拒绝原因",
+	}, []string{"scene", "type", "code"})
+	transferTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wepay",
+		Subsystem: "transfer",
+		Name:      "status_transitions_total",
+		Help:      "转账单状态变更次数",
+	}, []string{"from", "to", "source"})
+	wxpayRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "wepay",
+		Subsystem: "wxpay",
+		Name:      "request_duration_seconds",
+		Help:      "调用微信支付 API 的耗时，code 为微信的错误码，成功时为 OK，没有错误码时为 HTTP_ 加状态码，没有拿到应答时为 error",
+		Buckets:   []float64{0.05, 0.1, 0.2, 0.5, 1, 2, 5, 10},
+	}, []string{"api", "code"})
+)
+
+// observeTransition 记录一次状态变更，到达成功或失败时计入对应的转账单数
+func observeTransition(before domain.TransferRecord, state, source string) {
+	transferTransitions.WithLabelValues(before.Status, state, source).Inc()
+	switch state {
+	case domain.TransferStatusSuccess:
+		transferSucceeded.WithLabelValues(before.SceneId, before.Type).Inc()
+	case domain.TransferStatusFail:
+		transferFailed.WithLabelValues(before.SceneId, before.Type, failCode(before.FailReason)).Inc()
+	}
+}
+
+// failCode 失败原因里冒号后面是人工填写的说明，不作为标签
+func failCode(reason string) string {
+	code, _, _ := strings.Cut(reason, ":")
+	return code
+}
+
+// observeWxpayRequest 记录一次微信支付 API 调用的耗时
+func observeWxpayRequest(api string, start time.Time, err error) {
+	wxpayRequestDuration.WithLabelValues(api, wxpayCode(err)).Observe(time.Since(start).Seconds())
+}
+
+// wxpayCode 同一个 HTTP 状态码对应很多种错误，按微信的错误码区分
+func wxpayCode(err error) string {
+	if err == nil {
+		return "OK"
+	}
+	apiErr, ok := wxpay_utility.AsApiException(err)
+	if !ok {
+		return "error"
+	}
+	if apiErr.ErrorCode() != "" {
+		return string(apiErr.ErrorCode())
+	}
+	return "HTTP_" + strconv.Itoa(apiErr.StatusCode())
+}
+
+// PendingTransferCollector 采集时按状态统计还没到终态的转账单数
+type PendingTransferCollector struct {
+	repo    repository.TransferRepository
+	timeout time.Duration
+	desc    *prometheus.Desc
+}
+
+func NewPendingTransferCollector(repo repository.TransferRepository) *PendingTransferCollector {
+	return &PendingTransferCollector{
+		repo:    repo,
+		timeout: 3 * time.Second,
+		desc: prometheus.NewDesc("wepay_transfer_pending_bills",
+			"还没到终态的转账单数", []string{"status"}, nil),
+	}
+}
+
+func (c *PendingTransferCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *PendingTransferCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	counts, err := c.repo.CountUnfinishedTransfers(ctx)
+	if err != nil {
+		slog.Error("collect pending transfers failed", "error", err)
+		ch <- prometheus.NewInvalidMetric(c.desc, err)
+		return
+	}
+	for status, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count), status)
+	}
+}
+
+// LockStatsCollector 把 MeteredLocker 的统计导出为计数器
+type LockStatsCollector struct {
+	locker   *MeteredLocker
+	acquired *prometheus.Desc
+	timeouts *prometheus.Desc
+	errors   *prometheus.Desc
+	wait     *prometheus.Desc
+}
+
+func NewLockStatsCollector(locker *MeteredLocker) *LockStatsCollector {
+	return &LockStatsCollector{
+		locker:   locker,
+		acquired: prometheus.NewDesc("wepay_lock_acquired_total", "加锁成功的次数", nil, nil),
+		timeouts: prometheus.NewDesc("wepay_lock_timeouts_total", "等锁超时的次数", nil, nil),
+		errors:   prometheus.NewDesc("wepay_lock_errors_total", "加锁出错的次数", nil, nil),
+		wait:     prometheus.NewDesc("wepay_lock_wait_seconds_total", "所有加锁请求等待时间的总和", nil, nil),
+	}
+}
+
+func (c *LockStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquired
+	ch <- c.timeouts
+	ch <- c.errors
+	ch <- c.wait
+}
+
+func (c *LockStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.locker.Stats()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.CounterValue, float64(stats.Acquired))
+	ch <- prometheus.MustNewConstMetric(c.timeouts, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(stats.Errors))
+	ch <- prometheus.MustNewConstMetric(c.wait, prometheus.CounterValue, stats.WaitTime.Seconds())
+}
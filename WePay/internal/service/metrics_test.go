@@ -0,0 +1,38 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"wepay/internal/service/wxpay_utility"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWxpayCode(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "成功", want: "OK"},
+		{
+			name: "微信的错误码",
+			err: fmt.Errorf("wrap: %w", wxpay_utility.NewApiException(http.StatusForbidden, http.Header{},
+				[]byte(`{"code":"NOT_ENOUGH","message":"余额不足"}`))),
+			want: "NOT_ENOUGH",
+		},
+		{
+			name: "没有错误码",
+			err:  wxpay_utility.NewApiException(http.StatusBadGateway, http.Header{}, []byte("bad gateway")),
+			want: "HTTP_502",
+		},
+		{name: "没有拿到应答", err: errors.New("timeout"), want: "error"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, wxpayCode(tc.err))
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: ./internal/service/merchant.go
+//
+// Generated by this command:
+//
+//	mockgen -source=./internal/service/merchant.go -destination=./internal/service/mocks/merchant.go -package=svcmocks
+//
+
+// Package svcmocks is a generated GoMock package.
+package svcmocks
+
+import (
+	context "context"
+	reflect "reflect"
+	domain "wepay/internal/domain"
+	wxpay_utility "wepay/internal/service/wxpay_utility"
+
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockMchConfigProvider is a mock of MchConfigProvider interface.
+type MockMchConfigProvider struct {
+	ctrl     *gomock.Controller
+	recorder *MockMchConfigProviderMockRecorder
+	isgomock struct{}
+}
+
+// MockMchConfigProviderMockRecorder is the mock recorder for MockMchConfigProvider.
+type MockMchConfigProviderMockRecorder struct {
+	mock *MockMchConfigProvider
+}
+
+// NewMockMchConfigProvider creates a new mock instance.
+func NewMockMchConfigProvider(ctrl *gomock.Controller) *MockMchConfigProvider {
+	mock := &MockMchConfigProvider{ctrl: ctrl}
+	mock.recorder = &MockMchConfigProviderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMchConfigProvider) EXPECT() *MockMchConfigProviderMockRecorder {
+	return m.recorder
+}
+
+// MchConfig mocks base method.
+func (m *MockMchConfigProvider) MchConfig(ctx context.Context, mchid string) (*wxpay_utility.MchConfig, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MchConfig", ctx, mchid)
+	ret0, _ := ret[0].(*wxpay_utility.MchConfig)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MchConfig indicates an expected call of MchConfig.
+func (mr *MockMchConfigProviderMockRecorder) MchConfig(ctx, mchid any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MchConfig", reflect.TypeOf((*MockMchConfigProvider)(nil).MchConfig), ctx, mchid)
+}
+
+// MockMerchantService is a mock of MerchantService interface.
+type MockMerchantService struct {
+	ctrl     *gomock.Controller
+	recorder *MockMerchantServiceMockRecorder
+	isgomock struct{}
+}
+
+// MockMerchantServiceMockRecorder is the mock recorder for MockMerchantService.
+type MockMerchantServiceMockRecorder struct {
+	mock *MockMerchantService
+}
+
+// NewMockMerchantService creates a new mock instance.
+func NewMockMerchantService(ctrl *gomock.Controller) *MockMerchantService {
+	mock := &MockMerchantService{ctrl: ctrl}
+	mock.recorder = &MockMerchantServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMerchantService) EXPECT() *MockMerchantServiceMockRecorder {
+	return m.recorder
+}
+
+// GetByAppid mocks base method.
+func (m *MockMerchantService) GetByAppid(ctx context.Context, appid string) (domain.Merchant, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByAppid", ctx, appid)
+	ret0, _ := ret[0].(domain.Merchant)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByAppid indicates an expected call of GetByAppid.
+func (mr *MockMerchantServiceMockRecorder) GetByAppid(ctx, appid any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByAppid", reflect.TypeOf((*MockMerchantService)(nil).GetByAppid), ctx, appid)
+}
+
+// List mocks base method.
+func (m *MockMerchantService) List(ctx context.Context) ([]domain.Merchant, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]domain.Merchant)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockMerchantServiceMockRecorder) List(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockMerchantService)(nil).List), ctx)
+}
+
+// MchConfig mocks base method.
+func (m *MockMerchantService) MchConfig(ctx context.Context, mchid string) (*wxpay_utility.MchConfig, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MchConfig", ctx, mchid)
+	ret0, _ := ret[0].(*wxpay_utility.MchConfig)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MchConfig indicates an expected call of MchConfig.
+func (mr *MockMerchantServiceMockRecorder) MchConfig(ctx, mchid any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MchConfig", reflect.TypeOf((*MockMerchantService)(nil).MchConfig), ctx, mchid)
+}
+
+// Register mocks base method.
+func (m_2 *MockMerchantService) Register(ctx context.Context, m domain.Merchant) error {
+	m_2.ctrl.T.Helper()
+	ret := m_2.ctrl.Call(m_2, "Register", ctx, m)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Register indicates an expected call of Register.
+func (mr *MockMerchantServiceMockRecorder) Register(ctx, m any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockMerchantService)(nil).Register), ctx, m)
+}
@@ -0,0 +1,98 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: ./internal/service/reconcile.go
+//
+// Generated by this command:
+//
+//	mockgen -source=./internal/service/reconcile.go -destination=./internal/service/mocks/reconcile.go -package=svcmocks
+//
+
+// Package svcmocks is a generated GoMock package.
+package svcmocks
+
+import (
+	context "context"
+	io "io"
+	reflect "reflect"
+	time "time"
+	domain "wepay/internal/domain"
+
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockReconcileService is a mock of ReconcileService interface.
+type MockReconcileService struct {
+	ctrl     *gomock.Controller
+	recorder *MockReconcileServiceMockRecorder
+	isgomock struct{}
+}
+
+// MockReconcileServiceMockRecorder is the mock recorder for MockReconcileService.
+type MockReconcileServiceMockRecorder struct {
+	mock *MockReconcileService
+}
+
+// NewMockReconcileService creates a new mock instance.
+func NewMockReconcileService(ctrl *gomock.Controller) *MockReconcileService {
+	mock := &MockReconcileService{ctrl: ctrl}
+	mock.recorder = &MockReconcileServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockReconcileService) EXPECT() *MockReconcileServiceMockRecorder {
+	return m.recorder
+}
+
+// Reconcile mocks base method.
+func (m *MockReconcileService) Reconcile(ctx context.Context, mchid string, date time.Time) (domain.ReconcileReport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reconcile", ctx, mchid, date)
+	ret0, _ := ret[0].(domain.ReconcileReport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reconcile indicates an expected call of Reconcile.
+func (mr *MockReconcileServiceMockRecorder) Reconcile(ctx, mchid, date any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reconcile", reflect.TypeOf((*MockReconcileService)(nil).Reconcile), ctx, mchid, date)
+}
+
+// MockBillSource is a mock of BillSource interface.
+type MockBillSource struct {
+	ctrl     *gomock.Controller
+	recorder *MockBillSourceMockRecorder
+	isgomock struct{}
+}
+
+// MockBillSourceMockRecorder is the mock recorder for MockBillSource.
+type MockBillSourceMockRecorder struct {
+	mock *MockBillSource
+}
+
+// NewMockBillSource creates a new mock instance.
+func NewMockBillSource(ctrl *gomock.Controller) *MockBillSource {
+	mock := &MockBillSource{ctrl: ctrl}
+	mock.recorder = &MockBillSourceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBillSource) EXPECT() *MockBillSourceMockRecorder {
+	return m.recorder
+}
+
+// FetchBill mocks base method.
+func (m *MockBillSource) FetchBill(ctx context.Context, mchid string, date time.Time) (io.ReadCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FetchBill", ctx, mchid, date)
+	ret0, _ := ret[0].(io.ReadCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FetchBill indicates an expected call of FetchBill.
+func (mr *MockBillSourceMockRecorder) FetchBill(ctx, mchid, date any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FetchBill", reflect.TypeOf((*MockBillSource)(nil).FetchBill), ctx, mchid, date)
+}
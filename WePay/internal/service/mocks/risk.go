@@ -0,0 +1,86 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: ./internal/service/risk.go
+//
+// Generated by this command:
+//
+//	mockgen -source=./internal/service/risk.go -destination=./internal/service/mocks/risk.go -package=svcmocks
+//
+
+// Package svcmocks is a generated GoMock package.
+package svcmocks
+
+import (
+	context "context"
+	reflect "reflect"
+	domain "wepay/internal/domain"
+
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockRiskService is a mock of RiskService interface.
+type MockRiskService struct {
+	ctrl     *gomock.Controller
+	recorder *MockRiskServiceMockRecorder
+	isgomock struct{}
+}
+
+// MockRiskServiceMockRecorder is the mock recorder for MockRiskService.
+type MockRiskServiceMockRecorder struct {
+	mock *MockRiskService
+}
+
+// NewMockRiskService creates a new mock instance.
+func NewMockRiskService(ctrl *gomock.Controller) *MockRiskService {
+	mock := &MockRiskService{ctrl: ctrl}
+	mock.recorder = &MockRiskServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRiskService) EXPECT() *MockRiskServiceMockRecorder {
+	return m.recorder
+}
+
+// Evaluate mocks base method.
+func (m *MockRiskService) Evaluate(ctx context.Context, in domain.RiskInput) (domain.RiskResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Evaluate", ctx, in)
+	ret0, _ := ret[0].(domain.RiskResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Evaluate indicates an expected call of Evaluate.
+func (mr *MockRiskServiceMockRecorder) Evaluate(ctx, in any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Evaluate", reflect.TypeOf((*MockRiskService)(nil).Evaluate), ctx, in)
+}
+
+// GetRules mocks base method.
+func (m *MockRiskService) GetRules(ctx context.Context) (domain.RiskRules, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRules", ctx)
+	ret0, _ := ret[0].(domain.RiskRules)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRules indicates an expected call of GetRules.
+func (mr *MockRiskServiceMockRecorder) GetRules(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRules", reflect.TypeOf((*MockRiskService)(nil).GetRules), ctx)
+}
+
+// UpdateRules mocks base method.
+func (m *MockRiskService) UpdateRules(ctx context.Context, operator string, rules domain.RiskRules) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateRules", ctx, operator, rules)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateRules indicates an expected call of UpdateRules.
+func (mr *MockRiskServiceMockRecorder) UpdateRules(ctx, operator, rules any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRules", reflect.TypeOf((*MockRiskService)(nil).UpdateRules), ctx, operator, rules)
+}
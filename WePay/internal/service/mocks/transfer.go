@@ -57,18 +57,107 @@ func (mr *MockTransferServiceMockRecorder) AddTransferRequest(ctx, req any) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddTransferRequest", reflect.TypeOf((*MockTransferService)(nil).AddTransferRequest), ctx, req)
 }
 
+// ApplyTransferReceipt mocks base method.
+func (m *MockTransferService) ApplyTransferReceipt(ctx context.Context, config *wxpay_utility.MchConfig, outbillno string) (*service.TransferReceiptEntity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ApplyTransferReceipt", ctx, config, outbillno)
+	ret0, _ := ret[0].(*service.TransferReceiptEntity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ApplyTransferReceipt indicates an expected call of ApplyTransferReceipt.
+func (mr *MockTransferServiceMockRecorder) ApplyTransferReceipt(ctx, config, outbillno any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ApplyTransferReceipt", reflect.TypeOf((*MockTransferService)(nil).ApplyTransferReceipt), ctx, config, outbillno)
+}
+
+// ApproveTransfer mocks base method.
+func (m *MockTransferService) ApproveTransfer(ctx context.Context, outbillno, operator string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ApproveTransfer", ctx, outbillno, operator)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ApproveTransfer indicates an expected call of ApproveTransfer.
+func (mr *MockTransferServiceMockRecorder) ApproveTransfer(ctx, outbillno, operator any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ApproveTransfer", reflect.TypeOf((*MockTransferService)(nil).ApproveTransfer), ctx, outbillno, operator)
+}
+
+// CancelTransfer mocks base method.
+func (m *MockTransferService) CancelTransfer(ctx context.Context, config *wxpay_utility.MchConfig, outbillno, operator string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelTransfer", ctx, config, outbillno, operator)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CancelTransfer indicates an expected call of CancelTransfer.
+func (mr *MockTransferServiceMockRecorder) CancelTransfer(ctx, config, outbillno, operator any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelTransfer", reflect.TypeOf((*MockTransferService)(nil).CancelTransfer), ctx, config, outbillno, operator)
+}
+
+// ConfirmTransfer mocks base method.
+func (m *MockTransferService) ConfirmTransfer(ctx context.Context, openid, packageInfo string) (domain.TransferRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ConfirmTransfer", ctx, openid, packageInfo)
+	ret0, _ := ret[0].(domain.TransferRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ConfirmTransfer indicates an expected call of ConfirmTransfer.
+func (mr *MockTransferServiceMockRecorder) ConfirmTransfer(ctx, openid, packageInfo any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConfirmTransfer", reflect.TypeOf((*MockTransferService)(nil).ConfirmTransfer), ctx, openid, packageInfo)
+}
+
+// DispatchPendingTransfers mocks base method.
+func (m *MockTransferService) DispatchPendingTransfers(ctx context.Context, configs service.MchConfigProvider, limit int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DispatchPendingTransfers", ctx, configs, limit)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DispatchPendingTransfers indicates an expected call of DispatchPendingTransfers.
+func (mr *MockTransferServiceMockRecorder) DispatchPendingTransfers(ctx, configs, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DispatchPendingTransfers", reflect.TypeOf((*MockTransferService)(nil).DispatchPendingTransfers), ctx, configs, limit)
+}
+
+// DownloadTransferReceipt mocks base method.
+func (m *MockTransferService) DownloadTransferReceipt(ctx context.Context, config *wxpay_utility.MchConfig, outbillno string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DownloadTransferReceipt", ctx, config, outbillno)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DownloadTransferReceipt indicates an expected call of DownloadTransferReceipt.
+func (mr *MockTransferServiceMockRecorder) DownloadTransferReceipt(ctx, config, outbillno any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadTransferReceipt", reflect.TypeOf((*MockTransferService)(nil).DownloadTransferReceipt), ctx, config, outbillno)
+}
+
 // GenerateOutBillNo mocks base method.
-func (m *MockTransferService) GenerateOutBillNo(openid string, amount int64) string {
+func (m *MockTransferService) GenerateOutBillNo() string {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GenerateOutBillNo", openid, amount)
+	ret := m.ctrl.Call(m, "GenerateOutBillNo")
 	ret0, _ := ret[0].(string)
 	return ret0
 }
 
 // GenerateOutBillNo indicates an expected call of GenerateOutBillNo.
-func (mr *MockTransferServiceMockRecorder) GenerateOutBillNo(openid, amount any) *gomock.Call {
+func (mr *MockTransferServiceMockRecorder) GenerateOutBillNo() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GenerateOutBillNo", reflect.TypeOf((*MockTransferService)(nil).GenerateOutBillNo), openid, amount)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GenerateOutBillNo", reflect.TypeOf((*MockTransferService)(nil).GenerateOutBillNo))
 }
 
 // GetTransferRecordByOutBillNo mocks base method.
@@ -116,31 +205,136 @@ func (mr *MockTransferServiceMockRecorder) GetTransferStatus(ctx, outbillno any)
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransferStatus", reflect.TypeOf((*MockTransferService)(nil).GetTransferStatus), ctx, outbillno)
 }
 
+// GetTransferTimeline mocks base method.
+func (m *MockTransferService) GetTransferTimeline(ctx context.Context, outbillno string) ([]domain.TransferStatusEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTransferTimeline", ctx, outbillno)
+	ret0, _ := ret[0].([]domain.TransferStatusEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTransferTimeline indicates an expected call of GetTransferTimeline.
+func (mr *MockTransferServiceMockRecorder) GetTransferTimeline(ctx, outbillno any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTransferTimeline", reflect.TypeOf((*MockTransferService)(nil).GetTransferTimeline), ctx, outbillno)
+}
+
+// InitiateTransfer mocks base method.
+func (m *MockTransferService) InitiateTransfer(ctx context.Context, config *wxpay_utility.MchConfig, bill *domain.TransferRecord, request *service.TransferToUserRequest) (*service.TransferToUserResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InitiateTransfer", ctx, config, bill, request)
+	ret0, _ := ret[0].(*service.TransferToUserResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InitiateTransfer indicates an expected call of InitiateTransfer.
+func (mr *MockTransferServiceMockRecorder) InitiateTransfer(ctx, config, bill, request any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InitiateTransfer", reflect.TypeOf((*MockTransferService)(nil).InitiateTransfer), ctx, config, bill, request)
+}
+
+// ListTransferHistory mocks base method.
+func (m *MockTransferService) ListTransferHistory(ctx context.Context, query domain.TransferHistoryQuery) ([]domain.TransferRecord, int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListTransferHistory", ctx, query)
+	ret0, _ := ret[0].([]domain.TransferRecord)
+	ret1, _ := ret[1].(int64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ListTransferHistory indicates an expected call of ListTransferHistory.
+func (mr *MockTransferServiceMockRecorder) ListTransferHistory(ctx, query any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTransferHistory", reflect.TypeOf((*MockTransferService)(nil).ListTransferHistory), ctx, query)
+}
+
+// QueryTransferBill mocks base method.
+func (m *MockTransferService) QueryTransferBill(ctx context.Context, config *wxpay_utility.MchConfig, outbillno string) (*service.TransferBillEntity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryTransferBill", ctx, config, outbillno)
+	ret0, _ := ret[0].(*service.TransferBillEntity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryTransferBill indicates an expected call of QueryTransferBill.
+func (mr *MockTransferServiceMockRecorder) QueryTransferBill(ctx, config, outbillno any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryTransferBill", reflect.TypeOf((*MockTransferService)(nil).QueryTransferBill), ctx, config, outbillno)
+}
+
+// QueryTransferReceipt mocks base method.
+func (m *MockTransferService) QueryTransferReceipt(ctx context.Context, config *wxpay_utility.MchConfig, outbillno string) (*service.TransferReceiptEntity, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryTransferReceipt", ctx, config, outbillno)
+	ret0, _ := ret[0].(*service.TransferReceiptEntity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryTransferReceipt indicates an expected call of QueryTransferReceipt.
+func (mr *MockTransferServiceMockRecorder) QueryTransferReceipt(ctx, config, outbillno any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryTransferReceipt", reflect.TypeOf((*MockTransferService)(nil).QueryTransferReceipt), ctx, config, outbillno)
+}
+
+// RejectTransfer mocks base method.
+func (m *MockTransferService) RejectTransfer(ctx context.Context, outbillno, operator, reason string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RejectTransfer", ctx, outbillno, operator, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RejectTransfer indicates an expected call of RejectTransfer.
+func (mr *MockTransferServiceMockRecorder) RejectTransfer(ctx, outbillno, operator, reason any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RejectTransfer", reflect.TypeOf((*MockTransferService)(nil).RejectTransfer), ctx, outbillno, operator, reason)
+}
+
+// SyncTransferStatus mocks base method.
+func (m *MockTransferService) SyncTransferStatus(ctx context.Context, config *wxpay_utility.MchConfig, outbillno, source, payloadRef string) (domain.TransferRecord, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SyncTransferStatus", ctx, config, outbillno, source, payloadRef)
+	ret0, _ := ret[0].(domain.TransferRecord)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SyncTransferStatus indicates an expected call of SyncTransferStatus.
+func (mr *MockTransferServiceMockRecorder) SyncTransferStatus(ctx, config, outbillno, source, payloadRef any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SyncTransferStatus", reflect.TypeOf((*MockTransferService)(nil).SyncTransferStatus), ctx, config, outbillno, source, payloadRef)
+}
+
 // TransferToUser mocks base method.
-func (m *MockTransferService) TransferToUser(config *wxpay_utility.MchConfig, request *service.TransferToUserRequest) (*service.TransferToUserResponse, error) {
+func (m *MockTransferService) TransferToUser(ctx context.Context, config *wxpay_utility.MchConfig, request *service.TransferToUserRequest) (*service.TransferToUserResponse, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "TransferToUser", config, request)
+	ret := m.ctrl.Call(m, "TransferToUser", ctx, config, request)
 	ret0, _ := ret[0].(*service.TransferToUserResponse)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // TransferToUser indicates an expected call of TransferToUser.
-func (mr *MockTransferServiceMockRecorder) TransferToUser(config, request any) *gomock.Call {
+func (mr *MockTransferServiceMockRecorder) TransferToUser(ctx, config, request any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferToUser", reflect.TypeOf((*MockTransferService)(nil).TransferToUser), config, request)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferToUser", reflect.TypeOf((*MockTransferService)(nil).TransferToUser), ctx, config, request)
 }
 
 // UpdateTransferStatus mocks base method.
-func (m *MockTransferService) UpdateTransferStatus(ctx context.Context, outbillno, state string) error {
+func (m *MockTransferService) UpdateTransferStatus(ctx context.Context, outbillno, state, source, payloadRef string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateTransferStatus", ctx, outbillno, state)
+	ret := m.ctrl.Call(m, "UpdateTransferStatus", ctx, outbillno, state, source, payloadRef)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdateTransferStatus indicates an expected call of UpdateTransferStatus.
-func (mr *MockTransferServiceMockRecorder) UpdateTransferStatus(ctx, outbillno, state any) *gomock.Call {
+func (mr *MockTransferServiceMockRecorder) UpdateTransferStatus(ctx, outbillno, state, source, payloadRef any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTransferStatus", reflect.TypeOf((*MockTransferService)(nil).UpdateTransferStatus), ctx, outbillno, state)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTransferStatus", reflect.TypeOf((*MockTransferService)(nil).UpdateTransferStatus), ctx, outbillno, state, source, payloadRef)
 }
@@ -40,6 +40,21 @@ func (m *MockUserService) EXPECT() *MockUserServiceMockRecorder {
 	return m.recorder
 }
 
+// AdjustBalance mocks base method.
+func (m *MockUserService) AdjustBalance(ctx context.Context, operator, openid string, delta int64, reason string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AdjustBalance", ctx, operator, openid, delta, reason)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AdjustBalance indicates an expected call of AdjustBalance.
+func (mr *MockUserServiceMockRecorder) AdjustBalance(ctx, operator, openid, delta, reason any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AdjustBalance", reflect.TypeOf((*MockUserService)(nil).AdjustBalance), ctx, operator, openid, delta, reason)
+}
+
 // GetAmount mocks base method.
 func (m *MockUserService) GetAmount(ctx context.Context, openid string) (int64, error) {
 	m.ctrl.T.Helper()
@@ -0,0 +1,59 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: ./internal/service/withdraw.go
+//
+// Generated by this command:
+//
+//	mockgen -source=./internal/service/withdraw.go -destination=./internal/service/mocks/withdraw.go -package=svcmocks
+//
+
+// Package svcmocks is a generated GoMock package.
+package svcmocks
+
+import (
+	context "context"
+	reflect "reflect"
+	domain "wepay/internal/domain"
+	service "wepay/internal/service"
+	wxpay_utility "wepay/internal/service/wxpay_utility"
+
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockWithdrawService is a mock of WithdrawService interface.
+type MockWithdrawService struct {
+	ctrl     *gomock.Controller
+	recorder *MockWithdrawServiceMockRecorder
+	isgomock struct{}
+}
+
+// MockWithdrawServiceMockRecorder is the mock recorder for MockWithdrawService.
+type MockWithdrawServiceMockRecorder struct {
+	mock *MockWithdrawService
+}
+
+// NewMockWithdrawService creates a new mock instance.
+func NewMockWithdrawService(ctrl *gomock.Controller) *MockWithdrawService {
+	mock := &MockWithdrawService{ctrl: ctrl}
+	mock.recorder = &MockWithdrawServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockWithdrawService) EXPECT() *MockWithdrawServiceMockRecorder {
+	return m.recorder
+}
+
+// Withdraw mocks base method.
+func (m *MockWithdrawService) Withdraw(ctx context.Context, config *wxpay_utility.MchConfig, bill *domain.TransferRecord, request *service.TransferToUserRequest) (*service.TransferToUserResponse, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Withdraw", ctx, config, bill, request)
+	ret0, _ := ret[0].(*service.TransferToUserResponse)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Withdraw indicates an expected call of Withdraw.
+func (mr *MockWithdrawServiceMockRecorder) Withdraw(ctx, config, bill, request any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Withdraw", reflect.TypeOf((*MockWithdrawService)(nil).Withdraw), ctx, config, bill, request)
+}
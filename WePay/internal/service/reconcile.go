@@ -0,0 +1,351 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/repository"
+)
+
+type ReconcileService interface {
+	// Reconcile 拉取商户 mchid 在 date 当天的微信账单，与本地这个商户的转账单逐笔核对
+	Reconcile(ctx context.Context, mchid string, date time.Time) (domain.ReconcileReport, error)
+}
+
+const (
+	// reconcileSettleLag 本地转账成功到微信记账之间的延迟，零点前这段时间里成功的单子可能记在下一天的账单里
+	reconcileSettleLag = 30 * time.Minute
+	// reconcileLookback 微信账单按记账日期出，待确认收款的单子可能在创建之后一两天才转出去
+	reconcileLookback = 2 * 24 * time.Hour
+)
+
+// BillSource 微信账单文件的来源
+type BillSource interface {
+	FetchBill(ctx context.Context, mchid string, date time.Time) (io.ReadCloser, error)
+}
+
+type reconcileService struct {
+	source BillSource
+	repo   repository.TransferRepository
+}
+
+func NewReconcileService(source BillSource, repo repository.TransferRepository) ReconcileService {
+	return &reconcileService{
+		source: source,
+		repo:   repo,
+	}
+}
+
+func (s *reconcileService) Reconcile(ctx context.Context, mchid string, date time.Time) (domain.ReconcileReport, error) {
+	start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, date.Location())
+	file, err := s.source.FetchBill(ctx, mchid, start)
+	if err != nil {
+		return domain.ReconcileReport{}, fmt.Errorf("fetch bill: %w", err)
+	}
+	defer file.Close()
+	rows, err := ParseWxBill(file)
+	if err != nil {
+		return domain.ReconcileReport{}, fmt.Errorf("parse bill: %w", err)
+	}
+
+	// 本地按创建时间查，往前多查几天，才能找到当天记账但是之前创建的单子
+	var records []domain.TransferRecord
+	query := domain.TransferHistoryQuery{
+		MchId:     mchid,
+		StartTime: start.Add(-reconcileLookback),
+		EndTime:   start.AddDate(0, 0, 1),
+		Limit:     500,
+	}
+	for {
+		page, err := s.repo.ListTransferRecords(ctx, query)
+		if err != nil {
+			return domain.ReconcileReport{}, err
+		}
+		records = append(records, page...)
+		if len(page) < query.Limit {
+			break
+		}
+		query.Cursor = page[len(page)-1].ID
+	}
+	// 还是找不到的单子按单号再查一次，只有确实没有的才算本地没有
+	local := make(map[string]bool, len(records))
+	for _, record := range records {
+		local[record.OutBillNo] = true
+	}
+	for _, row := range rows {
+		if local[row.OutBillNo] {
+			continue
+		}
+		record, err := s.repo.GetTransferRecordByOutBillNo(ctx, row.OutBillNo)
+		switch {
+		case err == nil:
+			records = append(records, record)
+		case !errors.Is(err, repository.ErrTransferNotFound):
+			return domain.ReconcileReport{}, err
+		}
+	}
+	return compareBills(start, rows, records), nil
+}
+
+// compareBills 以 out_bill_no 为键核对微信账单和本地转账单。
+// 微信账单按记账时间出，本地按转账单最后一次变更状态的时间判断是否应该出现在当天的账单里
+func compareBills(date time.Time, rows []WxBillRow, records []domain.TransferRecord) domain.ReconcileReport {
+	end := date.AddDate(0, 0, 1)
+	report := domain.ReconcileReport{
+		BillDate:    date,
+		WxBillCount: len(rows),
+	}
+	local := make(map[string]domain.TransferRecord, len(records))
+	for _, record := range records {
+		local[record.OutBillNo] = record
+		if !record.Utime.Before(date) && record.Utime.Before(end) {
+			report.LocalCount++
+		}
+	}
+	for _, row := range rows {
+		record, ok := local[row.OutBillNo]
+		if !ok {
+			report.Extra = append(report.Extra, domain.ReconcileItem{
+				OutBillNo: row.OutBillNo,
+				WxAmount:  row.Amount,
+				WxStatus:  row.State,
+			})
+			continue
+		}
+		delete(local, row.OutBillNo)
+		item := domain.ReconcileItem{
+			OutBillNo:   row.OutBillNo,
+			LocalAmount: record.Amount,
+			WxAmount:    row.Amount,
+			LocalStatus: record.Status,
+			WxStatus:    row.State,
+		}
+		switch {
+		case record.Amount != row.Amount:
+			report.AmountMismatched = append(report.AmountMismatched, item)
+		case record.Status != row.State:
+			report.StateMismatched = append(report.StateMismatched, item)
+		default:
+			report.Matched++
+		}
+	}
+	// 剩下的本地转账单在微信账单里找不到，只有本地认为钱在当天已经转出去的才算漏单
+	for _, record := range records {
+		if _, ok := local[record.OutBillNo]; !ok || record.Status != domain.TransferStatusSuccess ||
+			record.Utime.Before(date) || !record.Utime.Before(end) {
+			continue
+		}
+		item := domain.ReconcileItem{
+			OutBillNo:   record.OutBillNo,
+			LocalAmount: record.Amount,
+			LocalStatus: record.Status,
+		}
+		if record.Utime.Before(end.Add(-reconcileSettleLag)) {
+			report.Missing = append(report.Missing, item)
+		} else {
+			report.Unsettled = append(report.Unsettled, item)
+		}
+	}
+	return report
+}
+
+// WxBillRow 微信账单里的一笔转账单
+type WxBillRow struct {
+	OutBillNo      string
+	TransferBillNo string
+	Amount         int64 // 分
+	State          string
+}
+
+// 账单表头的别名，不同账单的列名不完全一样
+var wxBillColumns = map[string][]string{
+	"out_bill_no":      {"商户单号", "商家转账单号", "业务凭证号"},
+	"transfer_bill_no": {"微信转账单号", "微信支付业务单号"},
+	"amount":           {"转账金额(元)", "收支金额(元)"},
+	"state":            {"转账状态"},
+	"direction":        {"收支类型"},
+}
+
+var wxBillStates = map[string]string{
+	"成功":  domain.TransferStatusSuccess,
+	"失败":  domain.TransferStatusFail,
+	"已撤销": domain.TransferStatusCancelled,
+}
+
+// ParseWxBill 解析微信的账单文件（商家转账账单或资金账单）。
+// 第一行为表头，每个字段以 ` 开头，数据之后是以“总”开头的汇总行。
+// 没有转账状态列的资金账单里，支出视为转账成功，收入或者负数金额视为转账失败退回
+func ParseWxBill(r io.Reader) ([]WxBillRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = normalizeBillField(name)
+		name = strings.NewReplacer("（", "(", "）", ")").Replace(name)
+		for column, aliases := range wxBillColumns {
+			for _, alias := range aliases {
+				if name == alias {
+					columns[column] = i
+				}
+			}
+		}
+	}
+	for _, column := range []string{"out_bill_no", "amount"} {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("bill header missing column %s", column)
+		}
+	}
+
+	var rows []WxBillRow
+	index := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		first := normalizeBillField(record[0])
+		if first == "" || strings.Contains(first, "总") {
+			// 汇总行，账单明细到此为止
+			break
+		}
+		field := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return normalizeBillField(record[i])
+		}
+		row := WxBillRow{
+			OutBillNo:      field("out_bill_no"),
+			TransferBillNo: field("transfer_bill_no"),
+			State:          domain.TransferStatusSuccess,
+		}
+		row.Amount, err = parseYuan(field("amount"))
+		if err != nil {
+			return nil, fmt.Errorf("bill %s: %w", row.OutBillNo, err)
+		}
+		refund := field("direction") == "收入" || row.Amount < 0
+		if row.Amount < 0 {
+			row.Amount = -row.Amount
+		}
+		if state := field("state"); state != "" {
+			row.State = state
+			if mapped, ok := wxBillStates[state]; ok {
+				row.State = mapped
+			}
+		}
+		if i, ok := index[row.OutBillNo]; ok {
+			// 资金账单里同一单号再次出现且为退回，说明转账失败资金已退回
+			if refund {
+				rows[i].State = domain.TransferStatusFail
+			}
+			continue
+		}
+		if refund && field("state") == "" {
+			// 之前某天转出、当天退回的单子只有退回这一行
+			row.State = domain.TransferStatusFail
+		}
+		index[row.OutBillNo] = len(rows)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func normalizeBillField(field string) string {
+	return strings.TrimPrefix(strings.TrimSpace(field), "`")
+}
+
+// parseYuan 把以元为单位的金额解析成分，避免浮点误差。保留负号，退款在账单里是负数
+func parseYuan(s string) (int64, error) {
+	digits, negative := strings.CutPrefix(s, "-")
+	yuan, fen, _ := strings.Cut(digits, ".")
+	if len(fen) > 2 || strings.HasPrefix(yuan, "+") || strings.HasPrefix(yuan, "-") {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	fen += strings.Repeat("0", 2-len(fen))
+	amount, err := strconv.ParseInt(yuan+fen, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// FileBillSource 从本地目录读取账单，路径为 <商户号>/fundflow_2006-01-02.csv，用于测试和手工导入
+type FileBillSource struct {
+	dir string
+}
+
+func NewFileBillSource(dir string) *FileBillSource {
+	return &FileBillSource{dir: dir}
+}
+
+func (s *FileBillSource) FetchBill(ctx context.Context, mchid string, date time.Time) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.dir, filepath.Base(mchid), "fundflow_"+date.Format(time.DateOnly)+".csv"))
+}
+
+// WxpayBillSource 通过微信支付的申请资金账单 API 下载运营账户的资金账单
+type WxpayBillSource struct {
+	configs MchConfigProvider
+}
+
+func NewWxpayBillSource(configs MchConfigProvider) *WxpayBillSource {
+	return &WxpayBillSource{configs: configs}
+}
+
+type fundFlowBillResponse struct {
+	HashType    string `json:"hash_type"`
+	HashValue   string `json:"hash_value"`
+	DownloadUrl string `json:"download_url"`
+}
+
+func (s *WxpayBillSource) FetchBill(ctx context.Context, mchid string, date time.Time) (io.ReadCloser, error) {
+	config, err := s.configs.MchConfig(ctx, mchid)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{}
+	query.Set("bill_date", date.Format(time.DateOnly))
+	query.Set("account_type", "OPERATION")
+	var bill fundFlowBillResponse
+	err = doWxpayRequest(ctx, config, "fund_flow_bill", http.MethodGet, "/v3/bill/fundflowbill?"+query.Encode(), nil, &bill)
+	if err != nil {
+		return nil, err
+	}
+
+	// 下载地址同样需要签名，但下载的文件没有应答签名，用 hash 校验
+	_, body, err := sendWxpayRequest(ctx, config, "download_fund_flow_bill", http.MethodGet, bill.DownloadUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	ok, err := verifyDigest(body, bill.HashType, bill.HashValue)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("bill hash mismatch")
+	}
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/repository"
+	"wepay/internal/repository/dao"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWxBill(t *testing.T) {
+	date := time.Date(2025, 7, 23, 0, 0, 0, 0, time.Local)
+	file, err := NewFileBillSource("testdata").FetchBill(context.Background(), "1900001109", date)
+	require.NoError(t, err)
+	defer file.Close()
+
+	rows, err := ParseWxBill(file)
+	require.NoError(t, err)
+	assert.Equal(t, []WxBillRow{
+		{OutBillNo: "Transfer_o1_35_1", TransferBillNo: "1330000071100999991182020050700019480001", Amount: 35, State: domain.TransferStatusSuccess},
+		{OutBillNo: "Transfer_o2_100_2", TransferBillNo: "1330000071100999991182020050700019480002", Amount: 100, State: domain.TransferStatusSuccess},
+		{OutBillNo: "Transfer_o3_20_3", TransferBillNo: "1330000071100999991182020050700019480003", Amount: 20, State: domain.TransferStatusFail},
+		{OutBillNo: "Transfer_o4_8_4", TransferBillNo: "1330000071100999991182020050700019480004", Amount: 8, State: domain.TransferStatusSuccess},
+	}, rows)
+}
+
+func TestParseWxBill_Refund(t *testing.T) {
+	bill := "商家转账单号,转账金额(元)\n" +
+		"`b1,`0.35\n" +
+		"`b1,`-0.35\n" +
+		// 之前某天转出，当天只有退回的一行
+		"`b2,`-1.00\n" +
+		"总笔数\n"
+	rows, err := ParseWxBill(strings.NewReader(bill))
+	require.NoError(t, err)
+	assert.Equal(t, []WxBillRow{
+		{OutBillNo: "b1", Amount: 35, State: domain.TransferStatusFail},
+		{OutBillNo: "b2", Amount: 100, State: domain.TransferStatusFail},
+	}, rows)
+}
+
+// TestReconcileService_Reconcile 本地的单子不在按创建时间查的范围里时按单号找到，不算本地没有
+func TestReconcileService_Reconcile(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewTransferRepository(dao.NewTransferDao(newTestDB(t)), nil, 0)
+	for _, record := range []domain.TransferRecord{
+		{OutBillNo: "Transfer_o1_35_1", Amount: 35, Status: domain.TransferStatusSuccess},
+		{OutBillNo: "Transfer_o2_100_2", Amount: 100, Status: domain.TransferStatusSuccess},
+		{OutBillNo: "Transfer_o3_20_3", Amount: 20, Status: domain.TransferStatusFail},
+	} {
+		record.MchId = "1900001109"
+		record.Openid = "o1"
+		record.PackageInfo = "pk" + record.OutBillNo
+		require.NoError(t, repo.CreateTransferRequest(ctx, &record))
+	}
+
+	svc := NewReconcileService(NewFileBillSource("testdata"), repo)
+	report, err := svc.Reconcile(ctx, "1900001109", time.Date(2025, 7, 23, 15, 0, 0, 0, time.Local))
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Matched)
+	assert.Equal(t, []domain.ReconcileItem{
+		{OutBillNo: "Transfer_o4_8_4", WxAmount: 8, WxStatus: domain.TransferStatusSuccess},
+	}, report.Extra)
+	assert.Empty(t, report.Missing)
+}
+
+func TestCompareBills(t *testing.T) {
+	date := time.Date(2025, 7, 23, 0, 0, 0, 0, time.Local)
+	rows := []WxBillRow{
+		{OutBillNo: "Transfer_o1_35_1", Amount: 35, State: domain.TransferStatusSuccess},
+		{OutBillNo: "Transfer_o2_100_2", Amount: 100, State: domain.TransferStatusSuccess},
+		{OutBillNo: "Transfer_o3_20_3", Amount: 20, State: domain.TransferStatusFail},
+		{OutBillNo: "Transfer_o4_8_4", Amount: 8, State: domain.TransferStatusSuccess},
+	}
+	noon := date.Add(12 * time.Hour)
+	records := []domain.TransferRecord{
+		// 前一天晚上成功，当天零点之后才记账
+		{OutBillNo: "Transfer_o1_35_1", Amount: 35, Status: domain.TransferStatusSuccess, Utime: date.Add(-time.Minute)},
+		{OutBillNo: "Transfer_o2_100_2", Amount: 10, Status: domain.TransferStatusSuccess, Utime: noon},
+		{OutBillNo: "Transfer_o3_20_3", Amount: 20, Status: domain.TransferStatusSuccess, Utime: noon},
+		{OutBillNo: "Transfer_o5_5_5", Amount: 5, Status: domain.TransferStatusSuccess, Utime: noon},
+		// 还没转出去的单子不算漏单
+		{OutBillNo: "Transfer_o6_6_6", Amount: 6, Status: domain.TransferStatusProcessing, Utime: noon},
+		// 前一天成功的单子记在前一天的账单里
+		{OutBillNo: "Transfer_o7_7_7", Amount: 7, Status: domain.TransferStatusSuccess, Utime: date.Add(-time.Hour)},
+		// 零点前不久成功，可能记在下一天的账单里
+		{OutBillNo: "Transfer_o8_8_8", Amount: 8, Status: domain.TransferStatusSuccess, Utime: date.AddDate(0, 0, 1).Add(-time.Minute)},
+	}
+
+	report := compareBills(date, rows, records)
+	assert.False(t, report.Balanced())
+	assert.Equal(t, domain.ReconcileReport{
+		BillDate:    date,
+		WxBillCount: 4,
+		LocalCount:  5,
+		Matched:     1,
+		Missing: []domain.ReconcileItem{
+			{OutBillNo: "Transfer_o5_5_5", LocalAmount: 5, LocalStatus: domain.TransferStatusSuccess},
+		},
+		Unsettled: []domain.ReconcileItem{
+			{OutBillNo: "Transfer_o8_8_8", LocalAmount: 8, LocalStatus: domain.TransferStatusSuccess},
+		},
+		Extra: []domain.ReconcileItem{
+			{OutBillNo: "Transfer_o4_8_4", WxAmount: 8, WxStatus: domain.TransferStatusSuccess},
+		},
+		AmountMismatched: []domain.ReconcileItem{
+			{OutBillNo: "Transfer_o2_100_2", LocalAmount: 10, WxAmount: 100, LocalStatus: domain.TransferStatusSuccess, WxStatus: domain.TransferStatusSuccess},
+		},
+		StateMismatched: []domain.ReconcileItem{
+			{OutBillNo: "Transfer_o3_20_3", LocalAmount: 20, WxAmount: 20, LocalStatus: domain.TransferStatusSuccess, WxStatus: domain.TransferStatusFail},
+		},
+	}, report)
+}
+
+func TestParseYuan(t *testing.T) {
+	testCases := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "0.01", want: 1},
+		{in: "1.5", want: 150},
+		{in: "12", want: 1200},
+		{in: "-0.20", want: -20},
+		{in: "--0.20", wantErr: true},
+		{in: "0.001", wantErr: true},
+		{in: "abc", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := parseYuan(tc.in)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
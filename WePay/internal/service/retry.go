@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+	"wepay/internal/service/wxpay_utility"
+)
+
+// errorClass 调用微信 API 失败后的处理方式
+type errorClass int
+
+const (
+	errorRetryable   errorClass = iota // 可以用同一个 out_bill_no 重试
+	errorRateLimited                   // 被限频，退避更久再重试
+	errorFinal                         // 重试也不会成功，转账单直接失败
+)
+
+// classifyError 对调用微信 API 的错误分类。没有拿到 ApiException 的错误（网络错误、应答验签失败）
+// 不知道微信是否已经受理，用同一个 out_bill_no 重试是安全的
+func classifyError(err error) errorClass {
+	if _, ok := wxpay_utility.AsApiException(err); !ok {
+		return errorRetryable
+	}
+	switch {
+	case wxpay_utility.IsRateLimited(err):
+		return errorRateLimited
+	case wxpay_utility.IsRetryable(err):
+		return errorRetryable
+	default:
+		// NOT_ENOUGH、PARAM_ERROR、INVALID_REQUEST 等
+		return errorFinal
+	}
+}
+
+// RetryPolicy 调用微信 API 的重试策略
+type RetryPolicy struct {
+	MaxAttempts    int           // 最多尝试几次，包括第一次
+	BaseDelay      time.Duration // 第一次重试前的等待时间，之后每次翻倍
+	MaxDelay       time.Duration
+	RateLimitDelay time.Duration // 被限频时至少等待的时间，应答带 Retry-After 时以它为准
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	BaseDelay:      200 * time.Millisecond,
+	MaxDelay:       2 * time.Second,
+	RateLimitDelay: time.Second,
+}
+
+// Delay 第 attempt 次失败后，下一次重试前的等待时间
+func (p RetryPolicy) Delay(attempt int, err error) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	if classifyError(err) == errorRateLimited {
+		delay = max(delay, p.RateLimitDelay, retryAfter(err))
+	}
+	return delay
+}
+
+// retryAfter 读取应答的 Retry-After 头，单位秒
+func retryAfter(err error) time.Duration {
+	apiErr, ok := wxpay_utility.AsApiException(err)
+	if !ok {
+		return 0
+	}
+	seconds, convErr := strconv.Atoi(apiErr.Header().Get("Retry-After"))
+	if convErr != nil || seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// withRetry 按策略重试 fn，只重试可以重试的错误。返回最后一次的错误
+func withRetry[T any](ctx context.Context, policy RetryPolicy, fn func() (T, error)) (T, error) {
+	var (
+		res T
+		err error
+	)
+	for attempt := 1; ; attempt++ {
+		res, err = fn()
+		if err == nil || classifyError(err) == errorFinal || attempt >= policy.MaxAttempts {
+			return res, err
+		}
+		timer := time.NewTimer(policy.Delay(attempt, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+	"wepay/internal/service/wxpay_utility"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newApiError(statusCode int, code string, header http.Header) error {
+	body := []byte(`{"code":"` + code + `","message":"test"}`)
+	return wxpay_utility.NewApiException(statusCode, header, body)
+}
+
+func TestClassifyError(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want errorClass
+	}{
+		{name: "network error", err: errors.New("connection reset"), want: errorRetryable},
+		{name: "system error", err: newApiError(500, "SYSTEM_ERROR", nil), want: errorRetryable},
+		{name: "frequency limited", err: newApiError(429, "FREQUENCY_LIMITED", nil), want: errorRateLimited},
+		{name: "not enough", err: newApiError(403, "NOT_ENOUGH", nil), want: errorFinal},
+		{name: "param error", err: newApiError(400, "PARAM_ERROR", nil), want: errorFinal},
+		{name: "invalid request", err: newApiError(400, "INVALID_REQUEST", nil), want: errorFinal},
+		{name: "5xx without code", err: wxpay_utility.NewApiException(502, nil, nil), want: errorRetryable},
+		{name: "4xx without code", err: wxpay_utility.NewApiException(404, nil, nil), want: errorFinal},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, classifyError(tc.err))
+		})
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		BaseDelay:      time.Millisecond,
+		MaxDelay:       time.Millisecond,
+		RateLimitDelay: time.Millisecond,
+	}
+	testCases := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      bool
+	}{
+		{
+			name:         "success at first",
+			errs:         []error{nil},
+			wantAttempts: 1,
+		},
+		{
+			name:         "success after retry",
+			errs:         []error{newApiError(500, "SYSTEM_ERROR", nil), newApiError(429, "FREQUENCY_LIMITED", nil), nil},
+			wantAttempts: 3,
+		},
+		{
+			name:         "final error is not retried",
+			errs:         []error{newApiError(403, "NOT_ENOUGH", nil)},
+			wantAttempts: 1,
+			wantErr:      true,
+		},
+		{
+			name:         "give up after max attempts",
+			errs:         []error{errors.New("timeout"), errors.New("timeout"), errors.New("timeout"), nil},
+			wantAttempts: 3,
+			wantErr:      true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			attempts := 0
+			_, err := withRetry(context.Background(), policy, func() (int, error) {
+				err := tc.errs[attempts]
+				attempts++
+				return attempts, err
+			})
+			assert.Equal(t, tc.wantAttempts, attempts)
+			assert.Equal(t, tc.wantErr, err != nil)
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{
+		MaxAttempts:    3,
+		BaseDelay:      100 * time.Millisecond,
+		MaxDelay:       time.Second,
+		RateLimitDelay: 2 * time.Second,
+	}
+	assert.Equal(t, 100*time.Millisecond, policy.Delay(1, errors.New("timeout")))
+	assert.Equal(t, 400*time.Millisecond, policy.Delay(3, errors.New("timeout")))
+	assert.Equal(t, time.Second, policy.Delay(10, errors.New("timeout")))
+	assert.Equal(t, 2*time.Second, policy.Delay(1, newApiError(429, "FREQUENCY_LIMITED", nil)))
+	assert.Equal(t, 5*time.Second, policy.Delay(1, newApiError(429, "FREQUENCY_LIMITED", http.Header{"Retry-After": []string{"5"}})))
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/logger"
+	"wepay/internal/repository"
+	"wepay/internal/tracing"
+)
+
+var (
+	ErrTransferDenied        = errors.New("转账被风控拒绝")
+	ErrTransferUnderReview   = errors.New("转账需要人工审核")
+	ErrTransferNotReviewable = errors.New("转账单不在审核中")
+	ErrInvalidRiskRules      = errors.New("风控规则不合法")
+)
+
+const (
+	riskRulesReloadInterval = 30 * time.Second    // 其他实例修改的规则最多这么久之后生效
+	riskAverageWindow       = 30 * 24 * time.Hour // 计算平均金额的时间范围
+)
+
+type RiskService interface {
+	// Evaluate 发起转账前评估风险，命中多条规则时取最严重的决定
+	Evaluate(ctx context.Context, in domain.RiskInput) (domain.RiskResult, error)
+	// GetRules 返回当前生效的规则，还没配置过时返回默认规则
+	GetRules(ctx context.Context) (domain.RiskRules, error)
+	// UpdateRules 校验并保存新规则，当前实例立即生效，其他实例在重新加载后生效
+	UpdateRules(ctx context.Context, operator string, rules domain.RiskRules) error
+}
+
+type riskService struct {
+	repo         repository.RiskRepository
+	transferRepo repository.TransferRepository
+	now          func() time.Time
+
+	mu       sync.RWMutex
+	rules    domain.RiskRules
+	loadedAt time.Time // 零值表示还没加载过
+}
+
+func NewRiskService(repo repository.RiskRepository, transferRepo repository.TransferRepository) RiskService {
+	return &riskService{repo: repo, transferRepo: transferRepo, now: time.Now}
+}
+
+func (s *riskService) Evaluate(ctx context.Context, in domain.RiskInput) (_ domain.RiskResult, err error) {
+	ctx, span := tracing.Start(ctx, "RiskService.Evaluate")
+	defer tracing.End(span, &err)
+	rules, err := s.GetRules(ctx)
+	if err != nil {
+		return domain.RiskResult{}, err
+	}
+	now := s.now()
+	res := domain.RiskResult{Decision: domain.RiskDecisionAllow}
+	hit := func(decision, reason string) {
+		res.Reasons = append(res.Reasons, reason)
+		if riskSeverity(decision) > riskSeverity(res.Decision) {
+			res.Decision = decision
+		}
+	}
+
+	if slices.Contains(rules.Blocklist.Openids, in.Openid) {
+		hit(domain.RiskDecisionDeny, "blocklist:openid")
+	}
+	if in.DeviceId != "" && slices.Contains(rules.Blocklist.Devices, in.DeviceId) {
+		hit(domain.RiskDecisionDeny, "blocklist:device")
+	}
+	if in.ClientIp != "" && slices.Contains(rules.Blocklist.IPs, in.ClientIp) {
+		hit(domain.RiskDecisionDeny, "blocklist:ip")
+	}
+	switch amount := rules.Amount; {
+	case amount.DenyAbove > 0 && in.Amount > amount.DenyAbove:
+		hit(domain.RiskDecisionDeny, "amount:deny_above")
+	case amount.ReviewAbove > 0 && in.Amount > amount.ReviewAbove:
+		hit(domain.RiskDecisionReview, "amount:review_above")
+	}
+	// 已经拒绝了，不用再查数据库
+	if res.Decision == domain.RiskDecisionDeny {
+		return res, nil
+	}
+
+	if rules.Amount.AverageMultiple > 0 {
+		avg, count, err := s.transferRepo.AvgTransferAmount(ctx, domain.TransferHistoryQuery{
+			Openid:    in.Openid,
+			Status:    domain.TransferStatusSuccess,
+			StartTime: now.Add(-riskAverageWindow),
+		})
+		if err != nil {
+			return domain.RiskResult{}, err
+		}
+		if count > 0 && float64(in.Amount) > float64(avg)*rules.Amount.AverageMultiple {
+			hit(domain.RiskDecisionReview, "amount:average")
+		}
+	}
+
+	if hold := rules.NewAccount; hold.HoldSeconds > 0 && in.Amount > hold.MaxAmount {
+		first, err := s.transferRepo.FirstTransferTime(ctx, in.Openid)
+		if err != nil {
+			return domain.RiskResult{}, err
+		}
+		if first.IsZero() || now.Sub(first) < time.Duration(hold.HoldSeconds)*time.Second {
+			hit(domain.RiskDecisionReview, "new_account")
+		}
+	}
+
+	for _, rule := range rules.Velocity {
+		query := domain.TransferHistoryQuery{StartTime: now.Add(-time.Duration(rule.WindowSeconds) * time.Second)}
+		switch rule.Dimension {
+		case domain.RiskDimensionOpenid:
+			query.Openid = in.Openid
+		case domain.RiskDimensionDevice:
+			query.DeviceId = in.DeviceId
+		case domain.RiskDimensionIP:
+			query.ClientIp = in.ClientIp
+		}
+		// 没有上报设备或者 IP 时不统计，否则所有没上报的请求会算在一起
+		if query.Openid == "" && query.DeviceId == "" && query.ClientIp == "" {
+			continue
+		}
+		count, err := s.transferRepo.CountTransferRecords(ctx, query)
+		if err != nil {
+			return domain.RiskResult{}, err
+		}
+		if count+1 > rule.MaxCount {
+			hit(rule.Decision, fmt.Sprintf("velocity:%s:%d/%ds", rule.Dimension, rule.MaxCount, rule.WindowSeconds))
+		}
+	}
+	return res, nil
+}
+
+func riskSeverity(decision string) int {
+	switch decision {
+	case domain.RiskDecisionDeny:
+		return 2
+	case domain.RiskDecisionReview:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// GetRules 规则缓存 riskRulesReloadInterval，重新加载失败时继续用旧的规则
+func (s *riskService) GetRules(ctx context.Context) (domain.RiskRules, error) {
+	s.mu.RLock()
+	rules, loadedAt := s.rules, s.loadedAt
+	s.mu.RUnlock()
+	if !loadedAt.IsZero() && s.now().Sub(loadedAt) < riskRulesReloadInterval {
+		return rules, nil
+	}
+
+	fresh, err := s.repo.GetRules(ctx)
+	if errors.Is(err, repository.ErrRiskRulesNotFound) {
+		fresh, err = domain.DefaultRiskRules(), nil
+	}
+	if err != nil {
+		if loadedAt.IsZero() {
+			return domain.RiskRules{}, err
+		}
+		logger.FromContext(ctx).Warn("reload risk rules failed, keep using the old rules", "error", err)
+		return rules, nil
+	}
+	s.setRules(fresh)
+	return fresh, nil
+}
+
+func (s *riskService) UpdateRules(ctx context.Context, operator string, rules domain.RiskRules) error {
+	if err := validateRiskRules(rules); err != nil {
+		return err
+	}
+	if err := s.repo.SaveRules(ctx, rules, operator); err != nil {
+		return err
+	}
+	s.setRules(rules)
+	return nil
+}
+
+func (s *riskService) setRules(rules domain.RiskRules) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = rules
+	s.loadedAt = s.now()
+}
+
+func validateRiskRules(rules domain.RiskRules) error {
+	for _, rule := range rules.Velocity {
+		switch rule.Dimension {
+		case domain.RiskDimensionOpenid, domain.RiskDimensionDevice, domain.RiskDimensionIP:
+		default:
+			return fmt.Errorf("%w: 未知的维度 %q", ErrInvalidRiskRules, rule.Dimension)
+		}
+		if rule.WindowSeconds <= 0 || rule.MaxCount <= 0 {
+			return fmt.Errorf("%w: 频率规则的窗口和次数必须大于 0", ErrInvalidRiskRules)
+		}
+		if rule.Decision != domain.RiskDecisionReview && rule.Decision != domain.RiskDecisionDeny {
+			return fmt.Errorf("%w: 频率规则的决定只能是 REVIEW 或者 DENY", ErrInvalidRiskRules)
+		}
+	}
+	if rules.NewAccount.HoldSeconds < 0 || rules.NewAccount.MaxAmount < 0 ||
+		rules.Amount.ReviewAbove < 0 || rules.Amount.DenyAbove < 0 || rules.Amount.AverageMultiple < 0 {
+		return fmt.Errorf("%w: 不能为负数", ErrInvalidRiskRules)
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/repository"
+	"wepay/internal/repository/dao"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := dao.OpenDB(dao.DriverSQLite, filepath.Join(t.TempDir(), "wepay.db"), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, dao.InitTable(db))
+	return db
+}
+
+func TestRiskService_Evaluate(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	transferRepo := repository.NewTransferRepository(dao.NewTransferDao(db), nil, 0)
+	svc := NewRiskService(repository.NewRiskRepository(dao.NewRiskDao(db)), transferRepo)
+	require.NoError(t, svc.UpdateRules(ctx, "admin", domain.RiskRules{
+		Velocity: []domain.VelocityRule{
+			{Dimension: domain.RiskDimensionOpenid, WindowSeconds: 3600, MaxCount: 3, Decision: domain.RiskDecisionReview},
+			{Dimension: domain.RiskDimensionDevice, WindowSeconds: 3600, MaxCount: 3, Decision: domain.RiskDecisionDeny},
+		},
+		Blocklist:  domain.RiskBlocklist{Openids: []string{"bad"}, IPs: []string{"6.6.6.6"}},
+		NewAccount: domain.NewAccountRule{HoldSeconds: 3600, MaxAmount: 100},
+		Amount:     domain.AmountRule{ReviewAbove: 1000, DenyAbove: 5000, AverageMultiple: 3},
+	}))
+
+	// old 是老用户，两天前有两张成功的转账单，平均 100 分；d1 设备今天已经有三张
+	for i, openid := range []string{"old", "old", "o1", "o2", "o3"} {
+		record := &domain.TransferRecord{
+			OutBillNo:   "b" + string(rune('1'+i)),
+			Openid:      openid,
+			Amount:      100,
+			Status:      domain.TransferStatusSuccess,
+			PackageInfo: "pk" + string(rune('1'+i)),
+		}
+		if openid != "old" {
+			record.DeviceId = "d1"
+		}
+		require.NoError(t, transferRepo.CreateTransferRequest(ctx, record))
+	}
+	require.NoError(t, db.Model(&dao.TransferRequestRecord{}).Where("openid = ?", "old").
+		Update("ctime", time.Now().Add(-48*time.Hour)).Error)
+
+	testCases := []struct {
+		name         string
+		in           domain.RiskInput
+		wantDecision string
+		wantReasons  []string
+	}{
+		{name: "老用户正常金额", in: domain.RiskInput{Openid: "old", Amount: 200}, wantDecision: domain.RiskDecisionAllow},
+		{name: "新用户小额", in: domain.RiskInput{Openid: "new", Amount: 100}, wantDecision: domain.RiskDecisionAllow},
+		{name: "新用户大额", in: domain.RiskInput{Openid: "new", Amount: 101},
+			wantDecision: domain.RiskDecisionReview, wantReasons: []string{"new_account"}},
+		{name: "黑名单", in: domain.RiskInput{Openid: "bad", ClientIp: "6.6.6.6", Amount: 1},
+			wantDecision: domain.RiskDecisionDeny, wantReasons: []string{"blocklist:openid", "blocklist:ip"}},
+		{name: "超过平均金额", in: domain.RiskInput{Openid: "old", Amount: 301},
+			wantDecision: domain.RiskDecisionReview, wantReasons: []string{"amount:average"}},
+		{name: "大额审核", in: domain.RiskInput{Openid: "old", Amount: 1001},
+			wantDecision: domain.RiskDecisionReview, wantReasons: []string{"amount:review_above", "amount:average"}},
+		{name: "超大额拒绝", in: domain.RiskInput{Openid: "old", Amount: 5001},
+			wantDecision: domain.RiskDecisionDeny, wantReasons: []string{"amount:deny_above"}},
+		{name: "设备频率", in: domain.RiskInput{Openid: "o4", DeviceId: "d1", Amount: 1},
+			wantDecision: domain.RiskDecisionDeny, wantReasons: []string{"velocity:device:3/3600s"}},
+		{name: "openid 频率", in: domain.RiskInput{Openid: "old", DeviceId: "d2", Amount: 1},
+			wantDecision: domain.RiskDecisionAllow},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := svc.Evaluate(ctx, tc.in)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantDecision, res.Decision)
+			assert.Equal(t, tc.wantReasons, res.Reasons)
+		})
+	}
+}
+
+// fakeRiskRepository GetRules 返回 err
+type fakeRiskRepository struct {
+	repository.RiskRepository
+	rules domain.RiskRules
+	err   error
+}
+
+func (r *fakeRiskRepository) GetRules(ctx context.Context) (domain.RiskRules, error) {
+	return r.rules, r.err
+}
+
+func TestRiskService_GetRules(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeRiskRepository{err: repository.ErrRiskRulesNotFound}
+	now := time.Now()
+	svc := &riskService{repo: repo, now: func() time.Time { return now }}
+
+	rules, err := svc.GetRules(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, domain.DefaultRiskRules(), rules, "没有配置过时使用默认规则")
+
+	repo.rules, repo.err = domain.RiskRules{Amount: domain.AmountRule{DenyAbove: 1}}, nil
+	rules, err = svc.GetRules(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, domain.DefaultRiskRules(), rules, "还没到重新加载的时间")
+
+	now = now.Add(riskRulesReloadInterval)
+	rules, err = svc.GetRules(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rules.Amount.DenyAbove, "重新加载")
+
+	repo.err = errors.New("db error")
+	now = now.Add(riskRulesReloadInterval)
+	rules, err = svc.GetRules(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rules.Amount.DenyAbove, "加载失败时继续用旧规则")
+
+	_, err = (&riskService{repo: repo, now: time.Now}).GetRules(ctx)
+	assert.Error(t, err, "从来没加载成功过")
+}
+
+func TestValidateRiskRules(t *testing.T) {
+	assert.NoError(t, validateRiskRules(domain.DefaultRiskRules()))
+	assert.ErrorIs(t, validateRiskRules(domain.RiskRules{Velocity: []domain.VelocityRule{
+		{Dimension: "phone", WindowSeconds: 60, MaxCount: 1, Decision: domain.RiskDecisionDeny},
+	}}), ErrInvalidRiskRules)
+	assert.ErrorIs(t, validateRiskRules(domain.RiskRules{Velocity: []domain.VelocityRule{
+		{Dimension: domain.RiskDimensionIP, WindowSeconds: 0, MaxCount: 1, Decision: domain.RiskDecisionDeny},
+	}}), ErrInvalidRiskRules)
+	assert.ErrorIs(t, validateRiskRules(domain.RiskRules{Velocity: []domain.VelocityRule{
+		{Dimension: domain.RiskDimensionIP, WindowSeconds: 60, MaxCount: 1, Decision: domain.RiskDecisionAllow},
+	}}), ErrInvalidRiskRules)
+	assert.ErrorIs(t, validateRiskRules(domain.RiskRules{Amount: domain.AmountRule{DenyAbove: -1}}), ErrInvalidRiskRules)
+}
+
+func TestTransferService_RiskReview(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	transferRepo := repository.NewTransferRepository(dao.NewTransferDao(db), nil, 0)
+	outboxRepo := repository.NewTransferOutboxRepository(dao.NewTransferOutboxDao(db))
+	withdrawRepo := repository.NewWithdrawRepository(dao.NewWithdrawDao(db), nil, 0)
+	userRepo := repository.NewUserRepository(dao.NewUserDao(db), nil, 0)
+	riskSvc := NewRiskService(repository.NewRiskRepository(dao.NewRiskDao(db)), transferRepo)
+	require.NoError(t, riskSvc.UpdateRules(ctx, "admin", domain.RiskRules{
+		Amount: domain.AmountRule{ReviewAbove: 100, DenyAbove: 1000},
+	}))
+	transferSvc := NewTransferService(transferRepo, withdrawRepo, outboxRepo, t.TempDir(), nil, nil, riskSvc)
+	withdrawSvc := NewWithdrawService(withdrawRepo, transferSvc, nil)
+
+	initiate := func(outbillno string, amount int64) error {
+		bill := &domain.TransferRecord{
+			OutBillNo:   outbillno,
+			Openid:      "o1",
+			Amount:      amount,
+			Type:        domain.TransferTypeReward,
+			Status:      domain.TransferStatusProcessing,
+			PackageInfo: "pk" + outbillno,
+		}
+		// 风控不放行时不会发给微信，不需要商户配置
+		_, err := transferSvc.InitiateTransfer(ctx, nil, bill, &TransferToUserRequest{})
+		return err
+	}
+	assert.ErrorIs(t, initiate("b1", 1001), ErrTransferDenied)
+	record, err := transferRepo.GetTransferRecordByOutBillNo(ctx, "b1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.TransferStatusFail, record.Status)
+	assert.Equal(t, domain.RiskDecisionDeny, record.RiskDecision)
+	assert.Equal(t, "amount:deny_above", record.RiskReasons)
+
+	assert.ErrorIs(t, initiate("b2", 101), ErrTransferUnderReview)
+	assert.ErrorIs(t, initiate("b3", 101), ErrTransferUnderReview)
+	assert.ErrorIs(t, initiate("b3", 101), ErrTransferExists, "同一个转账单号不会重复写入")
+	due, err := outboxRepo.FindDue(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, due, "审核中的请求不发送")
+
+	require.NoError(t, transferSvc.ApproveTransfer(ctx, "b2", "admin"))
+	assert.ErrorIs(t, transferSvc.ApproveTransfer(ctx, "b2", "admin"), ErrTransferNotReviewable)
+	assert.ErrorIs(t, transferSvc.RejectTransfer(ctx, "b2", "admin", "fraud"), ErrTransferNotReviewable)
+	due, err = outboxRepo.FindDue(ctx, time.Now(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, due, "改完状态之后才发送")
+	due, err = outboxRepo.FindDue(ctx, time.Now().Add(outboxLease), 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, "b2", due[0].OutBillNo)
+	status, err := transferRepo.GetTransferStatus(ctx, "b2")
+	require.NoError(t, err)
+	assert.Equal(t, domain.TransferStatusProcessing, status)
+
+	require.NoError(t, transferSvc.RejectTransfer(ctx, "b3", "admin", "fraud"))
+	assert.ErrorIs(t, transferSvc.ApproveTransfer(ctx, "b3", "admin"), ErrTransferNotReviewable)
+	record, err = transferRepo.GetTransferRecordByOutBillNo(ctx, "b3")
+	require.NoError(t, err)
+	assert.Equal(t, domain.TransferStatusFail, record.Status)
+	assert.Equal(t, "RISK_REJECTED: fraud", record.FailReason)
+
+	// 审核中的提现不退回余额，审核不通过时退回
+	require.NoError(t, userRepo.UpdateBalance(ctx, "o2", 500))
+	_, err = withdrawSvc.Withdraw(ctx, nil, &domain.TransferRecord{
+		OutBillNo: "w1", Openid: "o2", Amount: 200, PackageInfo: "pkw1",
+	}, &TransferToUserRequest{})
+	assert.ErrorIs(t, err, ErrTransferUnderReview)
+	balance, err := userRepo.GetAmount(ctx, "o2")
+	require.NoError(t, err)
+	assert.Equal(t, int64(300), balance)
+	require.NoError(t, transferSvc.RejectTransfer(ctx, "w1", "admin", "fraud"))
+	balance, err = userRepo.GetAmount(ctx, "o2")
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), balance)
+
+	// 余额不足和风控拒绝的提现都不扣减余额
+	_, err = withdrawSvc.Withdraw(ctx, nil, &domain.TransferRecord{
+		OutBillNo: "w2", Openid: "o2", Amount: 600, PackageInfo: "pkw2",
+	}, &TransferToUserRequest{})
+	assert.ErrorIs(t, err, ErrInsufficientBalance)
+	_, err = transferRepo.GetTransferRecordByOutBillNo(ctx, "w2")
+	assert.ErrorIs(t, err, ErrTransferNotFound)
+	_, err = withdrawSvc.Withdraw(ctx, nil, &domain.TransferRecord{
+		OutBillNo: "w3", Openid: "o2", Amount: 1001, PackageInfo: "pkw3",
+	}, &TransferToUserRequest{})
+	assert.ErrorIs(t, err, ErrTransferDenied)
+	balance, err = userRepo.GetAmount(ctx, "o2")
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), balance)
+}
+
+func TestTransferService_ApproveRejectConcurrently(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	transferRepo := repository.NewTransferRepository(dao.NewTransferDao(db), nil, 0)
+	outboxRepo := repository.NewTransferOutboxRepository(dao.NewTransferOutboxDao(db))
+	transferSvc := NewTransferService(transferRepo, repository.NewWithdrawRepository(dao.NewWithdrawDao(db), nil, 0),
+		outboxRepo, t.TempDir(), nil, nil, nil)
+
+	for i := 0; i < 10; i++ {
+		outbillno := fmt.Sprintf("b%d", i)
+		_, err := transferRepo.CreateTransferRequestWithOutbox(ctx, &domain.TransferRecord{
+			OutBillNo:   outbillno,
+			Openid:      "o1",
+			Amount:      100,
+			Type:        domain.TransferTypeReward,
+			Status:      domain.TransferStatusReviewing,
+			PackageInfo: "pk" + outbillno,
+		}, domain.TransferOutbox{Status: domain.OutboxStatusHeld, NextRetryTime: time.Now()})
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		var approveErr, rejectErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			approveErr = transferSvc.ApproveTransfer(ctx, outbillno, "admin1")
+		}()
+		go func() {
+			defer wg.Done()
+			rejectErr = transferSvc.RejectTransfer(ctx, outbillno, "admin2", "fraud")
+		}()
+		wg.Wait()
+
+		// 只有一个成功，转账单的状态和请求一致
+		status, err := transferRepo.GetTransferStatus(ctx, outbillno)
+		require.NoError(t, err)
+		due, err := outboxRepo.FindDue(ctx, time.Now().Add(outboxLease), 100)
+		require.NoError(t, err)
+		sending := slices.ContainsFunc(due, func(o domain.TransferOutbox) bool { return o.OutBillNo == outbillno })
+		if approveErr == nil {
+			assert.ErrorIs(t, rejectErr, ErrTransferNotReviewable)
+			assert.Equal(t, domain.TransferStatusProcessing, status)
+			assert.True(t, sending)
+		} else {
+			assert.ErrorIs(t, approveErr, ErrTransferNotReviewable)
+			assert.NoError(t, rejectErr)
+			assert.Equal(t, domain.TransferStatusFail, status)
+			assert.False(t, sending)
+		}
+	}
+}
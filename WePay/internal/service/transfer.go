@@ -1,131 +1,312 @@
 package service
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
-	"strconv"
-	"time"
 	"wepay/internal/domain"
+	"wepay/internal/logger"
 	"wepay/internal/repository"
 	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/tracing"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type TransferService interface {
-	TransferToUser(config *wxpay_utility.MchConfig, request *TransferToUserRequest) (response *TransferToUserResponse, err error)
-	GenerateOutBillNo(openid string, amount int64) string
+	// InitiateTransfer 在同一个事务里写入转账单和待发送的请求，然后立即尝试发送。
+	// 发送失败或者进程在发送前崩溃时，由 DispatchPendingTransfers 重试，此时返回 ErrTransferPending；
+	// 微信明确拒绝时转账单失败，返回 ErrTransferRejected；余额不足暂停期间的红包返回 ErrTransferPaused。
+	// 发送前先过风控：拒绝时转账单直接失败，返回 ErrTransferDenied；需要审核时转账单进入审核队列，返回 ErrTransferUnderReview。
+	// 转账单号已经存在时返回 ErrTransferExists，微信受理后转账单的 package_info 换成微信返回的
+	InitiateTransfer(ctx context.Context, config *wxpay_utility.MchConfig, bill *domain.TransferRecord, request *TransferToUserRequest) (*TransferToUserResponse, error)
+	// ConfirmTransfer 用户在小程序确认收款后把转账单改为成功，红包计入余额。
+	// 转账单不是发给用户 openid 的时返回 ErrTransferNotOwned，已经确认过时返回 ErrTransferConfirmed，
+	// 不在待确认状态时返回 ErrTransferNotConfirmable。改状态和入账在同一个事务里，并发的确认只有一个入账
+	ConfirmTransfer(ctx context.Context, openid, packageInfo string) (domain.TransferRecord, error)
+	// ApproveTransfer 风控审核通过，转账单交给后台发送给微信。转账单不在审核中时返回 ErrTransferNotReviewable，
+	// 和 RejectTransfer 同时调用时只有一个成功
+	ApproveTransfer(ctx context.Context, outbillno, operator string) error
+	// RejectTransfer 风控审核不通过，转账单失败，提现退回余额。转账单不在审核中时返回 ErrTransferNotReviewable
+	RejectTransfer(ctx context.Context, outbillno, operator, reason string) error
+	// DispatchPendingTransfers 发送到了重试时间还没成功的请求，按请求里的商户号取商户配置，返回发送成功的条数
+	DispatchPendingTransfers(ctx context.Context, configs MchConfigProvider, limit int) (int, error)
+	TransferToUser(ctx context.Context, config *wxpay_utility.MchConfig, request *TransferToUserRequest) (response *TransferToUserResponse, err error)
+	// GenerateOutBillNo 没有幂等键时随机生成转账单号
+	GenerateOutBillNo() string
 	AddTransferRequest(ctx context.Context, req *domain.TransferRecord) error
 	GetTransferStatus(ctx context.Context, outbillno string) (string, error)
-	UpdateTransferStatus(ctx context.Context, outbillno, state string) error
+	// UpdateTransferStatus 修改转账单状态，source 为变更来源（domain.TransferEventSourceXXX），payloadRef 为原始报文的引用
+	UpdateTransferStatus(ctx context.Context, outbillno, state, source, payloadRef string) error
 	GetTransferRecordByOutBillNo(ctx context.Context, outbillno string) (domain.TransferRecord, error)
 	GetTransferRecordByPackageInfo(ctx context.Context, packageInfo string) (domain.TransferRecord, error)
+	// ListTransferHistory 分页查询用户的转账历史，nextCursor 为 0 表示没有下一页
+	ListTransferHistory(ctx context.Context, query domain.TransferHistoryQuery) (records []domain.TransferRecord, nextCursor int64, err error)
+	// QueryTransferBill 通过商户单号向微信查询转账单
+	QueryTransferBill(ctx context.Context, config *wxpay_utility.MchConfig, outbillno string) (*TransferBillEntity, error)
+	// SyncTransferStatus 向微信查询转账单并把状态同步到本地，返回同步后的转账单
+	SyncTransferStatus(ctx context.Context, config *wxpay_utility.MchConfig, outbillno, source, payloadRef string) (domain.TransferRecord, error)
+	// CancelTransfer 管理员向微信撤销还没完成的转账单，返回撤销后的状态
+	CancelTransfer(ctx context.Context, config *wxpay_utility.MchConfig, outbillno, operator string) (string, error)
+	// GetTransferTimeline 按发生顺序返回转账单的状态变更记录
+	GetTransferTimeline(ctx context.Context, outbillno string) ([]domain.TransferStatusEvent, error)
+	// ApplyTransferReceipt 为已成功的转账单申请电子回单
+	ApplyTransferReceipt(ctx context.Context, config *wxpay_utility.MchConfig, outbillno string) (*TransferReceiptEntity, error)
+	// QueryTransferReceipt 查询电子回单的生成状态
+	QueryTransferReceipt(ctx context.Context, config *wxpay_utility.MchConfig, outbillno string) (*TransferReceiptEntity, error)
+	// DownloadTransferReceipt 下载已生成的电子回单并保存到本地，返回本地文件路径，已经下载过时直接返回
+	DownloadTransferReceipt(ctx context.Context, config *wxpay_utility.MchConfig, outbillno string) (string, error)
 }
 
+var (
+	ErrTransferNotFound       = repository.ErrTransferNotFound
+	ErrTransferExists         = repository.ErrTransferExists
+	ErrTransferFinished       = repository.ErrTransferFinished
+	ErrTransferNotCancelable  = errors.New("转账单当前状态不可撤销")
+	ErrTransferRejected       = errors.New("微信拒绝了转账请求")
+	ErrTransferPending        = errors.New("微信暂时没有应答，转账单会在后台继续发送")
+	ErrTransferNotConfirmable = errors.New("转账单当前状态不能确认收款")
+	ErrTransferConfirmed      = errors.New("转账单已经确认收款")
+	ErrTransferNotOwned       = errors.New("转账单不属于这个用户")
+)
+
+const (
+	defaultHistoryPageSize = 20
+	maxHistoryPageSize     = 100
+)
+
 type transferService struct {
-	repo repository.TransferRepository
+	repo         repository.TransferRepository
+	withdrawRepo repository.WithdrawRepository
+	outboxRepo   repository.TransferOutboxRepository
+	receiptDir   string // 电子回单的存放目录
+	guard        *FundGuard
+	locker       Locker
+	risk         RiskService
+	retryPolicy  RetryPolicy
 }
 
-func NewTransferService(repo repository.TransferRepository) TransferService {
+// NewTransferService locker 为 nil 时使用进程内的锁，risk 为 nil 时不做风控
+func NewTransferService(repo repository.TransferRepository, withdrawRepo repository.WithdrawRepository,
+	outboxRepo repository.TransferOutboxRepository, receiptDir string, guard *FundGuard, locker Locker, risk RiskService) TransferService {
+	if locker == nil {
+		locker = NewLocalLocker()
+	}
 	return &transferService{
-		repo: repo,
+		repo:         repo,
+		withdrawRepo: withdrawRepo,
+		outboxRepo:   outboxRepo,
+		receiptDir:   receiptDir,
+		guard:        guard,
+		locker:       locker,
+		risk:         risk,
+		retryPolicy:  DefaultRetryPolicy,
 	}
 }
 
 // TransferToUser 发起转账到用户
-func (svc *transferService) TransferToUser(config *wxpay_utility.MchConfig, request *TransferToUserRequest) (response *TransferToUserResponse, err error) {
-	const (
-		host   = "https://api.mch.weixin.qq.com"
-		method = "POST"
-		path   = "/v3/fund-app/mch-transfer/transfer-bills"
-	)
-
-	reqUrl, err := url.Parse(fmt.Sprintf("%s%s", host, path))
+func (svc *transferService) TransferToUser(ctx context.Context, config *wxpay_utility.MchConfig, request *TransferToUserRequest) (response *TransferToUserResponse, err error) {
+	outbillno := deref(request.OutBillNo)
+	ctx, span := tracing.Start(ctx, "TransferService.TransferToUser", tracing.OutBillNo(outbillno))
+	defer tracing.End(span, &err)
+	ctx = logger.With(ctx, "out_bill_no", outbillno)
+	response = &TransferToUserResponse{}
+	err = doWxpayRequest(ctx, config, "transfer_bills", http.MethodPost, "/v3/fund-app/mch-transfer/transfer-bills", request, response)
 	if err != nil {
 		return nil, err
 	}
-	reqBody, err := json.Marshal(request)
+	return response, nil
+}
+
+// GenerateOutBillNo "TR" 加 30 位十六进制，和幂等键生成的转账单号一样长。
+// 转账单号会写进日志和微信的账单，不能带 openid 等用户信息
+func (svc *transferService) GenerateOutBillNo() string {
+	id := uuid.New()
+	return "TR" + hex.EncodeToString(id[:])[:30]
+}
+
+func (svc *transferService) AddTransferRequest(ctx context.Context, req *domain.TransferRecord) error {
+
+	err := svc.repo.CreateTransferRequest(ctx, req)
 	if err != nil {
-		return nil, err
+		logger.FromContext(ctx).Error("insert transfer record failed", "out_bill_no", req.OutBillNo, "error", err)
 	}
-	httpRequest, err := http.NewRequest(method, reqUrl.String(), bytes.NewReader(reqBody))
+	return err
+}
+
+func (svc *transferService) GetTransferStatus(ctx context.Context, outbillno string) (string, error) {
+	return svc.repo.GetTransferStatus(ctx, outbillno)
+}
+
+func (svc *transferService) UpdateTransferStatus(ctx context.Context, outbillno, state, source, payloadRef string) (err error) {
+	ctx, span := tracing.Start(ctx, "TransferService.UpdateTransferStatus", tracing.OutBillNo(outbillno), attribute.String("status", state), attribute.String("source", source))
+	defer tracing.End(span, &err)
+	before, err := svc.repo.UpdateTransferRequestStatus(ctx, outbillno, state, source, payloadRef)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	httpRequest.Header.Set("Accept", "application/json")
-	httpRequest.Header.Set("Wechatpay-Serial", config.WechatPayPublicKeyId())
-	httpRequest.Header.Set("Content-Type", "application/json")
-	authorization, err := wxpay_utility.BuildAuthorization(config.MchId(), config.CertificateSerialNo(), config.PrivateKey(), method, reqUrl.Path, reqBody)
-	if err != nil {
-		return nil, err
+	if before.Status != state {
+		observeTransition(before, state, source)
 	}
-	httpRequest.Header.Set("Authorization", authorization)
+	return svc.settleWithdrawal(ctx, outbillno, state)
+}
 
-	client := &http.Client{}
-	httpResponse, err := client.Do(httpRequest)
+func (svc *transferService) ConfirmTransfer(ctx context.Context, openid, packageInfo string) (_ domain.TransferRecord, err error) {
+	ctx, span := tracing.Start(ctx, "TransferService.ConfirmTransfer")
+	defer tracing.End(span, &err)
+	record, err := svc.repo.GetTransferRecordByPackageInfo(ctx, packageInfo)
 	if err != nil {
-		return nil, err
+		return domain.TransferRecord{}, err
 	}
-
-	respBody, err := wxpay_utility.ExtractResponseBody(httpResponse)
+	if record.Openid != openid {
+		return domain.TransferRecord{}, ErrTransferNotOwned
+	}
+	// 只有把转账单从待确认改为成功的那个请求入账，提现的钱已经从余额扣过了。
+	// 入账和结算提现不跟着请求取消，调用方断开时不会入了账却没有结算
+	commitCtx, cancel := commitContext(ctx)
+	defer cancel()
+	before, confirmed, err := svc.repo.ConfirmTransfer(commitCtx, record.OutBillNo, record.Type != domain.TransferTypeWithdraw,
+		domain.TransferEventSourceConfirm, packageInfo)
 	if err != nil {
-		return nil, err
+		return record, fmt.Errorf("确认收款失败: %w", err)
 	}
-
-	if httpResponse.StatusCode >= 200 && httpResponse.StatusCode < 300 {
-		// 2XX 成功，验证应答签名
-		err = wxpay_utility.ValidateResponse(
-			config.WechatPayPublicKeyId(),
-			config.WechatPayPublicKey(),
-			&httpResponse.Header,
-			respBody,
-		)
+	if !confirmed {
+		// 可能已经被并发的请求确认过了，重新读一次状态
+		record, err = svc.repo.GetTransferRecordByOutBillNo(ctx, record.OutBillNo)
 		if err != nil {
-			return nil, err
+			return domain.TransferRecord{}, err
 		}
-
-		if err := json.Unmarshal(respBody, response); err != nil {
-			return nil, err
+		if record.Status == domain.TransferStatusSuccess {
+			return record, ErrTransferConfirmed
 		}
+		return record, ErrTransferNotConfirmable
+	}
+	observeTransition(before, domain.TransferStatusSuccess, domain.TransferEventSourceConfirm)
+	if err := svc.settleWithdrawal(commitCtx, record.OutBillNo, domain.TransferStatusSuccess); err != nil {
+		return record, fmt.Errorf("结算提现失败: %w", err)
+	}
+	record.Status = domain.TransferStatusSuccess
+	return record, nil
+}
 
-		return response, nil
-	} else {
-		return nil, wxpay_utility.NewApiException(
-			httpResponse.StatusCode,
-			httpResponse.Header,
-			respBody,
-		)
+// settleWithdrawal 转账单到达终态时结算对应的提现：成功则完成，失败或撤销则退回余额
+// 不是提现的转账单不受影响
+func (svc *transferService) settleWithdrawal(ctx context.Context, outbillno, state string) error {
+	switch state {
+	case domain.TransferStatusSuccess:
+		return svc.withdrawRepo.MarkSuccess(ctx, outbillno)
+	case domain.TransferStatusFail, domain.TransferStatusCancelled:
+		refunded, err := svc.withdrawRepo.Refund(ctx, outbillno)
+		if err != nil {
+			return err
+		}
+		if refunded {
+			logger.FromContext(ctx).Info("withdrawal refunded", "out_bill_no", outbillno, "status", state)
+		}
 	}
+	return nil
 }
 
-func (svc *transferService) GenerateOutBillNo(openid string, amount int64) string {
-	return fmt.Sprintf("Transfer_%v_%v_%v", openid, amount, strconv.FormatInt(time.Now().UnixNano(), 10))
+func (svc *transferService) GetTransferRecordByOutBillNo(ctx context.Context, outbillno string) (domain.TransferRecord, error) {
+	return svc.repo.GetTransferRecordByOutBillNo(ctx, outbillno)
 }
 
-func (svc *transferService) AddTransferRequest(ctx context.Context, req *domain.TransferRecord) error {
+func (svc *transferService) GetTransferRecordByPackageInfo(ctx context.Context, packageInfo string) (domain.TransferRecord, error) {
+	return svc.repo.GetTransferRecordByPackageInfo(ctx, packageInfo)
+}
 
-	err := svc.repo.CreateTransferRequest(ctx, req)
+func (svc *transferService) ListTransferHistory(ctx context.Context, query domain.TransferHistoryQuery) ([]domain.TransferRecord, int64, error) {
+	if query.Limit <= 0 {
+		query.Limit = defaultHistoryPageSize
+	}
+	if query.Limit > maxHistoryPageSize {
+		query.Limit = maxHistoryPageSize
+	}
+	limit := query.Limit
+	// 多查一条，用来判断是否还有下一页
+	query.Limit = limit + 1
+	records, err := svc.repo.ListTransferRecords(ctx, query)
 	if err != nil {
-		log.Printf("Failed to insert into database for TransferRecord: %v", err)
+		return nil, 0, err
 	}
-	return err
+	if len(records) <= limit {
+		return records, 0, nil
+	}
+	records = records[:limit]
+	return records, records[limit-1].ID, nil
 }
 
-func (svc *transferService) GetTransferStatus(ctx context.Context, outbillno string) (string, error) {
-	return svc.repo.GetTransferStatus(ctx, outbillno)
+func (svc *transferService) QueryTransferBill(ctx context.Context, config *wxpay_utility.MchConfig, outbillno string) (_ *TransferBillEntity, err error) {
+	ctx, span := tracing.Start(ctx, "TransferService.QueryTransferBill", tracing.OutBillNo(outbillno))
+	defer tracing.End(span, &err)
+	response := &TransferBillEntity{}
+	path := "/v3/fund-app/mch-transfer/transfer-bills/out-bill-no/" + url.PathEscape(outbillno)
+	ctx = logger.With(ctx, "out_bill_no", outbillno)
+	err = doWxpayRequest(ctx, config, "query_transfer_bill", http.MethodGet, path, nil, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
 }
 
-func (svc *transferService) UpdateTransferStatus(ctx context.Context, outbillno, state string) error {
-	return svc.repo.UpdateTransferRequestStatus(ctx, outbillno, state)
+func (svc *transferService) SyncTransferStatus(ctx context.Context, config *wxpay_utility.MchConfig, outbillno, source, payloadRef string) (_ domain.TransferRecord, err error) {
+	ctx, span := tracing.Start(ctx, "TransferService.SyncTransferStatus", tracing.OutBillNo(outbillno))
+	defer tracing.End(span, &err)
+	record, err := svc.repo.GetTransferRecordByOutBillNo(ctx, outbillno)
+	if err != nil {
+		return domain.TransferRecord{}, err
+	}
+	bill, err := svc.QueryTransferBill(ctx, config, outbillno)
+	if err != nil {
+		return domain.TransferRecord{}, err
+	}
+	if bill.State == nil || string(*bill.State) == record.Status {
+		return record, nil
+	}
+	if bill.FailReason != nil && *bill.FailReason != "" {
+		err = svc.repo.UpdateTransferFailReason(ctx, outbillno, *bill.FailReason)
+		if err != nil {
+			return domain.TransferRecord{}, err
+		}
+	}
+	err = svc.UpdateTransferStatus(ctx, outbillno, string(*bill.State), source, payloadRef)
+	if err != nil {
+		return domain.TransferRecord{}, err
+	}
+	return svc.repo.GetTransferRecordByOutBillNo(ctx, outbillno)
 }
 
-func (svc *transferService) GetTransferRecordByOutBillNo(ctx context.Context, outbillno string) (domain.TransferRecord, error) {
-	return svc.repo.GetTransferRecordByOutBillNo(ctx, outbillno)
+func (svc *transferService) CancelTransfer(ctx context.Context, config *wxpay_utility.MchConfig, outbillno, operator string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "TransferService.CancelTransfer", tracing.OutBillNo(outbillno))
+	defer tracing.End(span, &err)
+	record, err := svc.repo.GetTransferRecordByOutBillNo(ctx, outbillno)
+	if err != nil {
+		return "", err
+	}
+	switch record.Status {
+	case domain.TransferStatusAccepted, domain.TransferStatusProcessing,
+		domain.TransferStatusWaitUserConfirm, domain.TransferStatusTransfering:
+	default:
+		return record.Status, ErrTransferNotCancelable
+	}
+
+	response := &CancelTransferResponse{}
+	path := "/v3/fund-app/mch-transfer/transfer-bills/out-bill-no/" + url.PathEscape(outbillno) + "/cancel"
+	err = doWxpayRequest(logger.With(ctx, "out_bill_no", outbillno), config, "cancel_transfer_bill", http.MethodPost, path, struct{}{}, response)
+	if err != nil {
+		return record.Status, err
+	}
+	state := domain.TransferStatusCanceling
+	if response.State != nil {
+		state = string(*response.State)
+	}
+	return state, svc.UpdateTransferStatus(ctx, outbillno, state, domain.TransferEventSourceAdmin, operator)
 }
 
-func (svc *transferService) GetTransferRecordByPackageInfo(ctx context.Context, packageInfo string) (domain.TransferRecord, error) {
-	return svc.repo.GetTransferRecordByPackageInfo(ctx, packageInfo)
+func (svc *transferService) GetTransferTimeline(ctx context.Context, outbillno string) ([]domain.TransferStatusEvent, error) {
+	return svc.repo.GetTransferTimeline(ctx, outbillno)
 }
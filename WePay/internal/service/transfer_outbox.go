@@ -0,0 +1,304 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/logger"
+	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/tracing"
+)
+
+const (
+	outboxLease       = 30 * time.Second // 发送一次请求最长占用的时间，过期后其他实例可以重新发送
+	outboxMaxAttempts = 10
+	outboxMaxBackoff  = 10 * time.Minute
+)
+
+func (svc *transferService) InitiateTransfer(ctx context.Context, config *wxpay_utility.MchConfig, bill *domain.TransferRecord, request *TransferToUserRequest) (_ *TransferToUserResponse, err error) {
+	ctx, span := tracing.Start(ctx, "TransferService.InitiateTransfer", tracing.OutBillNo(bill.OutBillNo))
+	defer tracing.End(span, &err)
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	// 写入时就由当前请求占用，避免和 DispatchPendingTransfers 重复发送
+	outbox := domain.TransferOutbox{
+		OutBillNo:     bill.OutBillNo,
+		Payload:       string(payload),
+		Attempts:      1,
+		NextRetryTime: time.Now().Add(outboxLease),
+	}
+	outbox.ID, err = svc.createTransfer(ctx, bill, outbox)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := svc.dispatch(ctx, config, outbox, request)
+	if err != nil {
+		if classifyError(err) == errorFinal {
+			return nil, fmt.Errorf("%w: %w", ErrTransferRejected, err)
+		}
+		logger.FromContext(ctx).Warn("post transfer to wx failed, will retry", "out_bill_no", bill.OutBillNo, "error", err)
+		return nil, fmt.Errorf("%w: %w", ErrTransferPending, err)
+	}
+	return resp, nil
+}
+
+// createTransfer 检查通过后写入转账单，同一个用户的检查和写入串行执行，不会有两个请求同时通过检查。
+// 发送给微信在锁外面，不占用锁
+func (svc *transferService) createTransfer(ctx context.Context, bill *domain.TransferRecord, outbox domain.TransferOutbox) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "TransferService.createTransfer", tracing.OutBillNo(bill.OutBillNo))
+	defer tracing.End(span, &err)
+	unlock, err := svc.locker.Lock(ctx, transferLockKey(bill.Openid), defaultLockTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	// 提现是用户自己的钱，不受暂停影响
+	if paused, _ := svc.guard.Paused(bill.MchId); paused && bill.Type == domain.TransferTypeReward {
+		return 0, ErrTransferPaused
+	}
+
+	decision, err := svc.evaluateRisk(ctx, bill)
+	if err != nil {
+		return 0, err
+	}
+	switch decision {
+	case domain.RiskDecisionDeny:
+		// 拒绝的转账单也要保存，频率规则会统计到
+		bill.Status = domain.TransferStatusFail
+		bill.FailReason = domain.TransferFailReasonRiskDenied
+		if err := svc.repo.CreateTransferRequest(ctx, bill); err != nil {
+			return 0, err
+		}
+		transferInitiated.WithLabelValues(bill.SceneId, bill.Type).Inc()
+		transferFailed.WithLabelValues(bill.SceneId, bill.Type, bill.FailReason).Inc()
+		return 0, ErrTransferDenied
+	case domain.RiskDecisionReview:
+		bill.Status = domain.TransferStatusReviewing
+		outbox.Status = domain.OutboxStatusHeld
+	}
+
+	var id int64
+	if bill.Type == domain.TransferTypeWithdraw {
+		// 提现和扣减余额在同一个事务里，不会扣了余额却没有转账单；开始写入之后不跟着请求取消
+		commitCtx, cancel := commitContext(ctx)
+		defer cancel()
+		id, err = svc.withdrawRepo.CreateWithdrawal(commitCtx, domain.Withdrawal{
+			Openid:    bill.Openid,
+			OutBillNo: bill.OutBillNo,
+			Amount:    bill.Amount,
+		}, bill, outbox)
+	} else {
+		id, err = svc.repo.CreateTransferRequestWithOutbox(ctx, bill, outbox)
+	}
+	if errors.Is(err, ErrInsufficientBalance) || errors.Is(err, ErrTransferExists) {
+		return 0, err
+	}
+	if err != nil {
+		logger.FromContext(ctx).Error("insert transfer record failed", "out_bill_no", bill.OutBillNo, "error", err)
+		return 0, err
+	}
+	transferInitiated.WithLabelValues(bill.SceneId, bill.Type).Inc()
+	if decision == domain.RiskDecisionReview {
+		return id, ErrTransferUnderReview
+	}
+	return id, nil
+}
+
+// evaluateRisk 风控结果记在转账单上
+func (svc *transferService) evaluateRisk(ctx context.Context, bill *domain.TransferRecord) (string, error) {
+	if svc.risk == nil {
+		return domain.RiskDecisionAllow, nil
+	}
+	res, err := svc.risk.Evaluate(ctx, domain.RiskInput{
+		Openid:   bill.Openid,
+		DeviceId: bill.DeviceId,
+		ClientIp: bill.ClientIp,
+		MchId:    bill.MchId,
+		Type:     bill.Type,
+		Amount:   bill.Amount,
+	})
+	if err != nil {
+		return "", err
+	}
+	bill.RiskDecision = res.Decision
+	bill.RiskReasons = strings.Join(res.Reasons, ",")
+	if res.Decision != domain.RiskDecisionAllow {
+		logger.FromContext(ctx).Warn("transfer held by risk control", "out_bill_no", bill.OutBillNo,
+			"openid", bill.Openid, "decision", res.Decision, "reasons", bill.RiskReasons)
+	}
+	return res.Decision, nil
+}
+
+func (svc *transferService) ApproveTransfer(ctx context.Context, outbillno, operator string) (err error) {
+	ctx, span := tracing.Start(ctx, "TransferService.ApproveTransfer", tracing.OutBillNo(outbillno))
+	defer tracing.End(span, &err)
+	// 和 RejectTransfer 一样以请求的状态为准，先占用审核中的请求，同时审核时只有一个能成功。
+	// 占用的请求过了 outboxLease 才发送，状态先改为 PROCESSING，不会把微信的应答改回去
+	ok, err := svc.outboxRepo.ReleaseHeld(ctx, outbillno, time.Now().Add(outboxLease))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrTransferNotReviewable
+	}
+	return svc.UpdateTransferStatus(ctx, outbillno, domain.TransferStatusProcessing, domain.TransferEventSourceAdmin, operator)
+}
+
+func (svc *transferService) RejectTransfer(ctx context.Context, outbillno, operator, reason string) (err error) {
+	ctx, span := tracing.Start(ctx, "TransferService.RejectTransfer", tracing.OutBillNo(outbillno))
+	defer tracing.End(span, &err)
+	// 以请求的状态为准，和审核通过同时发生时只有一个能成功
+	ok, err := svc.outboxRepo.DiscardHeld(ctx, outbillno, "risk rejected by "+operator+": "+reason)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrTransferNotReviewable
+	}
+	if err := svc.repo.UpdateTransferFailReason(ctx, outbillno, "RISK_REJECTED: "+reason); err != nil {
+		return err
+	}
+	return svc.UpdateTransferStatus(ctx, outbillno, domain.TransferStatusFail, domain.TransferEventSourceAdmin, operator)
+}
+
+func (svc *transferService) DispatchPendingTransfers(ctx context.Context, configs MchConfigProvider, limit int) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "TransferService.DispatchPendingTransfers")
+	defer tracing.End(span, &err)
+	now := time.Now()
+	outboxes, err := svc.outboxRepo.FindDue(ctx, now, limit)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, outbox := range outboxes {
+		ok, err := svc.outboxRepo.Claim(ctx, outbox.ID, now, now.Add(outboxLease))
+		if err != nil {
+			return sent, err
+		}
+		if !ok {
+			// 被其他实例抢先发送了
+			continue
+		}
+		outbox.Attempts++
+
+		var request TransferToUserRequest
+		if err := json.Unmarshal([]byte(outbox.Payload), &request); err != nil {
+			logger.FromContext(ctx).Error("outbox has invalid payload", "outbox_id", outbox.ID, "out_bill_no", outbox.OutBillNo, "error", err)
+			if err := svc.outboxRepo.MarkFailed(ctx, outbox.ID, err.Error()); err != nil {
+				return sent, err
+			}
+			continue
+		}
+		// 按转账单的商户号找到发起转账时用的密钥
+		config, err := svc.mchConfigOf(ctx, configs, outbox.OutBillNo)
+		if err != nil {
+			logger.FromContext(ctx).Error("load merchant config failed", "outbox_id", outbox.ID, "out_bill_no", outbox.OutBillNo, "error", err)
+			svc.handleDispatchError(ctx, outbox, err)
+			continue
+		}
+		if _, err := svc.dispatch(ctx, config, outbox, &request); err != nil {
+			logger.FromContext(ctx).Warn("dispatch transfer failed", "out_bill_no", outbox.OutBillNo, "attempts", outbox.Attempts, "error", err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+func (svc *transferService) mchConfigOf(ctx context.Context, configs MchConfigProvider, outbillno string) (*wxpay_utility.MchConfig, error) {
+	record, err := svc.repo.GetTransferRecordByOutBillNo(ctx, outbillno)
+	if err != nil {
+		return nil, err
+	}
+	return configs.MchConfig(ctx, record.MchId)
+}
+
+// dispatch 把请求发送给微信并记录结果，按 retryPolicy 当场重试几次，仍然失败时安排下一次重试。
+// 重试用的是同一个 out_bill_no，微信侧不会重复转账。微信明确拒绝时转账单直接失败
+func (svc *transferService) dispatch(ctx context.Context, config *wxpay_utility.MchConfig, outbox domain.TransferOutbox, request *TransferToUserRequest) (_ *TransferToUserResponse, err error) {
+	ctx, span := tracing.Start(ctx, "TransferService.dispatch", tracing.OutBillNo(outbox.OutBillNo))
+	defer tracing.End(span, &err)
+	resp, err := withRetry(ctx, svc.retryPolicy, func() (*TransferToUserResponse, error) {
+		return svc.TransferToUser(ctx, config, request)
+	})
+	if err != nil {
+		svc.handleDispatchError(ctx, outbox, err)
+		return nil, err
+	}
+
+	if err := svc.outboxRepo.MarkDone(ctx, outbox.ID); err != nil {
+		logger.FromContext(ctx).Error("mark outbox done failed", "outbox_id", outbox.ID, "out_bill_no", outbox.OutBillNo, "error", err)
+	}
+	// 小程序拉起确认收款用的是微信返回的 package_info，先保存再改状态，待确认的转账单一定能按它找到
+	if resp.PackageInfo != nil && *resp.PackageInfo != "" {
+		if err := svc.repo.UpdateTransferPackageInfo(ctx, outbox.OutBillNo, *resp.PackageInfo); err != nil {
+			logger.FromContext(ctx).Error("save package info failed", "out_bill_no", outbox.OutBillNo, "error", err)
+		}
+	}
+	if resp.State != nil {
+		err = svc.UpdateTransferStatus(ctx, outbox.OutBillNo, string(*resp.State), domain.TransferEventSourceAPI, "")
+		if err != nil {
+			logger.FromContext(ctx).Error("update transfer status failed", "out_bill_no", outbox.OutBillNo, "status", *resp.State, "error", err)
+		}
+	}
+	return resp, nil
+}
+
+func (svc *transferService) handleDispatchError(ctx context.Context, outbox domain.TransferOutbox, err error) {
+	var markErr error
+	switch {
+	case classifyError(err) == errorFinal:
+		markErr = svc.outboxRepo.MarkFailed(ctx, outbox.ID, err.Error())
+		if failErr := svc.failTransfer(ctx, outbox.OutBillNo, err); failErr != nil {
+			logger.FromContext(ctx).Error("set transfer to FAIL failed", "out_bill_no", outbox.OutBillNo, "error", failErr)
+		}
+	case outbox.Attempts >= outboxMaxAttempts:
+		// 不知道微信是否受理了，转账单保持原状态，等回调或者人工同步
+		markErr = svc.outboxRepo.MarkFailed(ctx, outbox.ID, err.Error())
+		logger.FromContext(ctx).Error("transfer dispatch gave up", "out_bill_no", outbox.OutBillNo, "attempts", outbox.Attempts, "error", err)
+	default:
+		delay := max(outboxBackoff(outbox.Attempts), svc.retryPolicy.Delay(svc.retryPolicy.MaxAttempts, err))
+		markErr = svc.outboxRepo.MarkRetry(ctx, outbox.ID, time.Now().Add(delay), err.Error())
+	}
+	if markErr != nil {
+		logger.FromContext(ctx).Error("update outbox failed", "outbox_id", outbox.ID, "out_bill_no", outbox.OutBillNo, "error", markErr)
+	}
+}
+
+// failTransfer 微信明确拒绝了转账，记录失败原因并把转账单置为失败
+func (svc *transferService) failTransfer(ctx context.Context, outbillno string, err error) error {
+	reason, requestID := err.Error(), ""
+	if apiErr, ok := wxpay_utility.AsApiException(err); ok {
+		reason = string(apiErr.ErrorCode())
+		if reason == "" {
+			reason = fmt.Sprintf("HTTP_%d", apiErr.StatusCode())
+		}
+		requestID = apiErr.Header().Get(wxpay_utility.RequestID)
+	}
+	if wxpay_utility.IsInsufficientFunds(err) {
+		// 等余额检查确认余额恢复后再继续发红包
+		if record, err := svc.repo.GetTransferRecordByOutBillNo(ctx, outbillno); err == nil {
+			svc.guard.Pause(record.MchId, "转账单 "+outbillno+" 返回 "+reason)
+		}
+	}
+	if err := svc.repo.UpdateTransferFailReason(ctx, outbillno, reason); err != nil {
+		return err
+	}
+	return svc.UpdateTransferStatus(ctx, outbillno, domain.TransferStatusFail, domain.TransferEventSourceAPI, requestID)
+}
+
+// outboxBackoff 第 attempts 次失败后的等待时间，指数退避
+func outboxBackoff(attempts int) time.Duration {
+	backoff := 5 * time.Second
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, outboxMaxBackoff)
+}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	testCases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: 5 * time.Second},
+		{attempts: 2, want: 10 * time.Second},
+		{attempts: 4, want: 40 * time.Second},
+		{attempts: 7, want: 320 * time.Second},
+		{attempts: 8, want: outboxMaxBackoff},
+		{attempts: 100, want: outboxMaxBackoff},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, outboxBackoff(tc.attempts), "attempts=%d", tc.attempts)
+	}
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"wepay/internal/domain"
+	"wepay/internal/logger"
+	"wepay/internal/service/wxpay_utility"
+)
+
+var (
+	ErrTransferNotFinished = errors.New("转账单还没成功，不能申请电子回单")
+	ErrReceiptNotReady     = errors.New("电子回单还在生成中")
+	ErrReceiptHashMismatch = errors.New("电子回单摘要校验失败")
+	errInvalidOutBillNo    = errors.New("invalid out_bill_no")
+)
+
+// 商户单号只允许数字、字母、下划线和中划线，保证可以安全地用作文件名
+var outBillNoPattern = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+func (svc *transferService) ApplyTransferReceipt(ctx context.Context, config *wxpay_utility.MchConfig, outbillno string) (*TransferReceiptEntity, error) {
+	record, err := svc.repo.GetTransferRecordByOutBillNo(ctx, outbillno)
+	if err != nil {
+		return nil, err
+	}
+	if record.Status != domain.TransferStatusSuccess {
+		return nil, ErrTransferNotFinished
+	}
+	response := &TransferReceiptEntity{}
+	ctx = logger.With(ctx, "out_bill_no", outbillno)
+	err = doWxpayRequest(ctx, config, "apply_transfer_receipt", http.MethodPost, "/v3/fund-app/mch-transfer/elecsign/out-bill-no",
+		map[string]string{"out_bill_no": outbillno}, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (svc *transferService) QueryTransferReceipt(ctx context.Context, config *wxpay_utility.MchConfig, outbillno string) (*TransferReceiptEntity, error) {
+	response := &TransferReceiptEntity{}
+	path := "/v3/fund-app/mch-transfer/elecsign/out-bill-no/" + url.PathEscape(outbillno)
+	ctx = logger.With(ctx, "out_bill_no", outbillno)
+	err := doWxpayRequest(ctx, config, "query_transfer_receipt", http.MethodGet, path, nil, response)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (svc *transferService) DownloadTransferReceipt(ctx context.Context, config *wxpay_utility.MchConfig, outbillno string) (string, error) {
+	path, err := receiptPath(svc.receiptDir, outbillno)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	receipt, err := svc.QueryTransferReceipt(ctx, config, outbillno)
+	if err != nil {
+		return "", err
+	}
+	if receipt.State == nil || *receipt.State != TRANSFERRECEIPTSTATE_FINISHED || receipt.DownloadUrl == nil {
+		return "", ErrReceiptNotReady
+	}
+	// 下载地址同样需要签名，文件本身没有应答签名，用摘要校验
+	_, body, err := sendWxpayRequest(logger.With(ctx, "out_bill_no", outbillno), config, "download_transfer_receipt", http.MethodGet, *receipt.DownloadUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	return storeReceipt(svc.receiptDir, outbillno, body, deref(receipt.HashType), deref(receipt.HashValue))
+}
+
+func receiptPath(dir, outbillno string) (string, error) {
+	if !outBillNoPattern.MatchString(outbillno) {
+		return "", errInvalidOutBillNo
+	}
+	return filepath.Join(dir, outbillno+".pdf"), nil
+}
+
+// storeReceipt 按微信返回的摘要算法校验后保存电子回单，先写临时文件再改名，避免留下写了一半的文件
+func storeReceipt(dir, outbillno string, body []byte, hashType, hashValue string) (string, error) {
+	path, err := receiptPath(dir, outbillno)
+	if err != nil {
+		return "", err
+	}
+	ok, err := verifyDigest(body, hashType, hashValue)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrReceiptHashMismatch
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, outbillno+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// verifyDigest 用 hashType 指定的算法计算 body 的摘要并和 hashValue 比较，不认识的算法返回错误，不跳过校验
+func verifyDigest(body []byte, hashType, hashValue string) (bool, error) {
+	var sum []byte
+	switch strings.ToUpper(strings.ReplaceAll(hashType, "-", "")) {
+	case "SHA1":
+		s := sha1.Sum(body)
+		sum = s[:]
+	case "SHA256":
+		s := sha256.Sum256(body)
+		sum = s[:]
+	default:
+		return false, fmt.Errorf("unsupported hash type %q", hashType)
+	}
+	return strings.EqualFold(hex.EncodeToString(sum), hashValue), nil
+}
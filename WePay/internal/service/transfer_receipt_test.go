@@ -0,0 +1,90 @@
+package service
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreReceipt(t *testing.T) {
+	body := []byte("%PDF-1.4 receipt")
+	sum := sha256.Sum256(body)
+	hashValue := hex.EncodeToString(sum[:])
+	sum1 := sha1.Sum(body)
+
+	testCases := []struct {
+		name      string
+		outbillno string
+		hashType  string
+		hashValue string
+		wantErr   error
+	}{
+		{
+			name:      "success",
+			outbillno: "Transfer_o1234567890_100_1",
+			hashType:  "SHA256",
+			hashValue: hashValue,
+		},
+		{
+			name:      "sha1",
+			outbillno: "Transfer_o1234567890_100_3",
+			hashType:  "SHA1",
+			hashValue: hex.EncodeToString(sum1[:]),
+		},
+		{
+			name:      "hash mismatch",
+			outbillno: "Transfer_o1234567890_100_2",
+			hashType:  "SHA256",
+			hashValue: "0000",
+			wantErr:   ErrReceiptHashMismatch,
+		},
+		{
+			// 按微信返回的算法校验，SHA1 的回单不能拿 SHA-256 的摘要通过
+			name:      "hash type mismatch",
+			outbillno: "Transfer_o1234567890_100_4",
+			hashType:  "SHA1",
+			hashValue: hashValue,
+			wantErr:   ErrReceiptHashMismatch,
+		},
+		{
+			name:      "path traversal",
+			outbillno: "../../etc/passwd",
+			hashType:  "SHA256",
+			hashValue: hashValue,
+			wantErr:   errInvalidOutBillNo,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path, err := storeReceipt(dir, tc.outbillno, body, tc.hashType, tc.hashValue)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				entries, _ := os.ReadDir(dir)
+				assert.Empty(t, entries)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(dir, tc.outbillno+".pdf"), path)
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, body, content)
+			entries, _ := os.ReadDir(dir)
+			assert.Len(t, entries, 1)
+		})
+	}
+}
+
+func TestStoreReceipt_UnsupportedHashType(t *testing.T) {
+	dir := t.TempDir()
+	_, err := storeReceipt(dir, "Transfer_o1234567890_100_1", []byte("%PDF-1.4 receipt"), "SM3", "0000")
+	assert.ErrorContains(t, err, "unsupported hash type")
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries, "不认识的算法不跳过校验")
+}
@@ -1,5 +1,10 @@
 package service
 
+import (
+	"log/slog"
+	"wepay/internal/logger"
+)
+
 type TransferToUserResponse struct {
 	OutBillNo      *string             `json:"out_bill_no,omitempty"`
 	TransferBillNo *string             `json:"transfer_bill_no,omitempty"`
@@ -43,3 +48,67 @@ type TransferToUserRequest struct {
 	UserRecvPerception       *string                   `json:"user_recv_perception,omitempty"`
 	TransferSceneReportInfos []TransferSceneReportInfo `json:"transfer_scene_report_infos,omitempty"`
 }
+
+// LogValue 记录日志时不输出收款用户的实名
+func (r *TransferToUserRequest) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("out_bill_no", deref(r.OutBillNo)),
+		slog.String("appid", deref(r.Appid)),
+		slog.String("openid", deref(r.Openid)),
+		slog.String("transfer_scene_id", deref(r.TransferSceneId)),
+	}
+	if r.TransferAmount != nil {
+		attrs = append(attrs, slog.Int64("transfer_amount", *r.TransferAmount))
+	}
+	if r.UserName != nil {
+		attrs = append(attrs, slog.String("user_name", logger.Redacted))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// TransferBillEntity 商户单号查询转账单的应答
+type TransferBillEntity struct {
+	MchId          *string             `json:"mch_id,omitempty"`
+	OutBillNo      *string             `json:"out_bill_no,omitempty"`
+	TransferBillNo *string             `json:"transfer_bill_no,omitempty"`
+	Appid          *string             `json:"appid,omitempty"`
+	State          *TransferBillStatus `json:"state,omitempty"`
+	TransferAmount *int64              `json:"transfer_amount,omitempty"`
+	TransferRemark *string             `json:"transfer_remark,omitempty"`
+	FailReason     *string             `json:"fail_reason,omitempty"`
+	Openid         *string             `json:"openid,omitempty"`
+	UserName       *string             `json:"user_name,omitempty"`
+	CreateTime     *string             `json:"create_time,omitempty"`
+	UpdateTime     *string             `json:"update_time,omitempty"`
+}
+
+// CancelTransferResponse 撤销转账的应答
+type CancelTransferResponse struct {
+	OutBillNo      *string             `json:"out_bill_no,omitempty"`
+	TransferBillNo *string             `json:"transfer_bill_no,omitempty"`
+	State          *TransferBillStatus `json:"state,omitempty"`
+	UpdateTime     *string             `json:"update_time,omitempty"`
+}
+
+// TransferReceiptEntity 商家转账电子回单
+type TransferReceiptEntity struct {
+	State       *string `json:"state,omitempty"`
+	CreateTime  *string `json:"create_time,omitempty"`
+	UpdateTime  *string `json:"update_time,omitempty"`
+	HashType    *string `json:"hash_type,omitempty"`
+	HashValue   *string `json:"hash_value,omitempty"`
+	DownloadUrl *string `json:"download_url,omitempty"`
+	FailReason  *string `json:"fail_reason,omitempty"`
+}
+
+const (
+	TRANSFERRECEIPTSTATE_ACCEPTED = "ACCEPTED"
+	TRANSFERRECEIPTSTATE_FINISHED = "FINISHED"
+)
@@ -2,12 +2,22 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"wepay/internal/domain"
 	"wepay/internal/repository"
 )
 
+var (
+	ErrAuditReasonRequired = errors.New("必须填写调整原因")
+	ErrUserNotFound        = repository.ErrUserNotFound
+)
+
 type UserService interface {
 	GetAmount(ctx context.Context, openid string) (int64, error)
 	UpdateBalance(ctx context.Context, openid string, amount int64) error
+	// AdjustBalance 管理员人工调整余额，reason 必填并写入操作记录，返回调整后的余额
+	AdjustBalance(ctx context.Context, operator, openid string, delta int64, reason string) (int64, error)
 }
 
 type userService struct {
@@ -29,3 +39,16 @@ func (s *userService) GetAmount(ctx context.Context, openid string) (int64, erro
 func (s *userService) UpdateBalance(ctx context.Context, openid string, amount int64) error {
 	return s.repo.UpdateBalance(ctx, openid, amount)
 }
+
+func (s *userService) AdjustBalance(ctx context.Context, operator, openid string, delta int64, reason string) (int64, error) {
+	if reason == "" {
+		return 0, ErrAuditReasonRequired
+	}
+	return s.repo.AdjustBalance(ctx, openid, delta, domain.AuditLog{
+		Operator: operator,
+		Action:   domain.AuditActionAdjustBalance,
+		Target:   openid,
+		Detail:   fmt.Sprintf("delta=%d", delta),
+		Reason:   reason,
+	})
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"wepay/internal/domain"
+	"wepay/internal/logger"
+	"wepay/internal/repository"
+	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/tracing"
+)
+
+var ErrInsufficientBalance = repository.ErrInsufficientBalance
+
+type WithdrawService interface {
+	// Withdraw 扣减余额并发起提现转账。bill 为待创建的转账单，request 为发往微信的转账请求。
+	// 微信暂时没有应答时返回 ErrTransferPending，转账单保持 PROCESSING，由后续状态变更结算。
+	// 同一个用户的提现串行执行，等锁超时返回 ErrLockTimeout。风控要求审核时返回 ErrTransferUnderReview，余额不退回
+	Withdraw(ctx context.Context, config *wxpay_utility.MchConfig, bill *domain.TransferRecord, request *TransferToUserRequest) (*TransferToUserResponse, error)
+}
+
+type withdrawService struct {
+	repo        repository.WithdrawRepository
+	transferSvc TransferService
+	locker      Locker
+}
+
+// NewWithdrawService locker 为 nil 时使用进程内的锁
+func NewWithdrawService(repo repository.WithdrawRepository, transferSvc TransferService, locker Locker) WithdrawService {
+	if locker == nil {
+		locker = NewLocalLocker()
+	}
+	return &withdrawService{
+		repo:        repo,
+		transferSvc: transferSvc,
+		locker:      locker,
+	}
+}
+
+func (s *withdrawService) Withdraw(ctx context.Context, config *wxpay_utility.MchConfig, bill *domain.TransferRecord, request *TransferToUserRequest) (_ *TransferToUserResponse, err error) {
+	ctx, span := tracing.Start(ctx, "WithdrawService.Withdraw", tracing.OutBillNo(bill.OutBillNo))
+	defer tracing.End(span, &err)
+	unlock, err := s.locker.Lock(ctx, withdrawLockKey(bill.Openid), defaultLockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// 扣减余额和转账单在 InitiateTransfer 的同一个事务里写入，余额不足时返回 ErrInsufficientBalance
+	bill.Type = domain.TransferTypeWithdraw
+	bill.Status = domain.TransferStatusProcessing
+	resp, err := s.transferSvc.InitiateTransfer(ctx, config, bill, request)
+	// 审核中的提现等审核结果，不通过时由 RejectTransfer 退回；后台还在发送的提现由后续状态变更结算
+	if err != nil && !errors.Is(err, ErrTransferUnderReview) && !errors.Is(err, ErrTransferPending) {
+		// 微信拒绝了，退回余额。转账单没有建出来时没有扣减，退回什么都不做
+		// 请求可能已经取消了，退回不能跟着取消
+		refundCtx, cancel := commitContext(ctx)
+		defer cancel()
+		if _, refundErr := s.repo.Refund(refundCtx, bill.OutBillNo); refundErr != nil {
+			logger.FromContext(ctx).Error("refund withdrawal failed", "out_bill_no", bill.OutBillNo, "error", refundErr)
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"wepay/internal/logger"
+	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/tracing"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const wxpayHost = "https://api.mch.weixin.qq.com"
+
+// wxpayTimeout 单次调用微信支付 API 的超时，包括下载账单和回单；ctx 的截止时间更早时以 ctx 为准
+const wxpayTimeout = 15 * time.Second
+
+// wxpayClient 调用微信支付 API 的客户端，每次请求都会创建一个 HTTP client span。
+// 不带 traceparent 和 baggage 请求头，内部的 trace id 和 baggage 不发给微信
+var wxpayClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport,
+	otelhttp.WithPropagators(propagation.NewCompositeTextMapPropagator()))}
+
+// doWxpayRequest 签名并发送微信支付 API 请求，2XX 时验证应答签名并把 Body 解析到 response，
+// 否则返回 ApiException。request 为 nil 时不带 Body，api 是接口名，用于统计耗时和记录日志
+func doWxpayRequest(ctx context.Context, config *wxpay_utility.MchConfig, api, method, path string, request any, response any) error {
+	var reqBody []byte
+	if request != nil {
+		var err error
+		reqBody, err = json.Marshal(request)
+		if err != nil {
+			return err
+		}
+	}
+	httpResponse, respBody, err := sendWxpayRequest(ctx, config, api, method, wxpayHost+path, reqBody)
+	if err != nil {
+		return err
+	}
+
+	// 2XX 成功，验证应答签名
+	err = wxpay_utility.ValidateResponse(
+		config.WechatPayPublicKeyId(),
+		config.WechatPayPublicKey(),
+		&httpResponse.Header,
+		respBody,
+	)
+	if err != nil {
+		return err
+	}
+	if response == nil || len(respBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, response)
+}
+
+// sendWxpayRequest 签名并发送请求，返回 2XX 的应答及其 Body，不验证应答签名，非 2XX 时返回 ApiException。
+// 每次调用都用 ctx 里的 logger 记录状态码、微信的 Request-Id 和耗时
+func sendWxpayRequest(ctx context.Context, config *wxpay_utility.MchConfig, api, method, reqUrl string, reqBody []byte) (_ *http.Response, _ []byte, err error) {
+	ctx, span := tracing.Start(ctx, "wxpay."+api, attribute.String("wxpay.api", api))
+	defer tracing.End(span, &err)
+	ctx, cancel := context.WithTimeout(ctx, wxpayTimeout)
+	defer cancel()
+	httpRequest, err := http.NewRequestWithContext(ctx, method, reqUrl, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, nil, err
+	}
+	httpRequest.Header.Set("Accept", "application/json")
+	httpRequest.Header.Set("Wechatpay-Serial", config.WechatPayPublicKeyId())
+	if reqBody != nil {
+		httpRequest.Header.Set("Content-Type", "application/json")
+	}
+	authorization, err := wxpay_utility.BuildAuthorization(config.MchId(), config.CertificateSerialNo(), config.PrivateKey(), method, httpRequest.URL.RequestURI(), reqBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpRequest.Header.Set("Authorization", authorization)
+
+	start := time.Now()
+	httpResponse, err := wxpayClient.Do(httpRequest)
+	if err != nil {
+		logWxpayRequest(ctx, api, httpRequest, nil, start, err)
+		return nil, nil, err
+	}
+	defer httpResponse.Body.Close()
+
+	respBody, err := wxpay_utility.ExtractResponseBody(httpResponse)
+	if err == nil && (httpResponse.StatusCode < 200 || httpResponse.StatusCode >= 300) {
+		err = wxpay_utility.NewApiException(
+			httpResponse.StatusCode,
+			httpResponse.Header,
+			respBody,
+		)
+	}
+	logWxpayRequest(ctx, api, httpRequest, httpResponse, start, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return httpResponse, respBody, nil
+}
+
+// logWxpayRequest 只记录 URL 的路径，下载地址的参数里带着 token
+func logWxpayRequest(ctx context.Context, api string, req *http.Request, resp *http.Response, start time.Time, err error) {
+	observeWxpayRequest(api, start, err)
+	attrs := []any{
+		"api", api,
+		"method", req.Method,
+		"path", req.URL.Path,
+		"latency", time.Since(start),
+	}
+	if resp != nil {
+		attrs = append(attrs, "status", resp.StatusCode, "wx_request_id", resp.Header.Get(wxpay_utility.RequestID))
+	}
+	l := logger.FromContext(ctx)
+	if err != nil {
+		if apiErr, ok := wxpay_utility.AsApiException(err); ok {
+			attrs = append(attrs, "wx_error_code", string(apiErr.ErrorCode()))
+		}
+		l.Warn("wxpay request failed", append(attrs, "error", err)...)
+		return
+	}
+	l.Info("wxpay request", attrs...)
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"wepay/internal/logger"
+	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/tracing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTestMchConfig 用临时生成的私钥签名
+func newTestMchConfig(t *testing.T) *wxpay_utility.MchConfig {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "private_key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	config, err := wxpay_utility.CreateMchConfig("1900001109", "serial", path, "PUB_KEY_ID", filepath.Join(t.TempDir(), "missing.pem"))
+	require.NoError(t, err)
+	return config
+}
+
+func TestSendWxpayRequest_Log(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(wxpay_utility.RequestID, "wx-req-1")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"code":"PARAM_ERROR","message":"参数错误"}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	ctx := logger.WithContext(context.Background(), logger.New(&buf, "info"))
+	ctx = logger.With(ctx, "out_bill_no", "b1")
+	_, _, err := sendWxpayRequest(ctx, newTestMchConfig(t), "download_transfer_receipt",
+		http.MethodGet, server.URL+"/download?token=secret", nil)
+	require.Error(t, err)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "WARN", entry["level"])
+	assert.Equal(t, "b1", entry["out_bill_no"])
+	assert.Equal(t, "download_transfer_receipt", entry["api"])
+	assert.Equal(t, "/download", entry["path"])
+	assert.Equal(t, float64(http.StatusBadRequest), entry["status"])
+	assert.Equal(t, "wx-req-1", entry["wx_request_id"])
+	assert.Equal(t, "PARAM_ERROR", entry["wx_error_code"])
+	assert.Contains(t, entry, "latency")
+	assert.False(t, strings.Contains(buf.String(), "secret"), "不记录 URL 的参数")
+}
+
+func TestTransferToUserRequest_LogValue(t *testing.T) {
+	var buf bytes.Buffer
+	outbillno, userName := "b1", "张三"
+	logger.New(&buf, "info").Info("transfer", "request", &TransferToUserRequest{OutBillNo: &outbillno, UserName: &userName})
+	assert.Contains(t, buf.String(), `"out_bill_no":"b1"`)
+	assert.NotContains(t, buf.String(), userName)
+}
+
+// 转账单号会写进日志，不能带 openid，微信要求最长 32 位字母和数字
+func TestGenerateOutBillNo(t *testing.T) {
+	svc := &transferService{}
+	a, b := svc.GenerateOutBillNo(), svc.GenerateOutBillNo()
+	assert.Regexp(t, `^TR[0-9a-f]{30}$`, a)
+	assert.NotEqual(t, a, b)
+}
+
+func TestSendWxpayRequest_Trace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+
+	var traceparent, baggage string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent, baggage = r.Header.Get("traceparent"), r.Header.Get("baggage")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ctx, parent := tracing.Start(context.Background(), "TransferService.TransferToUser", tracing.OutBillNo("b1"))
+	_, _, err := sendWxpayRequest(ctx, newTestMchConfig(t), "transfer_bills",
+		http.MethodPost, server.URL+"/v3/fund-app/mch-transfer/transfer-bills", []byte(`{}`))
+	parent.End()
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	byName := make(map[string]sdktrace.ReadOnlySpan, len(spans))
+	for _, span := range spans {
+		byName[span.Name()] = span
+	}
+	wx, ok := byName["wxpay.transfer_bills"]
+	require.True(t, ok)
+	// 微信 API 的 span 挂在业务 span 下面，HTTP client 的 span 又挂在微信 API 的 span 下面
+	assert.Equal(t, parent.SpanContext().SpanID(), wx.Parent().SpanID())
+	assert.Equal(t, codes.Error, wx.Status().Code)
+	var client sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		if span.Parent().SpanID() == wx.SpanContext().SpanID() {
+			client = span
+		}
+	}
+	require.NotNil(t, client)
+	assert.Empty(t, traceparent, "trace 不传给微信")
+	assert.Empty(t, baggage)
+}
+
+func TestSendWxpayRequest_Deadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := sendWxpayRequest(ctx, newTestMchConfig(t), "query_transfer_bill",
+		http.MethodGet, server.URL+"/v3/fund-app/mch-transfer/transfer-bills/out-bill-no/b1", nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), wxpayTimeout, "调用方的截止时间更早时以调用方为准")
+}
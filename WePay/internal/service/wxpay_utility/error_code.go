@@ -0,0 +1,74 @@
+package wxpay_utility
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrorCode 微信支付 API 应答里的错误码，实现了 error，可以用 errors.Is(err, ErrorCodeXXX) 判断
+type ErrorCode string
+
+func (c ErrorCode) Error() string {
+	return string(c)
+}
+
+// 商家转账相关 API 的错误码
+const (
+	ErrorCodeSystemError        ErrorCode = "SYSTEM_ERROR"          // 系统错误，稍后用原单号重试
+	ErrorCodeFrequencyLimited   ErrorCode = "FREQUENCY_LIMITED"     // 频率超限，降低频率后用原单号重试
+	ErrorCodeNotEnough          ErrorCode = "NOT_ENOUGH"            // 商户运营账户资金不足
+	ErrorCodeParamError         ErrorCode = "PARAM_ERROR"           // 参数错误
+	ErrorCodeInvalidRequest     ErrorCode = "INVALID_REQUEST"       // 请求不符合业务规则，如单据状态不对
+	ErrorCodeNoAuth             ErrorCode = "NO_AUTH"               // 商户没有这个接口的权限
+	ErrorCodeSignError          ErrorCode = "SIGN_ERROR"            // 签名错误
+	ErrorCodeNotFound           ErrorCode = "NOT_FOUND"             // 单据不存在
+	ErrorCodeResourceNotExists  ErrorCode = "RESOURCE_NOT_EXISTS"   // 单据不存在
+	ErrorCodeAppidMchidNotMatch ErrorCode = "APPID_MCHID_NOT_MATCH" // appid 与商户号没有绑定
+	ErrorCodeOpenidMismatch     ErrorCode = "OPENID_MISMATCH"       // openid 与 appid 不匹配
+	ErrorCodeAccountError       ErrorCode = "ACCOUNT_ERROR"         // 收款用户账户异常
+)
+
+// 可以用原单号重试的错误码
+var retryableErrorCodes = map[ErrorCode]bool{
+	ErrorCodeSystemError:      true,
+	ErrorCodeFrequencyLimited: true,
+}
+
+// AsApiException 取出 err 链上的 ApiException
+func AsApiException(err error) (*ApiException, bool) {
+	var apiErr *ApiException
+	ok := errors.As(err, &apiErr)
+	return apiErr, ok
+}
+
+// IsRetryable 是否是可以用原单号重试的 API 错误。没有错误码时 5XX 和 429 可以重试，
+// 不是 ApiException 的错误返回 false，由调用方决定
+func IsRetryable(err error) bool {
+	apiErr, ok := AsApiException(err)
+	if !ok {
+		return false
+	}
+	if apiErr.ErrorCode() != "" {
+		return retryableErrorCodes[apiErr.ErrorCode()]
+	}
+	return apiErr.StatusCode() >= http.StatusInternalServerError || apiErr.StatusCode() == http.StatusTooManyRequests
+}
+
+// IsRateLimited 是否被限频
+func IsRateLimited(err error) bool {
+	if errors.Is(err, ErrorCodeFrequencyLimited) {
+		return true
+	}
+	apiErr, ok := AsApiException(err)
+	return ok && apiErr.ErrorCode() == "" && apiErr.StatusCode() == http.StatusTooManyRequests
+}
+
+// IsInsufficientFunds 是否是商户运营账户资金不足
+func IsInsufficientFunds(err error) bool {
+	return errors.Is(err, ErrorCodeNotEnough)
+}
+
+// IsNotFound 是否是单据不存在
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrorCodeNotFound) || errors.Is(err, ErrorCodeResourceNotExists)
+}
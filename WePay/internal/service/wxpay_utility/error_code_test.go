@@ -0,0 +1,87 @@
+package wxpay_utility
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewApiException(t *testing.T) {
+	testCases := []struct {
+		name    string
+		body    string
+		code    ErrorCode
+		message string
+		detail  ErrorDetail
+		hasDet  bool
+	}{
+		{
+			name:    "带错误详情",
+			body:    `{"code":"PARAM_ERROR","message":"参数错误","detail":{"field":"/transfer_amount","value":0,"issue":"必须大于0","location":"body"}}`,
+			code:    ErrorCodeParamError,
+			message: "参数错误",
+			detail:  ErrorDetail{Field: "/transfer_amount", Value: []byte("0"), Issue: "必须大于0", Location: "body"},
+			hasDet:  true,
+		},
+		{
+			name:    "没有错误详情",
+			body:    `{"code":"NOT_ENOUGH","message":"资金不足"}`,
+			code:    ErrorCodeNotEnough,
+			message: "资金不足",
+		},
+		{
+			name:    "code 不是字符串",
+			body:    `{"code":500,"message":null}`,
+			code:    "500",
+			message: "",
+		},
+		{
+			name: "body 不是 JSON",
+			body: `<html>502 Bad Gateway</html>`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := NewApiException(http.StatusBadRequest, http.Header{}, []byte(tc.body))
+			apiErr, ok := AsApiException(fmt.Errorf("wrap: %w", err))
+			require.True(t, ok)
+			assert.Equal(t, tc.code, apiErr.ErrorCode())
+			assert.Equal(t, tc.message, apiErr.ErrorMessage())
+			detail, ok := apiErr.ErrorDetail()
+			assert.Equal(t, tc.hasDet, ok)
+			assert.Equal(t, tc.detail, detail)
+		})
+	}
+}
+
+func TestErrorPredicates(t *testing.T) {
+	newErr := func(status int, body string) error {
+		return fmt.Errorf("wrap: %w", NewApiException(status, http.Header{}, []byte(body)))
+	}
+	testCases := []struct {
+		name         string
+		err          error
+		retryable    bool
+		rateLimited  bool
+		insufficient bool
+	}{
+		{name: "系统错误", err: newErr(500, `{"code":"SYSTEM_ERROR"}`), retryable: true},
+		{name: "限频", err: newErr(429, `{"code":"FREQUENCY_LIMITED"}`), retryable: true, rateLimited: true},
+		{name: "资金不足", err: newErr(403, `{"code":"NOT_ENOUGH"}`), insufficient: true},
+		{name: "没有错误码的 502", err: newErr(502, ``), retryable: true},
+		{name: "没有错误码的 429", err: newErr(429, ``), retryable: true, rateLimited: true},
+		{name: "不是 ApiException", err: errors.New("timeout")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.retryable, IsRetryable(tc.err))
+			assert.Equal(t, tc.rateLimited, IsRateLimited(tc.err))
+			assert.Equal(t, tc.insufficient, IsInsufficientFunds(tc.err))
+			assert.Equal(t, tc.insufficient, errors.Is(tc.err, ErrorCodeNotEnough))
+		})
+	}
+}
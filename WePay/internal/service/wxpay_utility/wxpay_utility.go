@@ -14,7 +14,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"math/big"
 	"net/http"
 	"os"
@@ -39,6 +39,15 @@ type MchConfig struct {
 	wechatPayPublicKeyFilePath string          // 微信支付公钥文件路径
 	privateKey                 *rsa.PrivateKey // 商户API证书对应的私钥
 	wechatPayPublicKey         *rsa.PublicKey  // 微信支付公钥
+	apiV3Key                   string          // 商户APIv3密钥，用于解密回调通知
+}
+
+// LogValue 记录日志时只输出商户号和证书序列号，不输出密钥
+func (c *MchConfig) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("mch_id", c.mchId),
+		slog.String("certificate_serial_no", c.certificateSerialNo),
+	)
 }
 
 // MchId 商户号
@@ -66,6 +75,20 @@ func (c *MchConfig) WechatPayPublicKey() *rsa.PublicKey {
 	return c.wechatPayPublicKey
 }
 
+// ApiV3Key 商户APIv3密钥，没有配置时为空
+func (c *MchConfig) ApiV3Key() string {
+	return c.apiV3Key
+}
+
+// SetApiV3Key 设置商户APIv3密钥，密钥必须是 32 字节
+func (c *MchConfig) SetApiV3Key(apiV3Key string) error {
+	if len(apiV3Key) != 32 {
+		return errors.New("invalid api v3 key, length must be 32 bytes")
+	}
+	c.apiV3Key = apiV3Key
+	return nil
+}
+
 // CreateMchConfig MchConfig 构造函数
 func CreateMchConfig(
 	mchId string,
@@ -92,7 +115,7 @@ func CreateMchConfig(
 			D:      big.NewInt(0),                            // 私钥d，实际应为大整数
 			Primes: []*big.Int{big.NewInt(0), big.NewInt(0)}, // 两个大素数p、q
 		}
-		log.Printf("load private key from file %s error: %s", mchConfig.privateKeyFilePath, err.Error())
+		slog.Error("load private key failed", "path", mchConfig.privateKeyFilePath, "error", err)
 	}
 	mchConfig.privateKey = privateKey
 	wechatPayPublicKey, err := LoadPublicKeyWithPath(mchConfig.wechatPayPublicKeyFilePath)
@@ -102,7 +125,7 @@ func CreateMchConfig(
 			N: big.NewInt(0), // 公钥N，实际应为大素数乘积
 			E: 65537,         // 公钥e，常用65537
 		}
-		log.Printf("load wechat pay public key from file %s error: %s", mchConfig.wechatPayPublicKeyFilePath, err.Error())
+		slog.Error("load wechat pay public key failed", "path", mchConfig.wechatPayPublicKeyFilePath, "error", err)
 	}
 	mchConfig.wechatPayPublicKey = wechatPayPublicKey
 	return mchConfig, nil
@@ -166,6 +189,15 @@ func LoadPublicKeyWithPath(path string) (publicKey *rsa.PublicKey, err error) {
 	return LoadPublicKey(string(publicKeyBytes))
 }
 
+// LoadApiV3KeyWithPath 通过文件路径加载商户APIv3密钥，忽略首尾的空白
+func LoadApiV3KeyWithPath(path string) (string, error) {
+	apiV3KeyBytes, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read api v3 key file err:%s", err.Error())
+	}
+	return string(bytes.TrimSpace(apiV3KeyBytes)), nil
+}
+
 // EncryptOAEPWithPublicKey 使用 OAEP padding方式用公钥进行加密
 func EncryptOAEPWithPublicKey(message string, publicKey *rsa.PublicKey) (ciphertext string, err error) {
 	if publicKey == nil {
@@ -331,11 +363,20 @@ func ValidateResponse(
 
 // ApiException 微信支付API错误异常，发送HTTP请求成功，但返回状态码不是 2XX 时抛出本异常
 type ApiException struct {
-	statusCode   int         // 应答报文的 HTTP 状态码
-	header       http.Header // 应答报文的 Header 信息
-	body         []byte      // 应答报文的 Body 原文
-	errorCode    string      // 微信支付回包的错误码
-	errorMessage string      // 微信支付回包的错误信息
+	statusCode   int             // 应答报文的 HTTP 状态码
+	header       http.Header     // 应答报文的 Header 信息
+	body         []byte          // 应答报文的 Body 原文
+	errorCode    ErrorCode       // 微信支付回包的错误码
+	errorMessage string          // 微信支付回包的错误信息
+	detail       json.RawMessage // 微信支付回包的错误详情原文
+}
+
+// ErrorDetail 微信支付回包里的错误详情，指出是哪个参数有问题
+type ErrorDetail struct {
+	Field    string          `json:"field"`
+	Value    json.RawMessage `json:"value"`
+	Issue    string          `json:"issue"`
+	Location string          `json:"location"`
 }
 
 func (c *ApiException) Error() string {
@@ -352,6 +393,12 @@ func (c *ApiException) Error() string {
 	return buf.String()
 }
 
+// Is 支持 errors.Is(err, wxpay_utility.ErrorCodeNotEnough) 这样按错误码判断
+func (c *ApiException) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && code == c.errorCode
+}
+
 func (c *ApiException) StatusCode() int {
 	return c.statusCode
 }
@@ -364,7 +411,7 @@ func (c *ApiException) Body() []byte {
 	return c.body
 }
 
-func (c *ApiException) ErrorCode() string {
+func (c *ApiException) ErrorCode() ErrorCode {
 	return c.errorCode
 }
 
@@ -372,6 +419,20 @@ func (c *ApiException) ErrorMessage() string {
 	return c.errorMessage
 }
 
+// Detail 错误详情原文，没有时为 nil
+func (c *ApiException) Detail() json.RawMessage {
+	return c.detail
+}
+
+// ErrorDetail 解析错误详情，没有或者格式不对时返回 false
+func (c *ApiException) ErrorDetail() (ErrorDetail, bool) {
+	var detail ErrorDetail
+	if len(c.detail) == 0 || json.Unmarshal(c.detail, &detail) != nil {
+		return ErrorDetail{}, false
+	}
+	return detail, true
+}
+
 func NewApiException(statusCode int, header http.Header, body []byte) error {
 	ret := &ApiException{
 		statusCode: statusCode,
@@ -379,19 +440,34 @@ func NewApiException(statusCode int, header http.Header, body []byte) error {
 		body:       body,
 	}
 
-	bodyObject := map[string]interface{}{}
+	// 逐个字段解析，某个字段类型不对时不影响其他字段
+	bodyObject := map[string]json.RawMessage{}
 	if err := json.Unmarshal(body, &bodyObject); err == nil {
-		if val, ok := bodyObject["code"]; ok {
-			ret.errorCode = val.(string)
-		}
-		if val, ok := bodyObject["message"]; ok {
-			ret.errorMessage = val.(string)
+		ret.errorCode = ErrorCode(jsonString(bodyObject["code"]))
+		ret.errorMessage = jsonString(bodyObject["message"])
+		if detail, ok := bodyObject["detail"]; ok && string(detail) != "null" {
+			ret.detail = detail
 		}
 	}
 
 	return ret
 }
 
+// jsonString 取 JSON 字符串的值，不是字符串时返回原文
+func jsonString(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	if string(raw) == "null" {
+		return ""
+	}
+	return string(raw)
+}
+
 // Time 复制 time.Time 对象，并返回复制体的指针
 func Time(t time.Time) *time.Time {
 	return &t
@@ -0,0 +1,80 @@
+// Package tracing 初始化 OpenTelemetry，并提供 service 层创建 span 的工具函数
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ServiceName = "wepay"
+
+	ExporterNone   = ""
+	ExporterOTLP   = "otlp"   // OTLP/HTTP，地址等配置读 OTEL_EXPORTER_OTLP_* 环境变量
+	ExporterStdout = "stdout" // 本地调试时输出到标准输出
+)
+
+// OutBillNoKey 转账单号，所有和转账单相关的 span 都带上
+const OutBillNoKey = attribute.Key("out_bill_no")
+
+func OutBillNo(outbillno string) attribute.KeyValue {
+	return OutBillNoKey.String(outbillno)
+}
+
+// Init 按 exporter 设置全局的 TracerProvider，返回的 shutdown 在退出前调用，把缓冲的 span 发出去。
+// exporter 为空时不采集，span 都是 no-op
+func Init(ctx context.Context, exporter string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanExporter sdktrace.SpanExporter
+	switch exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start 用全局的 TracerProvider 创建 span，name 用 "类型.方法" 的形式
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(ServiceName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End 结束 span，*err 不为 nil 时记录错误。配合具名返回值使用：defer tracing.End(span, &err)
+func End(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+		span.SetStatus(codes.Error, (*err).Error())
+	}
+	span.End()
+}
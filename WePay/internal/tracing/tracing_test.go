@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInit(t *testing.T) {
+	shutdown, err := Init(context.Background(), ExporterNone)
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = Init(context.Background(), "jaeger")
+	assert.Error(t, err)
+}
+
+func TestEnd(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	testCases := []struct {
+		name     string
+		err      error
+		wantCode codes.Code
+	}{
+		{name: "成功", wantCode: codes.Unset},
+		{name: "失败", err: errors.New("boom"), wantCode: codes.Error},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, span := Start(context.Background(), "Test."+tc.name, OutBillNo("b1"))
+			err := tc.err
+			End(span, &err)
+
+			spans := recorder.Ended()
+			got := spans[len(spans)-1]
+			assert.Equal(t, "Test."+tc.name, got.Name())
+			assert.Equal(t, tc.wantCode, got.Status().Code)
+			assert.Contains(t, got.Attributes(), OutBillNo("b1"))
+		})
+	}
+}
@@ -0,0 +1,414 @@
+package web
+
+import (
+	"errors"
+	"time"
+	"wepay/internal/domain"
+	"wepay/internal/logger"
+	"wepay/internal/service"
+	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/web/middleware"
+	"wepay/internal/web/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminHandler 运营管理后台
+type AdminHandler struct {
+	svc          service.TransferService
+	userSvc      service.UserService
+	reconcileSvc service.ReconcileService
+	merchantSvc  service.MerchantService
+}
+
+func NewAdminHandler(svc service.TransferService, userSvc service.UserService, reconcileSvc service.ReconcileService,
+	merchantSvc service.MerchantService) *AdminHandler {
+	return &AdminHandler{
+		svc:          svc,
+		userSvc:      userSvc,
+		reconcileSvc: reconcileSvc,
+		merchantSvc:  merchantSvc,
+	}
+}
+
+// RegisterRoutes ug 需要挂上管理员鉴权的 middleware
+func (a *AdminHandler) RegisterRoutes(ug *gin.RouterGroup) {
+	ug.GET("/bills", a.SearchBills)                          // 查询转账单
+	ug.GET("/bills/:out_bill_no", a.BillDetail)              // 转账单详情
+	ug.POST("/bills/:out_bill_no/sync", a.SyncBill)          // 向微信查询并同步状态
+	ug.POST("/bills/:out_bill_no/cancel", a.CancelBill)      // 撤销转账
+	ug.POST("/bills/:out_bill_no/receipt", a.ApplyReceipt)   // 申请电子回单
+	ug.GET("/bills/:out_bill_no/receipt", a.DownloadReceipt) // 下载电子回单
+	ug.POST("/users/:openid/balance", a.AdjustBalance)       // 人工调整余额
+	ug.GET("/reconcile", a.Reconcile)                        // 手动对账
+	ug.GET("/merchants", a.ListMerchants)                    // 商户列表
+	ug.POST("/merchants", a.RegisterMerchant)                // 新增或修改商户
+}
+
+type AdminTransferRecordVo struct {
+	ID           int64  `json:"id"`
+	OutBillNo    string `json:"out_bill_no"`
+	Openid       string `json:"openid"`
+	MchId        string `json:"mch_id"`
+	Amount       int64  `json:"amount"`
+	Remark       string `json:"remark"`
+	SceneId      string `json:"scene_id"`
+	Type         string `json:"type"`
+	Status       string `json:"status"`
+	PackageInfo  string `json:"package_info"`
+	FailReason   string `json:"fail_reason"`
+	DeviceId     string `json:"device_id"`
+	ClientIp     string `json:"client_ip"`
+	RiskDecision string `json:"risk_decision"`
+	RiskReasons  string `json:"risk_reasons"`
+	Ctime        string `json:"ctime"`
+	Utime        string `json:"utime"`
+}
+
+type TransferStatusEventVo struct {
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+	Source     string `json:"source"`
+	PayloadRef string `json:"payload_ref"`
+	Ctime      string `json:"ctime"`
+}
+
+type AdminBillDetailResp struct {
+	Record   AdminTransferRecordVo   `json:"record"`
+	Timeline []TransferStatusEventVo `json:"timeline"`
+}
+
+type AdminSearchBillsResp struct {
+	Records    []AdminTransferRecordVo `json:"records"`
+	NextCursor int64                   `json:"next_cursor"` // 0 表示没有下一页
+}
+
+func (a *AdminHandler) SearchBills(ctx *gin.Context) {
+	var req struct {
+		Openid    string `form:"openid"`
+		OutBillNo string `form:"out_bill_no"`
+		Status    string `form:"status"`
+		StartDate string `form:"start_date"`
+		EndDate   string `form:"end_date"`
+		Cursor    int64  `form:"cursor"`
+		Limit     int    `form:"limit"`
+	}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		writeInvalidParam(ctx, err)
+		return
+	}
+	query := domain.TransferHistoryQuery{
+		Openid:    req.Openid,
+		OutBillNo: req.OutBillNo,
+		Status:    req.Status,
+		Cursor:    req.Cursor,
+		Limit:     req.Limit,
+	}
+	var err error
+	query.StartTime, query.EndTime, err = parseDateRange(req.StartDate, req.EndDate)
+	if err != nil {
+		writeInvalidParam(ctx, err)
+		return
+	}
+
+	records, nextCursor, err := a.svc.ListTransferHistory(ctx, query)
+	if err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin search bills failed", "error", err)
+		return
+	}
+	resp := AdminSearchBillsResp{
+		Records:    make([]AdminTransferRecordVo, 0, len(records)),
+		NextCursor: nextCursor,
+	}
+	for _, record := range records {
+		resp.Records = append(resp.Records, toAdminTransferRecordVo(record))
+	}
+	response.OK(ctx, resp)
+}
+
+// BillDetail 转账单详情及其状态变更记录
+func (a *AdminHandler) BillDetail(ctx *gin.Context) {
+	outbillno := ctx.Param("out_bill_no")
+	record, err := a.svc.GetTransferRecordByOutBillNo(ctx, outbillno)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	events, err := a.svc.GetTransferTimeline(ctx, outbillno)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	resp := AdminBillDetailResp{
+		Record:   toAdminTransferRecordVo(record),
+		Timeline: make([]TransferStatusEventVo, 0, len(events)),
+	}
+	for _, event := range events {
+		resp.Timeline = append(resp.Timeline, TransferStatusEventVo{
+			FromStatus: event.FromStatus,
+			ToStatus:   event.ToStatus,
+			Source:     event.Source,
+			PayloadRef: event.PayloadRef,
+			Ctime:      event.Ctime.Format(time.RFC3339),
+		})
+	}
+	response.OK(ctx, resp)
+}
+
+func (a *AdminHandler) SyncBill(ctx *gin.Context) {
+	outbillno := ctx.Param("out_bill_no")
+	operator := ctx.GetString(middleware.OperatorKey)
+	config, err := a.mchConfigOf(ctx, outbillno)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	record, err := a.svc.SyncTransferStatus(ctx, config, outbillno, domain.TransferEventSourceAdmin, operator)
+	if err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin sync bill failed", "operator", operator, "out_bill_no", outbillno, "error", err)
+		return
+	}
+	response.OK(ctx, toAdminTransferRecordVo(record))
+}
+
+func (a *AdminHandler) CancelBill(ctx *gin.Context) {
+	outbillno := ctx.Param("out_bill_no")
+	operator := ctx.GetString(middleware.OperatorKey)
+	config, err := a.mchConfigOf(ctx, outbillno)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	state, err := a.svc.CancelTransfer(ctx, config, outbillno, operator)
+	if err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin cancel bill failed", "operator", operator, "out_bill_no", outbillno, "error", err)
+		return
+	}
+	logger.FromContext(ctx).Info("admin cancelled bill", "operator", operator, "out_bill_no", outbillno, "status", state)
+	response.OK(ctx, gin.H{"out_bill_no": outbillno, "status": state})
+}
+
+func (a *AdminHandler) ApplyReceipt(ctx *gin.Context) {
+	outbillno := ctx.Param("out_bill_no")
+	config, err := a.mchConfigOf(ctx, outbillno)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	receipt, err := a.svc.ApplyTransferReceipt(ctx, config, outbillno)
+	if err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin apply receipt failed", "out_bill_no", outbillno, "error", err)
+		return
+	}
+	response.OK(ctx, receipt)
+}
+
+// DownloadReceipt 下载电子回单，还没生成好时返回 202
+func (a *AdminHandler) DownloadReceipt(ctx *gin.Context) {
+	outbillno := ctx.Param("out_bill_no")
+	config, err := a.mchConfigOf(ctx, outbillno)
+	if err != nil {
+		writeError(ctx, err)
+		return
+	}
+	path, err := a.svc.DownloadTransferReceipt(ctx, config, outbillno)
+	if errors.Is(err, service.ErrReceiptNotReady) {
+		writeError(ctx, err)
+		return
+	}
+	if err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin download receipt failed", "out_bill_no", outbillno, "error", err)
+		return
+	}
+	ctx.FileAttachment(path, outbillno+".pdf")
+}
+
+// mchConfigOf 按转账单的商户号取商户配置，查询、撤销等操作要用发起转账的商户的密钥
+func (a *AdminHandler) mchConfigOf(ctx *gin.Context, outbillno string) (*wxpay_utility.MchConfig, error) {
+	record, err := a.svc.GetTransferRecordByOutBillNo(ctx, outbillno)
+	if err != nil {
+		return nil, err
+	}
+	return a.merchantSvc.MchConfig(ctx, record.MchId)
+}
+
+func (a *AdminHandler) AdjustBalance(ctx *gin.Context) {
+	var req struct {
+		Delta  int64  `json:"delta" binding:"required"`
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		writeInvalidParam(ctx, err)
+		return
+	}
+	openid := ctx.Param("openid")
+	balance, err := a.userSvc.AdjustBalance(ctx, ctx.GetString(middleware.OperatorKey), openid, req.Delta, req.Reason)
+	switch {
+	case errors.Is(err, service.ErrInsufficientBalance), errors.Is(err, service.ErrAuditReasonRequired):
+		writeError(ctx, err)
+		return
+	case err != nil:
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin adjust balance failed", "openid", openid, "error", err)
+		return
+	}
+	response.OK(ctx, gin.H{"openid": openid, "balance": balance})
+}
+
+func toAdminTransferRecordVo(record domain.TransferRecord) AdminTransferRecordVo {
+	return AdminTransferRecordVo{
+		ID:           record.ID,
+		OutBillNo:    record.OutBillNo,
+		Openid:       record.Openid,
+		MchId:        record.MchId,
+		Amount:       record.Amount,
+		Remark:       record.Remark,
+		SceneId:      record.SceneId,
+		Type:         record.Type,
+		Status:       record.Status,
+		PackageInfo:  record.PackageInfo,
+		FailReason:   record.FailReason,
+		DeviceId:     record.DeviceId,
+		ClientIp:     record.ClientIp,
+		RiskDecision: record.RiskDecision,
+		RiskReasons:  record.RiskReasons,
+		Ctime:        record.Ctime.Format(time.RFC3339),
+		Utime:        record.Utime.Format(time.RFC3339),
+	}
+}
+
+type ReconcileItemVo struct {
+	OutBillNo   string `json:"out_bill_no"`
+	LocalAmount int64  `json:"local_amount"`
+	WxAmount    int64  `json:"wx_amount"`
+	LocalStatus string `json:"local_status"`
+	WxStatus    string `json:"wx_status"`
+}
+
+type ReconcileReportVo struct {
+	BillDate         string            `json:"bill_date"`
+	Balanced         bool              `json:"balanced"`
+	WxBillCount      int               `json:"wx_bill_count"`
+	LocalCount       int               `json:"local_count"`
+	Matched          int               `json:"matched"`
+	Missing          []ReconcileItemVo `json:"missing"`
+	Unsettled        []ReconcileItemVo `json:"unsettled"`
+	Extra            []ReconcileItemVo `json:"extra"`
+	AmountMismatched []ReconcileItemVo `json:"amount_mismatched"`
+	StateMismatched  []ReconcileItemVo `json:"state_mismatched"`
+}
+
+// Reconcile 手动核对某个商户某一天的微信账单，date 格式为 2006-01-02
+func (a *AdminHandler) Reconcile(ctx *gin.Context) {
+	mchid := ctx.Query("mch_id")
+	if mchid == "" {
+		writeInvalidParam(ctx, errors.New("mch_id"))
+		return
+	}
+	date, err := time.ParseInLocation(time.DateOnly, ctx.Query("date"), time.Local)
+	if err != nil {
+		writeInvalidParam(ctx, errors.New("date"))
+		return
+	}
+	report, err := a.reconcileSvc.Reconcile(ctx, mchid, date)
+	if err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin reconcile failed", "mch_id", mchid, "error", err)
+		return
+	}
+	toVos := func(items []domain.ReconcileItem) []ReconcileItemVo {
+		vos := make([]ReconcileItemVo, 0, len(items))
+		for _, item := range items {
+			vos = append(vos, ReconcileItemVo(item))
+		}
+		return vos
+	}
+	response.OK(ctx, ReconcileReportVo{
+		BillDate:         report.BillDate.Format(time.DateOnly),
+		Balanced:         report.Balanced(),
+		WxBillCount:      report.WxBillCount,
+		LocalCount:       report.LocalCount,
+		Matched:          report.Matched,
+		Missing:          toVos(report.Missing),
+		Unsettled:        toVos(report.Unsettled),
+		Extra:            toVos(report.Extra),
+		AmountMismatched: toVos(report.AmountMismatched),
+		StateMismatched:  toVos(report.StateMismatched),
+	})
+}
+
+// MerchantVo 商户信息，只返回密钥文件的位置，不返回密钥本身
+type MerchantVo struct {
+	MchId                  string   `json:"mch_id"`
+	CertificateSerialNo    string   `json:"certificate_serial_no"`
+	PrivateKeyPath         string   `json:"private_key_path"`
+	WechatPayPublicKeyId   string   `json:"wechat_pay_public_key_id"`
+	WechatPayPublicKeyPath string   `json:"wechat_pay_public_key_path"`
+	ApiV3KeyPath           string   `json:"api_v3_key_path"`
+	NotifyUrl              string   `json:"notify_url"`
+	Appids                 []string `json:"appids"`
+}
+
+func (a *AdminHandler) ListMerchants(ctx *gin.Context) {
+	merchants, err := a.merchantSvc.List(ctx)
+	if err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin list merchants failed", "error", err)
+		return
+	}
+	vos := make([]MerchantVo, 0, len(merchants))
+	for _, m := range merchants {
+		vos = append(vos, MerchantVo{
+			MchId:                  m.MchId,
+			CertificateSerialNo:    m.CertificateSerialNo,
+			PrivateKeyPath:         m.PrivateKeyPath,
+			WechatPayPublicKeyId:   m.WechatPayPublicKeyId,
+			WechatPayPublicKeyPath: m.WechatPayPublicKeyPath,
+			ApiV3KeyPath:           m.ApiV3KeyPath,
+			NotifyUrl:              m.NotifyUrl,
+			Appids:                 m.Appids,
+		})
+	}
+	response.OK(ctx, vos)
+}
+
+// RegisterMerchant 新增或修改商户，appids 里的小程序改由这个商户出款
+func (a *AdminHandler) RegisterMerchant(ctx *gin.Context) {
+	var req struct {
+		MchId                  string   `json:"mch_id" binding:"required"`
+		CertificateSerialNo    string   `json:"certificate_serial_no" binding:"required"`
+		PrivateKeyPath         string   `json:"private_key_path" binding:"required"`
+		WechatPayPublicKeyId   string   `json:"wechat_pay_public_key_id" binding:"required"`
+		WechatPayPublicKeyPath string   `json:"wechat_pay_public_key_path" binding:"required"`
+		ApiV3KeyPath           string   `json:"api_v3_key_path" binding:"required"`
+		NotifyUrl              string   `json:"notify_url" binding:"required"`
+		Appids                 []string `json:"appids" binding:"required,min=1"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		writeInvalidParam(ctx, err)
+		return
+	}
+	err := a.merchantSvc.Register(ctx, domain.Merchant{
+		MchId:                  req.MchId,
+		CertificateSerialNo:    req.CertificateSerialNo,
+		PrivateKeyPath:         req.PrivateKeyPath,
+		WechatPayPublicKeyId:   req.WechatPayPublicKeyId,
+		WechatPayPublicKeyPath: req.WechatPayPublicKeyPath,
+		ApiV3KeyPath:           req.ApiV3KeyPath,
+		NotifyUrl:              req.NotifyUrl,
+		Appids:                 req.Appids,
+	})
+	if err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin register merchant failed", "mch_id", req.MchId, "error", err)
+		return
+	}
+	logger.FromContext(ctx).Info("admin registered merchant", "operator", ctx.GetString(middleware.OperatorKey),
+		"mch_id", req.MchId, "appids", req.Appids)
+	response.OK(ctx, gin.H{"mch_id": req.MchId})
+}
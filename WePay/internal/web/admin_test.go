@@ -0,0 +1,264 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wepay/internal/domain"
+	"wepay/internal/service"
+	svcmocks "wepay/internal/service/mocks"
+	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/web/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestAdminHandler(t *testing.T) {
+	testCases := []struct {
+		name     string
+		method   string
+		url      string
+		token    string
+		reqBody  string
+		mock     func(ctrl *gomock.Controller) (service.TransferService, service.UserService, service.MerchantService)
+		wantCode int
+	}{
+		{
+			name:     "unauthorized",
+			method:   http.MethodGet,
+			url:      "/admin/bills",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "wrong token",
+			method:   http.MethodGet,
+			url:      "/admin/bills",
+			token:    "wrong",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:   "search bills",
+			method: http.MethodGet,
+			url:    "/admin/bills?out_bill_no=plfk2020042013",
+			token:  "secret",
+			mock: func(ctrl *gomock.Controller) (service.TransferService, service.UserService, service.MerchantService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ListTransferHistory(gomock.Any(), domain.TransferHistoryQuery{
+					OutBillNo: "plfk2020042013",
+				}).Return([]domain.TransferRecord{{OutBillNo: "plfk2020042013"}}, int64(0), nil)
+				return transferSvc, nil, nil
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:   "bill not found",
+			method: http.MethodGet,
+			url:    "/admin/bills/plfk2020042013",
+			token:  "secret",
+			mock: func(ctrl *gomock.Controller) (service.TransferService, service.UserService, service.MerchantService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), "plfk2020042013").
+					Return(domain.TransferRecord{}, service.ErrTransferNotFound)
+				return transferSvc, nil, nil
+			},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:   "bill detail",
+			method: http.MethodGet,
+			url:    "/admin/bills/plfk2020042013",
+			token:  "secret",
+			mock: func(ctrl *gomock.Controller) (service.TransferService, service.UserService, service.MerchantService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), "plfk2020042013").
+					Return(domain.TransferRecord{OutBillNo: "plfk2020042013", Status: domain.TransferStatusSuccess}, nil)
+				transferSvc.EXPECT().GetTransferTimeline(gomock.Any(), "plfk2020042013").
+					Return([]domain.TransferStatusEvent{
+						{
+							OutBillNo:  "plfk2020042013",
+							FromStatus: domain.TransferStatusProcessing,
+							ToStatus:   domain.TransferStatusSuccess,
+							Source:     domain.TransferEventSourceConfirm,
+						},
+					}, nil)
+				return transferSvc, nil, nil
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:   "cancel finished bill",
+			method: http.MethodPost,
+			url:    "/admin/bills/plfk2020042013/cancel",
+			token:  "secret",
+			mock: func(ctrl *gomock.Controller) (service.TransferService, service.UserService, service.MerchantService) {
+				transferSvc, merchantSvc := mockBillMerchant(ctrl, "plfk2020042013")
+				transferSvc.EXPECT().CancelTransfer(gomock.Any(), gomock.Any(), "plfk2020042013", "alice").
+					Return(domain.TransferStatusSuccess, service.ErrTransferNotCancelable)
+				return transferSvc, nil, merchantSvc
+			},
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:   "receipt not ready",
+			method: http.MethodGet,
+			url:    "/admin/bills/plfk2020042013/receipt",
+			token:  "secret",
+			mock: func(ctrl *gomock.Controller) (service.TransferService, service.UserService, service.MerchantService) {
+				transferSvc, merchantSvc := mockBillMerchant(ctrl, "plfk2020042013")
+				transferSvc.EXPECT().DownloadTransferReceipt(gomock.Any(), gomock.Any(), "plfk2020042013").
+					Return("", service.ErrReceiptNotReady)
+				return transferSvc, nil, merchantSvc
+			},
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:   "apply receipt for unfinished bill",
+			method: http.MethodPost,
+			url:    "/admin/bills/plfk2020042013/receipt",
+			token:  "secret",
+			mock: func(ctrl *gomock.Controller) (service.TransferService, service.UserService, service.MerchantService) {
+				transferSvc, merchantSvc := mockBillMerchant(ctrl, "plfk2020042013")
+				transferSvc.EXPECT().ApplyTransferReceipt(gomock.Any(), gomock.Any(), "plfk2020042013").
+					Return(nil, service.ErrTransferNotFinished)
+				return transferSvc, nil, merchantSvc
+			},
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:    "adjust balance",
+			method:  http.MethodPost,
+			url:     "/admin/users/o1234567890/balance",
+			token:   "secret",
+			reqBody: `{"delta": -50, "reason": "重复发放"}`,
+			mock: func(ctrl *gomock.Controller) (service.TransferService, service.UserService, service.MerchantService) {
+				userSvc := svcmocks.NewMockUserService(ctrl)
+				userSvc.EXPECT().AdjustBalance(gomock.Any(), "alice", "o1234567890", int64(-50), "重复发放").
+					Return(int64(50), nil)
+				return nil, userSvc, nil
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "adjust balance without reason",
+			method:   http.MethodPost,
+			url:      "/admin/users/o1234567890/balance",
+			token:    "secret",
+			reqBody:  `{"delta": -50}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:    "adjust balance below zero",
+			method:  http.MethodPost,
+			url:     "/admin/users/o1234567890/balance",
+			token:   "secret",
+			reqBody: `{"delta": -500, "reason": "重复发放"}`,
+			mock: func(ctrl *gomock.Controller) (service.TransferService, service.UserService, service.MerchantService) {
+				userSvc := svcmocks.NewMockUserService(ctrl)
+				userSvc.EXPECT().AdjustBalance(gomock.Any(), "alice", "o1234567890", int64(-500), "重复发放").
+					Return(int64(0), service.ErrInsufficientBalance)
+				return nil, userSvc, nil
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:   "sync bill of unknown merchant",
+			method: http.MethodPost,
+			url:    "/admin/bills/plfk2020042013/sync",
+			token:  "secret",
+			mock: func(ctrl *gomock.Controller) (service.TransferService, service.UserService, service.MerchantService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), "plfk2020042013").
+					Return(domain.TransferRecord{OutBillNo: "plfk2020042013", MchId: "1900001109"}, nil)
+				merchantSvc := svcmocks.NewMockMerchantService(ctrl)
+				merchantSvc.EXPECT().MchConfig(gomock.Any(), "1900001109").Return(nil, service.ErrMerchantNotFound)
+				return transferSvc, nil, merchantSvc
+			},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "reconcile without mch_id",
+			method:   http.MethodGet,
+			url:      "/admin/reconcile?date=2025-07-23",
+			token:    "secret",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:   "register merchant",
+			method: http.MethodPost,
+			url:    "/admin/merchants",
+			token:  "secret",
+			reqBody: `{"mch_id": "1900001109", "certificate_serial_no": "5157F09EFDC096DE15EBE81A47057A72",
+				"private_key_path": "certs/1900001109/private_key.pem", "wechat_pay_public_key_id": "PUB_KEY_ID_0119000011092025",
+				"wechat_pay_public_key_path": "certs/1900001109/public_key.pem", "api_v3_key_path": "certs/1900001109/apiv3_key",
+				"notify_url": "https://example.com/transfer/notify", "appids": ["wx8888888888888888"]}`,
+			mock: func(ctrl *gomock.Controller) (service.TransferService, service.UserService, service.MerchantService) {
+				merchantSvc := svcmocks.NewMockMerchantService(ctrl)
+				merchantSvc.EXPECT().Register(gomock.Any(), domain.Merchant{
+					MchId:                  "1900001109",
+					CertificateSerialNo:    "5157F09EFDC096DE15EBE81A47057A72",
+					PrivateKeyPath:         "certs/1900001109/private_key.pem",
+					WechatPayPublicKeyId:   "PUB_KEY_ID_0119000011092025",
+					WechatPayPublicKeyPath: "certs/1900001109/public_key.pem",
+					ApiV3KeyPath:           "certs/1900001109/apiv3_key",
+					NotifyUrl:              "https://example.com/transfer/notify",
+					Appids:                 []string{"wx8888888888888888"},
+				}).Return(nil)
+				return nil, nil, merchantSvc
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "register merchant without appids",
+			method:   http.MethodPost,
+			url:      "/admin/merchants",
+			token:    "secret",
+			reqBody:  `{"mch_id": "1900001109", "appids": []}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				transferSvc service.TransferService
+				userSvc     service.UserService
+				merchantSvc service.MerchantService
+			)
+			if tc.mock != nil {
+				transferSvc, userSvc, merchantSvc = tc.mock(ctrl)
+			}
+			server := gin.Default()
+			adminHandler := NewAdminHandler(transferSvc, userSvc, nil, merchantSvc)
+			auth := middleware.NewAdminAuthBuilder(map[string]string{"secret": "alice"}).Build()
+			adminHandler.RegisterRoutes(server.Group("/admin", auth))
+
+			req, err := http.NewRequest(tc.method, tc.url, bytes.NewBuffer([]byte(tc.reqBody)))
+			assert.Nil(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.wantCode, resp.Code)
+		})
+	}
+}
+
+// mockBillMerchant 转账单 outbillno 属于商户 1368139500
+func mockBillMerchant(ctrl *gomock.Controller, outbillno string) (*svcmocks.MockTransferService, *svcmocks.MockMerchantService) {
+	transferSvc := svcmocks.NewMockTransferService(ctrl)
+	transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), outbillno).
+		Return(domain.TransferRecord{OutBillNo: outbillno, MchId: "1368139500"}, nil)
+	merchantSvc := svcmocks.NewMockMerchantService(ctrl)
+	merchantSvc.EXPECT().MchConfig(gomock.Any(), "1368139500").Return(&wxpay_utility.MchConfig{}, nil)
+	return transferSvc, merchantSvc
+}
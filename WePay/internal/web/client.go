@@ -1,7 +1,21 @@
 package web
 
-import "wepay/internal/service/wxpay_utility"
+import (
+	"context"
+	"errors"
+	"wepay/internal/service"
+	"wepay/internal/service/wxpay_utility"
 
+	"github.com/wechatpay-apiv3/wechatpay-go/core"
+)
+
+// 商户的配置 transfer_scene_id（转账场景）,  user_recv_perception（用户收款码）
+const (
+	transferSceneId    = "1000" // 转账场景：现金营销
+	userRecvPerception = "现金红包" // 用户收款时感知到的收款原因将根据转账场景自动展示默认内容。
+)
+
+// Client 某个小程序及其出款商户，按请求里的 appid 或转账单的商户号从商户注册表构造
 type Client struct {
 	Appid     string
 	MchConfig *wxpay_utility.MchConfig
@@ -15,3 +29,36 @@ func NewClient(appid string, mchConfig *wxpay_utility.MchConfig, notifyUrl strin
 		NotifyUrl: notifyUrl,
 	}
 }
+
+// clientByAppid 找到小程序对应的出款商户，构造调用微信支付 API 用的 Client。appid 没有关联商户时返回 errAppidNotBound
+func clientByAppid(ctx context.Context, merchantSvc service.MerchantService, appid string) (Client, error) {
+	m, err := merchantSvc.GetByAppid(ctx, appid)
+	if errors.Is(err, service.ErrMerchantNotFound) {
+		return Client{}, errAppidNotBound
+	}
+	if err != nil {
+		return Client{}, err
+	}
+	config, err := merchantSvc.MchConfig(ctx, m.MchId)
+	if err != nil {
+		return Client{}, err
+	}
+	return NewClient(appid, config, m.NotifyUrl), nil
+}
+
+// NewTransferToUserRequest 构造发往微信的 TransferToUserRequest
+func (c Client) NewTransferToUserRequest(outbillno, openid string, amount int64, remark string) *service.TransferToUserRequest {
+	return &service.TransferToUserRequest{
+		// 商家
+		Appid:              core.String(c.Appid), // 小程序与商户关联的appid
+		OutBillNo:          core.String(outbillno),
+		TransferSceneId:    core.String(transferSceneId),
+		Openid:             core.String(openid),
+		MchId:              core.String(c.MchConfig.MchId()),
+		UserName:           core.String(openid),
+		TransferAmount:     core.Int64(amount),
+		TransferRemark:     core.String(remark),
+		NotifyUrl:          core.String(c.NotifyUrl),
+		UserRecvPerception: core.String(userRecvPerception),
+	}
+}
@@ -0,0 +1,87 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"wepay/internal/service"
+	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/web/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errAppidNotBound 小程序传来的 appid 没有关联出款商户，是调用方的配置问题
+var errAppidNotBound = errors.New("appid 未关联商户")
+
+// errIdempotencyConflict 同一个幂等键发起了用户或金额不同的转账，是调用方的 bug
+var errIdempotencyConflict = errors.New("幂等键已用于其他转账")
+
+// errorMappings service 层的错误对应的 HTTP 状态码和业务错误码，按顺序用 errors.Is 匹配，第一个匹配的生效。
+// message 为空时用 err 的描述，service 层用 "%w: 说明" 包装的说明也会返回给调用方
+var errorMappings = []struct {
+	err     error
+	status  int
+	code    response.Code
+	message string
+}{
+	{err: errAppidNotBound, status: http.StatusBadRequest, code: response.CodeMerchantNotFound},
+	{err: service.ErrMerchantNotFound, status: http.StatusNotFound, code: response.CodeMerchantNotFound},
+	{err: errIdempotencyConflict, status: http.StatusConflict, code: response.CodeIdempotencyConflict},
+	{err: service.ErrUserNotFound, status: http.StatusNotFound, code: response.CodeUserNotFound},
+	{err: service.ErrTransferNotFound, status: http.StatusNotFound, code: response.CodeBillNotFound, message: "转账单不存在"},
+	{err: service.ErrTransferExists, status: http.StatusConflict, code: response.CodeBillExists, message: "转账单已存在"},
+	{err: service.ErrTransferNotOwned, status: http.StatusForbidden, code: response.CodeBillNotOwned},
+	{err: service.ErrTransferConfirmed, status: http.StatusConflict, code: response.CodeBillAlreadyConfirmed},
+	{err: service.ErrTransferNotConfirmable, status: http.StatusConflict, code: response.CodeBillNotConfirmable},
+	{err: service.ErrTransferNotCancelable, status: http.StatusConflict, code: response.CodeBillNotCancelable},
+	{err: service.ErrTransferFinished, status: http.StatusConflict, code: response.CodeBillFinished},
+	{err: service.ErrTransferNotFinished, status: http.StatusConflict, code: response.CodeBillNotFinished},
+	{err: service.ErrReceiptNotReady, status: http.StatusAccepted, code: response.CodeReceiptNotReady},
+	{err: service.ErrTransferPaused, status: http.StatusServiceUnavailable, code: response.CodeTransferPaused},
+	{err: service.ErrInsufficientBalance, status: http.StatusBadRequest, code: response.CodeInsufficientBalance},
+	{err: service.ErrAuditReasonRequired, status: http.StatusBadRequest, code: response.CodeAuditReasonRequired},
+	{err: service.ErrTransferDenied, status: http.StatusForbidden, code: response.CodeTransferDenied},
+	{err: service.ErrTransferUnderReview, status: http.StatusAccepted, code: response.CodeTransferUnderReview},
+	{err: service.ErrTransferPending, status: http.StatusAccepted, code: response.CodeTransferPending, message: service.ErrTransferPending.Error()},
+	{err: service.ErrTransferNotReviewable, status: http.StatusConflict, code: response.CodeBillNotReviewable},
+	{err: service.ErrInvalidRiskRules, status: http.StatusBadRequest, code: response.CodeInvalidRiskRules},
+	{err: service.ErrLockTimeout, status: http.StatusConflict, code: response.CodeBusy},
+	{err: service.ErrTransferRejected, status: http.StatusBadGateway, code: response.CodeTransferRejected, message: service.ErrTransferRejected.Error()},
+	{err: context.DeadlineExceeded, status: http.StatusGatewayTimeout, code: response.CodeTimeout, message: "处理超时，请稍后重试"},
+}
+
+// writeError 把 service 层的错误写成统一格式的应答。微信支付 API 的错误把微信的错误码放在 data 里，
+// 其他没有对应错误码的错误返回 500，不把内部错误暴露给调用方，错误本身记在访问日志里
+func writeError(ctx *gin.Context, err error) {
+	writeErrorData(ctx, err, nil)
+}
+
+// writeErrorData 和 writeError 一样，data 不为 nil 时放在应答的 data 里，比如进入审核的转账单号
+func writeErrorData(ctx *gin.Context, err error, data any) {
+	_ = ctx.Error(err)
+	apiErr, isApiErr := wxpay_utility.AsApiException(err)
+	if data == nil && isApiErr {
+		data = gin.H{"wx_code": apiErr.ErrorCode(), "wx_message": apiErr.ErrorMessage()}
+	}
+	for _, m := range errorMappings {
+		if errors.Is(err, m.err) {
+			message := m.message
+			if message == "" {
+				message = err.Error()
+			}
+			response.Fail(ctx, m.status, m.code, message, data)
+			return
+		}
+	}
+	if isApiErr {
+		response.Fail(ctx, http.StatusBadGateway, response.CodeWxpayError, "调用微信支付失败", data)
+		return
+	}
+	response.Fail(ctx, http.StatusInternalServerError, response.CodeInternal, "服务内部错误", data)
+}
+
+// writeInvalidParam 参数绑定或者校验失败
+func writeInvalidParam(ctx *gin.Context, err error) {
+	response.Fail(ctx, http.StatusBadRequest, response.CodeInvalidParam, "参数不合法: "+err.Error(), nil)
+}
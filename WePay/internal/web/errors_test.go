@@ -0,0 +1,128 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wepay/internal/service"
+	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/web/response"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeResult 解析统一格式的应答，data 不为 nil 时把应答的 data 解析到 data 里
+func decodeResult(t *testing.T, body []byte, data any) response.Result {
+	var result struct {
+		response.Result
+		Data json.RawMessage `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(body, &result), string(body))
+	if data != nil && len(result.Data) > 0 {
+		require.NoError(t, json.Unmarshal(result.Data, data))
+	}
+	return result.Result
+}
+
+func TestWriteError(t *testing.T) {
+	apiErr := wxpay_utility.NewApiException(http.StatusForbidden, http.Header{},
+		[]byte(`{"code":"NOT_ENOUGH","message":"资金不足"}`))
+	testCases := []struct {
+		name        string
+		err         error
+		data        any
+		wantStatus  int
+		wantCode    response.Code
+		wantMessage string
+		wantData    map[string]any
+	}{
+		{
+			name:        "转账单不存在",
+			err:         fmt.Errorf("query: %w", service.ErrTransferNotFound),
+			wantStatus:  http.StatusNotFound,
+			wantCode:    response.CodeBillNotFound,
+			wantMessage: "转账单不存在",
+		},
+		{
+			name:        "用户不存在",
+			err:         service.ErrUserNotFound,
+			wantStatus:  http.StatusNotFound,
+			wantCode:    response.CodeUserNotFound,
+			wantMessage: service.ErrUserNotFound.Error(),
+		},
+		{
+			name:        "转账单号重复",
+			err:         fmt.Errorf("insert: %w", service.ErrTransferExists),
+			wantStatus:  http.StatusConflict,
+			wantCode:    response.CodeBillExists,
+			wantMessage: "转账单已存在",
+		},
+		{
+			name:        "已经确认过",
+			err:         service.ErrTransferConfirmed,
+			data:        ConfirmVo{OutBillNo: "b1", Status: "SUCCESS"},
+			wantStatus:  http.StatusConflict,
+			wantCode:    response.CodeBillAlreadyConfirmed,
+			wantMessage: service.ErrTransferConfirmed.Error(),
+			wantData:    map[string]any{"out_bill_no": "b1", "status": "SUCCESS"},
+		},
+		{
+			name:        "带说明的错误返回说明",
+			err:         fmt.Errorf("%w: 不能为负数", service.ErrInvalidRiskRules),
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    response.CodeInvalidRiskRules,
+			wantMessage: "风控规则不合法: 不能为负数",
+		},
+		{
+			name:        "微信拒绝转账",
+			err:         fmt.Errorf("%w: %w", service.ErrTransferRejected, apiErr),
+			wantStatus:  http.StatusBadGateway,
+			wantCode:    response.CodeTransferRejected,
+			wantMessage: service.ErrTransferRejected.Error(),
+			wantData:    map[string]any{"wx_code": "NOT_ENOUGH", "wx_message": "资金不足"},
+		},
+		{
+			name:        "其他微信错误",
+			err:         apiErr,
+			wantStatus:  http.StatusBadGateway,
+			wantCode:    response.CodeWxpayError,
+			wantMessage: "调用微信支付失败",
+			wantData:    map[string]any{"wx_code": "NOT_ENOUGH", "wx_message": "资金不足"},
+		},
+		{
+			name:        "超时",
+			err:         fmt.Errorf("query: %w", context.DeadlineExceeded),
+			wantStatus:  http.StatusGatewayTimeout,
+			wantCode:    response.CodeTimeout,
+			wantMessage: "处理超时，请稍后重试",
+		},
+		{
+			name:        "内部错误不暴露细节",
+			err:         errors.New("dial tcp 10.0.0.1:3306: connection refused"),
+			wantStatus:  http.StatusInternalServerError,
+			wantCode:    response.CodeInternal,
+			wantMessage: "服务内部错误",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(resp)
+			writeErrorData(ctx, tc.err, tc.data)
+
+			var data map[string]any
+			result := decodeResult(t, resp.Body.Bytes(), &data)
+			assert.Equal(t, tc.wantStatus, resp.Code)
+			assert.Equal(t, tc.wantCode, result.Code)
+			assert.Equal(t, tc.wantMessage, result.Message)
+			assert.Equal(t, tc.wantData, data)
+			assert.Len(t, ctx.Errors, 1, "错误记在访问日志里")
+		})
+	}
+}
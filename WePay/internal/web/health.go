@@ -0,0 +1,105 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+	"wepay/internal/logger"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HealthCheck 就绪检查的一项依赖，返回 nil 表示可用
+type HealthCheck func(ctx context.Context) error
+
+// HealthHandler /healthz 只表示进程还活着，/readyz 检查依赖是否可用，开始退出后 /readyz 返回 503，
+// 负载均衡不再把新请求转过来。/readyz 只返回每项检查是否通过，失败原因记在日志里
+type HealthHandler struct {
+	names    []string
+	checks   map[string]HealthCheck
+	optional map[string]bool
+	timeout  time.Duration
+	draining atomic.Bool
+}
+
+func NewHealthHandler(timeout time.Duration) *HealthHandler {
+	return &HealthHandler{
+		checks:   make(map[string]HealthCheck),
+		optional: make(map[string]bool),
+		timeout:  timeout,
+	}
+}
+
+// Add 增加一项就绪检查，name 出现在 /readyz 的应答里
+func (h *HealthHandler) Add(name string, check HealthCheck) *HealthHandler {
+	h.names = append(h.names, name)
+	h.checks[name] = check
+	return h
+}
+
+// AddOptional 增加一项不影响就绪的检查，失败时 /readyz 仍然返回 200，status 为 degraded。
+// 用于所有实例共用的依赖，它不可用时摘掉实例也没有用，只会让整个服务不可用
+func (h *HealthHandler) AddOptional(name string, check HealthCheck) *HealthHandler {
+	h.optional[name] = true
+	return h.Add(name, check)
+}
+
+// Drain 开始退出，之后 /readyz 都返回 503
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
+
+func (h *HealthHandler) RegisterRoutes(server gin.IRoutes) {
+	server.GET("/healthz", h.Healthz)
+	server.GET("/readyz", h.Readyz)
+}
+
+func (h *HealthHandler) Healthz(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *HealthHandler) Readyz(ctx *gin.Context) {
+	if h.draining.Load() {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+		return
+	}
+	c, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	// 各项检查并发执行，总耗时不超过 timeout
+	l := logger.FromContext(ctx)
+	results := make(map[string]string, len(h.names))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range h.names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := "ok"
+			if err := h.checks[name](c); err != nil {
+				result = "unavailable"
+				l.Warn("readiness check failed", "check", name, "optional", h.optional[name], "error", err)
+			}
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	for name, result := range results {
+		switch {
+		case result == "ok":
+		case h.optional[name]:
+			if code == http.StatusOK {
+				status = "degraded"
+			}
+		default:
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	ctx.JSON(code, gin.H{"status": status, "checks": results})
+}
@@ -0,0 +1,124 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthHandler(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	testCases := []struct {
+		name       string
+		path       string
+		checks     map[string]HealthCheck
+		optional   map[string]HealthCheck
+		drain      bool
+		wantCode   int
+		wantStatus string
+		wantChecks map[string]string
+	}{
+		{
+			name:       "存活",
+			path:       "/healthz",
+			checks:     map[string]HealthCheck{"db": func(context.Context) error { return errors.New("down") }},
+			wantCode:   http.StatusOK,
+			wantStatus: "ok",
+		},
+		{
+			name:       "就绪",
+			path:       "/readyz",
+			checks:     map[string]HealthCheck{"db": ok, "redis": ok},
+			wantCode:   http.StatusOK,
+			wantStatus: "ok",
+			wantChecks: map[string]string{"db": "ok", "redis": "ok"},
+		},
+		{
+			name: "依赖不可用",
+			path: "/readyz",
+			checks: map[string]HealthCheck{
+				"db":            ok,
+				"merchant_keys": func(context.Context) error { return errors.New("merchant 1368139500: no such file") },
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "unavailable",
+			// 不返回失败原因
+			wantChecks: map[string]string{"db": "ok", "merchant_keys": "unavailable"},
+		},
+		{
+			name:       "可选的依赖不可用",
+			path:       "/readyz",
+			checks:     map[string]HealthCheck{"db": ok},
+			optional:   map[string]HealthCheck{"redis": func(context.Context) error { return errors.New("connection refused") }},
+			wantCode:   http.StatusOK,
+			wantStatus: "degraded",
+			wantChecks: map[string]string{"db": "ok", "redis": "unavailable"},
+		},
+		{
+			name: "可选的依赖和必需的依赖都不可用",
+			path: "/readyz",
+			checks: map[string]HealthCheck{
+				"db": func(context.Context) error { return errors.New("down") },
+			},
+			optional:   map[string]HealthCheck{"redis": func(context.Context) error { return errors.New("connection refused") }},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "unavailable",
+			wantChecks: map[string]string{"db": "unavailable", "redis": "unavailable"},
+		},
+		{
+			name: "检查超时",
+			path: "/readyz",
+			checks: map[string]HealthCheck{"redis": func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "unavailable",
+			wantChecks: map[string]string{"redis": "unavailable"},
+		},
+		{
+			name:       "正在退出",
+			path:       "/readyz",
+			checks:     map[string]HealthCheck{"db": ok},
+			drain:      true,
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "draining",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			health := NewHealthHandler(50 * time.Millisecond)
+			for name, check := range tc.checks {
+				health.Add(name, check)
+			}
+			for name, check := range tc.optional {
+				health.AddOptional(name, check)
+			}
+			if tc.drain {
+				health.Drain()
+			}
+			server := gin.New()
+			health.RegisterRoutes(server)
+
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			var body struct {
+				Status string            `json:"status"`
+				Checks map[string]string `json:"checks"`
+			}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantStatus, body.Status)
+			assert.Equal(t, tc.wantChecks, body.Checks)
+		})
+	}
+}
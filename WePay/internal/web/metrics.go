@@ -0,0 +1,31 @@
+package web
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// 回调和确认收款的指标，转账本身的指标在 service 里
+var (
+	notifyVerifyFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wepay",
+		Subsystem: "notify",
+		Name:      "verify_failures_total",
+		Help:      "微信回调验签失败的次数",
+	}, []string{"mch_id"})
+	confirmOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wepay",
+		Subsystem: "transfer",
+		Name:      "confirm_total",
+		Help:      "用户确认收款的结果",
+	}, []string{"result"})
+)
+
+const (
+	confirmResultSuccess          = "success"
+	confirmResultAlreadyConfirmed = "already_confirmed"
+	confirmResultNotConfirmable   = "not_confirmable"
+	confirmResultNotFound         = "not_found"
+	confirmResultNotOwned         = "not_owned"
+	confirmResultError            = "error"
+)
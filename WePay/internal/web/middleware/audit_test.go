@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wepay/internal/domain"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAuditRecorder struct {
+	logs []domain.AuditLog
+	err  error
+}
+
+func (r *fakeAuditRecorder) Record(ctx context.Context, log domain.AuditLog) error {
+	r.logs = append(r.logs, log)
+	return r.err
+}
+
+func TestAuditBuilder(t *testing.T) {
+	recorder := &fakeAuditRecorder{}
+	server := gin.New()
+	server.Use(NewAdminAuthBuilder(map[string]string{"t1": "alice"}).Build(), NewAuditBuilder(recorder).Build())
+	var handlerBody string
+	server.POST("/bills/:out_bill_no/cancel", func(ctx *gin.Context) {
+		body, _ := io.ReadAll(ctx.Request.Body)
+		handlerBody = string(body)
+		ctx.Status(http.StatusOK)
+	})
+	server.GET("/bills/:out_bill_no", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+	send := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer t1")
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/bills/b1", ""))
+	assert.Empty(t, recorder.logs, "查询不记录")
+
+	body := `{"reason":"重复发放"}` + strings.Repeat(" ", maxAuditBodySize)
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/bills/b1/cancel", body))
+	assert.Equal(t, body, handlerBody, "handler 读到完整的请求体")
+	require.Len(t, recorder.logs, 1)
+	log := recorder.logs[0]
+	assert.Equal(t, "alice", log.Operator)
+	assert.Equal(t, "POST /bills/:out_bill_no/cancel", log.Action)
+	assert.Equal(t, "b1", log.Target)
+	assert.True(t, strings.HasPrefix(log.Detail, `status=200 body={"reason":"重复发放"}`), log.Detail)
+	assert.LessOrEqual(t, len(log.Detail), maxAuditBodySize+32)
+
+	recorder.err = errors.New("db down")
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/bills/b2/cancel", ""), "记录失败不影响应答")
+	assert.Len(t, recorder.logs, 2)
+}
+
+func TestAdminAuthBuilder(t *testing.T) {
+	server := gin.New()
+	server.Use(NewAdminAuthBuilder(map[string]string{"t1": "alice", "t2": "bob"}).Build())
+	server.GET("/whoami", func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, ctx.GetString(OperatorKey))
+	})
+	testCases := []struct {
+		name     string
+		header   string
+		wantCode int
+		wantBody string
+	}{
+		{name: "alice", header: "Bearer t1", wantCode: http.StatusOK, wantBody: "alice"},
+		{name: "bob", header: "Bearer t2", wantCode: http.StatusOK, wantBody: "bob"},
+		{name: "错误的 token", header: "Bearer t3", wantCode: http.StatusUnauthorized},
+		{name: "token 的前缀", header: "Bearer t", wantCode: http.StatusUnauthorized},
+		{name: "没有 token", wantCode: http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+			req.Header.Set("Authorization", tc.header)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code)
+			if tc.wantBody != "" {
+				assert.Equal(t, tc.wantBody, resp.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Limiter 令牌桶限流，桶的容量为 burst，每秒补充 rate 个令牌
+type Limiter interface {
+	// Take 从 key 的桶里取一个令牌，取不到时返回还要等多久才有令牌
+	Take(ctx context.Context, key string, rate float64, burst int) (ok bool, retryAfter time.Duration, err error)
+}
+
+// LocalLimiter 进程内的令牌桶，多实例部署时每个实例各自限流
+type LocalLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // 不再取令牌时桶满的时间，之后可以删掉
+}
+
+func NewLocalLimiter() *LocalLimiter {
+	return &LocalLimiter{buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+func (l *LocalLimiter) Take(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.full = now.Add(secondsToDuration((float64(burst) - b.tokens) / rate))
+	if allowed {
+		return true, 0, nil
+	}
+	return false, secondsToDuration((1 - b.tokens) / rate), nil
+}
+
+// sweep 每分钟删除一次已经满了的桶，这些桶和新建的没有区别
+func (l *LocalLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
+
+// 桶里存令牌数和上次补充的时间（毫秒），桶满之后自动过期
+var redisTakeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(now - ts, 0) / 1000 * rate)
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((burst - tokens) / rate * 1000) + 1000)
+return {allowed, wait}`)
+
+// RedisLimiter 多个实例共用的令牌桶
+type RedisLimiter struct {
+	client redis.Cmdable
+	now    func() time.Time
+}
+
+func NewRedisLimiter(client redis.Cmdable) *RedisLimiter {
+	return &RedisLimiter{client: client, now: time.Now}
+}
+
+func (l *RedisLimiter) Take(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error) {
+	res, err := redisTakeScript.Run(ctx, l.client, []string{"wepay:ratelimit:" + key},
+		rate, burst, l.now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"wepay/internal/logger"
+	"wepay/internal/web/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitRule 一条限流规则，Key 返回空字符串时这条规则不适用于当前请求
+type RateLimitRule struct {
+	Name  string // 区分不同规则的桶
+	Key   func(ctx *gin.Context) string
+	Rate  float64 // 每秒补充的令牌数
+	Burst int     // 桶的容量，允许的突发请求数
+}
+
+// GlobalRule 路由的所有请求共用一个桶
+func GlobalRule(rate float64, burst int) RateLimitRule {
+	return RateLimitRule{Name: "global", Key: func(ctx *gin.Context) string { return "all" }, Rate: rate, Burst: burst}
+}
+
+// IPRule 每个客户端 IP 一个桶。IP 取自 ctx.ClientIP()，只有请求来自 gin 信任的代理时才读 X-Forwarded-For，
+// 要用 SetTrustedProxies 配置，否则客户端可以伪造 IP 绕过限流
+func IPRule(rate float64, burst int) RateLimitRule {
+	return RateLimitRule{Name: "ip", Key: func(ctx *gin.Context) string { return ctx.ClientIP() }, Rate: rate, Burst: burst}
+}
+
+// OpenidRule 每个 openid 一个桶，请求里没有 openid 时不限制
+func OpenidRule(rate float64, burst int) RateLimitRule {
+	return RateLimitRule{Name: "openid", Key: requestOpenid, Rate: rate, Burst: burst}
+}
+
+// maxPeekBodySize 只解析不超过这个大小的请求体找 openid
+const maxPeekBodySize = 64 << 10
+
+// requestOpenid 从 query、JSON 或者表单请求体里取 openid，读完请求体后放回去，不影响后面校验和绑定参数
+func requestOpenid(ctx *gin.Context) string {
+	if openid := ctx.Query("openid"); openid != "" {
+		return openid
+	}
+	contentType := ctx.ContentType()
+	if ctx.Request.Body == nil || (contentType != gin.MIMEJSON && contentType != gin.MIMEPOSTForm) ||
+		ctx.Request.ContentLength > maxPeekBodySize {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxPeekBodySize))
+	if err != nil {
+		return ""
+	}
+	ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if contentType == gin.MIMEPOSTForm {
+		values, _ := url.ParseQuery(string(body))
+		return values.Get("openid")
+	}
+	var req struct {
+		Openid string `json:"openid"`
+	}
+	_ = json.Unmarshal(body, &req)
+	return req.Openid
+}
+
+// RateLimitConfig 一条限流规则的配置，比如从配置文件或者环境变量读取的
+type RateLimitConfig struct {
+	Rule  string  `json:"rule"` // global、ip 或 openid
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// NewRateLimitRule 按配置生成限流规则
+func NewRateLimitRule(c RateLimitConfig) (RateLimitRule, error) {
+	if c.Rate <= 0 || c.Burst < 1 {
+		return RateLimitRule{}, fmt.Errorf("invalid rate limit rule %s: rate %v, burst %d", c.Rule, c.Rate, c.Burst)
+	}
+	switch c.Rule {
+	case "global":
+		return GlobalRule(c.Rate, c.Burst), nil
+	case "ip":
+		return IPRule(c.Rate, c.Burst), nil
+	case "openid":
+		return OpenidRule(c.Rate, c.Burst), nil
+	default:
+		return RateLimitRule{}, fmt.Errorf("unknown rate limit rule %q", c.Rule)
+	}
+}
+
+// RateLimitBuilder 按路由配置限流规则，没有配置的路由（比如微信的回调）不限流
+type RateLimitBuilder struct {
+	limiter Limiter
+	routes  map[string][]RateLimitRule // 路由（ctx.FullPath()）-> 规则
+}
+
+func NewRateLimitBuilder(limiter Limiter) *RateLimitBuilder {
+	return &RateLimitBuilder{limiter: limiter, routes: make(map[string][]RateLimitRule)}
+}
+
+// Route 为路由 path 增加规则，所有规则都通过才放行
+func (b *RateLimitBuilder) Route(path string, rules ...RateLimitRule) *RateLimitBuilder {
+	b.routes[path] = append(b.routes[path], rules...)
+	return b
+}
+
+func (b *RateLimitBuilder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		path := ctx.FullPath()
+		for _, rule := range b.routes[path] {
+			key := rule.Key(ctx)
+			if key == "" {
+				continue
+			}
+			ok, retryAfter, err := b.limiter.Take(ctx, strings.Join([]string{path, rule.Name, key}, ":"), rule.Rate, rule.Burst)
+			if err != nil {
+				// 限流出错时放行，不能因为 Redis 不可用影响发红包
+				logger.FromContext(ctx).Warn("rate limit failed, let it pass", "route", path, "rule", rule.Name, "error", err)
+				continue
+			}
+			if !ok {
+				ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				response.Abort(ctx, http.StatusTooManyRequests, response.CodeTooManyRequests, "请求太频繁，请稍后重试")
+				return
+			}
+		}
+		ctx.Next()
+	}
+}
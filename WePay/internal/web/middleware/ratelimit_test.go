@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLimiter(t *testing.T, limiter Limiter, advance func(d time.Duration)) {
+	ctx := context.Background()
+	take := func(key string) (bool, time.Duration) {
+		ok, retryAfter, err := limiter.Take(ctx, key, 2, 2)
+		require.NoError(t, err)
+		return ok, retryAfter
+	}
+	for i := 0; i < 2; i++ {
+		ok, _ := take("k1")
+		assert.True(t, ok, "桶满时允许突发")
+	}
+	ok, retryAfter := take("k1")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+	ok, _ = take("k2")
+	assert.True(t, ok, "不同的 key 互不影响")
+
+	advance(250 * time.Millisecond)
+	ok, retryAfter = take("k1")
+	assert.False(t, ok)
+	assert.Equal(t, 250*time.Millisecond, retryAfter)
+	advance(250 * time.Millisecond)
+	ok, _ = take("k1")
+	assert.True(t, ok, "补充了一个令牌")
+
+	advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		ok, _ := take("k1")
+		assert.True(t, ok, "补满之后不超过容量")
+	}
+	ok, _ = take("k1")
+	assert.False(t, ok)
+}
+
+func TestLocalLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewLocalLimiter()
+	limiter.now = func() time.Time { return now }
+	testLimiter(t, limiter, func(d time.Duration) { now = now.Add(d) })
+
+	now = now.Add(time.Hour)
+	_, _, err := limiter.Take(context.Background(), "k3", 1, 1)
+	require.NoError(t, err)
+	assert.Len(t, limiter.buckets, 1, "满了的桶被删除")
+}
+
+func TestRedisLimiter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	now := time.Now()
+	limiter := NewRedisLimiter(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	limiter.now = func() time.Time { return now }
+	testLimiter(t, limiter, func(d time.Duration) {
+		now = now.Add(d)
+		mr.FastForward(d)
+	})
+}
+
+func TestRateLimitBuilder(t *testing.T) {
+	limiter := NewLocalLimiter()
+	server := gin.New()
+	server.Use(NewRateLimitBuilder(limiter).
+		Route("/transfer/to_user", OpenidRule(0.001, 1), IPRule(0.001, 2)).
+		Build())
+	server.POST("/transfer/to_user", func(ctx *gin.Context) {
+		var req struct {
+			Openid string `json:"openid"`
+		}
+		require.NoError(t, ctx.ShouldBindJSON(&req), "限流读过的请求体要放回去")
+		ctx.String(http.StatusOK, req.Openid)
+	})
+	server.POST("/transfer/notify", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	do := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	resp := do("/transfer/to_user", `{"openid": "o1"}`)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "o1", resp.Body.String())
+	resp = do("/transfer/to_user", `{"openid": "o1"}`)
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code, "同一个 openid")
+	assert.Equal(t, "1000", resp.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, do("/transfer/to_user", `{"openid": "o2"}`).Code)
+	assert.Equal(t, http.StatusTooManyRequests, do("/transfer/to_user", `{"openid": "o3"}`).Code, "同一个 IP")
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, http.StatusOK, do("/transfer/notify", `{}`).Code, "没有配置的路由不限流")
+	}
+}
+
+func TestRateLimitBuilder_ClientIP(t *testing.T) {
+	server := gin.New()
+	require.NoError(t, server.SetTrustedProxies([]string{"10.0.0.1"}))
+	server.Use(NewRateLimitBuilder(NewLocalLimiter()).
+		Route("/user/withdraw", IPRule(0.001, 1)).
+		Build())
+	server.POST("/user/withdraw", func(ctx *gin.Context) {
+		ctx.Status(http.StatusOK)
+	})
+
+	do := func(remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodPost, "/user/withdraw", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	assert.Equal(t, http.StatusOK, do("192.168.1.1:1234", "1.1.1.1"))
+	assert.Equal(t, http.StatusTooManyRequests, do("192.168.1.1:1234", "2.2.2.2"), "不是信任的代理时忽略 X-Forwarded-For")
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:1234", "3.3.3.3"))
+	assert.Equal(t, http.StatusTooManyRequests, do("10.0.0.1:1234", "3.3.3.3"), "信任的代理按 X-Forwarded-For 限流")
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:1234", "4.4.4.4"))
+}
+
+func TestRequestOpenid(t *testing.T) {
+	testCases := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		wantOpenid  string
+	}{
+		{name: "query", target: "/?openid=o1", wantOpenid: "o1"},
+		{name: "JSON", contentType: "application/json", body: `{"openid":"o2"}`, wantOpenid: "o2"},
+		{name: "表单", contentType: "application/x-www-form-urlencoded", body: "appid=wx1&openid=o3", wantOpenid: "o3"},
+		{name: "其他格式", contentType: "text/plain", body: "openid=o4"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			target := tc.target
+			if target == "" {
+				target = "/"
+			}
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Request = httptest.NewRequest(http.MethodPost, target, strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				ctx.Request.Header.Set("Content-Type", tc.contentType)
+			}
+			assert.Equal(t, tc.wantOpenid, requestOpenid(ctx))
+			body, err := io.ReadAll(ctx.Request.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tc.body, string(body), "请求体要放回去")
+		})
+	}
+}
+
+func TestNewRateLimitRule(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   RateLimitConfig
+		wantName string
+		wantErr  bool
+	}{
+		{name: "global", config: RateLimitConfig{Rule: "global", Rate: 200, Burst: 400}, wantName: "global"},
+		{name: "ip", config: RateLimitConfig{Rule: "ip", Rate: 5, Burst: 10}, wantName: "ip"},
+		{name: "openid", config: RateLimitConfig{Rule: "openid", Rate: 0.1, Burst: 2}, wantName: "openid"},
+		{name: "未知的规则", config: RateLimitConfig{Rule: "device", Rate: 1, Burst: 1}, wantErr: true},
+		{name: "速率为 0", config: RateLimitConfig{Rule: "ip", Burst: 1}, wantErr: true},
+		{name: "容量为 0", config: RateLimitConfig{Rule: "ip", Rate: 1}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := NewRateLimitRule(tc.config)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantName, rule.Name)
+			assert.Equal(t, tc.config.Rate, rule.Rate)
+			assert.Equal(t, tc.config.Burst, rule.Burst)
+		})
+	}
+}
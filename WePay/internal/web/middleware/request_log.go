@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+	"wepay/internal/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// RequestIDHeader 上游传入时沿用，否则生成一个，并在应答里带回
+	RequestIDHeader = "X-Request-Id"
+	// RequestIDKey 请求 id 存放在 gin.Context 里的 key
+	RequestIDKey = "request_id"
+)
+
+// RequestLogBuilder 为每个请求分配请求 id，把带着请求 id 的 logger 放进请求的 context，
+// 请求结束后记录访问日志。需要打开 gin.Engine.ContextWithFallback，下层通过 *gin.Context 才能取到 logger
+type RequestLogBuilder struct {
+	l *slog.Logger
+}
+
+func NewRequestLogBuilder(l *slog.Logger) *RequestLogBuilder {
+	return &RequestLogBuilder{l: l}
+}
+
+func (b *RequestLogBuilder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestID := ctx.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		ctx.Set(RequestIDKey, requestID)
+		ctx.Header(RequestIDHeader, requestID)
+		l := b.l.With(RequestIDKey, requestID)
+		// 放在 otelgin 之后时带上 trace id，日志和链路可以互相查找
+		if sc := trace.SpanContextFromContext(ctx.Request.Context()); sc.IsValid() {
+			l = l.With("trace_id", sc.TraceID().String())
+		}
+		ctx.Request = ctx.Request.WithContext(logger.WithContext(ctx.Request.Context(), l))
+
+		start := time.Now()
+		ctx.Next()
+
+		level := slog.LevelInfo
+		if ctx.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		args := []any{
+			"method", ctx.Request.Method,
+			"route", ctx.FullPath(),
+			"status", ctx.Writer.Status(),
+			"latency", time.Since(start),
+			"client_ip", ctx.ClientIP(),
+			"errors", ctx.Errors.ByType(gin.ErrorTypePrivate).String(),
+		}
+		// 路径里可能有 openid，匹配到路由时只记路由
+		if ctx.FullPath() == "" {
+			args = append(args, "path", ctx.Request.URL.Path)
+		}
+		l.Log(ctx.Request.Context(), level, "http request", args...)
+	}
+}
+
+// validRequestID 只接受长度合适的字母、数字、'-'、'_'、'.'，避免日志注入
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wepay/internal/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLogBuilder(t *testing.T) {
+	testCases := []struct {
+		name      string
+		requestID string
+		wantID    func(t *testing.T, id string)
+	}{
+		{
+			name:      "沿用上游的请求 id",
+			requestID: "req-123",
+			wantID: func(t *testing.T, id string) {
+				assert.Equal(t, "req-123", id)
+			},
+		},
+		{
+			name: "生成请求 id",
+			wantID: func(t *testing.T, id string) {
+				assert.Len(t, id, 36)
+			},
+		},
+		{
+			name:      "不合法的请求 id",
+			requestID: "a\nb",
+			wantID: func(t *testing.T, id string) {
+				assert.Len(t, id, 36)
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			server := gin.New()
+			server.ContextWithFallback = true
+			server.Use(NewRequestLogBuilder(logger.New(&buf, "info")).Build())
+			server.GET("/bills/:id", func(ctx *gin.Context) {
+				// 下层拿到的是 *gin.Context
+				logger.FromContext(ctx).Info("handling")
+				ctx.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/bills/b1", nil)
+			if tc.requestID != "" {
+				req.Header.Set(RequestIDHeader, tc.requestID)
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			id := resp.Header().Get(RequestIDHeader)
+			tc.wantID(t, id)
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			require.Len(t, lines, 2)
+			var handling, access map[string]any
+			require.NoError(t, json.Unmarshal([]byte(lines[0]), &handling))
+			require.NoError(t, json.Unmarshal([]byte(lines[1]), &access))
+			assert.Equal(t, id, handling[RequestIDKey])
+			assert.Equal(t, id, access[RequestIDKey])
+			assert.Equal(t, "/bills/:id", access["route"])
+			assert.NotContains(t, access, "path", "不记录路径里的参数")
+			assert.Equal(t, float64(http.StatusOK), access["status"])
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestTimeoutBuilder 给请求的 context 加上截止时间，超时后下层的数据库和微信支付调用返回错误。
+// 只取消 context，不替 handler 写应答。需要打开 gin.Engine.ContextWithFallback，下层通过 *gin.Context 才能感知到
+type RequestTimeoutBuilder struct {
+	timeout time.Duration
+}
+
+func NewRequestTimeoutBuilder(timeout time.Duration) *RequestTimeoutBuilder {
+	return &RequestTimeoutBuilder{timeout: timeout}
+}
+
+func (b *RequestTimeoutBuilder) Build() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		c, cancel := context.WithTimeout(ctx.Request.Context(), b.timeout)
+		defer cancel()
+		ctx.Request = ctx.Request.WithContext(c)
+		ctx.Next()
+	}
+}
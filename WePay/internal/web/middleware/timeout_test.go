@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestTimeoutBuilder(t *testing.T) {
+	server := gin.New()
+	server.ContextWithFallback = true
+	server.Use(NewRequestTimeoutBuilder(20 * time.Millisecond).Build())
+	var deadline time.Time
+	var hasDeadline bool
+	var ctxErr error
+	server.GET("/slow", func(ctx *gin.Context) {
+		deadline, hasDeadline = ctx.Deadline()
+		<-ctx.Done()
+		ctxErr = ctx.Err()
+		ctx.Status(http.StatusGatewayTimeout)
+	})
+
+	start := time.Now()
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.True(t, hasDeadline)
+	assert.WithinDuration(t, start.Add(20*time.Millisecond), deadline, 10*time.Millisecond)
+	assert.ErrorIs(t, ctxErr, context.DeadlineExceeded)
+	assert.Equal(t, http.StatusGatewayTimeout, resp.Code)
+}
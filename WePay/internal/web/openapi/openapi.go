@@ -0,0 +1,160 @@
+// Package openapi 小程序和业务后端共用的接口约定 openapi.json，以及按约定校验请求的 middleware。
+// 修改接口时同时修改 openapi.json，web 包的测试会检查两边的路由是否一致
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"wepay/internal/web/response"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.json
+var specJSON []byte
+
+// Spec 解析好的接口约定
+type Spec struct {
+	Doc    *openapi3.T
+	router routers.Router
+}
+
+// Load 解析并检查内嵌的 openapi.json
+func Load() (*Spec, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(specJSON)
+	if err != nil {
+		return nil, fmt.Errorf("load openapi.json: %w", err)
+	}
+	if err := doc.Validate(loader.Context); err != nil {
+		return nil, fmt.Errorf("invalid openapi.json: %w", err)
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Spec{Doc: doc, router: router}, nil
+}
+
+// FindRoute 找到请求对应的接口，约定里没有时返回 routers.ErrPathNotFound 或 routers.ErrMethodNotAllowed
+func (s *Spec) FindRoute(req *http.Request) (*routers.Route, map[string]string, error) {
+	return s.router.FindRoute(req)
+}
+
+// Handler 原样返回 openapi.json，挂在 /openapi.json
+func Handler(ctx *gin.Context) {
+	ctx.Data(http.StatusOK, "application/json; charset=utf-8", specJSON)
+}
+
+// ValidatorBuilder 按接口约定校验请求的参数和请求体，不合法时返回 400，不再执行 handler。
+// 约定里没有的接口（比如管理后台）不校验。挂在路由组的鉴权之后，没有鉴权的请求先返回 401
+type ValidatorBuilder struct {
+	spec   *Spec
+	ignore map[string]bool
+}
+
+func NewValidatorBuilder(spec *Spec) *ValidatorBuilder {
+	return &ValidatorBuilder{spec: spec, ignore: make(map[string]bool)}
+}
+
+// Ignore 不校验这些路由，path 为 gin 的路由，比如应答格式由微信规定的回调
+func (b *ValidatorBuilder) Ignore(paths ...string) *ValidatorBuilder {
+	for _, path := range paths {
+		b.ignore[path] = true
+	}
+	return b
+}
+
+func (b *ValidatorBuilder) Build() gin.HandlerFunc {
+	options := &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc}
+	return func(ctx *gin.Context) {
+		if b.ignore[ctx.FullPath()] {
+			ctx.Next()
+			return
+		}
+		route, pathParams, err := b.spec.FindRoute(ctx.Request)
+		if err != nil {
+			ctx.Next()
+			return
+		}
+		err = openapi3filter.ValidateRequest(ctx.Request.Context(), &openapi3filter.RequestValidationInput{
+			Request:    ctx.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    options,
+		})
+		if err != nil {
+			_ = ctx.Error(err)
+			response.Abort(ctx, http.StatusBadRequest, response.CodeInvalidParam, "参数不合法: "+validationMessage(err))
+			return
+		}
+		ctx.Next()
+	}
+}
+
+const formContentType = "application/x-www-form-urlencoded"
+
+func init() {
+	decode := openapi3filter.RegisteredBodyDecoder(formContentType)
+	openapi3filter.RegisterBodyDecoder(formContentType, formBodyDecoder(decode))
+}
+
+// formBodyDecoder kin-openapi 解析表单时会把没有提交的字段当成 null，
+// 非必填的字段因此校验不过，这里去掉表单里没有的字段
+func formBodyDecoder(decode openapi3filter.BodyDecoder) openapi3filter.BodyDecoder {
+	return func(body io.Reader, header http.Header, schema *openapi3.SchemaRef, encFn openapi3filter.EncodingFn) (any, error) {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			return nil, err
+		}
+		value, err := decode(bytes.NewReader(data), header, schema, encFn)
+		if obj, ok := value.(map[string]any); ok {
+			for name := range obj {
+				if !values.Has(name) {
+					delete(obj, name)
+				}
+			}
+		}
+		return value, err
+	}
+}
+
+// validationMessage 只保留出错的字段和原因，不返回整个 schema
+func validationMessage(err error) string {
+	var reqErr *openapi3filter.RequestError
+	if !errors.As(err, &reqErr) {
+		return err.Error()
+	}
+	field := ""
+	if reqErr.Parameter != nil {
+		field = reqErr.Parameter.Name
+	}
+	reason := reqErr.Reason
+	var schemaErr *openapi3.SchemaError
+	if errors.As(reqErr.Err, &schemaErr) {
+		if pointer := schemaErr.JSONPointer(); len(pointer) > 0 {
+			field = strings.Join(pointer, ".")
+		}
+		reason = schemaErr.Reason
+	} else if reqErr.Err != nil {
+		reason = reqErr.Err.Error()
+	}
+	if field == "" {
+		return reason
+	}
+	return field + ": " + reason
+}
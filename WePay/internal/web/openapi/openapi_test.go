@@ -0,0 +1,160 @@
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wepay/internal/web/response"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	spec, err := Load()
+	require.NoError(t, err)
+	assert.NotNil(t, spec.Doc.Paths.Find("/transfer/to_user"))
+
+	server := gin.New()
+	server.GET("/openapi.json", Handler)
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Header().Get("Content-Type"), "application/json")
+	assert.JSONEq(t, string(specJSON), resp.Body.String())
+}
+
+func TestValidatorBuilder(t *testing.T) {
+	spec, err := Load()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		wantCode    int
+		wantMessage string
+		// handler 收到的请求体，校验之后还能再读
+		wantBody string
+	}{
+		{
+			name:        "合法的 JSON",
+			method:      http.MethodPost,
+			path:        "/transfer/to_user",
+			contentType: "application/json",
+			body:        `{"appid":"wx1","openid":"o1","amount":100,"time":"20250101120000"}`,
+			wantCode:    http.StatusOK,
+			wantBody:    `{"appid":"wx1","openid":"o1","amount":100,"time":"20250101120000"}`,
+		},
+		{
+			name:        "合法的表单",
+			method:      http.MethodPost,
+			path:        "/transfer/to_user",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "appid=wx1&openid=o1&amount=100&time=20250101120000",
+			wantCode:    http.StatusOK,
+			wantBody:    "appid=wx1&openid=o1&amount=100&time=20250101120000",
+		},
+		{
+			name:        "金额为 0",
+			method:      http.MethodPost,
+			path:        "/transfer/to_user",
+			contentType: "application/json",
+			body:        `{"appid":"wx1","openid":"o1","amount":0,"time":"20250101120000"}`,
+			wantCode:    http.StatusBadRequest,
+			wantMessage: "amount",
+		},
+		{
+			name:        "缺少 openid",
+			method:      http.MethodPost,
+			path:        "/transfer/to_user",
+			contentType: "application/json",
+			body:        `{"appid":"wx1","amount":100,"time":"20250101120000"}`,
+			wantCode:    http.StatusBadRequest,
+			wantMessage: "openid",
+		},
+		{
+			name:        "表单缺少 time",
+			method:      http.MethodPost,
+			path:        "/transfer/to_user",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "appid=wx1&openid=o1&amount=100",
+			wantCode:    http.StatusBadRequest,
+			wantMessage: "time",
+		},
+		{
+			name:        "内部服务的前缀",
+			method:      http.MethodPost,
+			path:        "/api/transfer/to_user",
+			contentType: "application/json",
+			body:        `{"appid":"wx1","openid":"o1","amount":0,"time":"20250101120000"}`,
+			wantCode:    http.StatusBadRequest,
+			wantMessage: "amount",
+		},
+		{
+			name:        "缺少查询参数",
+			method:      http.MethodGet,
+			path:        "/transfer/amount",
+			wantCode:    http.StatusBadRequest,
+			wantMessage: "openid",
+		},
+		{
+			name:     "约定里没有的接口",
+			method:   http.MethodPost,
+			path:     "/admin/merchants",
+			body:     `not json`,
+			wantCode: http.StatusOK,
+			wantBody: `not json`,
+		},
+		{
+			name:        "忽略的接口",
+			method:      http.MethodPost,
+			path:        "/transfer/notify",
+			contentType: "application/json",
+			body:        `{}`,
+			wantCode:    http.StatusOK,
+			wantBody:    `{}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := gin.New()
+			server.Use(NewValidatorBuilder(spec).Ignore("/transfer/notify").Build())
+			var gotBody string
+			handler := func(ctx *gin.Context) {
+				body, err := io.ReadAll(ctx.Request.Body)
+				require.NoError(t, err)
+				gotBody = string(body)
+				ctx.Status(http.StatusOK)
+			}
+			server.POST("/transfer/to_user", handler)
+			server.POST("/api/transfer/to_user", handler)
+			server.POST("/transfer/notify", handler)
+			server.GET("/transfer/amount", handler)
+			server.POST("/admin/merchants", handler)
+
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			require.Equal(t, tc.wantCode, resp.Code, resp.Body.String())
+			if tc.wantCode != http.StatusOK {
+				var result response.Result
+				require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+				assert.Equal(t, response.CodeInvalidParam, result.Code)
+				assert.Contains(t, result.Message, tc.wantMessage)
+				assert.Empty(t, gotBody, "不合法的请求不进入 handler")
+				return
+			}
+			assert.Equal(t, tc.wantBody, gotBody)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package web
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"wepay/internal/domain"
+	svcmocks "wepay/internal/service/mocks"
+	"wepay/internal/web/openapi"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+var ginParam = regexp.MustCompile(`[:*](\w+)`)
+
+// TestOpenAPIRoutes 小程序和业务后端调用的接口和 openapi.json 一一对应
+func TestOpenAPIRoutes(t *testing.T) {
+	spec, err := openapi.Load()
+	require.NoError(t, err)
+
+	server := gin.New()
+	transferHandler := NewTransferHandler(nil, nil, nil)
+	transferHandler.RegisterRoutes(server.Group("/transfer"))
+	transferHandler.RegisterNotifyRoutes(server.Group("/transfer"))
+	NewUserHandler(nil, nil, nil).RegisterRoutes(server.Group("/user"))
+	var routes []string
+	for _, route := range server.Routes() {
+		routes = append(routes, route.Method+" "+ginParam.ReplaceAllString(route.Path, "{$1}"))
+	}
+
+	var documented []string
+	for path, item := range spec.Doc.Paths.Map() {
+		for method := range item.Operations() {
+			documented = append(documented, method+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	sort.Strings(documented)
+	assert.Equal(t, routes, documented, "修改接口时同时修改 internal/web/openapi/openapi.json")
+}
+
+// TestOpenAPIResponses handler 返回的应答符合 openapi.json
+func TestOpenAPIResponses(t *testing.T) {
+	spec, err := openapi.Load()
+	require.NoError(t, err)
+	ctime := time.Date(2025, 7, 23, 10, 0, 0, 0, time.Local)
+
+	testCases := []struct {
+		name     string
+		url      string
+		mock     func(ctrl *gomock.Controller) *svcmocks.MockTransferService
+		wantCode int
+	}{
+		{
+			name: "history",
+			url:  "/transfer/history?openid=o1&status=SUCCESS&limit=1",
+			mock: func(ctrl *gomock.Controller) *svcmocks.MockTransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ListTransferHistory(gomock.Any(), gomock.Any()).Return([]domain.TransferRecord{
+					{OutBillNo: "b1", Amount: 100, Remark: "test", Status: domain.TransferStatusSuccess, Ctime: ctime, Utime: ctime},
+				}, int64(99), nil)
+				return transferSvc
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "invalid param",
+			url:  "/transfer/history?openid=o1&start_date=20250701",
+			mock: func(ctrl *gomock.Controller) *svcmocks.MockTransferService {
+				return svcmocks.NewMockTransferService(ctrl)
+			},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			server := gin.New()
+			NewTransferHandler(tc.mock(ctrl), nil, nil).RegisterRoutes(server.Group("/transfer"))
+			req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			require.Equal(t, tc.wantCode, resp.Code, resp.Body.String())
+
+			route, pathParams, err := spec.FindRoute(req)
+			require.NoError(t, err)
+			err = openapi3filter.ValidateResponse(req.Context(), &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: &openapi3filter.RequestValidationInput{
+					Request:    req,
+					PathParams: pathParams,
+					Route:      route,
+					Options:    &openapi3filter.Options{ExcludeRequestBody: true},
+				},
+				Status: resp.Code,
+				Header: resp.Header(),
+				Body:   io.NopCloser(bytes.NewReader(resp.Body.Bytes())),
+				Options: &openapi3filter.Options{
+					IncludeResponseStatus: true,
+				},
+			})
+			assert.NoError(t, err, strings.TrimSpace(resp.Body.String()))
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package response
+
+// Code 业务错误码，0 表示成功。小程序和调用方按 code 判断结果，message 只用于展示。
+// 1xxxx 通用错误，2xxxx 商户，3xxxx 转账单，4xxxx 余额和提现，5xxxx 风控，6xxxx 微信支付
+type Code int
+
+const (
+	CodeOK Code = 0
+
+	CodeInternal        Code = 10000 // 服务内部错误
+	CodeInvalidParam    Code = 10001 // 参数不合法
+	CodeUnauthorized    Code = 10002 // 没有登录管理后台，或者内部服务的 token 不对
+	CodeTooManyRequests Code = 10003 // 触发限流
+	CodeBusy            Code = 10004 // 同一个用户的操作正在处理，等锁超时
+	CodeTimeout         Code = 10005 // 处理超时
+	CodeNotFound        Code = 10006 // 接口不存在
+
+	CodeMerchantNotFound Code = 20001 // appid 没有关联商户或者商户不存在
+
+	CodeBillNotFound         Code = 30001 // 转账单不存在
+	CodeBillNotOwned         Code = 30002 // 转账单不属于这个用户
+	CodeBillAlreadyConfirmed Code = 30003 // 转账单已经确认收款
+	CodeBillNotConfirmable   Code = 30004 // 转账单当前状态不能确认收款，比如微信还没处理完
+	CodeBillNotCancelable    Code = 30005 // 转账单当前状态不可撤销
+	CodeBillNotFinished      Code = 30006 // 转账单还没成功，不能申请电子回单
+	CodeReceiptNotReady      Code = 30007 // 电子回单还在生成中
+	CodeTransferPaused       Code = 30008 // 运营账户余额不足，红包活动已暂停
+	CodeIdempotencyConflict  Code = 30009 // 幂等键已经用于其他用户或金额的转账
+	CodeTransferPending      Code = 30010 // 微信暂时没有应答，转账单已经创建，后台会继续发送
+	CodeBillFinished         Code = 30011 // 转账单已经是终态，不能再修改状态
+	CodeBillExists           Code = 30012 // 转账单号已经存在
+
+	CodeInsufficientBalance Code = 40001 // 余额不足
+	CodeAuditReasonRequired Code = 40002 // 人工调整余额必须填写原因
+	CodeUserNotFound        Code = 40003 // 用户还没有余额记录
+
+	CodeTransferDenied      Code = 50001 // 风控拒绝
+	CodeTransferUnderReview Code = 50002 // 进入人工审核，转账单已经创建
+	CodeBillNotReviewable   Code = 50003 // 转账单不在审核中
+	CodeInvalidRiskRules    Code = 50004 // 风控规则不合法
+
+	CodeTransferRejected Code = 60001 // 微信拒绝了转账请求
+	CodeWxpayError       Code = 60002 // 调用微信支付 API 出错
+)
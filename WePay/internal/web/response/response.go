@@ -0,0 +1,30 @@
+// Package response 所有接口统一的应答格式和业务错误码
+package response
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Result 应答的 JSON 格式，code 为 0 表示成功，data 为接口返回的数据
+type Result struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// OK 成功，HTTP 状态码 200
+func OK(ctx *gin.Context, data any) {
+	ctx.JSON(http.StatusOK, Result{Code: CodeOK, Message: "ok", Data: data})
+}
+
+// Fail 失败或者需要调用方特别处理的结果，data 可以为 nil
+func Fail(ctx *gin.Context, status int, code Code, message string, data any) {
+	ctx.JSON(status, Result{Code: code, Message: message, Data: data})
+}
+
+// Abort 在 middleware 里拒绝请求，不再执行后面的 handler
+func Abort(ctx *gin.Context, status int, code Code, message string) {
+	ctx.AbortWithStatusJSON(status, Result{Code: code, Message: message})
+}
@@ -0,0 +1,123 @@
+package web
+
+import (
+	"errors"
+	"wepay/internal/domain"
+	"wepay/internal/logger"
+	"wepay/internal/service"
+	"wepay/internal/web/middleware"
+	"wepay/internal/web/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RiskHandler 管理后台的风控规则和人工审核队列
+type RiskHandler struct {
+	riskSvc     service.RiskService
+	transferSvc service.TransferService
+}
+
+func NewRiskHandler(riskSvc service.RiskService, transferSvc service.TransferService) *RiskHandler {
+	return &RiskHandler{riskSvc: riskSvc, transferSvc: transferSvc}
+}
+
+// RegisterRoutes ug 需要挂上管理员鉴权的 middleware
+func (h *RiskHandler) RegisterRoutes(ug *gin.RouterGroup) {
+	ug.GET("/rules", h.GetRules)                                // 当前生效的风控规则
+	ug.PUT("/rules", h.UpdateRules)                             // 修改风控规则，不需要重新部署
+	ug.GET("/reviews", h.ListReviews)                           // 待审核的转账单
+	ug.POST("/reviews/:out_bill_no/approve", h.ApproveTransfer) // 审核通过
+	ug.POST("/reviews/:out_bill_no/reject", h.RejectTransfer)   // 审核不通过
+}
+
+func (h *RiskHandler) GetRules(ctx *gin.Context) {
+	rules, err := h.riskSvc.GetRules(ctx)
+	if err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin get risk rules failed", "error", err)
+		return
+	}
+	response.OK(ctx, rules)
+}
+
+func (h *RiskHandler) UpdateRules(ctx *gin.Context) {
+	var rules domain.RiskRules
+	if err := ctx.ShouldBindJSON(&rules); err != nil {
+		writeInvalidParam(ctx, err)
+		return
+	}
+	operator := ctx.GetString(middleware.OperatorKey)
+	err := h.riskSvc.UpdateRules(ctx, operator, rules)
+	if errors.Is(err, service.ErrInvalidRiskRules) {
+		writeError(ctx, err)
+		return
+	}
+	if err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin update risk rules failed", "operator", operator, "error", err)
+		return
+	}
+	logger.FromContext(ctx).Info("admin updated risk rules", "operator", operator)
+	response.OK(ctx, rules)
+}
+
+// ListReviews 按创建时间倒序分页返回审核中的转账单
+func (h *RiskHandler) ListReviews(ctx *gin.Context) {
+	var req struct {
+		Cursor int64 `form:"cursor"`
+		Limit  int   `form:"limit"`
+	}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		writeInvalidParam(ctx, err)
+		return
+	}
+	records, nextCursor, err := h.transferSvc.ListTransferHistory(ctx, domain.TransferHistoryQuery{
+		Status: domain.TransferStatusReviewing,
+		Cursor: req.Cursor,
+		Limit:  req.Limit,
+	})
+	if err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin list risk reviews failed", "error", err)
+		return
+	}
+	resp := AdminSearchBillsResp{
+		Records:    make([]AdminTransferRecordVo, 0, len(records)),
+		NextCursor: nextCursor,
+	}
+	for _, record := range records {
+		resp.Records = append(resp.Records, toAdminTransferRecordVo(record))
+	}
+	response.OK(ctx, resp)
+}
+
+func (h *RiskHandler) ApproveTransfer(ctx *gin.Context) {
+	outbillno := ctx.Param("out_bill_no")
+	operator := ctx.GetString(middleware.OperatorKey)
+	if err := h.transferSvc.ApproveTransfer(ctx, outbillno, operator); err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin approve bill failed", "operator", operator, "out_bill_no", outbillno, "error", err)
+		return
+	}
+	logger.FromContext(ctx).Info("admin approved bill", "operator", operator, "out_bill_no", outbillno)
+	response.OK(ctx, gin.H{"out_bill_no": outbillno, "status": domain.TransferStatusProcessing})
+}
+
+func (h *RiskHandler) RejectTransfer(ctx *gin.Context) {
+	var req struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		writeInvalidParam(ctx, err)
+		return
+	}
+	outbillno := ctx.Param("out_bill_no")
+	operator := ctx.GetString(middleware.OperatorKey)
+	if err := h.transferSvc.RejectTransfer(ctx, outbillno, operator, req.Reason); err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("admin reject bill failed", "operator", operator, "out_bill_no", outbillno, "error", err)
+		return
+	}
+	logger.FromContext(ctx).Info("admin rejected bill", "operator", operator, "out_bill_no", outbillno, "reason", req.Reason)
+	response.OK(ctx, gin.H{"out_bill_no": outbillno, "status": domain.TransferStatusFail})
+}
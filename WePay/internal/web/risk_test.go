@@ -0,0 +1,152 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wepay/internal/domain"
+	"wepay/internal/service"
+	svcmocks "wepay/internal/service/mocks"
+	"wepay/internal/web/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestRiskHandler(t *testing.T) {
+	testCases := []struct {
+		name     string
+		method   string
+		url      string
+		reqBody  string
+		mock     func(ctrl *gomock.Controller) (service.RiskService, service.TransferService)
+		wantCode int
+	}{
+		{
+			name:    "update rules",
+			method:  http.MethodPut,
+			url:     "/admin/risk/rules",
+			reqBody: `{"amount": {"review_above": 100, "deny_above": 1000}}`,
+			mock: func(ctrl *gomock.Controller) (service.RiskService, service.TransferService) {
+				riskSvc := svcmocks.NewMockRiskService(ctrl)
+				riskSvc.EXPECT().UpdateRules(gomock.Any(), "alice", domain.RiskRules{
+					Amount: domain.AmountRule{ReviewAbove: 100, DenyAbove: 1000},
+				}).Return(nil)
+				return riskSvc, nil
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:    "invalid rules",
+			method:  http.MethodPut,
+			url:     "/admin/risk/rules",
+			reqBody: `{"amount": {"deny_above": -1}}`,
+			mock: func(ctrl *gomock.Controller) (service.RiskService, service.TransferService) {
+				riskSvc := svcmocks.NewMockRiskService(ctrl)
+				riskSvc.EXPECT().UpdateRules(gomock.Any(), gomock.Any(), gomock.Any()).Return(service.ErrInvalidRiskRules)
+				return riskSvc, nil
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:   "list reviews",
+			method: http.MethodGet,
+			url:    "/admin/risk/reviews?limit=10",
+			mock: func(ctrl *gomock.Controller) (service.RiskService, service.TransferService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ListTransferHistory(gomock.Any(), domain.TransferHistoryQuery{
+					Status: domain.TransferStatusReviewing,
+					Limit:  10,
+				}).Return([]domain.TransferRecord{{OutBillNo: "plfk2020042013"}}, int64(0), nil)
+				return nil, transferSvc
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:   "approve",
+			method: http.MethodPost,
+			url:    "/admin/risk/reviews/plfk2020042013/approve",
+			mock: func(ctrl *gomock.Controller) (service.RiskService, service.TransferService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ApproveTransfer(gomock.Any(), "plfk2020042013", "alice").Return(nil)
+				return nil, transferSvc
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:   "approve not reviewable",
+			method: http.MethodPost,
+			url:    "/admin/risk/reviews/plfk2020042013/approve",
+			mock: func(ctrl *gomock.Controller) (service.RiskService, service.TransferService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ApproveTransfer(gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(service.ErrTransferNotReviewable)
+				return nil, transferSvc
+			},
+			wantCode: http.StatusConflict,
+		},
+		{
+			name:    "reject",
+			method:  http.MethodPost,
+			url:     "/admin/risk/reviews/plfk2020042013/reject",
+			reqBody: `{"reason": "fraud"}`,
+			mock: func(ctrl *gomock.Controller) (service.RiskService, service.TransferService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().RejectTransfer(gomock.Any(), "plfk2020042013", "alice", "fraud").Return(nil)
+				return nil, transferSvc
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "reject without reason",
+			method:   http.MethodPost,
+			url:      "/admin/risk/reviews/plfk2020042013/reject",
+			reqBody:  `{}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:    "reject not found",
+			method:  http.MethodPost,
+			url:     "/admin/risk/reviews/plfk2020042013/reject",
+			reqBody: `{"reason": "fraud"}`,
+			mock: func(ctrl *gomock.Controller) (service.RiskService, service.TransferService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().RejectTransfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(service.ErrTransferNotFound)
+				return nil, transferSvc
+			},
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			var (
+				riskSvc     service.RiskService
+				transferSvc service.TransferService
+			)
+			if tc.mock != nil {
+				riskSvc, transferSvc = tc.mock(ctrl)
+			}
+			server := gin.Default()
+			riskHandler := NewRiskHandler(riskSvc, transferSvc)
+			auth := middleware.NewAdminAuthBuilder(map[string]string{"secret": "alice"}).Build()
+			riskHandler.RegisterRoutes(server.Group("/admin/risk", auth))
+
+			req, err := http.NewRequest(tc.method, tc.url, bytes.NewBuffer([]byte(tc.reqBody)))
+			assert.Nil(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer secret")
+
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.wantCode, resp.Code)
+		})
+	}
+}
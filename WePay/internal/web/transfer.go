@@ -1,120 +1,189 @@
 package web
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"time"
 	"wepay/internal/domain"
+	"wepay/internal/logger"
 	"wepay/internal/service"
 	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/web/response"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wechatpay-apiv3/wechatpay-go/core"
 )
 
 type TransferHandler struct {
-	svc     service.TransferService
-	userSvc service.UserService
-	client  Client
+	svc         service.TransferService
+	userSvc     service.UserService
+	merchantSvc service.MerchantService
 }
 
-func NewTransferHandler(svc service.TransferService, userSvc service.UserService, client Client) *TransferHandler {
+func NewTransferHandler(svc service.TransferService, userSvc service.UserService, merchantSvc service.MerchantService) *TransferHandler {
 	return &TransferHandler{
-		svc:     svc,
-		userSvc: userSvc,
-		client:  client,
+		svc:         svc,
+		userSvc:     userSvc,
+		merchantSvc: merchantSvc,
 	}
 }
 
+// RegisterRoutes 小程序和业务后端调用的接口
 func (t *TransferHandler) RegisterRoutes(ug *gin.RouterGroup) {
 	ug.POST("/to_user", t.InitiateTransfer)
-	ug.POST("/notify", t.TransferNotify)   // 微信支付的回调（手动模拟实现）
 	ug.POST("/confirm", t.ConfirmTransfer) // 确认转账
 	ug.GET("/amount", t.FetchAmount)       // 查询余额
+	ug.GET("/history", t.TransferHistory)  // 转账历史
+	ug.GET("/status", t.TransferStatus)    // 查询转账单
 }
 
-func generatePackageInfo(openid string, timeStr string) string {
-	return fmt.Sprintf("PK%s-%s", openid, timeStr)
+// RegisterNotifyRoutes 微信支付的回调，只能挂在 notify_url 对应的路由上，不需要登录
+func (t *TransferHandler) RegisterNotifyRoutes(ug *gin.RouterGroup) {
+	ug.POST("/notify", t.TransferNotify)
+}
+
+// IdempotencyKeyHeader 发起转账时带上幂等键，重试同一个请求不会重复转账
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotentOutBillNo 按 appid 和幂等键生成固定的转账单号，同一个幂等键总是对应同一个转账单
+func idempotentOutBillNo(appid, key string) string {
+	sum := sha256.Sum256([]byte(appid + "\n" + key))
+	return "IK" + hex.EncodeToString(sum[:])[:30]
+}
+
+// generatePackageInfo 微信返回 package_info 之前的占位值。package_info 有唯一索引，
+// 按转账单号生成，同一秒发起的多笔转账也不会冲突
+func generatePackageInfo(outbillno string) string {
+	return "PK" + outbillno
 }
 
 // 发起转账
 func (t *TransferHandler) InitiateTransfer(ctx *gin.Context) {
 	// 用户传来的参数
 	var req struct {
+		Appid  string `form:"appid" json:"appid" binding:"required"`
 		Openid string `form:"openid" json:"openid" binding:"required"`
 		Amount int64  `form:"amount" json:"amount" binding:"required"`
 		Remark string `json:"remark"`
-		Time   string `json:"time" binding:"required"`
+		// 小程序发起转账的时间，精确到秒。已经不再使用，保留是为了兼容已经发布的小程序
+		Time string `json:"time" binding:"required"`
+		// 小程序上报的设备标识，用于风控
+		DeviceId string `json:"device_id"`
 	}
 	if err := ctx.ShouldBind(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "参数不合法: " + err.Error()})
+		writeInvalidParam(ctx, err)
+		return
+	}
+	// 按 appid 找到出款的商户
+	client, err := clientByAppid(ctx, t.merchantSvc, req.Appid)
+	if err != nil {
+		writeError(ctx, err)
+		if !errors.Is(err, errAppidNotBound) {
+			logger.FromContext(ctx).Error("load merchant failed", "appid", req.Appid, "error", err)
+		}
 		return
 	}
-
-	// 商户的配置 transfer_scene_id（转账场景）,  user_recv_perception（用户收款码）
-	transfer_scene_id := "1000"    // 转账场景：现金营销
-	user_recv_perception := "现金红包" // 用户收款时感知到的收款原因将根据转账场景自动展示默认内容。
 
 	// 生成唯一outbillno, packageInfo并保存转账请求
-	outbillno := t.svc.GenerateOutBillNo(req.Openid, req.Amount)
-	packageInfo := generatePackageInfo(req.Openid, req.Time)
+	var outbillno string
+	key := ctx.GetHeader(IdempotencyKeyHeader)
+	if key != "" {
+		outbillno = idempotentOutBillNo(req.Appid, key)
+		if t.replayTransfer(ctx, outbillno, req.Openid, req.Amount) {
+			return
+		}
+	} else {
+		outbillno = t.svc.GenerateOutBillNo()
+	}
+	packageInfo := generatePackageInfo(outbillno)
 	requestRecord := &domain.TransferRecord{
 		OutBillNo:   outbillno,
 		Openid:      req.Openid,
-		MchId:       t.client.MchConfig.MchId(),
+		MchId:       client.MchConfig.MchId(),
 		PackageInfo: packageInfo,
 		Amount:      req.Amount,
 		Remark:      req.Remark,
+		Type:        domain.TransferTypeReward,
 		Status:      domain.TransferStatusProcessing,
+		DeviceId:    req.DeviceId,
+		ClientIp:    ctx.ClientIP(),
 	}
-	err := t.svc.AddTransferRequest(ctx, requestRecord)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		log.Println("add transfer request error:", err)
+	// 构造 TransferToUserRequest
+	request := client.NewTransferToUserRequest(outbillno, req.Openid, req.Amount, req.Remark)
+
+	// 保存转账请求并发起转账，微信没有应答时由后台重试
+	resp, err := t.svc.InitiateTransfer(ctx, client.MchConfig, requestRecord, request)
+	switch {
+	case errors.Is(err, service.ErrTransferExists) && key != "":
+		// 同一个幂等键的请求并发时都查不到转账单，写入失败的按重复的请求处理
+		if !t.replayTransfer(ctx, outbillno, req.Openid, req.Amount) {
+			writeError(ctx, err)
+		}
+		return
+	case errors.Is(err, service.ErrTransferUnderReview), errors.Is(err, service.ErrTransferPending):
+		writeErrorData(ctx, err, gin.H{"out_bill_no": outbillno})
+		return
+	case errors.Is(err, service.ErrTransferRejected):
+		writeError(ctx, err)
+		logger.FromContext(ctx).Warn("transfer rejected by wx", "out_bill_no", outbillno, "error", err)
+		return
+	case errors.Is(err, service.ErrTransferPaused), errors.Is(err, service.ErrLockTimeout),
+		errors.Is(err, service.ErrTransferDenied):
+		writeError(ctx, err)
+		return
+	case err != nil:
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("initiate transfer failed", "out_bill_no", outbillno, "error", err)
 		return
 	}
 
-	// 构造 TransferToUserRequest
-	request := &service.TransferToUserRequest{
-		// 商家
-		Appid:              core.String(t.client.Appid), // 小程序与商户关联的appid
-		OutBillNo:          core.String(outbillno),
-		TransferSceneId:    core.String(transfer_scene_id),
-		Openid:             core.String(req.Openid),
-		MchId:              core.String(t.client.MchConfig.MchId()),
-		UserName:           core.String(req.Openid),
-		TransferAmount:     core.Int64(req.Amount),
-		TransferRemark:     core.String(req.Remark),
-		NotifyUrl:          core.String(t.client.NotifyUrl),
-		UserRecvPerception: core.String(user_recv_perception),
-	}
-
-	// 发起转账
-	_, err = t.svc.TransferToUser(t.client.MchConfig, request)
-	if err != nil {
-		log.Println("post to wx error:", err)
+	response.OK(ctx, resp)
+}
+
+// replayTransfer 幂等键对应的转账单已经存在时不再发起转账，按转账单当前的状态返回和第一次请求一样的应答，
+// 返回 false 表示转账单不存在，需要发起转账
+func (t *TransferHandler) replayTransfer(ctx *gin.Context, outbillno, openid string, amount int64) bool {
+	record, err := t.svc.GetTransferRecordByOutBillNo(ctx, outbillno)
+	switch {
+	case errors.Is(err, service.ErrTransferNotFound):
+		return false
+	case err != nil:
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("load transfer record failed", "out_bill_no", outbillno, "error", err)
+		return true
+	case record.Openid != openid || record.Amount != amount:
+		writeError(ctx, errIdempotencyConflict)
+		return true
 	}
-	response := &service.TransferToUserResponse{
-		OutBillNo:      core.String(outbillno),
-		TransferBillNo: core.String("1330000071100999991182020050700019480001"),
-		CreateTime:     core.String("2015-05-20T13:29:35.120+08:00"),
-		State:          service.TRANSFERBILLSTATUS_PROCESSING.Ptr(),
-		PackageInfo:    core.String(packageInfo),
+	switch record.Status {
+	case domain.TransferStatusReviewing:
+		writeErrorData(ctx, service.ErrTransferUnderReview, gin.H{"out_bill_no": outbillno})
+	case domain.TransferStatusProcessing:
+		// 还没有收到微信的应答，后台在继续发送
+		writeErrorData(ctx, service.ErrTransferPending, gin.H{"out_bill_no": outbillno})
+	case domain.TransferStatusFail:
+		if record.FailReason == domain.TransferFailReasonRiskDenied {
+			writeError(ctx, service.ErrTransferDenied)
+		} else {
+			writeError(ctx, service.ErrTransferRejected)
+		}
+	default:
+		response.OK(ctx, &service.TransferToUserResponse{
+			OutBillNo:   &record.OutBillNo,
+			CreateTime:  core.String(record.Ctime.Format(time.RFC3339)),
+			State:       service.TransferBillStatus(record.Status).Ptr(),
+			PackageInfo: &record.PackageInfo,
+		})
 	}
-
-	ctx.JSON(http.StatusOK, response)
-
-	go func() {
-		time.Sleep(10 * time.Second)
-		t.svc.UpdateTransferStatus(ctx, outbillno, domain.TransferStatusTransfering)
-	}()
-
+	return true
 }
 
 type NotifyResp struct {
@@ -146,54 +215,110 @@ type DecryptResult struct {
 	UpdateTime     string `json:"update_time"`
 }
 
+// TransferNotify 微信支付的回调，应答格式由微信规定，不使用统一的应答格式。
+// 用 Wechatpay-Serial 对应商户的微信支付公钥验签，再用商户的 APIv3 密钥解密 resource，
+// 只信任解密出来的转账单号、状态和商户号
 func (t *TransferHandler) TransferNotify(ctx *gin.Context) {
-	// 1. 请求体
-	var req struct {
-		OutBillNo string `json:"out_bill_no"  binding:"required"`
+	// 1. 验签，验签用的是原始的请求体，只能读一次
+	headers := ctx.Request.Header
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		notifyFail(ctx, http.StatusBadRequest, "invalid body")
+		return
+	}
+	wxRequestID := headers.Get(wxpay_utility.RequestID)
+	config, err := t.notifyMchConfig(ctx, headers.Get(wxpay_utility.WechatPaySerial))
+	if errors.Is(err, service.ErrMerchantNotFound) {
+		notifyFail(ctx, http.StatusUnauthorized, "unknown wechatpay serial")
+		return
 	}
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(400, gin.H{"code": "FAIL", "message": "invalid body"})
+	if err != nil {
+		notifyFail(ctx, http.StatusInternalServerError, err.Error())
+		return
+	}
+	err = wxpay_utility.ValidateResponse(config.WechatPayPublicKeyId(), config.WechatPayPublicKey(), &headers, body)
+	if err != nil {
+		notifyVerifyFailures.WithLabelValues(config.MchId()).Inc()
+		logger.FromContext(ctx).Warn("validate notify signature failed", "mch_id", config.MchId(),
+			"wx_request_id", wxRequestID, "error", err)
+		notifyFail(ctx, http.StatusUnauthorized, "invalid signature")
 		return
 	}
 
-	// 2. 校验回调请求
-	headers := ctx.Request.Header
-	body, err := io.ReadAll(ctx.Request.Body)
+	// 2. 解密
+	var notify NotifyResp
+	if err := json.Unmarshal(body, &notify); err != nil || notify.Resource.Ciphertext == "" {
+		notifyFail(ctx, http.StatusBadRequest, "invalid body")
+		return
+	}
+	plain, err := DecryptNotifyResource(config.ApiV3Key(), notify.Resource.AssociatedData,
+		notify.Resource.Nonce, notify.Resource.Ciphertext)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, err.Error())
+		logger.FromContext(ctx).Error("decrypt notify resource failed", "mch_id", config.MchId(),
+			"wx_request_id", wxRequestID, "error", err)
+		notifyFail(ctx, http.StatusInternalServerError, "decrypt resource failed")
+		return
+	}
+	var result DecryptResult
+	if err := json.Unmarshal([]byte(plain), &result); err != nil || result.OutBillNo == "" || result.State == "" {
+		notifyFail(ctx, http.StatusBadRequest, "invalid resource")
+		return
+	}
+	if result.MchId != config.MchId() {
+		logger.FromContext(ctx).Warn("notify mch_id mismatch", "mch_id", config.MchId(),
+			"resource_mch_id", result.MchId, "out_bill_no", result.OutBillNo, "wx_request_id", wxRequestID)
+		notifyFail(ctx, http.StatusBadRequest, "mch_id mismatch")
+		return
+	}
+
+	// 3. 转账单必须是这个商户发起的
+	record, err := t.svc.GetTransferRecordByOutBillNo(ctx, result.OutBillNo)
+	if errors.Is(err, service.ErrTransferNotFound) || (err == nil && record.MchId != result.MchId) {
+		notifyFail(ctx, http.StatusNotFound, "bill not found")
 		return
 	}
-	err = wxpay_utility.ValidateResponse(t.client.MchConfig.WechatPayPublicKeyId(), t.client.MchConfig.WechatPayPublicKey(), &headers, body)
 	if err != nil {
-		// ctx.JSON(http.StatusInternalServerError, err.Error())
-		log.Println("validate response error:", err)
+		notifyFail(ctx, http.StatusInternalServerError, err.Error())
+		return
 	}
 
-	// 更新	 requestRecord 状态
-	err = t.svc.UpdateTransferStatus(ctx, req.OutBillNo, domain.TransferStatusWaitUserConfirm)
+	// 4. 更新转账单状态。本地已经是终态时应答成功，不让微信重复推送，和微信不一致的留给对账发现
+	err = t.svc.UpdateTransferStatus(ctx, result.OutBillNo, result.State, domain.TransferEventSourceNotify, wxRequestID)
+	if errors.Is(err, service.ErrTransferFinished) {
+		logger.FromContext(ctx).Warn("notify for finished bill", "out_bill_no", result.OutBillNo,
+			"local_status", record.Status, "status", result.State, "wx_request_id", wxRequestID)
+		err = nil
+	}
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, err.Error())
+		notifyFail(ctx, http.StatusInternalServerError, err.Error())
 		return
 	}
 
 	ctx.String(http.StatusOK, "")
 }
 
-// 解密 AES-256-GCM 回调
-// apiV3Key 必须是 32 字节字符串
+// notifyMchConfig 找到微信支付公钥 ID 为 serial 的商户，没有时返回 ErrMerchantNotFound
+func (t *TransferHandler) notifyMchConfig(ctx context.Context, serial string) (*wxpay_utility.MchConfig, error) {
+	merchants, err := t.merchantSvc.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range merchants {
+		if serial != "" && m.WechatPayPublicKeyId == serial {
+			return t.merchantSvc.MchConfig(ctx, m.MchId)
+		}
+	}
+	return nil, service.ErrMerchantNotFound
+}
+
+// notifyFail 回调处理失败，按微信支付的要求返回 {"code": "FAIL", "message": ...}，微信会稍后重试
+func notifyFail(ctx *gin.Context, status int, message string) {
+	_ = ctx.Error(errors.New(message))
+	ctx.JSON(status, gin.H{"code": "FAIL", "message": message})
+}
+
+// DecryptNotifyResource 解密 AES-256-GCM 加密的回调内容，apiV3Key 必须是 32 字节字符串
 func DecryptNotifyResource(apiV3Key, associatedData, nonce, ciphertext string) (string, error) {
-	jsonStr := `{
-		"out_bill_no": "plfk2020042013",
-		"transfer_bill_no":"1330000071100999991182020050700019480001",
-		"state": "SUCCESS",
-		"mch_id": "1900001109",
-		"transfer_amount": 2000,
-		"openid": "o-MYE421800elYMDE34nYD456Xoy",
-		"fail_reason": "PAYEE_ACCOUNT_ABNORMAL",
-		"create_time": "2015-05-20T13:29:35+08:00",
-		"update_time": "2023-08-15T20:33:22+08:00"
-	}`
-	return jsonStr, nil
 	key := []byte(apiV3Key)
 	if len(key) != 32 {
 		return "", errors.New("无效的ApiV3Key，长度必须为32个字节")
@@ -220,54 +345,181 @@ func DecryptNotifyResource(apiV3Key, associatedData, nonce, ciphertext string) (
 		return "", err
 	}
 	return string(plain), nil
-
 }
 
 // 判断 notify 是不是来了
 
 func (t *TransferHandler) ConfirmTransfer(ctx *gin.Context) {
 	var req struct {
-		MchId       string `json:"mch_id" binding:"required"`
-		Appid       string `json:"appid" binding:"required"`
-		PackageInfo string `json:"package_info" binding:"required"`
+		Openid      string `form:"openid" json:"openid" binding:"required"`
+		Appid       string `form:"appid" json:"appid" binding:"required"`
+		PackageInfo string `form:"package_info" json:"package_info" binding:"required"`
 	}
 	if err := ctx.ShouldBind(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "参数不合法: " + err.Error()})
+		writeInvalidParam(ctx, err)
 		return
 	}
 
-	record, err := t.svc.GetTransferRecordByPackageInfo(ctx, req.PackageInfo)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, err.Error())
-	}
-	if record.Status == domain.TransferStatusWaitUserConfirm {
-		// 如果状态为 TransferStatusWaitUserConfirm，则更新用户余额
-		err := t.userSvc.UpdateBalance(ctx, record.Openid, record.Amount)
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, "")
-			log.Printf("更新用户余额失败: %v", err)
-			return
-		}
-		err = t.svc.UpdateTransferStatus(ctx, record.OutBillNo, domain.TransferStatusSuccess)
-		if err != nil {
-			ctx.JSON(http.StatusInternalServerError, "")
-			log.Printf("更新转账状态失败: %v", err)
-			return
-		}
-		ctx.JSON(http.StatusOK, gin.H{"message": "转账确认成功"})
-	} else {
-		ctx.JSON(http.StatusInternalServerError, "")
+	// 只能确认发给自己的转账单
+	record, err := t.svc.ConfirmTransfer(ctx, req.Openid, req.PackageInfo)
+	switch {
+	case errors.Is(err, service.ErrTransferConfirmed):
+		confirmOutcomes.WithLabelValues(confirmResultAlreadyConfirmed).Inc()
+		writeErrorData(ctx, err, toConfirmVo(record))
+	case errors.Is(err, service.ErrTransferNotConfirmable):
+		// 带上当前状态，小程序可以提示微信还在处理中还是已经失败
+		confirmOutcomes.WithLabelValues(confirmResultNotConfirmable).Inc()
+		writeErrorData(ctx, err, toConfirmVo(record))
+	case errors.Is(err, service.ErrTransferNotFound):
+		confirmOutcomes.WithLabelValues(confirmResultNotFound).Inc()
+		writeError(ctx, err)
+	case errors.Is(err, service.ErrTransferNotOwned):
+		confirmOutcomes.WithLabelValues(confirmResultNotOwned).Inc()
+		writeError(ctx, err)
+	case err != nil:
+		confirmOutcomes.WithLabelValues(confirmResultError).Inc()
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("confirm transfer failed", "package_info", req.PackageInfo, "error", err)
+	default:
+		confirmOutcomes.WithLabelValues(confirmResultSuccess).Inc()
+		response.OK(ctx, toConfirmVo(record))
 	}
+}
+
+// ConfirmVo 确认收款的结果，确认失败时也返回转账单当前的状态
+type ConfirmVo struct {
+	OutBillNo string `json:"out_bill_no"`
+	Status    string `json:"status"`
+}
 
+func toConfirmVo(record domain.TransferRecord) ConfirmVo {
+	return ConfirmVo{OutBillNo: record.OutBillNo, Status: record.Status}
 }
 
 func (t *TransferHandler) FetchAmount(ctx *gin.Context) {
 	openid := ctx.Query("openid")
-	log.Println("openid", openid)
 	amount, err := t.userSvc.GetAmount(ctx, openid)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, 0)
+		writeError(ctx, err)
+		if !errors.Is(err, service.ErrUserNotFound) {
+			logger.FromContext(ctx).Error("fetch amount failed", "openid", openid, "error", err)
+		}
+		return
+	}
+	response.OK(ctx, amount)
+}
+
+type TransferRecordVo struct {
+	OutBillNo  string `json:"out_bill_no"`
+	Amount     int64  `json:"amount"`
+	Remark     string `json:"remark"`
+	Status     string `json:"status"`
+	FailReason string `json:"fail_reason,omitempty"`
+	Ctime      string `json:"ctime"`
+	Utime      string `json:"utime"`
+}
+
+type TransferHistoryResp struct {
+	Records    []TransferRecordVo `json:"records"`
+	NextCursor int64              `json:"next_cursor"` // 0 表示没有下一页
+}
+
+// TransferHistory 分页查询转账历史
+// start_date / end_date 格式为 2006-01-02，end_date 当天也包含在内
+func (t *TransferHandler) TransferHistory(ctx *gin.Context) {
+	var req struct {
+		Openid    string `form:"openid" binding:"required"`
+		Status    string `form:"status"`
+		StartDate string `form:"start_date"`
+		EndDate   string `form:"end_date"`
+		Cursor    int64  `form:"cursor"`
+		Limit     int    `form:"limit"`
+	}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		writeInvalidParam(ctx, err)
+		return
+	}
+
+	query := domain.TransferHistoryQuery{
+		Openid: req.Openid,
+		Status: req.Status,
+		Cursor: req.Cursor,
+		Limit:  req.Limit,
+	}
+	var err error
+	query.StartTime, query.EndTime, err = parseDateRange(req.StartDate, req.EndDate)
+	if err != nil {
+		writeInvalidParam(ctx, err)
+		return
+	}
+
+	records, nextCursor, err := t.svc.ListTransferHistory(ctx, query)
+	if err != nil {
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("list transfer history failed", "error", err)
+		return
+	}
+
+	resp := TransferHistoryResp{
+		Records:    make([]TransferRecordVo, 0, len(records)),
+		NextCursor: nextCursor,
+	}
+	for _, record := range records {
+		resp.Records = append(resp.Records, toTransferRecordVo(record))
+	}
+	response.OK(ctx, resp)
+}
+
+// TransferStatus 查询用户的一笔转账单，转账单不属于这个用户时和不存在一样返回 404
+func (t *TransferHandler) TransferStatus(ctx *gin.Context) {
+	var req struct {
+		Openid    string `form:"openid" binding:"required"`
+		OutBillNo string `form:"out_bill_no" binding:"required"`
+	}
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		writeInvalidParam(ctx, err)
+		return
+	}
+	record, err := t.svc.GetTransferRecordByOutBillNo(ctx, req.OutBillNo)
+	if err == nil && record.Openid != req.Openid {
+		err = service.ErrTransferNotFound
+	}
+	if err != nil {
+		writeError(ctx, err)
+		if !errors.Is(err, service.ErrTransferNotFound) {
+			logger.FromContext(ctx).Error("load transfer record failed", "out_bill_no", req.OutBillNo, "error", err)
+		}
 		return
 	}
-	ctx.JSON(http.StatusOK, amount)
+	response.OK(ctx, toTransferRecordVo(record))
+}
+
+func toTransferRecordVo(record domain.TransferRecord) TransferRecordVo {
+	return TransferRecordVo{
+		OutBillNo:  record.OutBillNo,
+		Amount:     record.Amount,
+		Remark:     record.Remark,
+		Status:     record.Status,
+		FailReason: record.FailReason,
+		Ctime:      record.Ctime.Format(time.RFC3339),
+		Utime:      record.Utime.Format(time.RFC3339),
+	}
+}
+
+// parseDateRange 解析 2006-01-02 格式的起止日期，end 当天也包含在内，返回 [start, end)
+func parseDateRange(startDate, endDate string) (start, end time.Time, err error) {
+	if startDate != "" {
+		start, err = time.ParseInLocation(time.DateOnly, startDate, time.Local)
+		if err != nil {
+			return start, end, errors.New("start_date")
+		}
+	}
+	if endDate != "" {
+		end, err = time.ParseInLocation(time.DateOnly, endDate, time.Local)
+		if err != nil {
+			return start, end, errors.New("end_date")
+		}
+		end = end.AddDate(0, 0, 1)
+	}
+	return start, end, nil
 }
@@ -2,31 +2,52 @@ package web
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+	"wepay/internal/domain"
 	"wepay/internal/service"
 	svcmocks "wepay/internal/service/mocks"
 	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/web/response"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/wechatpay-apiv3/wechatpay-go/core"
 	"go.uber.org/mock/gomock"
 )
 
 func TestInitiateTransfer(t *testing.T) {
+	ctime := time.Date(2025, 7, 23, 10, 0, 0, 0, time.Local)
+	idempotentNo := idempotentOutBillNo("wxb9f4f763e5d4a6de", "k1")
 	testCases := []struct {
-		name     string
-		reqBody  string
-		mock     func(ctrl *gomock.Controller) service.TransferService
-		wantCode int
-		wantResp service.TransferToUserResponse
+		name           string
+		reqBody        string
+		idempotencyKey string
+		mock           func(ctrl *gomock.Controller) service.TransferService
+		wantCode       int
+		wantBiz        response.Code
+		wantResp       service.TransferToUserResponse
 	}{
 		{
 			name: "success",
 			reqBody: `{
+				"appid": "wxb9f4f763e5d4a6de",
 				"openid": "o1234567890",
 				"amount": 100,
 				"remark": "test",
@@ -34,10 +55,8 @@ func TestInitiateTransfer(t *testing.T) {
 			}`,
 			mock: func(ctrl *gomock.Controller) service.TransferService {
 				transferSvc := svcmocks.NewMockTransferService(ctrl)
-				transferSvc.EXPECT().GenerateOutBillNo(gomock.Any(), gomock.Any()).Return("plfk2020042013")
-				transferSvc.EXPECT().AddTransferRequest(gomock.Any(), gomock.Any()).Return(nil)
-
-				transferSvc.EXPECT().TransferToUser(gomock.Any(), gomock.Any()).Return(&service.TransferToUserResponse{
+				transferSvc.EXPECT().GenerateOutBillNo().Return("plfk2020042013")
+				transferSvc.EXPECT().InitiateTransfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(&service.TransferToUserResponse{
 					OutBillNo:      core.String("plfk2020042013"),
 					TransferBillNo: core.String("1330000071100999991182020050700019480001"),
 					CreateTime:     core.String("2015-05-20T13:29:35.120+08:00"),
@@ -56,6 +75,259 @@ func TestInitiateTransfer(t *testing.T) {
 				PackageInfo:    core.String("PKo1234567890-20200420130000"),
 			},
 		},
+		{
+			name: "campaign paused",
+			reqBody: `{
+				"appid": "wxb9f4f763e5d4a6de",
+				"openid": "o1234567890",
+				"amount": 100,
+				"remark": "test",
+				"time": "20200420130000"
+			}`,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GenerateOutBillNo().Return("plfk2020042013")
+				transferSvc.EXPECT().InitiateTransfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, service.ErrTransferPaused)
+				return transferSvc
+			},
+			wantCode: http.StatusServiceUnavailable,
+			wantBiz:  response.CodeTransferPaused,
+		},
+		{
+			name: "wx not responding",
+			reqBody: `{
+				"appid": "wxb9f4f763e5d4a6de",
+				"openid": "o1234567890",
+				"amount": 100,
+				"time": "20200420130000"
+			}`,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GenerateOutBillNo().Return("plfk2020042013")
+				transferSvc.EXPECT().InitiateTransfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(nil, fmt.Errorf("%w: %w", service.ErrTransferPending, context.DeadlineExceeded))
+				return transferSvc
+			},
+			// 不返回模拟的微信应答
+			wantCode: http.StatusAccepted,
+			wantBiz:  response.CodeTransferPending,
+			wantResp: service.TransferToUserResponse{OutBillNo: core.String("plfk2020042013")},
+		},
+		{
+			name: "idempotency key first use",
+			reqBody: `{
+				"appid": "wxb9f4f763e5d4a6de",
+				"openid": "o1234567890",
+				"amount": 100,
+				"time": "20200420130000"
+			}`,
+			idempotencyKey: "k1",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), idempotentNo).Return(domain.TransferRecord{}, service.ErrTransferNotFound)
+				transferSvc.EXPECT().InitiateTransfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ context.Context, _ *wxpay_utility.MchConfig, bill *domain.TransferRecord, request *service.TransferToUserRequest) (*service.TransferToUserResponse, error) {
+						assert.Equal(t, idempotentNo, bill.OutBillNo)
+						assert.Equal(t, idempotentNo, *request.OutBillNo)
+						return &service.TransferToUserResponse{OutBillNo: core.String(idempotentNo)}, nil
+					})
+				return transferSvc
+			},
+			wantCode: http.StatusOK,
+			wantResp: service.TransferToUserResponse{OutBillNo: core.String(idempotentNo)},
+		},
+		{
+			name: "idempotent replay",
+			reqBody: `{
+				"appid": "wxb9f4f763e5d4a6de",
+				"openid": "o1234567890",
+				"amount": 100,
+				"time": "20200420130001"
+			}`,
+			idempotencyKey: "k1",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				// 不再发起转账
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), idempotentNo).Return(domain.TransferRecord{
+					OutBillNo:   idempotentNo,
+					Openid:      "o1234567890",
+					Amount:      100,
+					Status:      domain.TransferStatusWaitUserConfirm,
+					PackageInfo: "PKo1234567890-20200420130000",
+					Ctime:       ctime,
+				}, nil)
+				return transferSvc
+			},
+			wantCode: http.StatusOK,
+			wantResp: service.TransferToUserResponse{
+				OutBillNo:   core.String(idempotentNo),
+				CreateTime:  core.String(ctime.Format(time.RFC3339)),
+				State:       service.TRANSFERBILLSTATUS_WAIT_USER_CONFIRM.Ptr(),
+				PackageInfo: core.String("PKo1234567890-20200420130000"),
+			},
+		},
+		{
+			name: "idempotent replay under review",
+			reqBody: `{
+				"appid": "wxb9f4f763e5d4a6de",
+				"openid": "o1234567890",
+				"amount": 100,
+				"time": "20200420130001"
+			}`,
+			idempotencyKey: "k1",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), idempotentNo).Return(domain.TransferRecord{
+					OutBillNo: idempotentNo,
+					Openid:    "o1234567890",
+					Amount:    100,
+					Status:    domain.TransferStatusReviewing,
+				}, nil)
+				return transferSvc
+			},
+			// 和第一次请求一样进入审核
+			wantCode: http.StatusAccepted,
+			wantBiz:  response.CodeTransferUnderReview,
+			wantResp: service.TransferToUserResponse{OutBillNo: core.String(idempotentNo)},
+		},
+		{
+			name: "idempotent replay not sent yet",
+			reqBody: `{
+				"appid": "wxb9f4f763e5d4a6de",
+				"openid": "o1234567890",
+				"amount": 100,
+				"time": "20200420130001"
+			}`,
+			idempotencyKey: "k1",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), idempotentNo).Return(domain.TransferRecord{
+					OutBillNo: idempotentNo,
+					Openid:    "o1234567890",
+					Amount:    100,
+					Status:    domain.TransferStatusProcessing,
+				}, nil)
+				return transferSvc
+			},
+			wantCode: http.StatusAccepted,
+			wantBiz:  response.CodeTransferPending,
+			wantResp: service.TransferToUserResponse{OutBillNo: core.String(idempotentNo)},
+		},
+		{
+			name: "idempotent replay rejected by wx",
+			reqBody: `{
+				"appid": "wxb9f4f763e5d4a6de",
+				"openid": "o1234567890",
+				"amount": 100,
+				"time": "20200420130001"
+			}`,
+			idempotencyKey: "k1",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), idempotentNo).Return(domain.TransferRecord{
+					OutBillNo:  idempotentNo,
+					Openid:     "o1234567890",
+					Amount:     100,
+					Status:     domain.TransferStatusFail,
+					FailReason: "NOT_ENOUGH",
+				}, nil)
+				return transferSvc
+			},
+			wantCode: http.StatusBadGateway,
+			wantBiz:  response.CodeTransferRejected,
+		},
+		{
+			name: "idempotent replay denied by risk control",
+			reqBody: `{
+				"appid": "wxb9f4f763e5d4a6de",
+				"openid": "o1234567890",
+				"amount": 100,
+				"time": "20200420130001"
+			}`,
+			idempotencyKey: "k1",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), idempotentNo).Return(domain.TransferRecord{
+					OutBillNo:  idempotentNo,
+					Openid:     "o1234567890",
+					Amount:     100,
+					Status:     domain.TransferStatusFail,
+					FailReason: domain.TransferFailReasonRiskDenied,
+				}, nil)
+				return transferSvc
+			},
+			wantCode: http.StatusForbidden,
+			wantBiz:  response.CodeTransferDenied,
+		},
+		{
+			name: "idempotent retry after the bill was inserted",
+			reqBody: `{
+				"appid": "wxb9f4f763e5d4a6de",
+				"openid": "o1234567890",
+				"amount": 100,
+				"time": "20200420130001"
+			}`,
+			idempotencyKey: "k1",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				// 检查时还没有转账单，写入前被同一个幂等键的另一个请求抢先写入了
+				gomock.InOrder(
+					transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), idempotentNo).Return(domain.TransferRecord{}, service.ErrTransferNotFound),
+					transferSvc.EXPECT().InitiateTransfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, service.ErrTransferExists),
+					transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), idempotentNo).Return(domain.TransferRecord{
+						OutBillNo:   idempotentNo,
+						Openid:      "o1234567890",
+						Amount:      100,
+						Status:      domain.TransferStatusWaitUserConfirm,
+						PackageInfo: "wx-package-info",
+						Ctime:       ctime,
+					}, nil),
+				)
+				return transferSvc
+			},
+			wantCode: http.StatusOK,
+			wantResp: service.TransferToUserResponse{
+				OutBillNo:   core.String(idempotentNo),
+				CreateTime:  core.String(ctime.Format(time.RFC3339)),
+				State:       service.TRANSFERBILLSTATUS_WAIT_USER_CONFIRM.Ptr(),
+				PackageInfo: core.String("wx-package-info"),
+			},
+		},
+		{
+			name: "idempotency key reused for another amount",
+			reqBody: `{
+				"appid": "wxb9f4f763e5d4a6de",
+				"openid": "o1234567890",
+				"amount": 200,
+				"time": "20200420130000"
+			}`,
+			idempotencyKey: "k1",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), idempotentNo).Return(domain.TransferRecord{
+					OutBillNo: idempotentNo,
+					Openid:    "o1234567890",
+					Amount:    100,
+				}, nil)
+				return transferSvc
+			},
+			wantCode: http.StatusConflict,
+			wantBiz:  response.CodeIdempotencyConflict,
+		},
+		{
+			name: "appid not bound",
+			reqBody: `{
+				"appid": "wx0000000000000000",
+				"openid": "o1234567890",
+				"amount": 100,
+				"time": "20200420130000"
+			}`,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				return svcmocks.NewMockTransferService(ctrl)
+			},
+			wantCode: http.StatusBadRequest,
+			wantBiz:  response.CodeMerchantNotFound,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -66,20 +338,15 @@ func TestInitiateTransfer(t *testing.T) {
 			// 创建 userHandler 及所需的依赖 userService
 			server := gin.Default()
 			transferSvc := tc.mock(ctrl)
-			MchConfig, _ := wxpay_utility.CreateMchConfig(
-				"1368139500",
-				"ajkhyuiKJSAHDn124fsadasda",
-				"certs/private_key.pem",
-				"adsbvcretgnfsde",
-				"certs/public_key.pem",
-			)
-			client := NewClient("wxb9f4f763e5d4a6de", MchConfig, "http://wepay.selfknow.cn")
-			transferHandler := NewTransferHandler(transferSvc, nil, client)
+			transferHandler := NewTransferHandler(transferSvc, nil, mockMerchants(ctrl))
 			transferHandler.RegisterRoutes(server.Group("/transfer"))
 
 			// 创建请求
 			req, err := http.NewRequest(http.MethodPost, "/transfer/to_user", bytes.NewBuffer([]byte(tc.reqBody)))
 			req.Header.Set("Content-Type", "application/json")
+			if tc.idempotencyKey != "" {
+				req.Header.Set(IdempotencyKeyHeader, tc.idempotencyKey)
+			}
 			assert.Nil(t, err)
 
 			// 执行请求
@@ -88,9 +355,472 @@ func TestInitiateTransfer(t *testing.T) {
 
 			// 检查响应
 			var respBody service.TransferToUserResponse
-			err = json.Unmarshal(resp.Body.Bytes(), &respBody)
+			result := decodeResult(t, resp.Body.Bytes(), &respBody)
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantBiz, result.Code)
+			assert.Equal(t, tc.wantResp, respBody)
+		})
+	}
+}
+
+// TestInitiateTransfer_SameSecond 同一个用户同一秒用不同的幂等键发起两笔转账，package_info 不能冲突
+func TestInitiateTransfer_SameSecond(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	transferSvc := svcmocks.NewMockTransferService(ctrl)
+	// 和数据库一样，package_info 重复时写入失败
+	packageInfos := make(map[string]bool)
+	transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), gomock.Any()).
+		Return(domain.TransferRecord{}, service.ErrTransferNotFound).Times(2)
+	transferSvc.EXPECT().InitiateTransfer(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ any, record *domain.TransferRecord, _ any) (*service.TransferToUserResponse, error) {
+			if packageInfos[record.PackageInfo] {
+				return nil, service.ErrTransferExists
+			}
+			packageInfos[record.PackageInfo] = true
+			return &service.TransferToUserResponse{OutBillNo: core.String(record.OutBillNo)}, nil
+		}).Times(2)
+
+	server := gin.Default()
+	NewTransferHandler(transferSvc, nil, mockMerchants(ctrl)).RegisterRoutes(server.Group("/transfer"))
+	const reqBody = `{"appid": "wxb9f4f763e5d4a6de", "openid": "o1234567890", "amount": 100, "time": "20200420130000"}`
+	for _, key := range []string{"k1", "k2"} {
+		req := httptest.NewRequest(http.MethodPost, "/transfer/to_user", strings.NewReader(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(IdempotencyKeyHeader, key)
+		resp := httptest.NewRecorder()
+		server.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusOK, resp.Code, key)
+	}
+	assert.Len(t, packageInfos, 2)
+}
+
+func TestConfirmTransfer(t *testing.T) {
+	const reqBody = `{"openid": "o1", "appid": "wxb9f4f763e5d4a6de", "package_info": "PKo1-20200420130000"}`
+	testCases := []struct {
+		name     string
+		reqBody  string
+		mock     func(ctrl *gomock.Controller) service.TransferService
+		wantCode int
+		wantBiz  response.Code
+		wantData ConfirmVo
+	}{
+		{
+			name:    "success",
+			reqBody: reqBody,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ConfirmTransfer(gomock.Any(), "o1", "PKo1-20200420130000").
+					Return(domain.TransferRecord{OutBillNo: "b1", Status: domain.TransferStatusSuccess}, nil)
+				return transferSvc
+			},
+			wantCode: http.StatusOK,
+			wantBiz:  response.CodeOK,
+			wantData: ConfirmVo{OutBillNo: "b1", Status: domain.TransferStatusSuccess},
+		},
+		{
+			name:    "already confirmed",
+			reqBody: reqBody,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ConfirmTransfer(gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(domain.TransferRecord{OutBillNo: "b1", Status: domain.TransferStatusSuccess}, service.ErrTransferConfirmed)
+				return transferSvc
+			},
+			wantCode: http.StatusConflict,
+			wantBiz:  response.CodeBillAlreadyConfirmed,
+			wantData: ConfirmVo{OutBillNo: "b1", Status: domain.TransferStatusSuccess},
+		},
+		{
+			name:    "not confirmable",
+			reqBody: reqBody,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ConfirmTransfer(gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(domain.TransferRecord{OutBillNo: "b1", Status: domain.TransferStatusProcessing}, service.ErrTransferNotConfirmable)
+				return transferSvc
+			},
+			wantCode: http.StatusConflict,
+			wantBiz:  response.CodeBillNotConfirmable,
+			wantData: ConfirmVo{OutBillNo: "b1", Status: domain.TransferStatusProcessing},
+		},
+		{
+			name:    "not owned",
+			reqBody: reqBody,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ConfirmTransfer(gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(domain.TransferRecord{}, service.ErrTransferNotOwned)
+				return transferSvc
+			},
+			wantCode: http.StatusForbidden,
+			wantBiz:  response.CodeBillNotOwned,
+		},
+		{
+			name:    "not found",
+			reqBody: reqBody,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ConfirmTransfer(gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(domain.TransferRecord{}, service.ErrTransferNotFound)
+				return transferSvc
+			},
+			wantCode: http.StatusNotFound,
+			wantBiz:  response.CodeBillNotFound,
+		},
+		{
+			name:    "missing package_info",
+			reqBody: `{"openid": "o1", "appid": "wxb9f4f763e5d4a6de"}`,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				return svcmocks.NewMockTransferService(ctrl)
+			},
+			wantCode: http.StatusBadRequest,
+			wantBiz:  response.CodeInvalidParam,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			server := gin.Default()
+			transferHandler := NewTransferHandler(tc.mock(ctrl), nil, nil)
+			transferHandler.RegisterRoutes(server.Group("/transfer"))
+
+			req, err := http.NewRequest(http.MethodPost, "/transfer/confirm", bytes.NewBufferString(tc.reqBody))
+			assert.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			var data ConfirmVo
+			result := decodeResult(t, resp.Body.Bytes(), &data)
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantBiz, result.Code)
+			assert.Equal(t, tc.wantData, data)
+		})
+	}
+}
+
+func TestTransferNotify(t *testing.T) {
+	const apiV3Key = "0123456789abcdef0123456789abcdef"
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+	publicKeyPath := filepath.Join(t.TempDir(), "public_key.pem")
+	require.NoError(t, os.WriteFile(publicKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+	mchConfig, err := wxpay_utility.CreateMchConfig("1368139500", "ajkhyuiKJSAHDn124fsadasda", "certs/private_key.pem",
+		"PUB_KEY_ID_1368139500", publicKeyPath)
+	require.NoError(t, err)
+	require.NoError(t, mchConfig.SetApiV3Key(apiV3Key))
+
+	// encrypt 模拟微信支付用 APIv3 密钥加密 resource
+	encrypt := func(resource string) string {
+		block, err := aes.NewCipher([]byte(apiV3Key))
+		require.NoError(t, err)
+		gcm, err := cipher.NewGCM(block)
+		require.NoError(t, err)
+		ciphertext := gcm.Seal(nil, []byte("0123456789ab"), []byte(resource), []byte("mch_payment"))
+		return fmt.Sprintf(`{"id": "EV-2018022511223320873", "create_time": "2025-07-23T10:00:00+08:00",
+			"resource_type": "encrypt-resource", "event_type": "MCHTRANSFER.BILL.FINISHED", "summary": "商家转账单据终态通知",
+			"resource": {"original_type": "mch_payment", "algorithm": "AEAD_AES_256_GCM", "ciphertext": %q,
+			"associated_data": "mch_payment", "nonce": "0123456789ab"}}`, base64.StdEncoding.EncodeToString(ciphertext))
+	}
+	successBody := encrypt(`{"out_bill_no": "plfk2020042013", "transfer_bill_no": "1330000071100999991182020050700019480001",
+		"state": "SUCCESS", "mch_id": "1368139500", "transfer_amount": 100, "openid": "o1234567890"}`)
+
+	testCases := []struct {
+		name     string
+		body     string
+		serial   string
+		tamper   bool // 签名之后修改请求体
+		mock     func(ctrl *gomock.Controller) service.TransferService
+		wantCode int
+	}{
+		{
+			name:   "success",
+			body:   successBody,
+			serial: "PUB_KEY_ID_1368139500",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), "plfk2020042013").
+					Return(domain.TransferRecord{OutBillNo: "plfk2020042013", MchId: "1368139500"}, nil)
+				// 用解密出来的状态
+				transferSvc.EXPECT().UpdateTransferStatus(gomock.Any(), "plfk2020042013", domain.TransferStatusSuccess,
+					domain.TransferEventSourceNotify, "req-1").Return(nil)
+				return transferSvc
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:   "invalid signature",
+			body:   successBody,
+			serial: "PUB_KEY_ID_1368139500",
+			tamper: true,
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				// 不改状态
+				return svcmocks.NewMockTransferService(ctrl)
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:   "unknown serial",
+			body:   successBody,
+			serial: "PUB_KEY_ID_OTHER",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				return svcmocks.NewMockTransferService(ctrl)
+			},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:   "plaintext out_bill_no",
+			body:   `{"out_bill_no": "plfk2020042013"}`,
+			serial: "PUB_KEY_ID_1368139500",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				return svcmocks.NewMockTransferService(ctrl)
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:   "mch_id mismatch",
+			body:   encrypt(`{"out_bill_no": "plfk2020042013", "state": "SUCCESS", "mch_id": "1900001109"}`),
+			serial: "PUB_KEY_ID_1368139500",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				return svcmocks.NewMockTransferService(ctrl)
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:   "bill of another merchant",
+			body:   successBody,
+			serial: "PUB_KEY_ID_1368139500",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), "plfk2020042013").
+					Return(domain.TransferRecord{OutBillNo: "plfk2020042013", MchId: "1900001109"}, nil)
+				return transferSvc
+			},
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			merchantSvc := svcmocks.NewMockMerchantService(ctrl)
+			merchantSvc.EXPECT().List(gomock.Any()).Return([]domain.Merchant{
+				{MchId: "1368139500", WechatPayPublicKeyId: "PUB_KEY_ID_1368139500"},
+			}, nil)
+			merchantSvc.EXPECT().MchConfig(gomock.Any(), "1368139500").Return(mchConfig, nil).AnyTimes()
+
+			server := gin.Default()
+			transferHandler := NewTransferHandler(tc.mock(ctrl), nil, merchantSvc)
+			transferHandler.RegisterNotifyRoutes(server.Group("/transfer"))
+
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			signature, err := wxpay_utility.SignSHA256WithRSA(fmt.Sprintf("%s\n%s\n%s\n", timestamp, "nonce1", tc.body), privateKey)
+			require.NoError(t, err)
+			body := tc.body
+			if tc.tamper {
+				body = strings.Replace(body, "EV-", "EV-X", 1)
+			}
+			req, err := http.NewRequest(http.MethodPost, "/transfer/notify", bytes.NewBufferString(body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(wxpay_utility.WechatPayTimestamp, timestamp)
+			req.Header.Set(wxpay_utility.WechatPayNonce, "nonce1")
+			req.Header.Set(wxpay_utility.WechatPaySignature, signature)
+			req.Header.Set(wxpay_utility.WechatPaySerial, tc.serial)
+			req.Header.Set(wxpay_utility.RequestID, "req-1")
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+			assert.Equal(t, tc.wantCode, resp.Code, resp.Body.String())
+		})
+	}
+}
+
+// mockMerchants 小程序 wxb9f4f763e5d4a6de 由商户 1368139500 出款，其他 appid 没有关联商户
+func mockMerchants(ctrl *gomock.Controller) service.MerchantService {
+	mchConfig, _ := wxpay_utility.CreateMchConfig(
+		"1368139500",
+		"ajkhyuiKJSAHDn124fsadasda",
+		"certs/private_key.pem",
+		"adsbvcretgnfsde",
+		"certs/public_key.pem",
+	)
+	merchantSvc := svcmocks.NewMockMerchantService(ctrl)
+	merchantSvc.EXPECT().GetByAppid(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, appid string) (domain.Merchant, error) {
+		if appid != "wxb9f4f763e5d4a6de" {
+			return domain.Merchant{}, service.ErrMerchantNotFound
+		}
+		return domain.Merchant{MchId: "1368139500", NotifyUrl: "http://wepay.selfknow.cn", Appids: []string{appid}}, nil
+	}).AnyTimes()
+	merchantSvc.EXPECT().MchConfig(gomock.Any(), "1368139500").Return(mchConfig, nil).AnyTimes()
+	return merchantSvc
+}
+
+func TestTransferHistory(t *testing.T) {
+	ctime := time.Date(2025, 7, 23, 10, 0, 0, 0, time.Local)
+	testCases := []struct {
+		name     string
+		url      string
+		mock     func(ctrl *gomock.Controller) service.TransferService
+		wantCode int
+		wantResp TransferHistoryResp
+	}{
+		{
+			name: "success",
+			url:  "/transfer/history?openid=o1234567890&status=SUCCESS&start_date=2025-07-01&end_date=2025-07-31&cursor=100&limit=1",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().ListTransferHistory(gomock.Any(), domain.TransferHistoryQuery{
+					Openid:    "o1234567890",
+					Status:    domain.TransferStatusSuccess,
+					StartTime: time.Date(2025, 7, 1, 0, 0, 0, 0, time.Local),
+					EndTime:   time.Date(2025, 8, 1, 0, 0, 0, 0, time.Local),
+					Cursor:    100,
+					Limit:     1,
+				}).Return([]domain.TransferRecord{
+					{
+						ID:        99,
+						OutBillNo: "plfk2020042013",
+						Amount:    100,
+						Remark:    "test",
+						Status:    domain.TransferStatusSuccess,
+						Ctime:     ctime,
+						Utime:     ctime,
+					},
+				}, int64(99), nil)
+				return transferSvc
+			},
+			wantCode: http.StatusOK,
+			wantResp: TransferHistoryResp{
+				Records: []TransferRecordVo{
+					{
+						OutBillNo: "plfk2020042013",
+						Amount:    100,
+						Remark:    "test",
+						Status:    domain.TransferStatusSuccess,
+						Ctime:     ctime.Format(time.RFC3339),
+						Utime:     ctime.Format(time.RFC3339),
+					},
+				},
+				NextCursor: 99,
+			},
+		},
+		{
+			name: "missing openid",
+			url:  "/transfer/history",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				return svcmocks.NewMockTransferService(ctrl)
+			},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "invalid date",
+			url:  "/transfer/history?openid=o1234567890&start_date=20250701",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				return svcmocks.NewMockTransferService(ctrl)
+			},
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			server := gin.Default()
+			transferHandler := NewTransferHandler(tc.mock(ctrl), nil, nil)
+			transferHandler.RegisterRoutes(server.Group("/transfer"))
+
+			req, err := http.NewRequest(http.MethodGet, tc.url, nil)
 			assert.Nil(t, err)
+
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.wantCode, resp.Code)
+			if resp.Code != http.StatusOK {
+				return
+			}
+			var respBody TransferHistoryResp
+			decodeResult(t, resp.Body.Bytes(), &respBody)
+			assert.Equal(t, tc.wantResp, respBody)
+		})
+	}
+}
+
+func TestTransferStatus(t *testing.T) {
+	ctime := time.Date(2025, 7, 23, 10, 0, 0, 0, time.Local)
+	record := domain.TransferRecord{
+		OutBillNo: "plfk2020042013",
+		Openid:    "o1234567890",
+		Amount:    100,
+		Status:    domain.TransferStatusSuccess,
+		Ctime:     ctime,
+		Utime:     ctime,
+	}
+	testCases := []struct {
+		name     string
+		url      string
+		mock     func(ctrl *gomock.Controller) service.TransferService
+		wantCode int
+		wantBiz  response.Code
+		wantResp TransferRecordVo
+	}{
+		{
+			name: "success",
+			url:  "/transfer/status?openid=o1234567890&out_bill_no=plfk2020042013",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), "plfk2020042013").Return(record, nil)
+				return transferSvc
+			},
+			wantCode: http.StatusOK,
+			wantResp: toTransferRecordVo(record),
+		},
+		{
+			name: "other user's bill",
+			url:  "/transfer/status?openid=o0000000000&out_bill_no=plfk2020042013",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GetTransferRecordByOutBillNo(gomock.Any(), "plfk2020042013").Return(record, nil)
+				return transferSvc
+			},
+			wantCode: http.StatusNotFound,
+			wantBiz:  response.CodeBillNotFound,
+		},
+		{
+			name: "missing out_bill_no",
+			url:  "/transfer/status?openid=o1234567890",
+			mock: func(ctrl *gomock.Controller) service.TransferService {
+				return svcmocks.NewMockTransferService(ctrl)
+			},
+			wantCode: http.StatusBadRequest,
+			wantBiz:  response.CodeInvalidParam,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			server := gin.Default()
+			NewTransferHandler(tc.mock(ctrl), nil, nil).RegisterRoutes(server.Group("/transfer"))
+
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+			var respBody TransferRecordVo
+			result := decodeResult(t, resp.Body.Bytes(), &respBody)
 			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantBiz, result.Code)
 			assert.Equal(t, tc.wantResp, respBody)
 		})
 	}
@@ -0,0 +1,87 @@
+package web
+
+import (
+	"errors"
+	"wepay/internal/domain"
+	"wepay/internal/logger"
+	"wepay/internal/service"
+	"wepay/internal/web/response"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UserHandler struct {
+	withdrawSvc service.WithdrawService
+	transferSvc service.TransferService
+	merchantSvc service.MerchantService
+}
+
+func NewUserHandler(withdrawSvc service.WithdrawService, transferSvc service.TransferService, merchantSvc service.MerchantService) *UserHandler {
+	return &UserHandler{
+		withdrawSvc: withdrawSvc,
+		transferSvc: transferSvc,
+		merchantSvc: merchantSvc,
+	}
+}
+
+func (u *UserHandler) RegisterRoutes(ug *gin.RouterGroup) {
+	ug.POST("/withdraw", u.Withdraw) // 余额提现到零钱
+}
+
+// Withdraw 把余额提现到微信零钱
+func (u *UserHandler) Withdraw(ctx *gin.Context) {
+	var req struct {
+		Appid  string `form:"appid" json:"appid" binding:"required"`
+		Openid string `form:"openid" json:"openid" binding:"required"`
+		Amount int64  `form:"amount" json:"amount" binding:"required,gt=0"`
+		// 小程序上报的设备标识，用于风控
+		DeviceId string `form:"device_id" json:"device_id"`
+	}
+	if err := ctx.ShouldBind(&req); err != nil {
+		writeInvalidParam(ctx, err)
+		return
+	}
+	client, err := clientByAppid(ctx, u.merchantSvc, req.Appid)
+	if err != nil {
+		writeError(ctx, err)
+		if !errors.Is(err, errAppidNotBound) {
+			logger.FromContext(ctx).Error("load merchant failed", "appid", req.Appid, "error", err)
+		}
+		return
+	}
+
+	const remark = "余额提现"
+	outbillno := u.transferSvc.GenerateOutBillNo()
+	packageInfo := generatePackageInfo(outbillno)
+	bill := &domain.TransferRecord{
+		OutBillNo:   outbillno,
+		Openid:      req.Openid,
+		MchId:       client.MchConfig.MchId(),
+		PackageInfo: packageInfo,
+		Amount:      req.Amount,
+		Remark:      remark,
+		DeviceId:    req.DeviceId,
+		ClientIp:    ctx.ClientIP(),
+	}
+	request := client.NewTransferToUserRequest(outbillno, req.Openid, req.Amount, remark)
+
+	resp, err := u.withdrawSvc.Withdraw(ctx, client.MchConfig, bill, request)
+	switch {
+	case errors.Is(err, service.ErrTransferUnderReview), errors.Is(err, service.ErrTransferPending):
+		writeErrorData(ctx, err, gin.H{"out_bill_no": outbillno})
+		return
+	case errors.Is(err, service.ErrTransferRejected):
+		writeError(ctx, err)
+		logger.FromContext(ctx).Warn("withdraw rejected by wx", "out_bill_no", outbillno, "error", err)
+		return
+	case errors.Is(err, service.ErrInsufficientBalance), errors.Is(err, service.ErrLockTimeout),
+		errors.Is(err, service.ErrTransferDenied):
+		writeError(ctx, err)
+		return
+	case err != nil:
+		writeError(ctx, err)
+		logger.FromContext(ctx).Error("withdraw failed", "out_bill_no", outbillno, "error", err)
+		return
+	}
+	response.OK(ctx, resp)
+}
@@ -0,0 +1,162 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wepay/internal/domain"
+	"wepay/internal/service"
+	svcmocks "wepay/internal/service/mocks"
+	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/web/response"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestWithdraw(t *testing.T) {
+	testCases := []struct {
+		name     string
+		reqBody  string
+		mock     func(ctrl *gomock.Controller) (service.WithdrawService, service.TransferService)
+		wantCode int
+		wantBiz  response.Code
+	}{
+		{
+			name:    "success",
+			reqBody: `{"appid": "wxb9f4f763e5d4a6de", "openid": "o1234567890", "amount": 100}`,
+			mock: func(ctrl *gomock.Controller) (service.WithdrawService, service.TransferService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GenerateOutBillNo().Return("plfk2020042013")
+				withdrawSvc := svcmocks.NewMockWithdrawService(ctrl)
+				withdrawSvc.EXPECT().Withdraw(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ any, _ *wxpay_utility.MchConfig, bill *domain.TransferRecord, request *service.TransferToUserRequest) (*service.TransferToUserResponse, error) {
+						assert.Equal(t, "plfk2020042013", bill.OutBillNo)
+						assert.Equal(t, "1368139500", bill.MchId)
+						assert.Equal(t, int64(100), bill.Amount)
+						assert.Equal(t, "plfk2020042013", *request.OutBillNo)
+						return nil, nil
+					})
+				return withdrawSvc, transferSvc
+			},
+			wantCode: http.StatusOK,
+			wantBiz:  response.CodeOK,
+		},
+		{
+			name:    "insufficient balance",
+			reqBody: `{"appid": "wxb9f4f763e5d4a6de", "openid": "o1234567890", "amount": 100}`,
+			mock: func(ctrl *gomock.Controller) (service.WithdrawService, service.TransferService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GenerateOutBillNo().Return("plfk2020042013")
+				withdrawSvc := svcmocks.NewMockWithdrawService(ctrl)
+				withdrawSvc.EXPECT().Withdraw(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(nil, service.ErrInsufficientBalance)
+				return withdrawSvc, transferSvc
+			},
+			wantCode: http.StatusBadRequest,
+			wantBiz:  response.CodeInsufficientBalance,
+		},
+		{
+			name:    "concurrent withdraw",
+			reqBody: `{"appid": "wxb9f4f763e5d4a6de", "openid": "o1234567890", "amount": 100}`,
+			mock: func(ctrl *gomock.Controller) (service.WithdrawService, service.TransferService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GenerateOutBillNo().Return("plfk2020042013")
+				withdrawSvc := svcmocks.NewMockWithdrawService(ctrl)
+				withdrawSvc.EXPECT().Withdraw(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(nil, service.ErrLockTimeout)
+				return withdrawSvc, transferSvc
+			},
+			wantCode: http.StatusConflict,
+			wantBiz:  response.CodeBusy,
+		},
+		{
+			name:    "risk denied",
+			reqBody: `{"appid": "wxb9f4f763e5d4a6de", "openid": "o1234567890", "amount": 100, "device_id": "d1"}`,
+			mock: func(ctrl *gomock.Controller) (service.WithdrawService, service.TransferService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GenerateOutBillNo().Return("plfk2020042013")
+				withdrawSvc := svcmocks.NewMockWithdrawService(ctrl)
+				withdrawSvc.EXPECT().Withdraw(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					DoAndReturn(func(_ any, _ *wxpay_utility.MchConfig, bill *domain.TransferRecord, _ *service.TransferToUserRequest) (*service.TransferToUserResponse, error) {
+						assert.Equal(t, "d1", bill.DeviceId)
+						return nil, service.ErrTransferDenied
+					})
+				return withdrawSvc, transferSvc
+			},
+			wantCode: http.StatusForbidden,
+			wantBiz:  response.CodeTransferDenied,
+		},
+		{
+			name:    "under review",
+			reqBody: `{"appid": "wxb9f4f763e5d4a6de", "openid": "o1234567890", "amount": 100}`,
+			mock: func(ctrl *gomock.Controller) (service.WithdrawService, service.TransferService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GenerateOutBillNo().Return("plfk2020042013")
+				withdrawSvc := svcmocks.NewMockWithdrawService(ctrl)
+				withdrawSvc.EXPECT().Withdraw(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(nil, service.ErrTransferUnderReview)
+				return withdrawSvc, transferSvc
+			},
+			wantCode: http.StatusAccepted,
+			wantBiz:  response.CodeTransferUnderReview,
+		},
+		{
+			name:    "db error",
+			reqBody: `{"appid": "wxb9f4f763e5d4a6de", "openid": "o1234567890", "amount": 100}`,
+			mock: func(ctrl *gomock.Controller) (service.WithdrawService, service.TransferService) {
+				transferSvc := svcmocks.NewMockTransferService(ctrl)
+				transferSvc.EXPECT().GenerateOutBillNo().Return("plfk2020042013")
+				withdrawSvc := svcmocks.NewMockWithdrawService(ctrl)
+				withdrawSvc.EXPECT().Withdraw(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(nil, errors.New("db error"))
+				return withdrawSvc, transferSvc
+			},
+			wantCode: http.StatusInternalServerError,
+			wantBiz:  response.CodeInternal,
+		},
+		{
+			name:    "invalid amount",
+			reqBody: `{"appid": "wxb9f4f763e5d4a6de", "openid": "o1234567890", "amount": -1}`,
+			mock: func(ctrl *gomock.Controller) (service.WithdrawService, service.TransferService) {
+				return svcmocks.NewMockWithdrawService(ctrl), svcmocks.NewMockTransferService(ctrl)
+			},
+			wantCode: http.StatusBadRequest,
+			wantBiz:  response.CodeInvalidParam,
+		},
+		{
+			name:    "unknown appid",
+			reqBody: `{"appid": "wx0000000000000000", "openid": "o1234567890", "amount": 100}`,
+			mock: func(ctrl *gomock.Controller) (service.WithdrawService, service.TransferService) {
+				return svcmocks.NewMockWithdrawService(ctrl), svcmocks.NewMockTransferService(ctrl)
+			},
+			wantCode: http.StatusBadRequest,
+			wantBiz:  response.CodeMerchantNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			server := gin.Default()
+			withdrawSvc, transferSvc := tc.mock(ctrl)
+			userHandler := NewUserHandler(withdrawSvc, transferSvc, mockMerchants(ctrl))
+			userHandler.RegisterRoutes(server.Group("/user"))
+
+			req, err := http.NewRequest(http.MethodPost, "/user/withdraw", bytes.NewBuffer([]byte(tc.reqBody)))
+			req.Header.Set("Content-Type", "application/json")
+			assert.Nil(t, err)
+
+			resp := httptest.NewRecorder()
+			server.ServeHTTP(resp, req)
+
+			assert.Equal(t, tc.wantCode, resp.Code)
+			assert.Equal(t, tc.wantBiz, decodeResult(t, resp.Body.Bytes(), nil).Code)
+		})
+	}
+}
@@ -1,55 +1,342 @@
 package main
 
 import (
-	"gorm.io/gorm/logger"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"wepay/internal/domain"
+	"wepay/internal/job"
+	"wepay/internal/logger"
 	"wepay/internal/repository"
+	"wepay/internal/repository/cache"
 	"wepay/internal/repository/dao"
 	"wepay/internal/service"
-	"wepay/internal/service/wxpay_utility"
+	"wepay/internal/tracing"
 	"wepay/internal/web"
+	"wepay/internal/web/middleware"
+	"wepay/internal/web/openapi"
+	"wepay/internal/web/response"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"gorm.io/driver/mysql"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	otelgorm "gorm.io/plugin/opentelemetry/tracing"
 )
 
 func main() {
-	db := initDB()
-	server := initWebServer()
-	client := initClient()
-	transferHandler := initTransfer(db, client)
+	// wepay migrate up|down [n]|status 只执行表结构变更，不启动服务
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		db, err := openDB()
+		if err == nil {
+			err = runMigrate(db, os.Args[2:])
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	l := initLogger()
+	if err := run(l); err != nil {
+		l.Error("wepay exited", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run 启动服务，收到 SIGINT 或 SIGTERM 后不再接收新请求，等正在处理的请求和后台任务结束后返回。
+// 启动失败或者没能在 WEPAY_SHUTDOWN_TIMEOUT 内退出完时返回错误
+func run(l *slog.Logger) (err error) {
+	shutdownTracing, err := initTracing()
+	if err != nil {
+		return fmt.Errorf("init tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = errors.Join(err, shutdownTracing(ctx))
+	}()
+	db, err := initDB(l)
+	if err != nil {
+		return fmt.Errorf("init db: %w", err)
+	}
+	redisClient := initRedis()
+	a, err := initApp(db, redisClient)
+	if err != nil {
+		return err
+	}
 
-	transferHandler.RegisterRoutes(server.Group("/transfer"))
+	spec, err := openapi.Load()
+	if err != nil {
+		return err
+	}
+	server, err := initWebServer(l, redisClient)
+	if err != nil {
+		return err
+	}
+	// 按 openapi.json 校验请求。/transfer 和 /user 给小程序用，没有鉴权，
+	// /api/transfer 的校验挂在鉴权之后，token 不对的请求拿不到参数校验的细节。
+	// /transfer/notify 的应答格式由微信规定，交给 handler 自己处理
+	validate := openapi.NewValidatorBuilder(spec).Build()
+	a.health.RegisterRoutes(server)
+	a.transfer.RegisterRoutes(server.Group("/transfer", validate))
+	a.transfer.RegisterNotifyRoutes(server.Group("/transfer"))
+	a.user.RegisterRoutes(server.Group("/user", validate))
+	// 管理后台的修改操作都记审计日志
+	adminAuth, audit := initAdminAuth(), middleware.NewAuditBuilder(a.audit).Build()
+	a.admin.RegisterRoutes(server.Group("/admin", adminAuth, audit))
+	a.risk.RegisterRoutes(server.Group("/admin/risk", adminAuth, audit))
+	// 内部服务通过 client 包调用，和小程序用同样的接口，不限流，也不挂微信的回调
+	a.transfer.RegisterRoutes(server.Group("/api/transfer", initAPIAuth(), validate))
+	// 小程序和业务后端对接用的接口约定
+	server.GET("/openapi.json", openapi.Handler)
 	// 定义路由
 	server.GET("/", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
+		response.OK(c, gin.H{
 			"message": "Welcome to WePay API",
 			"time":    time.Now().Format(time.RFC3339),
 		})
 	})
+	server.NoRoute(func(c *gin.Context) {
+		response.Fail(c, http.StatusNotFound, response.CodeNotFound, "接口不存在", nil)
+	})
+
+	addr := os.Getenv("WEPAY_HTTP_ADDR")
+	if addr == "" {
+		addr = ":8080"
+	}
+	// 先监听，端口被占用时直接启动失败
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	httpServer := &http.Server{
+		Handler:           server,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       15 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	// Prometheus 拉取指标，单独监听一个只在内网暴露的端口，不经过对外的路由
+	metricsListener, err := net.Listen("tcp", initMetricsAddr())
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	metricsServer := &http.Server{
+		Handler:           promhttp.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	serveErr := make(chan error, 2)
+	go func() {
+		serveErr <- httpServer.Serve(listener)
+	}()
+	go func() {
+		serveErr <- metricsServer.Serve(metricsListener)
+	}()
+	l.Info("wepay started", "addr", listener.Addr().String(), "metrics_addr", metricsListener.Addr().String())
+
+	// 后台任务的日志带上任务名
+	jobCtx, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+	var workers sync.WaitGroup
+	for name, j := range a.jobs {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			j.Start(logger.WithContext(jobCtx, l.With("job", name)))
+		}()
+	}
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err = <-serveErr:
+		err = fmt.Errorf("serve http: %w", err)
+	case <-signalCtx.Done():
+		l.Info("wepay shutting down")
+	}
 
-	_ = server.Run(":8080") // listen and serve on 8080
+	ctx, cancel := context.WithTimeout(context.Background(), initShutdownTimeout())
+	defer cancel()
+	a.health.Drain()
+	// 依次等正在处理的请求、后台任务结束
+	err = errors.Join(err, httpServer.Shutdown(ctx))
+	// 退出过程中还能拉到指标
+	err = errors.Join(err, metricsServer.Shutdown(ctx))
+	cancelJobs()
+	err = errors.Join(err, waitContext(ctx, &workers))
+	if err == nil {
+		l.Info("wepay stopped")
+	}
+	return err
+}
+
+// waitContext 等 wg 结束，ctx 先结束时返回 ctx.Err()
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// initMetricsAddr /metrics 的监听地址，默认 :9090，不要通过网关或者负载均衡对外暴露
+func initMetricsAddr() string {
+	addr := os.Getenv("WEPAY_METRICS_ADDR")
+	if addr == "" {
+		return ":9090"
+	}
+	return addr
+}
+
+// initShutdownTimeout 收到退出信号后最多等多久，默认 30 秒，要小于容器的 terminationGracePeriod
+func initShutdownTimeout() time.Duration {
+	timeout, err := time.ParseDuration(os.Getenv("WEPAY_SHUTDOWN_TIMEOUT"))
+	if err != nil || timeout <= 0 {
+		return 30 * time.Second
+	}
+	return timeout
+}
+
+// initLogger WEPAY_LOG_LEVEL 可选 debug、info、warn、error，默认 info。
+// 同时设为 slog 的默认 logger，没有 context 的地方也输出 JSON
+func initLogger() *slog.Logger {
+	l := logger.New(os.Stdout, os.Getenv("WEPAY_LOG_LEVEL"))
+	slog.SetDefault(l)
+	return l
+}
+
+// initTracing WEPAY_TRACE_EXPORTER 可选 otlp、stdout，不设置时不采集 span
+func initTracing() (func(context.Context) error, error) {
+	return tracing.Init(context.Background(), os.Getenv("WEPAY_TRACE_EXPORTER"))
 }
 
-func initDB() *gorm.DB {
-	db, err := gorm.Open(mysql.Open("root:root@tcp(localhost:13326)/wepay?charset=utf8mb4&parseTime=True&loc=Local"))
+func initDB(l *slog.Logger) (*gorm.DB, error) {
+	db, err := openDB()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	err = dao.InitTable(db)
+	// 启动时执行还没执行的表结构变更
+	if err := dao.InitTable(db); err != nil {
+		return nil, err
+	}
+	// 慢查询和出错的 SQL 按 warn 输出，debug 级别时输出所有 SQL。
+	// 只输出带占位符的 SQL，参数里有 openid、package_info
+	level := gormlogger.Warn
+	if l.Enabled(context.Background(), slog.LevelDebug) {
+		level = gormlogger.Info
+	}
+	db.Logger = gormlogger.New(slog.NewLogLogger(l.Handler(), slog.LevelWarn), gormlogger.Config{
+		SlowThreshold:        200 * time.Millisecond,
+		LogLevel:             level,
+		ParameterizedQueries: true,
+	})
+	// 每条 SQL 一个 span，挂在请求或任务的 span 下面。span 里只有带占位符的 SQL，不带参数
+	if err := db.Use(otelgorm.NewPlugin(otelgorm.WithoutMetrics(), otelgorm.WithoutQueryVariables())); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func openDB() (*gorm.DB, error) {
+	// 默认连 docker-compose 里的 MySQL，WEPAY_DB_DRIVER 可选 mysql、postgres、sqlite
+	driver, dsn := os.Getenv("WEPAY_DB_DRIVER"), os.Getenv("WEPAY_DB_DSN")
+	if driver == "" {
+		driver = dao.DriverMySQL
+	}
+	if dsn == "" && driver == dao.DriverMySQL {
+		dsn = "root:root@tcp(localhost:13326)/wepay?charset=utf8mb4&parseTime=True&loc=Local"
+	}
+	return dao.OpenDB(driver, dsn)
+}
+
+func runMigrate(db *gorm.DB, args []string) error {
+	migrator, err := dao.NewMigrator(db)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	ctx := context.Background()
+	if len(args) == 0 {
+		return errors.New("usage: wepay migrate up|down [n]|status")
+	}
+	switch args[0] {
+	case "up":
+		done, err := migrator.Up(ctx)
+		for _, m := range done {
+			fmt.Printf("up   %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "down":
+		// 默认只回滚最新的一个版本
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+		}
+		done, err := migrator.Down(ctx, steps)
+		for _, m := range done {
+			fmt.Printf("down %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			appliedAt := "pending"
+			if s.Applied {
+				appliedAt = s.AppliedAt.Format(time.DateTime)
+			}
+			fmt.Printf("%04d_%-40s %s\n", s.Version, s.Name, appliedAt)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
 	}
-	db.Logger = logger.Default.LogMode(logger.Info)
-	return db
 }
 
-func initWebServer() *gin.Engine {
-	server := gin.Default()
+func initWebServer(l *slog.Logger, redisClient *redis.Client) (*gin.Engine, error) {
+	server := gin.New()
+	// 下层拿到的 *gin.Context 可以取到请求 context 里的 logger
+	server.ContextWithFallback = true
+	// 只信任配置了的代理传来的 X-Forwarded-For，否则客户端可以伪造 IP 绕过按 IP 的限流和风控
+	if err := server.SetTrustedProxies(initTrustedProxies()); err != nil {
+		return nil, fmt.Errorf("init trusted proxies: %w", err)
+	}
+	// panic 时也返回统一格式的应答
+	recovery := gin.CustomRecovery(func(c *gin.Context, _ any) {
+		response.Abort(c, http.StatusInternalServerError, response.CodeInternal, "服务内部错误")
+	})
+	server.Use(recovery, otelgin.Middleware(tracing.ServiceName), middleware.NewRequestLogBuilder(l).Build(),
+		// 请求处理的截止时间，比 http.Server 的 WriteTimeout 短，超时的请求还来得及写应答
+		middleware.NewRequestTimeoutBuilder(20*time.Second).Build())
 
 	// middleware: 跨域请求
 	server.Use(cors.New(cors.Config{
@@ -65,36 +352,233 @@ func initWebServer() *gin.Engine {
 		},
 		MaxAge: 12 * time.Hour,
 	}))
+	rateLimit, err := initRateLimit(redisClient)
+	if err != nil {
+		return nil, err
+	}
+	server.Use(rateLimit)
+
+	return server, nil
+}
+
+// initTrustedProxies 反向代理的 IP 或网段从 WEPAY_TRUSTED_PROXIES 读取，逗号分隔，没配置时不信任任何代理
+func initTrustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("WEPAY_TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
+}
+
+// defaultRateLimits 小程序直接调用的接口的默认限流规则，/transfer/notify 是微信的回调，不限流
+var defaultRateLimits = map[string][]middleware.RateLimitConfig{
+	"/transfer/to_user": {
+		{Rule: "global", Rate: 200, Burst: 400},
+		{Rule: "ip", Rate: 5, Burst: 10},
+		{Rule: "openid", Rate: 1, Burst: 3},
+	},
+	"/transfer/confirm": {
+		{Rule: "global", Rate: 200, Burst: 400},
+		{Rule: "ip", Rate: 5, Burst: 10},
+	},
+	"/user/withdraw": {
+		{Rule: "global", Rate: 50, Burst: 100},
+		{Rule: "ip", Rate: 1, Burst: 5},
+		{Rule: "openid", Rate: 0.1, Burst: 2},
+	},
+}
+
+// initRateLimit WEPAY_RATE_LIMITS 为 JSON，格式和 defaultRateLimits 一样，比如
+// {"/user/withdraw": [{"rule": "openid", "rate": 0.05, "burst": 1}]}，配置了的路由替换默认规则，空数组表示不限流
+func initRateLimit(client *redis.Client) (gin.HandlerFunc, error) {
+	limits := maps.Clone(defaultRateLimits)
+	if raw := os.Getenv("WEPAY_RATE_LIMITS"); raw != "" {
+		var custom map[string][]middleware.RateLimitConfig
+		if err := json.Unmarshal([]byte(raw), &custom); err != nil {
+			return nil, fmt.Errorf("parse WEPAY_RATE_LIMITS: %w", err)
+		}
+		maps.Copy(limits, custom)
+	}
+
+	var limiter middleware.Limiter = middleware.NewLocalLimiter()
+	if client != nil {
+		limiter = middleware.NewRedisLimiter(client)
+	}
+	builder := middleware.NewRateLimitBuilder(limiter)
+	for path, configs := range limits {
+		for _, config := range configs {
+			rule, err := middleware.NewRateLimitRule(config)
+			if err != nil {
+				return nil, fmt.Errorf("rate limit of %s: %w", path, err)
+			}
+			builder.Route(path, rule)
+		}
+	}
+	return builder.Build(), nil
+}
+
+// initMerchants 注册默认的商户，其他商户通过管理后台添加
+func initMerchants(merchantSvc service.MerchantService) error {
+	return merchantSvc.Register(context.Background(), domain.Merchant{
+		MchId:                  "1368139500",
+		CertificateSerialNo:    "ajkhyuiKJSAHDn124fsadasda",
+		PrivateKeyPath:         "certs/private_key.pem",
+		WechatPayPublicKeyId:   "adsbvcretgnfsde",
+		WechatPayPublicKeyPath: "certs/public_key.pem",
+		ApiV3KeyPath:           "certs/apiv3_key",
+		NotifyUrl:              "http://wepay.selfknow.cn",
+		Appids:                 []string{"wxb9f4f763e5d4a6de"},
+	})
+}
 
-	return server
+// initRedis 没有配置 WEPAY_REDIS_ADDR 时返回 nil
+func initRedis() *redis.Client {
+	addr := os.Getenv("WEPAY_REDIS_ADDR")
+	if addr == "" {
+		return nil
+	}
+	return redis.NewClient(&redis.Options{
+		Addr:     addr,
+		Password: os.Getenv("WEPAY_REDIS_PASSWORD"),
+	})
 }
 
-func initClient() web.Client {
-	mchConfig, err := wxpay_utility.CreateMchConfig(
-		"1368139500",                // mchid
-		"ajkhyuiKJSAHDn124fsadasda", // certificateSerialNo
-		"certs/private_key.pem",     // privateKeyPath
-		"adsbvcretgnfsde",           // wechatPayPublicKeyId
-		"certs/public_key.pem",      // wechatPayPublicKeyPath
-	)
+// initCache 有 Redis 时缓存余额和转账状态，否则直接读数据库
+func initCache(client *redis.Client) cache.Cache {
+	if client == nil {
+		return nil
+	}
+	return cache.NewRedisCache(client)
+}
+
+// initLocker 有 Redis 时多个实例共用锁，否则只在进程内互斥
+func initLocker(client *redis.Client) *service.MeteredLocker {
+	if client == nil {
+		return service.NewMeteredLocker(service.NewLocalLocker())
+	}
+	return service.NewMeteredLocker(service.NewRedisLocker(client, time.Minute))
+}
+
+func initAdminAuth() gin.HandlerFunc {
+	// 管理员 token 从环境变量读取，没配置时管理后台不可用
+	tokens := map[string]string{}
+	if token := os.Getenv("WEPAY_ADMIN_TOKEN"); token != "" {
+		tokens[token] = "admin"
+	}
+	return middleware.NewAdminAuthBuilder(tokens).Build()
+}
+
+// initAPIAuth 内部服务的 token 从 WEPAY_API_TOKENS 读取，格式为 name:token,name:token，没配置时 /api 不可用
+func initAPIAuth() gin.HandlerFunc {
+	tokens := map[string]string{}
+	for _, entry := range strings.Split(os.Getenv("WEPAY_API_TOKENS"), ",") {
+		name, token, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if ok && name != "" && token != "" {
+			tokens[token] = name
+		}
+	}
+	return middleware.NewAdminAuthBuilder(tokens).Build()
+}
+
+func initAlerter() service.Alerter {
+	// 配置了 webhook 时同时发到 webhook 和日志
+	alerters := service.MultiAlerter{service.LogAlerter{}}
+	if webhook := os.Getenv("WEPAY_ALERT_WEBHOOK"); webhook != "" {
+		alerters = append(alerters, service.NewWebhookAlerter(webhook))
+	}
+	return alerters
+}
+
+func initBalanceThreshold() int64 {
+	// 运营账户可用余额的告警阈值，单位分，默认 1000 元
+	threshold, err := strconv.ParseInt(os.Getenv("WEPAY_BALANCE_ALERT_THRESHOLD"), 10, 64)
 	if err != nil {
-		panic(err)
+		return 100000
 	}
-	return web.NewClient(
-		"wxb9f4f763e5d4a6de", // appid
-		mchConfig,
-		"http://wepay.selfknow.cn", // notifyUrl
-	)
+	return threshold
 }
 
-func initTransfer(db *gorm.DB, client web.Client) *web.TransferHandler {
-	transferDao := dao.NewTransferDao(db)
-	transferRepo := repository.NewTransferRepository(transferDao)
-	transferSvc := service.NewTransferService(transferRepo)
+// app 组装好的 handler 和后台任务
+type app struct {
+	health   *web.HealthHandler
+	transfer *web.TransferHandler
+	user     *web.UserHandler
+	admin    *web.AdminHandler
+	risk     *web.RiskHandler
+	audit    service.AuditService
+	jobs     map[string]backgroundJob // 任务名 -> 任务
+}
+
+// backgroundJob 阻塞运行，直到 ctx 被取消
+type backgroundJob interface {
+	Start(ctx context.Context)
+}
 
+func initApp(db *gorm.DB, redisClient *redis.Client) (*app, error) {
+	merchantDao := dao.NewMerchantDao(db)
+	merchantRepo := repository.NewMerchantRepository(merchantDao)
+	merchantSvc := service.NewMerchantService(merchantRepo)
+	if err := initMerchants(merchantSvc); err != nil {
+		return nil, fmt.Errorf("init merchants: %w", err)
+	}
+
+	withdrawDao := dao.NewWithdrawDao(db)
+	c := initCache(redisClient)
+	withdrawRepo := repository.NewWithdrawRepository(withdrawDao, c, repository.DefaultCacheExpiration)
+
+	transferDao := dao.NewTransferDao(db)
+	transferRepo := repository.NewTransferRepository(transferDao, c, repository.DefaultCacheExpiration)
+	outboxDao := dao.NewTransferOutboxDao(db)
+	outboxRepo := repository.NewTransferOutboxRepository(outboxDao)
+	guard := service.NewFundGuard()
 	userDao := dao.NewUserDao(db)
-	userRepo := repository.NewUserRepository(userDao)
+	userRepo := repository.NewUserRepository(userDao, c, repository.DefaultCacheExpiration)
 	userSvc := service.NewUserService(userRepo)
 
-	return web.NewTransferHandler(transferSvc, userSvc, client)
+	riskSvc := service.NewRiskService(repository.NewRiskRepository(dao.NewRiskDao(db)), transferRepo)
+	locker := initLocker(redisClient)
+	transferSvc := service.NewTransferService(transferRepo, withdrawRepo, outboxRepo, "receipts", guard, locker, riskSvc)
+	prometheus.MustRegister(service.NewPendingTransferCollector(transferRepo), service.NewLockStatsCollector(locker))
+	withdrawSvc := service.NewWithdrawService(withdrawRepo, transferSvc, locker)
+
+	reconcileSvc := service.NewReconcileService(service.NewWxpayBillSource(merchantSvc), transferRepo)
+	balanceSvc := service.NewBalanceService(service.NewWxpayBalanceSource(merchantSvc), transferRepo,
+		guard, initAlerter(), initBalanceThreshold())
+
+	return &app{
+		health:   initHealth(db, redisClient, merchantSvc),
+		transfer: web.NewTransferHandler(transferSvc, userSvc, merchantSvc),
+		user:     web.NewUserHandler(withdrawSvc, transferSvc, merchantSvc),
+		admin:    web.NewAdminHandler(transferSvc, userSvc, reconcileSvc, merchantSvc),
+		risk:     web.NewRiskHandler(riskSvc, transferSvc),
+		audit:    service.NewAuditService(repository.NewAuditRepository(dao.NewAuditDao(db))),
+		jobs: map[string]backgroundJob{
+			"reconcile":         job.NewReconcileJob(reconcileSvc, merchantSvc, 10),
+			"transfer_dispatch": job.NewTransferDispatchJob(transferSvc, merchantSvc, 5*time.Second),
+			"balance_check":     job.NewBalanceCheckJob(balanceSvc, merchantSvc, 5*time.Minute),
+		},
+	}, nil
+}
+
+// initHealth 就绪检查数据库、所有商户的密钥。Redis 是所有实例共用的，不可用时只在 /readyz 里标记，不摘掉实例
+func initHealth(db *gorm.DB, redisClient *redis.Client, merchantSvc service.MerchantService) *web.HealthHandler {
+	health := web.NewHealthHandler(2*time.Second).
+		Add("db", func(ctx context.Context) error {
+			sqlDB, err := db.DB()
+			if err != nil {
+				return err
+			}
+			return sqlDB.PingContext(ctx)
+		}).
+		Add("merchant_keys", func(ctx context.Context) error {
+			return service.CheckMchConfigs(ctx, merchantSvc)
+		})
+	if redisClient != nil {
+		health.AddOptional("redis", func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		})
+	}
+	return health
 }
@@ -33,6 +33,7 @@ Page({
       method: 'POST',
       header: { 'content-type': 'application/json' },
       data: {
+        appid: that.data.appid,
         openid: that.data.openid,
         amount:  Math.floor(Math.random() * 49) + 1,  // 分
         remark: '红包签到',
@@ -40,12 +41,14 @@ Page({
       },
       success: (res) => {
         console.log(res);
-        if (res.data && res.data.package_info) {
-          wx.showToast({ title: res.data.msg || '签到成功', icon: 'success' });
-          this.setData({package_info: res.data.package_info})
-          console.log(res.data.out_bill_no);
+        // 应答格式为 {code, message, data}，code 为 0 表示成功
+        const body = res.data || {};
+        if (body.code === 0 && body.data && body.data.package_info) {
+          wx.showToast({ title: '签到成功', icon: 'success' });
+          this.setData({package_info: body.data.package_info})
+          console.log(body.data.out_bill_no);
         } else {
-          wx.showToast({ title: res.data.msg || '签到失败', icon: 'none' });
+          wx.showToast({ title: body.message || '签到失败', icon: 'none' });
         }
       },
       fail: (err) => {
@@ -69,18 +72,25 @@ Page({
       method: 'POST',
       data: {
         appid: this.data.appid,
-        mch_id: this.data.mchid,
+        openid: this.data.openid,
         package_info: this.data.package_info,
       },
       success: (res) => {
-        if (res.statusCode === 200) {
+        const body = res.data || {};
+        if (body.code === 0) {
           wx.showToast({ title: '转账已确认', icon: 'success' });
           // 这里可选择重新拉取余额、转账记录等
           this.fetchBalance();
           this.setData({package_info: ""})
           
+        } else if (body.code === 30003) {
+          // 已经确认过了，不用再确认
+          wx.showToast({ title: '红包已经领取过了', icon: 'none' });
+          this.setData({package_info: ""})
+        } else if (body.code === 30004) {
+          wx.showToast({ title: '微信平台还没处理完转账（没有 notify）', icon: 'none' });
         } else {
-          wx.showToast({ title: res.data.msg || '微信平台还没处理完转账（没有 notify）', icon: 'none' });
+          wx.showToast({ title: body.message || '确认失败', icon: 'none' });
         }
       },
       fail: () => {
@@ -99,7 +109,8 @@ Page({
       header: { 'content-type': 'application/json' },
       success(res) {
         console.log(res);
-        that.setData({ balance: res.data || 0});
+        const body = res.data || {};
+        that.setData({ balance: body.code === 0 ? body.data : 0});
       }
     });
   },